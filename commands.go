@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/version"
+)
+
+// commands are the subcommands accepted in place of a config path. Each
+// takes the remaining arguments and returns the process exit code.
+var commands = map[string]func(args []string) int{
+	"check":         runCheck,
+	"fetch-once":    runFetchOnce,
+	"dump-snapshot": runDumpSnapshot,
+	"discover":      runDiscover,
+	"version":       runVersion,
+}
+
+// configArg returns the config path given as first argument, or the
+// default.
+func configArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return "config.json"
+}
+
+// runCheck validates a config file and probes its data sources without
+// starting the server. It exits non-zero if anything is wrong.
+func runCheck(args []string) int {
+	path := configArg(args)
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+
+	mode := "single community"
+	if cfg.Federation {
+		mode = "federation"
+	}
+	fmt.Printf("%s: %s mode, listen %s, refresh every %s\n", path, mode, cfg.Listen, cfg.RefreshDuration)
+	fmt.Printf("  %d sources, %d tile layers, %d domain names, %d links\n",
+		len(cfg.Sources), len(cfg.TileLayers), len(cfg.DomainNames), len(cfg.Links))
+
+	failed := false
+	for _, p := range cfg.Check() {
+		fmt.Printf("  problem: %s\n", p)
+		failed = true
+	}
+
+	if cfg.Federation {
+		client := fetch.HTTPClient(cfg, cfg.FetchTimeoutDuration)
+		dir := federation.DirectoryLocation(cfg.FederationDirectory)
+		communities, err := federation.DiscoverCommunities(client, dir, cfg.MaxDirectoryBytes)
+		if err != nil {
+			fmt.Printf("  directory %s: %v\n", dir, err)
+			failed = true
+		} else {
+			fmt.Printf("  directory %s: %d communities\n", dir, len(communities))
+		}
+	} else {
+		s := store.New(config.NewLive(cfg))
+		for _, src := range cfg.Sources {
+			for _, u := range src.URL {
+				raw, err := s.Probe(u)
+				if err != nil {
+					fmt.Printf("  source %s: %v\n", u, err)
+					failed = true
+					continue
+				}
+				fmt.Printf("  source %s: %d nodes, %d links\n", u, len(raw.Nodes), len(raw.Links))
+				for _, w := range store.CheckRawData(raw) {
+					fmt.Printf("    warning: %s\n", w)
+				}
+			}
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	fmt.Println("OK")
+	return 0
+}
+
+// loadAndRefresh loads the config and performs one full refresh, with
+// discovery in federation mode. Errors are printed; nil means failure.
+func loadAndRefresh(path string) *store.Store {
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return nil
+	}
+	var s *store.Store
+	if cfg.Federation {
+		fedStore := federation.NewStore(config.NewLive(cfg))
+		// The state file belongs to the server; this store never
+		// restored it, so saving would drop its manual and disabled
+		// sources.
+		fedStore.DisableSaving()
+		s = fedStore.Store
+		err = fedStore.DiscoverAndRefresh()
+	} else {
+		s = store.New(config.NewLive(cfg))
+		err = s.Refresh()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "refresh failed: %v\n", err)
+		return nil
+	}
+	for url, warnings := range s.SourceWarnings() {
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s\n", url, w)
+		}
+	}
+	return s
+}
+
+// runFetchOnce fetches and processes the data once and prints the
+// resulting stats as JSON.
+func runFetchOnce(args []string) int {
+	s := loadAndRefresh(configArg(args))
+	if s == nil {
+		return 1
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.CurrentStats()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runDumpSnapshot writes the processed snapshot (nodes, links, stats) as
+// JSON: dump-snapshot [config] [file], where file defaults to
+// snapshot.json and "-" means stdout.
+func runDumpSnapshot(args []string) int {
+	out := "snapshot.json"
+	if len(args) > 1 {
+		out = args[1]
+	}
+	s := loadAndRefresh(configArg(args))
+	if s == nil {
+		return 1
+	}
+	snap := s.GetSnapshot()
+	data, err := json.Marshal(snap)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if out == "-" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+	} else {
+		err = os.WriteFile(out, data, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if out != "-" {
+		fmt.Fprintf(os.Stderr, "wrote %d nodes, %d links to %s\n",
+			len(snap.NodeList), len(snap.Links), out)
+	}
+	return 0
+}
+
+// runDiscover runs federation discovery and prints the data source chosen
+// for each community, without fetching node data.
+func runDiscover(args []string) int {
+	path := configArg(args)
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	fedStore := federation.NewStore(config.NewLive(cfg))
+	fedStore.DisableSaving()
+	communities, sources, failures, err := fedStore.Discover()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COMMUNITY\tTYPE\tURL")
+	for _, src := range sources {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", src.CommunityKey, src.DataType, src.DataURL)
+	}
+	tw.Flush()
+	keys := make([]string, 0, len(failures))
+	for k := range failures {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(os.Stderr, "%s: no data source: %s\n", k, failures[k])
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d communities have a reachable data source\n", len(sources), len(communities))
+	return 0
+}
+
+func runVersion(args []string) int {
+	fmt.Println(version.Get())
+	return 0
+}
@@ -1,3 +1,14 @@
 module github.com/freifunkMUC/freifunk-map-modern
 
 go 1.22.0
+
+require (
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.33.0
+)
+
+require (
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/aliases"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/annotations"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/api"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/exports"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/filters"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/hidden"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/history"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/overlays"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/sla"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/sse"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/statsd"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/watch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/wireguard"
+)
+
+// instance is one map served by the process, with its own store, SSE hub
+// and persistence files.
+type instance struct {
+	cfgPath string
+	live    *config.Live
+	store   *store.Store
+	handler http.Handler
+	mounted bool // BasePath comes from the instances key, not cfgPath
+}
+
+// reload re-reads the config file on SIGHUP and applies the keys that do
+// not need a restart. Connections, including SSE streams, stay open; a
+// config that fails to load leaves the running one untouched.
+func (in *instance) reload() {
+	next, err := config.Load(in.cfgPath)
+	if err != nil {
+		log.Printf("Config reload of %s failed, keeping current config: %v", in.cfgPath, err)
+		return
+	}
+	if in.mounted {
+		next.BasePath = in.live.Load().BasePath
+	}
+	applied, ignored := in.live.Reload(next)
+	if len(ignored) > 0 {
+		log.Printf("Config reload of %s: restart required for %v", in.cfgPath, ignored)
+	}
+	if len(applied) == 0 {
+		log.Printf("Config reload of %s: no changes applied", in.cfgPath)
+		return
+	}
+	in.store.ConfigReloaded()
+	log.Printf("Config reload of %s: applied %v", in.cfgPath, applied)
+}
+
+// startInstances starts the map of the main config, if it has data
+// sources, and every map listed in its instances key, and returns them
+// with the handler that routes between them.
+func startInstances(ctx context.Context, cfgPath string, cfg *config.Config) ([]*instance, http.Handler) {
+	if len(cfg.Instances) == 0 {
+		in := startInstance(ctx, cfgPath, cfg)
+		return []*instance{in}, in.handler
+	}
+
+	subs := make([]*config.Config, len(cfg.Instances))
+	for i, ic := range cfg.Instances {
+		sub, err := config.Load(ic.Config)
+		if err != nil {
+			log.Fatalf("Failed to load instance %s: %v", ic.Path, err)
+		}
+		sub.BasePath = ic.Path
+		subs[i] = sub
+	}
+	all := subs
+	if cfg.HasDataSources() {
+		all = append([]*config.Config{cfg}, subs...)
+	}
+	if err := config.CheckInstances(all); err != nil {
+		log.Fatalf("Invalid instances: %v", err)
+	}
+
+	var instances []*instance
+	root := http.NewServeMux()
+	if cfg.HasDataSources() {
+		in := startInstance(ctx, cfgPath, cfg)
+		instances = append(instances, in)
+		root.Handle("/", in.handler)
+	} else {
+		root.HandleFunc("/", handleInstanceIndex(subs))
+		root.HandleFunc("/healthz", api.HandleHealthz)
+	}
+	for i, ic := range cfg.Instances {
+		log.Printf("Instance %s: %s", ic.Path, subs[i].SiteName)
+		in := startInstance(ctx, ic.Config, subs[i])
+		in.mounted = true
+		instances = append(instances, in)
+		root.Handle(ic.Path, in.handler)
+		root.Handle(ic.Path+"/", in.handler)
+	}
+	return instances, root
+}
+
+// handleInstanceIndex lists the hosted maps when the main config has no
+// map of its own.
+func handleInstanceIndex(cfgs []*config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<!DOCTYPE html>\n<meta charset=\"utf-8\">\n<title>Freifunk Maps</title>\n<ul>\n")
+		for _, c := range cfgs {
+			fmt.Fprintf(w, "<li><a href=\"%s/\">%s</a></li>\n", html.EscapeString(c.BasePath), html.EscapeString(c.SiteName))
+		}
+		fmt.Fprint(w, "</ul>\n")
+	}
+}
+
+// startInstance loads the initial data of one map, starts its background
+// jobs and builds its HTTP handler.
+func startInstance(ctx context.Context, cfgPath string, cfg *config.Config) *instance {
+	// cfg is used for the keys that need a restart; everything reading
+	// reloadable keys gets live.
+	live := config.NewLive(cfg)
+	hub := sse.NewHub()
+	watcher := watch.Open(cfg.WatchFile, cfg.MaxWatches, cfg.MaxWatchesPerClient)
+	tracker := sla.Open(cfg.SLAFile)
+	hist := history.Open(cfg.HistoryFile)
+	hl := hidden.Open(cfg.HiddenNodesFile)
+	var s *store.Store
+	var fedStore *federation.Store
+
+	if cfg.Federation {
+		fedStore = federation.NewStore(live)
+		s = fedStore.Store
+		s.SetHideList(hl)
+		registerListeners(cfg, s, watcher, tracker, hist)
+
+		// Try to restore cached state for instant startup
+		if fedStore.RestoreState() {
+			log.Println("Federation mode: serving cached data, refreshing in background...")
+			go func() {
+				old := fedStore.GetSnapshot()
+				if err := fedStore.DiscoverAndRefresh(); err != nil {
+					log.Printf("Warning: background federation refresh failed: %v", err)
+					return
+				}
+				snap := fedStore.GetSnapshot()
+				log.Printf("Background refresh complete: %d nodes (%d online)",
+					snap.Stats.TotalNodes, snap.Stats.OnlineNodes)
+				diff := store.ComputeDiff(old, snap)
+				if diff != nil {
+					hub.Broadcast(diff)
+				}
+			}()
+		} else {
+			log.Println("Federation mode: no cache, performing initial discovery...")
+			if err := fedStore.DiscoverAndRefresh(); err != nil {
+				log.Printf("Warning: initial federation discovery failed: %v", err)
+			}
+		}
+		go fedStore.RunRefreshLoop(ctx, hub)
+	} else {
+		s = store.New(live)
+		s.SetHideList(hl)
+		registerListeners(cfg, s, watcher, tracker, hist)
+		if err := s.Refresh(); err != nil {
+			log.Printf("Warning: initial data fetch failed: %v", err)
+		}
+		go s.RunRefreshLoop(ctx, hub)
+	}
+
+	if len(cfg.WireguardStatsURLs) > 0 {
+		go wireguard.NewPoller(cfg, s).Run(ctx)
+	}
+
+	ov := overlays.Open(cfg)
+	if len(cfg.Overlays) > 0 {
+		go ov.Run(ctx)
+	}
+
+	fl := filters.Open(cfg.FiltersFile)
+	an := annotations.Open(cfg.AnnotationsFile)
+	al := aliases.Open(cfg.NodeAliasesFile)
+	if len(cfg.ExportJobs) > 0 {
+		go exports.NewScheduler(cfg.ExportJobs, s, fl, fetch.HTTPClient(cfg, 30*time.Second)).Run(ctx)
+	}
+
+	mux := http.NewServeMux()
+	api.RegisterHandlers(mux, live, s, hub, fedStore, an, al)
+	api.RegisterFilterHandlers(mux, cfg, s, fl)
+	api.RegisterWatchHandlers(mux, watcher)
+	api.RegisterSLAHandlers(mux, s, tracker)
+	api.RegisterGlobalMetricsHandler(mux, live, hist, fedStore != nil)
+	api.RegisterCompatHandlers(mux, live, s)
+	api.RegisterOverlayHandlers(mux, ov)
+	api.RegisterDebugHandlers(mux, cfg)
+	sm := api.NewServiceMetrics()
+	api.RegisterPrometheusHandler(mux, s, hub, sm)
+
+	if fedStore != nil {
+		api.RegisterFederationHandlers(mux, live, fedStore, al)
+		admin := api.RegisterAdminHandlers(mux, cfg, s, hub, fedStore.RefreshAllSources, fedStore.DiscoverAndRefresh)
+		api.RegisterFederationAdminHandlers(admin, fedStore, hub)
+		api.RegisterHiddenHandlers(admin, live, hl)
+		api.RegisterAnnotationHandlers(admin, an)
+		api.RegisterAliasHandlers(admin, live, al)
+	} else {
+		admin := api.RegisterAdminHandlers(mux, cfg, s, hub, s.Refresh, nil)
+		api.RegisterHiddenHandlers(admin, live, hl)
+		api.RegisterAnnotationHandlers(admin, an)
+		api.RegisterAliasHandlers(admin, live, al)
+		api.RegisterMetricsHandler(mux, live, s, al)
+		api.RegisterSourceHandler(mux, s)
+	}
+
+	webContent, err := fs.Sub(webFS, "web")
+	if err != nil {
+		log.Fatalf("Failed to mount web FS: %v", err)
+	}
+	if cfg.WebDir != "" {
+		if st, err := os.Stat(cfg.WebDir); err != nil || !st.IsDir() {
+			log.Fatalf("webDir %q is not a directory", cfg.WebDir)
+		}
+		webContent = overlayFS{os.DirFS(cfg.WebDir), webContent}
+		log.Printf("Serving frontend from %s (embedded files as fallback)", cfg.WebDir)
+	}
+	mux.Handle("/", api.StaticFiles(webContent))
+
+	handler := api.SecurityHeaders(live, sm.Instrument(api.RateLimit(cfg, api.GzipHandler(api.CacheHeaders(live, mux)))))
+	if cfg.BasePath != "" {
+		handler = api.WithBasePath(cfg.BasePath, handler)
+	}
+	return &instance{cfgPath: cfgPath, live: live, store: s, handler: handler}
+
+}
+
+// overlayFS serves files from top and falls back to base for files top
+// does not have.
+type overlayFS struct {
+	top, base fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.top.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.base.Open(name)
+	}
+	return f, err
+}
+
+// ReadDir merges both directories, so walking the overlay sees every
+// file it serves.
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	top, topErr := fs.ReadDir(o.top, name)
+	base, baseErr := fs.ReadDir(o.base, name)
+	if topErr != nil && baseErr != nil {
+		return nil, topErr
+	}
+	seen := make(map[string]bool, len(top))
+	for _, e := range top {
+		seen[e.Name()] = true
+	}
+	for _, e := range base {
+		if !seen[e.Name()] {
+			top = append(top, e)
+		}
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Name() < top[j].Name() })
+	return top, nil
+}
+
+// registerListeners attaches the optional snapshot consumers before the
+// first refresh, so the initial data is delivered too.
+func registerListeners(cfg *config.Config, s *store.Store, watcher *watch.Watcher, tracker *sla.Tracker, hist *history.Recorder) {
+	s.OnSnapshot(watcher.Observe)
+	s.OnSnapshot(tracker.Observe)
+	s.OnSnapshot(hist.Observe)
+	if cfg.StatsdAddr != "" {
+		em, err := statsd.New(cfg.StatsdAddr, cfg.StatsdPrefix)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+		} else {
+			s.OnSnapshot(em.Emit)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package acme obtains and renews TLS certificates from an ACME CA such
+// as Let's Encrypt, using golang.org/x/crypto/acme/autocert. The http-01
+// challenge is answered by the plain HTTP listener; the account key and
+// certificates are kept in the cache directory.
+package acme
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+)
+
+// renewBefore is how long before expiry a certificate is renewed.
+const renewBefore = 30 * 24 * time.Hour
+
+// Manager keeps certificates for the configured domains.
+type Manager struct {
+	domains []string
+	m       *autocert.Manager
+}
+
+// New creates a Manager from the acme* settings in cfg. Certificates are
+// requested on the first TLS handshake for a domain and renewed in the
+// background.
+func New(cfg *config.Config) *Manager {
+	m := &autocert.Manager{
+		Cache:       autocert.DirCache(cfg.ACMECacheDir),
+		HostPolicy:  autocert.HostWhitelist(cfg.ACMEDomains...),
+		Email:       cfg.ACMEEmail,
+		RenewBefore: renewBefore,
+		Client: &acme.Client{
+			DirectoryURL: cfg.ACMEDirectory,
+			HTTPClient:   fetch.HTTPClient(cfg, 30*time.Second),
+		},
+	}
+	// Without a Prompt autocert registers no account, so nothing is
+	// issued unless the operator agreed to the terms.
+	if cfg.ACMEAcceptTOS {
+		m.Prompt = autocert.AcceptTOS
+	}
+	return &Manager{domains: cfg.ACMEDomains, m: m}
+}
+
+// GetCertificate serves the certificate of the requested domain; it fits
+// tls.Config. Clients sending no server name get the first domain's.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName == "" && len(m.domains) > 0 {
+		h := *hello
+		h.ServerName = m.domains[0]
+		hello = &h
+	}
+	return m.m.GetCertificate(hello)
+}
+
+// HTTPHandler answers http-01 challenges and passes everything else to
+// next.
+func (m *Manager) HTTPHandler(next http.Handler) http.Handler {
+	return m.m.HTTPHandler(next)
+}
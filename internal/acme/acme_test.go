@@ -0,0 +1,33 @@
+package acme
+
+import (
+	"context"
+	"testing"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+func TestNewAcceptTOS(t *testing.T) {
+	cfg := &config.Config{ACMEDomains: []string{"map.example.net"}, ACMECacheDir: t.TempDir()}
+	if New(cfg).m.Prompt != nil {
+		t.Error("terms agreed to without acmeAcceptTOS")
+	}
+	cfg.ACMEAcceptTOS = true
+	if New(cfg).m.Prompt == nil {
+		t.Error("acmeAcceptTOS did not agree to the terms")
+	}
+}
+
+func TestHostPolicy(t *testing.T) {
+	m := New(&config.Config{ACMEDomains: []string{"map.example.net", "karte.example.net"}, ACMECacheDir: t.TempDir(), ACMEAcceptTOS: true})
+	for host, ok := range map[string]bool{
+		"map.example.net":   true,
+		"karte.example.net": true,
+		"other.example.net": false,
+		"example.net":       false,
+	} {
+		if err := m.m.HostPolicy(context.Background(), host); (err == nil) != ok {
+			t.Errorf("HostPolicy(%s) = %v", host, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package aliases maps the node IDs of replaced hardware to the ID of the
+// node that took its place. Gluon derives node IDs from the MAC, so a new
+// router is a new node; with an alias, permalinks, annotations and charts
+// keep following the logical node.
+package aliases
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/jsonfile"
+)
+
+// Alias points the old node ID From at its successor To.
+type Alias struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate trims both IDs and checks that they are usable and distinct.
+func (a *Alias) Validate() error {
+	a.From, a.To = strings.TrimSpace(a.From), strings.TrimSpace(a.To)
+	if a.From == "" || a.To == "" {
+		return fmt.Errorf("from and to are required")
+	}
+	if a.From == a.To {
+		return fmt.Errorf("a node cannot be its own alias")
+	}
+	if len(a.From) > 128 || len(a.To) > 128 || strings.ContainsAny(a.From+a.To, "/ ") {
+		return fmt.Errorf("node IDs must be at most 128 characters without slashes or spaces")
+	}
+	return nil
+}
+
+// Resolve follows id through m to the current node ID. A cycle stops at
+// the last ID before it repeats.
+func Resolve(m map[string]string, id string) string {
+	for i := 0; i < len(m); i++ {
+		next, ok := m[id]
+		if !ok {
+			break
+		}
+		id = next
+	}
+	return id
+}
+
+// Former returns the old IDs that resolve to id through m, sorted.
+func Former(m map[string]string, id string) []string {
+	var out []string
+	for from := range m {
+		if from != id && Resolve(m, from) == id {
+			out = append(out, from)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Cycle returns an ID of m that resolves back to itself, or "".
+func Cycle(m map[string]string) string {
+	for from := range m {
+		id := from
+		for i := 0; i < len(m); i++ {
+			next, ok := m[id]
+			if !ok {
+				break
+			}
+			if next == from {
+				return from
+			}
+			id = next
+		}
+	}
+	return ""
+}
+
+// Store keeps aliases added through the admin API and persists them to a
+// JSON file.
+type Store struct {
+	aliases *jsonfile.Map[Alias]
+}
+
+// Open loads aliases from path. A missing file yields an empty store.
+func Open(path string) *Store {
+	return &Store{aliases: jsonfile.Open(path, "Node aliases", func(a Alias) string { return a.From })}
+}
+
+// List returns the stored aliases sorted by old ID.
+func (as *Store) List() []Alias {
+	return as.aliases.Values()
+}
+
+// Map returns the configured aliases merged with the stored ones. The
+// configured nodeAliases win over stored aliases of the same old ID.
+func (as *Store) Map(configured map[string]string) map[string]string {
+	stored := as.aliases.Values()
+	m := make(map[string]string, len(configured)+len(stored))
+	for _, a := range stored {
+		m[a.From] = a.To
+	}
+	for from, to := range configured {
+		m[from] = to
+	}
+	return m
+}
+
+// Put validates and stores an alias, replacing any alias of the same old
+// ID. configured is checked too, so no alias can close a cycle. If saving
+// fails, the previous alias is kept.
+func (as *Store) Put(a Alias, configured map[string]string) (Alias, error) {
+	if err := a.Validate(); err != nil {
+		return a, err
+	}
+	if _, ok := configured[a.From]; ok {
+		return a, fmt.Errorf("%s is aliased by the nodeAliases setting", a.From)
+	}
+	m := as.Map(configured)
+	m[a.From] = a.To
+	if Cycle(m) != "" {
+		return a, fmt.Errorf("alias %s -> %s would create a cycle", a.From, a.To)
+	}
+	return as.aliases.Put(a.From, func(Alias, bool) Alias {
+		a.CreatedAt = time.Now().UTC()
+		return a
+	})
+}
+
+// Delete removes the alias of an old ID and reports whether it existed.
+func (as *Store) Delete(from string) (bool, error) {
+	return as.aliases.Delete(from)
+}
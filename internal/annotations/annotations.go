@@ -0,0 +1,101 @@
+// Package annotations stores operator notes, tags and a maintenance flag
+// per node, kept across refreshes and merged into node detail.
+package annotations
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/jsonfile"
+)
+
+const (
+	maxNoteLen = 2000
+	maxTags    = 32
+	maxTagLen  = 64
+)
+
+// Annotation is what operators attached to a node.
+type Annotation struct {
+	NodeID      string    `json:"node_id"`
+	Note        string    `json:"note,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Maintenance bool      `json:"maintenance,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Validate trims the note and tags, drops duplicate tags and checks the
+// limits. Tags may not contain commas, which separate them in filters.
+func (a *Annotation) Validate() error {
+	a.NodeID = strings.TrimSpace(a.NodeID)
+	if a.NodeID == "" {
+		return fmt.Errorf("node_id is required")
+	}
+	a.Note = strings.TrimSpace(a.Note)
+	if len(a.Note) > maxNoteLen {
+		return fmt.Errorf("note must be at most %d characters", maxNoteLen)
+	}
+	tags := make([]string, 0, len(a.Tags))
+	for _, t := range a.Tags {
+		t = strings.TrimSpace(t)
+		if t == "" || HasTag(tags, t) {
+			continue
+		}
+		if len(t) > maxTagLen || strings.Contains(t, ",") {
+			return fmt.Errorf("tag %q must be at most %d characters without commas", t, maxTagLen)
+		}
+		tags = append(tags, t)
+	}
+	if len(tags) > maxTags {
+		return fmt.Errorf("at most %d tags per node", maxTags)
+	}
+	a.Tags = tags
+	return nil
+}
+
+// HasTag reports whether tags contains tag, ignoring case.
+func HasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Store keeps annotations in memory and persists them to a JSON file.
+type Store struct {
+	nodes *jsonfile.Map[Annotation]
+}
+
+// Open loads annotations from path. A missing file yields an empty store.
+func Open(path string) *Store {
+	return &Store{nodes: jsonfile.Open(path, "Annotations", func(a Annotation) string { return a.NodeID })}
+}
+
+// List returns all annotations sorted by node ID.
+func (as *Store) List() []Annotation {
+	return as.nodes.Values()
+}
+
+func (as *Store) Get(nodeID string) (Annotation, bool) {
+	return as.nodes.Get(nodeID)
+}
+
+// Put validates and stores an annotation, replacing the node's previous one.
+// If saving fails, the previous annotation is kept.
+func (as *Store) Put(a Annotation) (Annotation, error) {
+	if err := a.Validate(); err != nil {
+		return a, err
+	}
+	return as.nodes.Put(a.NodeID, func(Annotation, bool) Annotation {
+		a.UpdatedAt = time.Now().UTC()
+		return a
+	})
+}
+
+// Delete removes a node's annotation and reports whether it existed.
+func (as *Store) Delete(nodeID string) (bool, error) {
+	return as.nodes.Delete(nodeID)
+}
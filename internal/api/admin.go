@@ -0,0 +1,174 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/sse"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// RegisterAdminHandlers mounts the operator API below /api/admin/. Every
+// route in the group requires admin credentials, so handlers added to the
+// returned mux need no checks of their own. refresh reloads the data;
+// rediscover, if not nil, re-runs federation discovery first.
+func RegisterAdminHandlers(mux *http.ServeMux, cfg *config.Config, s *store.Store, hub *sse.Hub, refresh, rediscover func() error) *http.ServeMux {
+	admin := http.NewServeMux()
+	admin.HandleFunc("/api/admin/diagnostics", handleDiagnostics(s))
+	admin.HandleFunc("/api/admin/refresh", handleAdminRefresh(s, hub, refresh))
+	if rediscover != nil {
+		admin.HandleFunc("/api/admin/rediscover", handleAdminRefresh(s, hub, rediscover))
+	}
+	mux.Handle("/api/admin/", adminOnly(cfg, admin))
+	return admin
+}
+
+// RegisterFederationAdminHandlers adds source management to the admin
+// group returned by RegisterAdminHandlers.
+func RegisterFederationAdminHandlers(admin *http.ServeMux, fs *federation.Store, hub *sse.Hub) {
+	admin.HandleFunc("/api/admin/sources", handleAdminSources(fs))
+	admin.HandleFunc("/api/admin/sources/", handleAdminSourceAction(fs, hub))
+}
+
+// handleAdminSources lists all federation sources (GET) or adds a manual
+// one from a JSON body with community_key, data_url and data_type (POST).
+func handleAdminSources(fs *federation.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req federation.SourceInfo
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			err := fs.AddSource(federation.CommunitySource{
+				CommunityKey: req.CommunityKey,
+				DataURL:      req.DataURL,
+				DataType:     req.DataType,
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(fs.ListSources())
+	}
+}
+
+// handleAdminSourceAction serves POST /api/admin/sources/{action}?url=…
+// where action is disable, enable, remove (manual sources only) or
+// refresh. refresh re-downloads the source, bypassing the parse cache,
+// along with any other source that is due, and merges; the others take
+// effect with the next refresh.
+func handleAdminSourceAction(fs *federation.Store, hub *sse.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		url := r.URL.Query().Get("url")
+		if url == "" {
+			http.Error(w, "missing url parameter", http.StatusBadRequest)
+			return
+		}
+
+		var ok bool
+		switch action := strings.TrimPrefix(r.URL.Path, "/api/admin/sources/"); action {
+		case "disable", "enable":
+			ok = fs.SetSourceDisabled(url, action == "disable")
+		case "remove":
+			ok = fs.RemoveSource(url)
+		case "refresh":
+			if !fs.InvalidateSource(url) {
+				http.Error(w, "unknown source", http.StatusNotFound)
+				return
+			}
+			handleAdminRefresh(fs.Store, hub, fs.RefreshSources)(w, r)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if !ok {
+			http.Error(w, "unknown source", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(fs.ListSources())
+	}
+}
+
+// adminOnly rejects requests without admin credentials.
+func adminOnly(cfg *config.Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireAdmin(cfg, w, r) {
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAdminRefresh runs fn immediately and pushes the resulting changes
+// to SSE clients, like a refresh tick would.
+func handleAdminRefresh(s *store.Store, hub *sse.Hub, fn func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		start := time.Now()
+		old := s.GetSnapshot()
+		if err := fn(); err != nil {
+			http.Error(w, "refresh failed: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		snap := s.GetSnapshot()
+		if diff := store.ComputeDiff(old, snap); diff != nil {
+			hub.Broadcast(diff)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"nodes":       snap.Stats.TotalNodes,
+			"online":      snap.Stats.OnlineNodes,
+			"duration_ms": time.Since(start).Milliseconds(),
+		})
+	}
+}
+
+// requireAdmin checks the request's bearer token against adminToken and
+// writes an error response if it does not match. A client certificate
+// verified by the TLS listener is accepted as well. Admin APIs stay
+// disabled while neither is configured.
+func requireAdmin(cfg *config.Config, w http.ResponseWriter, r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if cfg.AdminToken == "" {
+		http.Error(w, "admin API disabled (no adminToken configured)", http.StatusForbidden)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="freifunk-map"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/aliases"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/annotations"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// RegisterAliasHandlers adds node alias management to the admin group
+// returned by RegisterAdminHandlers.
+func RegisterAliasHandlers(admin *http.ServeMux, live *config.Live, al *aliases.Store) {
+	h := handleAdminAliases(live, al)
+	admin.HandleFunc("/api/admin/aliases", h)
+	admin.HandleFunc("/api/admin/aliases/", h)
+}
+
+// handleAdminAliases lists the node aliases (GET), adds one from a JSON
+// body with from and to (POST) or removes /api/admin/aliases/{from}
+// (DELETE). Aliases of the nodeAliases setting are listed separately and
+// only change with the config.
+func handleAdminAliases(live *config.Live, al *aliases.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
+		from := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/aliases"), "/")
+		if from != "" {
+			if r.Method != http.MethodDelete {
+				w.Header().Set("Allow", "DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			found, err := al.Delete(from)
+			if err != nil {
+				http.Error(w, "saving node aliases: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "alias not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			configured := cfg.NodeAliases
+			if configured == nil {
+				configured = map[string]string{}
+			}
+			jsonResponse(w, map[string]interface{}{
+				"configured": configured,
+				"entries":    al.List(),
+			})
+		case http.MethodPost:
+			var a aliases.Alias
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&a); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			saved, err := al.Put(a, cfg.NodeAliases)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(saved)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// nodeAnnotation returns the annotation of a node or, if it has none, that
+// of a former node ID aliased to it.
+func nodeAnnotation(an *annotations.Store, aliasMap map[string]string, nodeID string) (annotations.Annotation, bool) {
+	if a, ok := an.Get(nodeID); ok {
+		return a, true
+	}
+	for _, id := range aliases.Former(aliasMap, nodeID) {
+		if a, ok := an.Get(id); ok {
+			return a, true
+		}
+	}
+	return annotations.Annotation{}, false
+}
+
+// annotationsByNode maps current node IDs to their annotations, moving
+// those of aliased IDs to the replacement unless it has its own.
+func annotationsByNode(an *annotations.Store, aliasMap map[string]string) map[string]annotations.Annotation {
+	list := an.List()
+	out := make(map[string]annotations.Annotation, len(list))
+	for _, a := range list {
+		out[a.NodeID] = a
+	}
+	for _, a := range list {
+		to := aliases.Resolve(aliasMap, a.NodeID)
+		if _, ok := out[to]; !ok {
+			out[to] = a
+		}
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/annotations"
+)
+
+// RegisterAnnotationHandlers adds node annotation management to the admin
+// group returned by RegisterAdminHandlers. Annotations are published in
+// node detail, so notes should not hold anything private.
+func RegisterAnnotationHandlers(admin *http.ServeMux, an *annotations.Store) {
+	h := handleAdminAnnotations(an)
+	admin.HandleFunc("/api/admin/annotations", h)
+	admin.HandleFunc("/api/admin/annotations/", h)
+}
+
+// handleAdminAnnotations lists all annotations (GET /api/admin/annotations)
+// and reads, replaces or removes those of one node at
+// /api/admin/annotations/{node_id}. PUT takes note, tags and maintenance
+// as JSON. The node does not need to be in the current data.
+func handleAdminAnnotations(an *annotations.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/annotations"), "/")
+		if id == "" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", "GET")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			jsonResponse(w, an.List())
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			a, ok := an.Get(id)
+			if !ok {
+				http.Error(w, "no annotation for node", http.StatusNotFound)
+				return
+			}
+			jsonResponse(w, a)
+		case http.MethodPut:
+			var a annotations.Annotation
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&a); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			a.NodeID = id
+			if err := a.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			saved, err := an.Put(a)
+			if err != nil {
+				http.Error(w, "saving annotations: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jsonResponse(w, saved)
+		case http.MethodDelete:
+			found, err := an.Delete(id)
+			if err != nil {
+				http.Error(w, "saving annotations: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "no annotation for node", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
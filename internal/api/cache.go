@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// defaultCacheControl holds the Cache-Control values of GET routes that
+// cacheControl does not override, by path prefix. An empty value stands
+// for the data routes, whose max-age follows refreshInterval.
+var defaultCacheControl = map[string]string{
+	"/api/":                       "",
+	"/compat/":                    "",
+	"/api/communities":            "public, max-age=300",
+	"/api/overlays/":              "public, max-age=300",
+	"/api/metrics/":               "public, max-age=60",
+	"/api/source":                 "no-cache",
+	"/api/federation/status":      "no-cache",
+	"/api/config":                 "no-cache",
+	"/api/reports/merged-devices": "no-cache",
+	"/api/debug/communities":      "no-cache",
+}
+
+// dataMaxAge lets clients and CDNs reuse a snapshot for half a refresh
+// interval, so no copy trails the upstream data by more than one and a
+// half intervals.
+func dataMaxAge(refresh time.Duration) string {
+	secs := int(refresh / 2 / time.Second)
+	if secs < 5 {
+		secs = 5
+	}
+	return fmt.Sprintf("public, max-age=%d", secs)
+}
+
+// cacheControlFor returns the value for path from the longest matching
+// prefix. Configured prefixes win over defaults of the same length.
+func cacheControlFor(cfg *config.Config, path string) string {
+	best, value := -1, ""
+	for prefix, v := range defaultCacheControl {
+		if len(prefix) > best && strings.HasPrefix(path, prefix) {
+			best, value = len(prefix), v
+			if v == "" {
+				value = dataMaxAge(cfg.RefreshDuration)
+			}
+		}
+	}
+	for prefix, v := range cfg.CacheControl {
+		if len(prefix) >= best && strings.HasPrefix(path, prefix) {
+			best, value = len(prefix), v
+		}
+	}
+	return value
+}
+
+// CacheHeaders sets Cache-Control on GET and HEAD responses from the
+// route's configured or default value. Handlers that must not be cached,
+// like admin and probe endpoints, set no-store themselves and win. Error
+// responses lose the value again, so CDNs do not hold on to a 404 or 503.
+func CacheHeaders(live *config.Live, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || strings.HasSuffix(r.URL.Path, "/events") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		value := cacheControlFor(cfg, r.URL.Path)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", value)
+		next.ServeHTTP(&cacheWriter{ResponseWriter: w, value: value}, r)
+	})
+}
+
+type cacheWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if code >= 400 && w.Header().Get("Cache-Control") == w.value {
+			w.Header().Del("Cache-Control")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
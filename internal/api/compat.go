@@ -0,0 +1,402 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// compatFile renders one file a stock frontend expects. base is the URL
+// of the adapter directory, for files that point back to it.
+type compatFile func(cfg *config.Config, snap *store.Snapshot, base string) interface{}
+
+// frontendAdapters maps a frontend name to the data files it loads.
+// Each enabled adapter is served below /compat/{name}/.
+var frontendAdapters = map[string]map[string]compatFile{
+	"hopglass": {
+		"nodes.json":  hopglassNodes,
+		"graph.json":  hopglassGraph,
+		"config.json": hopglassConfig,
+	},
+	"meshviewer": {
+		"meshviewer.json": meshviewerData,
+		"config.json":     meshviewerConfig,
+	},
+}
+
+// RegisterCompatHandlers serves the data files of the frontends listed in
+// compatFrontends, so communities can keep their HopGlass or Meshviewer
+// installation and point its dataPath at this server.
+func RegisterCompatHandlers(mux *http.ServeMux, live *config.Live, s *store.Store) {
+	for _, name := range live.Load().CompatFrontends {
+		files := frontendAdapters[name]
+		prefix := "/compat/" + name + "/"
+		mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+			render, ok := files[strings.TrimPrefix(r.URL.Path, prefix)]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			// Stock frontends are usually hosted on another origin.
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			// The base never comes from Host or X-Forwarded-* headers:
+			// the files are publicly cacheable, so one forged request
+			// would point every client at another server.
+			cfg := live.Load()
+			jsonResponse(w, render(cfg, s.GetSnapshot(), cfg.PublicURL+cfg.BasePath+prefix))
+		})
+	}
+}
+
+// --- HopGlass (nodes.json v2, graph.json v1) ---
+
+type hgNodes struct {
+	Version   int      `json:"version"`
+	Timestamp string   `json:"timestamp"`
+	Nodes     []hgNode `json:"nodes"`
+}
+
+type hgNode struct {
+	Firstseen  string       `json:"firstseen"`
+	Lastseen   string       `json:"lastseen"`
+	Flags      hgFlags      `json:"flags"`
+	Statistics hgStatistics `json:"statistics"`
+	Nodeinfo   hgNodeinfo   `json:"nodeinfo"`
+}
+
+type hgFlags struct {
+	Online  bool `json:"online"`
+	Gateway bool `json:"gateway"`
+}
+
+type hgStatistics struct {
+	Clients     int     `json:"clients"`
+	Uptime      float64 `json:"uptime,omitempty"`
+	LoadAvg     float64 `json:"loadavg"`
+	MemoryUsage float64 `json:"memory_usage"`
+	RootfsUsage float64 `json:"rootfs_usage"`
+	Gateway     string  `json:"gateway,omitempty"`
+}
+
+type hgNodeinfo struct {
+	NodeID   string `json:"node_id"`
+	Hostname string `json:"hostname"`
+	Network  struct {
+		MAC       string   `json:"mac"`
+		Addresses []string `json:"addresses,omitempty"`
+	} `json:"network"`
+	Location *store.RawLocation `json:"location,omitempty"`
+	Owner    *struct {
+		Contact string `json:"contact"`
+	} `json:"owner,omitempty"`
+	Software struct {
+		Firmware struct {
+			Base    string `json:"base"`
+			Release string `json:"release"`
+		} `json:"firmware"`
+		Autoupdater struct {
+			Enabled bool   `json:"enabled"`
+			Branch  string `json:"branch"`
+		} `json:"autoupdater"`
+	} `json:"software"`
+	Hardware struct {
+		Model string `json:"model,omitempty"`
+		Nproc int    `json:"nproc,omitempty"`
+	} `json:"hardware"`
+	System struct {
+		SiteCode   string `json:"site_code,omitempty"`
+		DomainCode string `json:"domain_code,omitempty"`
+		Role       string `json:"role,omitempty"`
+	} `json:"system"`
+	VPN bool `json:"vpn,omitempty"`
+}
+
+func hopglassNodes(cfg *config.Config, snap *store.Snapshot, base string) interface{} {
+	out := hgNodes{Version: 2, Timestamp: compatTimestamp(snap), Nodes: make([]hgNode, 0, len(snap.NodeList))}
+	for _, n := range snap.NodeList {
+		if n.Placeholder {
+			continue
+		}
+		hn := hgNode{
+			Firstseen: n.Firstseen,
+			Lastseen:  n.Lastseen,
+			Flags:     hgFlags{Online: n.IsOnline, Gateway: n.IsGateway},
+			Statistics: hgStatistics{
+				Clients:     n.Clients,
+				LoadAvg:     n.LoadAvg,
+				MemoryUsage: n.MemUsage,
+				RootfsUsage: n.RootfsUsage,
+				Gateway:     n.Gateway,
+			},
+		}
+		if boot, ok := store.ParseTimestamp(n.Uptime); ok {
+			hn.Statistics.Uptime = time.Since(boot).Seconds()
+		}
+		ni := &hn.Nodeinfo
+		ni.NodeID = n.NodeID
+		ni.Hostname = n.Hostname
+		ni.Network.MAC = n.MAC
+		ni.Network.Addresses = n.Addresses
+		ni.Location = compatLocation(n)
+		if n.Owner != "" {
+			ni.Owner = &struct {
+				Contact string `json:"contact"`
+			}{n.Owner}
+		}
+		ni.Software.Firmware.Base = n.FWBase
+		ni.Software.Firmware.Release = n.Firmware
+		ni.Software.Autoupdater.Enabled = n.Autoupdater
+		ni.Software.Autoupdater.Branch = n.Branch
+		ni.Hardware.Model = n.Model
+		ni.Hardware.Nproc = n.Nproc
+		ni.System.SiteCode = n.Domain
+		if n.SiteCode != "" {
+			ni.System.SiteCode, ni.System.DomainCode = n.SiteCode, n.DomainCode
+		}
+		ni.VPN = n.IsUplink
+		ni.System.Role = n.Role
+		out.Nodes = append(out.Nodes, hn)
+	}
+	return out
+}
+
+type hgGraph struct {
+	Version int `json:"version"`
+	Batadv  struct {
+		Directed   bool          `json:"directed"`
+		Multigraph bool          `json:"multigraph"`
+		Graph      []interface{} `json:"graph"`
+		Nodes      []hgGraphNode `json:"nodes"`
+		Links      []hgGraphLink `json:"links"`
+	} `json:"batadv"`
+}
+
+type hgGraphNode struct {
+	ID     string `json:"id"`
+	NodeID string `json:"node_id"`
+}
+
+type hgGraphLink struct {
+	Source   int     `json:"source"`
+	Target   int     `json:"target"`
+	TQ       float64 `json:"tq"` // batman-adv style: 1 is perfect, larger is worse
+	VPN      bool    `json:"vpn"`
+	Bidirect bool    `json:"bidirect"`
+}
+
+func hopglassGraph(cfg *config.Config, snap *store.Snapshot, base string) interface{} {
+	var g hgGraph
+	g.Version = 1
+	g.Batadv.Graph = []interface{}{}
+	g.Batadv.Nodes = []hgGraphNode{}
+	g.Batadv.Links = []hgGraphLink{}
+	index := make(map[string]int)
+	nodeIndex := func(id string) int {
+		if i, ok := index[id]; ok {
+			return i
+		}
+		i := len(g.Batadv.Nodes)
+		index[id] = i
+		gn := hgGraphNode{ID: id, NodeID: id}
+		if n, ok := snap.Nodes[id]; ok && n.MAC != "" {
+			gn.ID = n.MAC
+		}
+		g.Batadv.Nodes = append(g.Batadv.Nodes, gn)
+		return i
+	}
+	for _, l := range snap.Links {
+		if l.Orphan {
+			continue
+		}
+		tq := (l.SourceTQ + l.TargetTQ) / 2
+		inv := 1000.0
+		if tq > 0 {
+			inv = 1 / tq
+		}
+		g.Batadv.Links = append(g.Batadv.Links, hgGraphLink{
+			Source:   nodeIndex(l.Source),
+			Target:   nodeIndex(l.Target),
+			TQ:       math.Round(inv*1000) / 1000,
+			VPN:      l.Type == store.LinkTypeVPN,
+			Bidirect: l.SourceTQ > 0 && l.TargetTQ > 0,
+		})
+	}
+	return g
+}
+
+// compatMapLayer is the tile layer format shared by HopGlass and Meshviewer.
+type compatMapLayer struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Config struct {
+		MaxZoom     int    `json:"maxZoom,omitempty"`
+		Attribution string `json:"attribution,omitempty"`
+	} `json:"config"`
+}
+
+func compatMapLayers(cfg *config.Config) []compatMapLayer {
+	layers := make([]compatMapLayer, 0, len(cfg.TileLayers))
+	for _, tl := range cfg.TileLayers {
+		l := compatMapLayer{Name: tl.Name, URL: tl.URL}
+		l.Config.MaxZoom = tl.MaxZoom
+		l.Config.Attribution = tl.Attribution
+		layers = append(layers, l)
+	}
+	return layers
+}
+
+func hopglassConfig(cfg *config.Config, snap *store.Snapshot, base string) interface{} {
+	return map[string]interface{}{
+		"dataPath":  base,
+		"siteName":  cfg.SiteName,
+		"mapLayers": compatMapLayers(cfg),
+		"nodeInfos": []interface{}{},
+		"maxAge":    14,
+	}
+}
+
+// --- Meshviewer (meshviewer.json) ---
+
+type mvData struct {
+	Timestamp string          `json:"timestamp"`
+	Nodes     []mvNode        `json:"nodes"`
+	Links     []store.RawLink `json:"links"`
+}
+
+// mvNode mirrors store.RawNode with plain types for encoding.
+type mvNode struct {
+	Firstseen   string             `json:"firstseen"`
+	Lastseen    string             `json:"lastseen"`
+	IsOnline    bool               `json:"is_online"`
+	IsGateway   bool               `json:"is_gateway"`
+	Clients     int                `json:"clients"`
+	ClientsW24  int                `json:"clients_wifi24"`
+	ClientsW5   int                `json:"clients_wifi5"`
+	ClientsOth  int                `json:"clients_other"`
+	RootfsUsage float64            `json:"rootfs_usage"`
+	LoadAvg     float64            `json:"loadavg"`
+	MemoryUsage float64            `json:"memory_usage"`
+	Uptime      string             `json:"uptime"`
+	Gateway     string             `json:"gateway,omitempty"`
+	NodeID      string             `json:"node_id"`
+	MAC         string             `json:"mac"`
+	Addresses   []string           `json:"addresses"`
+	Domain      string             `json:"domain"`
+	Hostname    string             `json:"hostname"`
+	Owner       string             `json:"owner,omitempty"`
+	Location    *store.RawLocation `json:"location,omitempty"`
+	Firmware    struct {
+		Base      string `json:"base"`
+		Release   string `json:"release"`
+		ImageName string `json:"image_name,omitempty"`
+	} `json:"firmware"`
+	Autoupdater struct {
+		Enabled bool   `json:"enabled"`
+		Branch  string `json:"branch"`
+	} `json:"autoupdater"`
+	Nproc int    `json:"nproc"`
+	Model string `json:"model"`
+}
+
+func meshviewerData(cfg *config.Config, snap *store.Snapshot, base string) interface{} {
+	out := mvData{
+		Timestamp: compatTimestamp(snap),
+		Nodes:     make([]mvNode, 0, len(snap.NodeList)),
+		Links:     make([]store.RawLink, 0, len(snap.Links)),
+	}
+	for _, n := range snap.NodeList {
+		if n.Placeholder {
+			continue
+		}
+		mn := mvNode{
+			Firstseen:   n.Firstseen,
+			Lastseen:    n.Lastseen,
+			IsOnline:    n.IsOnline,
+			IsGateway:   n.IsGateway,
+			Clients:     n.Clients,
+			ClientsW24:  n.ClientsW24,
+			ClientsW5:   n.ClientsW5,
+			ClientsOth:  n.ClientsOth,
+			RootfsUsage: n.RootfsUsage,
+			LoadAvg:     n.LoadAvg,
+			MemoryUsage: n.MemUsage,
+			Uptime:      n.Uptime,
+			Gateway:     n.Gateway,
+			NodeID:      n.NodeID,
+			MAC:         n.MAC,
+			Addresses:   n.Addresses,
+			Domain:      n.Domain,
+			Hostname:    n.Hostname,
+			Owner:       n.Owner,
+			Location:    compatLocation(n),
+			Nproc:       n.Nproc,
+			Model:       n.Model,
+		}
+		if mn.Addresses == nil {
+			mn.Addresses = []string{}
+		}
+		mn.Firmware.Base = n.FWBase
+		mn.Firmware.Release = n.Firmware
+		mn.Firmware.ImageName = n.ImageName
+		mn.Autoupdater.Enabled = n.Autoupdater
+		mn.Autoupdater.Branch = n.Branch
+		out.Nodes = append(out.Nodes, mn)
+	}
+	for _, l := range snap.Links {
+		if l.Orphan {
+			continue
+		}
+		typ := l.RawType
+		if typ == "" {
+			typ = l.Type
+		}
+		out.Links = append(out.Links, store.RawLink{
+			Source:   l.Source,
+			Target:   l.Target,
+			SourceTQ: l.SourceTQ,
+			TargetTQ: l.TargetTQ,
+			Type:     typ,
+		})
+	}
+	return out
+}
+
+func meshviewerConfig(cfg *config.Config, snap *store.Snapshot, base string) interface{} {
+	domains := make([]map[string]string, 0, len(cfg.DomainNames))
+	for k, v := range cfg.DomainNames {
+		domains = append(domains, map[string]string{"domain": k, "name": v})
+	}
+	return map[string]interface{}{
+		"dataPath":        []string{base},
+		"siteName":        cfg.SiteName,
+		"mapLayers":       compatMapLayers(cfg),
+		"domainNames":     domains,
+		"maxAge":          14,
+		"nodeZoom":        18,
+		"labelZoom":       13,
+		"clientZoom":      15,
+		"nodeInfos":       []interface{}{},
+		"devicePictures":  cfg.DevicePictureURL,
+		"supportedLocale": []string{"en", "de"},
+	}
+}
+
+func compatTimestamp(snap *store.Snapshot) string {
+	return snap.Timestamp.UTC().Format(time.RFC3339)
+}
+
+func compatLocation(n *store.Node) *store.RawLocation {
+	if n.Lat == nil || n.Lng == nil {
+		return nil
+	}
+	loc := &store.RawLocation{Latitude: *n.Lat, Longitude: *n.Lng}
+	if n.Alt != nil {
+		alt := store.FlexFloat64(*n.Alt)
+		loc.Altitude = &alt
+	}
+	return loc
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// gzipMinSize is the smallest body worth compressing; below it the
+// encoding header and a round trip through the compressor cost more than
+// they save.
+const gzipMinSize = 1024
+
+// dynamicEncodings are the codings GzipHandler compresses with, in order
+// of preference. At their fastest levels zstd compresses JSON about as
+// well as brotli in less time, and both beat gzip.
+var dynamicEncodings = []string{"zstd", "br", "gzip"}
+
+// zstdEncoders reuses zstd encoders, whose buffers are too large to
+// allocate per response. The window stays within the 8 MB RFC 9659 asks
+// HTTP senders to keep to.
+var zstdEncoders = sync.Pool{New: func() any {
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest),
+		zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(8<<20))
+	return enc
+}}
+
+// GzipHandler wraps an http.Handler with compression of text-like
+// responses of at least gzipMinSize bytes, in the first of
+// dynamicEncodings the client accepts. Responses that already carry a
+// Content-Encoding, such as precompressed assets from StaticFiles, and
+// binary types like images pass through unchanged.
+func GzipHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		accept := r.Header.Get("Accept-Encoding")
+		enc := ""
+		for _, e := range dynamicEncodings {
+			if acceptsEncoding(accept, e) {
+				enc = e
+				break
+			}
+		}
+		if enc == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, encoding: enc}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressibleType reports whether a Content-Type is text that compression
+// shrinks; media types with their own compression are left alone.
+func compressibleType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case strings.HasPrefix(mt, "text/"), strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/javascript", "application/xml", "application/wasm":
+		return true
+	}
+	return false
+}
+
+// compressResponseWriter holds back the status line until it knows
+// whether to compress: right away if the handler set Content-Encoding, an
+// incompressible Content-Type or a small Content-Length, otherwise once
+// gzipMinSize bytes are buffered or the handler returns.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding    string // Content-Encoding to compress with
+	code        int
+	wroteHeader bool
+	pending     bool // deciding; body is collected in buf
+	buf         []byte
+	cw          io.WriteCloser
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.code = code
+	h := w.Header()
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if ct := h.Get("Content-Type"); ct != "" && !compressibleType(ct) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < gzipMinSize {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.pending = true
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.cw != nil {
+		return w.cw.Write(b)
+	}
+	if !w.pending {
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= gzipMinSize {
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the header and the buffered body, compressed if the
+// content type allows it and enough of the body has been seen.
+func (w *compressResponseWriter) decide() error {
+	w.pending = false
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	var err error
+	if len(w.buf) >= gzipMinSize && compressibleType(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.code)
+		w.cw = newEncoder(w.encoding, w.ResponseWriter)
+		_, err = w.cw.Write(w.buf)
+	} else {
+		w.ResponseWriter.WriteHeader(w.code)
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+func (w *compressResponseWriter) close() {
+	if w.pending {
+		w.decide()
+	}
+	if w.cw != nil {
+		w.cw.Close()
+	}
+}
+
+// newEncoder returns a compressor for encoding writing to w, at the
+// fastest level since every response is compressed anew.
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case "zstd":
+		enc := zstdEncoders.Get().(*zstd.Encoder)
+		enc.Reset(w)
+		return &pooledZstd{enc}
+	case "br":
+		return brotli.NewWriterLevel(w, brotli.BestSpeed)
+	}
+	gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+	return gz
+}
+
+// pooledZstd returns its encoder to zstdEncoders when closed.
+type pooledZstd struct {
+	*zstd.Encoder
+}
+
+func (z *pooledZstd) Close() error {
+	err := z.Encoder.Close()
+	zstdEncoders.Put(z.Encoder)
+	return err
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestGzipHandlerNegotiates(t *testing.T) {
+	body := strings.Repeat(`{"node":"example"},`, 200)
+	h := GzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	for accept, want := range map[string]string{
+		"gzip, deflate, br, zstd": "zstd",
+		"gzip, br":                "br",
+		"gzip, zstd;q=0":          "gzip",
+		"identity":                "",
+	} {
+		req := httptest.NewRequest("GET", "/api/nodes", nil)
+		req.Header.Set("Accept-Encoding", accept)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Content-Encoding"); got != want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", accept, got, want)
+			continue
+		}
+		var r io.Reader = rec.Body
+		switch want {
+		case "zstd":
+			d, err := zstd.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			r = d
+		case "br":
+			r = brotli.NewReader(rec.Body)
+		case "gzip":
+			gz, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || string(got) != body {
+			t.Errorf("Accept-Encoding %q: body mismatch (err %v)", accept, err)
+		}
+	}
+}
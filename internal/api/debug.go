@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// started is the process start time reported on /debug/vars.
+var started = time.Now()
+
+// RegisterDebugHandlers mounts net/http/pprof below /debug/pprof/ and
+// runtime statistics on /debug/vars when debugEndpoints is set. Both
+// require admin credentials: profiles reveal memory contents.
+func RegisterDebugHandlers(mux *http.ServeMux, cfg *config.Config) {
+	if !cfg.DebugEndpoints {
+		return
+	}
+	dbg := http.NewServeMux()
+	dbg.HandleFunc("/debug/pprof/", pprof.Index)
+	dbg.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	dbg.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	dbg.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	dbg.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	dbg.HandleFunc("/debug/vars", handleDebugVars)
+	mux.Handle("/debug/", adminOnly(cfg, dbg))
+}
+
+type debugVars struct {
+	Goroutines    int     `json:"goroutines"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	UptimeSeconds int64   `json:"uptime_seconds"`
+	HeapAlloc     uint64  `json:"heap_alloc_bytes"`
+	HeapInuse     uint64  `json:"heap_inuse_bytes"`
+	HeapObjects   uint64  `json:"heap_objects"`
+	HeapReleased  uint64  `json:"heap_released_bytes"`
+	Sys           uint64  `json:"sys_bytes"`
+	TotalAlloc    uint64  `json:"total_alloc_bytes"`
+	NumGC         uint32  `json:"num_gc"`
+	LastGC        string  `json:"last_gc,omitempty"`
+	PauseTotalMS  float64 `json:"gc_pause_total_ms"`
+}
+
+func handleDebugVars(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	v := debugVars{
+		Goroutines:    runtime.NumGoroutine(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		UptimeSeconds: int64(time.Since(started).Seconds()),
+		HeapAlloc:     ms.HeapAlloc,
+		HeapInuse:     ms.HeapInuse,
+		HeapObjects:   ms.HeapObjects,
+		HeapReleased:  ms.HeapReleased,
+		Sys:           ms.Sys,
+		TotalAlloc:    ms.TotalAlloc,
+		NumGC:         ms.NumGC,
+		PauseTotalMS:  float64(ms.PauseTotalNs) / 1e6,
+	}
+	if ms.LastGC > 0 {
+		v.LastGC = time.Unix(0, int64(ms.LastGC)).UTC().Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
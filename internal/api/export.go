@@ -0,0 +1,277 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// linkWeight is the edge weight used in exports: the mean TQ of both directions.
+func linkWeight(l store.Link) float64 {
+	return (l.SourceTQ + l.TargetTQ) / 2
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+func handleExportDOT(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="graph.dot"`)
+
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+
+		fmt.Fprintln(bw, "graph mesh {")
+		fmt.Fprintln(bw, "  node [shape=circle];")
+		for _, n := range snap.NodeList {
+			// Graphviz sizes are in inches; keep nodes readable at both ends.
+			size := 0.2 + float64(n.Clients)*0.02
+			if size > 1.5 {
+				size = 1.5
+			}
+			fmt.Fprintf(bw, "  %s [label=%s, clients=%d, online=%t, gateway=%t, domain=%s, community=%s, width=%.2f];\n",
+				dotQuote(n.NodeID), dotQuote(n.Hostname), n.Clients, n.IsOnline, n.IsGateway,
+				dotQuote(n.Domain), dotQuote(n.Community), size)
+		}
+		for _, l := range snap.Links {
+			fmt.Fprintf(bw, "  %s -- %s [weight=%s, type=%s, distance=%.0f];\n",
+				dotQuote(l.Source), dotQuote(l.Target),
+				strconv.FormatFloat(linkWeight(l), 'f', 3, 64), dotQuote(l.Type), l.Distance)
+		}
+		fmt.Fprintln(bw, "}")
+	}
+}
+
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	_ = xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+func handleExportGraphML(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+
+		w.Header().Set("Content-Type", "application/graphml+xml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="graph.graphml"`)
+
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+
+		fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+		fmt.Fprintln(bw, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+		fmt.Fprintln(bw, `  <key id="hostname" for="node" attr.name="hostname" attr.type="string"/>`)
+		fmt.Fprintln(bw, `  <key id="clients" for="node" attr.name="clients" attr.type="int"/>`)
+		fmt.Fprintln(bw, `  <key id="online" for="node" attr.name="online" attr.type="boolean"/>`)
+		fmt.Fprintln(bw, `  <key id="gateway" for="node" attr.name="gateway" attr.type="boolean"/>`)
+		fmt.Fprintln(bw, `  <key id="domain" for="node" attr.name="domain" attr.type="string"/>`)
+		fmt.Fprintln(bw, `  <key id="community" for="node" attr.name="community" attr.type="string"/>`)
+		fmt.Fprintln(bw, `  <key id="lat" for="node" attr.name="lat" attr.type="double"/>`)
+		fmt.Fprintln(bw, `  <key id="lng" for="node" attr.name="lng" attr.type="double"/>`)
+		fmt.Fprintln(bw, `  <key id="alt" for="node" attr.name="alt" attr.type="double"/>`)
+		fmt.Fprintln(bw, `  <key id="weight" for="edge" attr.name="weight" attr.type="double"/>`)
+		fmt.Fprintln(bw, `  <key id="type" for="edge" attr.name="type" attr.type="string"/>`)
+		fmt.Fprintln(bw, `  <key id="distance" for="edge" attr.name="distance" attr.type="double"/>`)
+		fmt.Fprintln(bw, `  <graph id="mesh" edgedefault="undirected">`)
+
+		for _, n := range snap.NodeList {
+			fmt.Fprintf(bw, `    <node id="%s">`, xmlEscape(n.NodeID))
+			fmt.Fprintf(bw, `<data key="hostname">%s</data>`, xmlEscape(n.Hostname))
+			fmt.Fprintf(bw, `<data key="clients">%d</data>`, n.Clients)
+			fmt.Fprintf(bw, `<data key="online">%t</data>`, n.IsOnline)
+			fmt.Fprintf(bw, `<data key="gateway">%t</data>`, n.IsGateway)
+			if n.Domain != "" {
+				fmt.Fprintf(bw, `<data key="domain">%s</data>`, xmlEscape(n.Domain))
+			}
+			if n.Community != "" {
+				fmt.Fprintf(bw, `<data key="community">%s</data>`, xmlEscape(n.Community))
+			}
+			if n.Lat != nil {
+				fmt.Fprintf(bw, `<data key="lat">%f</data><data key="lng">%f</data>`, *n.Lat, *n.Lng)
+				if n.Alt != nil {
+					fmt.Fprintf(bw, `<data key="alt">%f</data>`, *n.Alt)
+				}
+			}
+			fmt.Fprintln(bw, `</node>`)
+		}
+
+		for i, l := range snap.Links {
+			// GraphML requires both endpoints to be declared nodes.
+			if _, ok := snap.Nodes[l.Source]; !ok {
+				continue
+			}
+			if _, ok := snap.Nodes[l.Target]; !ok {
+				continue
+			}
+			fmt.Fprintf(bw, `    <edge id="e%d" source="%s" target="%s">`, i, xmlEscape(l.Source), xmlEscape(l.Target))
+			fmt.Fprintf(bw, `<data key="weight">%.3f</data>`, linkWeight(l))
+			fmt.Fprintf(bw, `<data key="type">%s</data>`, xmlEscape(l.Type))
+			fmt.Fprintf(bw, `<data key="distance">%.0f</data>`, l.Distance)
+			fmt.Fprintln(bw, `</edge>`)
+		}
+
+		fmt.Fprintln(bw, `  </graph>`)
+		fmt.Fprintln(bw, `</graphml>`)
+	}
+}
+
+// nodeAltitude returns the node's altitude in meters, or 0 when unknown.
+func nodeAltitude(n *store.Node) float64 {
+	if n.Alt == nil {
+		return 0
+	}
+	return *n.Alt
+}
+
+func handleExportKML(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+
+		w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="nodes.kml"`)
+
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+
+		fmt.Fprintln(bw, `<?xml version="1.0" encoding="UTF-8"?>`)
+		fmt.Fprintln(bw, `<kml xmlns="http://www.opengis.net/kml/2.2">`)
+		fmt.Fprintf(bw, "<Document><name>%s</name>\n", xmlEscape(s.Cfg().SiteName))
+		fmt.Fprintln(bw, `<Style id="online"><IconStyle><color>ff00c853</color></IconStyle></Style>`)
+		fmt.Fprintln(bw, `<Style id="offline"><IconStyle><color>ff5252ff</color></IconStyle></Style>`)
+		fmt.Fprintln(bw, `<Style id="link"><LineStyle><color>b0ffffff</color><width>2</width></LineStyle></Style>`)
+
+		fmt.Fprintln(bw, "<Folder><name>Nodes</name>")
+		for _, n := range snap.NodeList {
+			if n.Lat == nil {
+				continue
+			}
+			style := "offline"
+			if n.IsOnline {
+				style = "online"
+			}
+			// Absolute altitude, as reported by the node, so antennas sit at
+			// their real height instead of being clamped to the terrain.
+			altMode := "clampToGround"
+			if n.Alt != nil {
+				altMode = "absolute"
+			}
+			fmt.Fprintf(bw, "<Placemark id=\"%s\"><name>%s</name><styleUrl>#%s</styleUrl>", xmlEscape(n.NodeID), xmlEscape(n.Hostname), style)
+			fmt.Fprintf(bw, "<description>%s, %d clients</description>", xmlEscape(n.Model), n.Clients)
+			fmt.Fprintf(bw, "<Point><altitudeMode>%s</altitudeMode><coordinates>%f,%f,%.1f</coordinates></Point></Placemark>\n",
+				altMode, *n.Lng, *n.Lat, nodeAltitude(n))
+		}
+		fmt.Fprintln(bw, "</Folder>")
+
+		fmt.Fprintln(bw, "<Folder><name>Links</name>")
+		for _, l := range snap.Links {
+			sn, tn := snap.Nodes[l.Source], snap.Nodes[l.Target]
+			if sn == nil || tn == nil || sn.Lat == nil || tn.Lat == nil {
+				continue
+			}
+			altMode := "clampToGround"
+			if sn.Alt != nil && tn.Alt != nil {
+				altMode = "absolute"
+			}
+			fmt.Fprintf(bw, "<Placemark><name>%s – %s</name><styleUrl>#link</styleUrl>", xmlEscape(sn.Hostname), xmlEscape(tn.Hostname))
+			fmt.Fprintf(bw, "<LineString><altitudeMode>%s</altitudeMode><coordinates>%f,%f,%.1f %f,%f,%.1f</coordinates></LineString></Placemark>\n",
+				altMode, *sn.Lng, *sn.Lat, nodeAltitude(sn), *tn.Lng, *tn.Lat, nodeAltitude(tn))
+		}
+		fmt.Fprintln(bw, "</Folder>")
+		fmt.Fprintln(bw, "</Document></kml>")
+	}
+}
+
+// czmlPacket is one entry of a CZML document (Cesium's JSON scene format).
+type czmlPacket struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name,omitempty"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Position    *czmlPosition `json:"position,omitempty"`
+	Point       *czmlPoint    `json:"point,omitempty"`
+	Polyline    *czmlPolyline `json:"polyline,omitempty"`
+}
+
+type czmlPosition struct {
+	CartographicDegrees []float64 `json:"cartographicDegrees"`
+}
+
+type czmlColor struct {
+	RGBA [4]int `json:"rgba"`
+}
+
+type czmlPoint struct {
+	Color     czmlColor `json:"color"`
+	PixelSize int       `json:"pixelSize"`
+}
+
+type czmlPolyline struct {
+	Positions czmlPosition `json:"positions"`
+	Width     float64      `json:"width"`
+	Material  struct {
+		SolidColor struct {
+			Color czmlColor `json:"color"`
+		} `json:"solidColor"`
+	} `json:"material"`
+}
+
+func handleExportCZML(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+
+		packets := []czmlPacket{{ID: "document", Name: s.Cfg().SiteName, Version: "1.0"}}
+		for _, n := range snap.NodeList {
+			if n.Lat == nil {
+				continue
+			}
+			color := czmlColor{RGBA: [4]int{255, 82, 82, 255}}
+			if n.IsOnline {
+				color = czmlColor{RGBA: [4]int{0, 200, 83, 255}}
+			}
+			packets = append(packets, czmlPacket{
+				ID:          n.NodeID,
+				Name:        n.Hostname,
+				Description: fmt.Sprintf("%s, %d clients", n.Model, n.Clients),
+				Position:    &czmlPosition{CartographicDegrees: []float64{*n.Lng, *n.Lat, nodeAltitude(n)}},
+				Point:       &czmlPoint{Color: color, PixelSize: 8},
+			})
+		}
+		for _, l := range snap.Links {
+			sn, tn := snap.Nodes[l.Source], snap.Nodes[l.Target]
+			if sn == nil || tn == nil || sn.Lat == nil || tn.Lat == nil {
+				continue
+			}
+			pl := &czmlPolyline{
+				Positions: czmlPosition{CartographicDegrees: []float64{
+					*sn.Lng, *sn.Lat, nodeAltitude(sn), *tn.Lng, *tn.Lat, nodeAltitude(tn),
+				}},
+				Width: 2,
+			}
+			// Fade from red to green with link quality.
+			q := math.Max(0, math.Min(1, linkWeight(l)))
+			pl.Material.SolidColor.Color = czmlColor{RGBA: [4]int{int(255 * (1 - q)), int(255 * q), 0, 200}}
+			packets = append(packets, czmlPacket{
+				ID:       l.Source + "-" + l.Target,
+				Polyline: pl,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="nodes.czml"`)
+		json.NewEncoder(w).Encode(packets)
+	}
+}
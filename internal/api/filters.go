@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/filters"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// RegisterFilterHandlers registers the saved filter API. Reads are public,
+// writes require the admin token.
+func RegisterFilterHandlers(mux *http.ServeMux, cfg *config.Config, s *store.Store, fl *filters.Store) {
+	h := handleFilters(cfg, s, fl)
+	mux.HandleFunc("/api/filters", h)
+	mux.HandleFunc("/api/filters/", h)
+}
+
+func handleFilters(cfg *config.Config, s *store.Store, fl *filters.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/filters"), "/")
+		id, sub, _ := strings.Cut(rest, "/")
+
+		if id == "" {
+			switch r.Method {
+			case http.MethodGet:
+				jsonResponse(w, fl.List())
+			case http.MethodPost:
+				if requireAdmin(cfg, w, r) {
+					putFilter(w, r, fl, "")
+				}
+			default:
+				w.Header().Set("Allow", "GET, POST")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		if sub == "nodes" && r.Method == http.MethodGet {
+			f, ok := fl.Get(id)
+			if !ok {
+				http.Error(w, "filter not found", http.StatusNotFound)
+				return
+			}
+			jsonResponse(w, f.Apply(s.GetSnapshot()))
+			return
+		}
+		if sub != "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			f, ok := fl.Get(id)
+			if !ok {
+				http.Error(w, "filter not found", http.StatusNotFound)
+				return
+			}
+			jsonResponse(w, f)
+		case http.MethodPut:
+			if requireAdmin(cfg, w, r) {
+				putFilter(w, r, fl, id)
+			}
+		case http.MethodDelete:
+			if !requireAdmin(cfg, w, r) {
+				return
+			}
+			found, err := fl.Delete(id)
+			if err != nil {
+				http.Error(w, "saving filters: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "filter not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// putFilter decodes a filter from the body and stores it. For PUT the ID
+// comes from the path; for POST an existing ID is a conflict.
+func putFilter(w http.ResponseWriter, r *http.Request, fl *filters.Store, id string) {
+	var f filters.Filter
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&f); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := http.StatusOK
+	if id != "" {
+		f.ID = id
+	}
+	if err := f.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id == "" {
+		if _, exists := fl.Get(f.ID); exists {
+			http.Error(w, "filter already exists", http.StatusConflict)
+			return
+		}
+		status = http.StatusCreated
+	}
+	saved, err := fl.Put(f)
+	if err != nil {
+		http.Error(w, "saving filters: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(saved)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/flight"
+)
+
+const (
+	renderCacheTTL      = 5 * time.Minute
+	renderCacheErrorTTL = 30 * time.Second
+	renderCacheEntries  = 500
+	maxRenderBytes      = 5 << 20
+)
+
+// renderImagesForNode returns the Grafana render templates of the first
+// community of nodeID that has any, with the node ID Grafana knows.
+func renderImagesForNode(fs *federation.Store, nodeID string) (federation.GrafanaInfo, string) {
+	_, originalID := fs.GrafanaInfoForNode(nodeID)
+	cache := fs.GetGrafanaCache()
+	for _, ck := range fs.CommunitiesForNode(nodeID) {
+		if info, ok := cache[ck]; ok && len(info.RenderImages) > 0 {
+			return info, originalID
+		}
+	}
+	return federation.GrafanaInfo{}, originalID
+}
+
+// renderPanelNames lists the panels /api/grafana-render/ serves for
+// nodeID, by the names the community's meshviewer gives them.
+func renderPanelNames(fs *federation.Store, nodeID string) []string {
+	if fs == nil {
+		return nil
+	}
+	info, _ := renderImagesForNode(fs, nodeID)
+	names := make([]string, len(info.RenderImages))
+	for i, img := range info.RenderImages {
+		names[i] = img.Name
+	}
+	return names
+}
+
+// handleGrafanaRender serves the Grafana panel images that a community's
+// meshviewer config links for its nodes. Fetching them here avoids mixed
+// content and keeps the browser away from community Grafana hosts; panels
+// are addressed by index or name.
+func handleGrafanaRender(live *config.Live, fs *federation.Store) http.HandlerFunc {
+	client := grafanaClient(live)
+	// Rendering takes Grafana seconds; viewers of one node share it.
+	var inflight flight.Group
+	cache := newResponseCache(renderCacheTTL, renderCacheErrorTTL, renderCacheEntries)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/grafana-render/"), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			http.Error(w, "node_id and panel required", http.StatusBadRequest)
+			return
+		}
+		nodeID, panel := parts[0], parts[1]
+		if fs.IsHidden(nodeID) {
+			http.Error(w, "node not found", http.StatusNotFound)
+			return
+		}
+
+		info, originalID := renderImagesForNode(fs, nodeID)
+		idx, err := strconv.Atoi(panel)
+		if err != nil {
+			idx = -1
+			for i, img := range info.RenderImages {
+				if strings.EqualFold(img.Name, panel) {
+					idx = i
+					break
+				}
+			}
+		}
+		if idx < 0 || idx >= len(info.RenderImages) {
+			http.Error(w, "panel not found", http.StatusNotFound)
+			return
+		}
+		if !validNodeID(originalID) {
+			http.Error(w, "invalid node_id", http.StatusBadRequest)
+			return
+		}
+
+		hostname := ""
+		if n, ok := fs.GetSnapshot().Nodes[nodeID]; ok {
+			hostname = n.Hostname
+		}
+		tpl := strings.NewReplacer(
+			"{TIME}", strconv.FormatInt(time.Now().Unix(), 10),
+			"{LOCALE}", "en",
+		).Replace(info.RenderImages[idx].Image)
+		target := expandDashboardURL(info.BaseURL, tpl, originalID, hostname, 0)
+		u, err := url.Parse(target)
+		if target == "" || err != nil {
+			http.Error(w, "invalid Grafana render URL", http.StatusBadGateway)
+			return
+		}
+		if err := federation.GrafanaTargetAllowed(cfg, u); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		key := nodeID + "|" + strconv.Itoa(idx)
+		body, ok := cache.get(key)
+		if !ok {
+			v, err, _ := inflight.Do(key, func() (interface{}, error) {
+				// Shared by other callers, so one leaving must not cancel it.
+				return fetchRender(context.WithoutCancel(r.Context()), client, target)
+			})
+			if err == nil {
+				body = v.([]byte)
+			}
+			cache.put(key, body, err != nil)
+		}
+		if body == nil {
+			http.Error(w, "Grafana render failed", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(body))
+		w.Write(body)
+	}
+}
+
+// fetchRender downloads a rendered panel and insists on an image, so an
+// error page or login form is never passed on as one.
+func fetchRender(ctx context.Context, client *http.Client, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/png,image/*")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRenderBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxRenderBytes {
+		return nil, fmt.Errorf("%s: render larger than %d bytes", req.URL.Host, maxRenderBytes)
+	}
+	if !strings.HasPrefix(http.DetectContentType(body), "image/") {
+		return nil, fmt.Errorf("%s: render is not an image", req.URL.Host)
+	}
+	return body, nil
+}
@@ -1,91 +1,224 @@
 package api
 
 import (
-	"compress/gzip"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/freifunkMUC/freifunk-map-modern/internal/aliases"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/annotations"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/flight"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/i18n"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/sse"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
-	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/timeseries"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/version"
 )
 
-// GzipHandler wraps an http.Handler with gzip compression.
-func GzipHandler(next http.Handler) http.Handler {
+// WithBasePath serves next below base (e.g. "/map"), with the prefix
+// stripped, and redirects base itself to base + "/" so relative asset and
+// API URLs in the page resolve.
+func WithBasePath(base string, next http.Handler) http.Handler {
+	stripped := http.StripPrefix(base, next)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, "/events") {
-			next.ServeHTTP(w, r)
-			return
-		}
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next.ServeHTTP(w, r)
-			return
+		switch {
+		case r.URL.Path == base:
+			u := base + "/"
+			if r.URL.RawQuery != "" {
+				u += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, u, http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, base+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
 		}
-
-		w.Header().Set("Content-Encoding", "gzip")
-		w.Header().Del("Content-Length")
-
-		gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
-		defer gz.Close()
-
-		gzw := &gzipResponseWriter{Writer: gz, ResponseWriter: w}
-		next.ServeHTTP(gzw, r)
 	})
 }
 
-type gzipResponseWriter struct {
-	io.Writer
-	http.ResponseWriter
-}
-
-func (w *gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
-}
-
-// RegisterHandlers registers core API routes.
-func RegisterHandlers(mux *http.ServeMux, cfg *config.Config, s *store.Store, hub *sse.Hub) {
-	mux.HandleFunc("/api/nodes", handleNodes(s))
-	mux.HandleFunc("/api/nodes/", handleNodeDetail(s))
+// RegisterHandlers registers core API routes. fedStore is nil in
+// single-community mode.
+func RegisterHandlers(mux *http.ServeMux, live *config.Live, s *store.Store, hub *sse.Hub, fedStore *federation.Store, an *annotations.Store, al *aliases.Store) {
+	mux.HandleFunc("/api/nodes", handleNodes(live, s, an, al))
+	mux.HandleFunc("/api/nodes/", handleNodeDetail(live, s, fedStore, an, al))
 	mux.HandleFunc("/api/links", handleLinks(s))
 	mux.HandleFunc("/api/stats", handleStats(s))
-	mux.HandleFunc("/api/config", handleClientConfig(cfg))
+	mux.HandleFunc("/api/stats/links", handleLinkStats(s))
+	mux.HandleFunc("/api/stats/channels", handleChannelStats(s))
+	mux.HandleFunc("/api/health/network", handleNetworkHealth(s))
+	mux.HandleFunc("/api/mesh-health", handleMeshHealth(s))
+	mux.HandleFunc("/api/config", handleClientConfig(live, s))
 	mux.HandleFunc("/api/events", sse.HandleSSE(hub))
+	mux.HandleFunc("/api/export/graph.dot", handleExportDOT(s))
+	mux.HandleFunc("/api/export/graph.graphml", handleExportGraphML(s))
+	mux.HandleFunc("/api/export/nodes.kml", handleExportKML(s))
+	mux.HandleFunc("/api/export/nodes.czml", handleExportCZML(s))
+	mux.HandleFunc("/api/reports/duplicate-hostnames", handleDuplicateHostnames(s))
+	mux.HandleFunc("/api/version", handleVersion)
+	mux.HandleFunc("/api/i18n/", handleI18n(live))
+	mux.HandleFunc("/healthz", HandleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz(s))
 }
 
 // RegisterFederationHandlers registers federation-specific routes.
-func RegisterFederationHandlers(mux *http.ServeMux, cfg *config.Config, fs *federation.Store) {
+func RegisterFederationHandlers(mux *http.ServeMux, live *config.Live, fs *federation.Store, al *aliases.Store) {
 	mux.HandleFunc("/api/communities", handleCommunities(fs))
-	mux.HandleFunc("/api/metrics/", handleNodeMetrics(cfg, fs))
+	mux.HandleFunc("/api/federation/status", handleFederationStatus(fs))
+	mux.HandleFunc("/api/metrics/", handleNodeMetrics(live, fs.Store, fs, al))
+	mux.HandleFunc("/api/grafana-render/", handleGrafanaRender(live, fs))
+	mux.HandleFunc("/api/metrics/aggregate", handleAggregateMetrics(live, fs.Store, fs))
 	mux.HandleFunc("/api/debug/communities", handleDebugCommunities(fs))
+	mux.HandleFunc("/api/reports/merged-devices", handleMergedDevices(fs))
+}
+
+// RegisterMetricsHandler registers the metrics routes for single-community mode.
+func RegisterMetricsHandler(mux *http.ServeMux, live *config.Live, s *store.Store, al *aliases.Store) {
+	mux.HandleFunc("/api/metrics/", handleNodeMetrics(live, s, nil, al))
+	mux.HandleFunc("/api/metrics/aggregate", handleAggregateMetrics(live, s, nil))
 }
 
-// RegisterMetricsHandler registers the metrics route for single-community mode.
-func RegisterMetricsHandler(mux *http.ServeMux, cfg *config.Config) {
-	mux.HandleFunc("/api/metrics/", handleNodeMetrics(cfg, nil))
+// RegisterSourceHandler registers the data source status route for
+// single-community mode.
+func RegisterSourceHandler(mux *http.ServeMux, s *store.Store) {
+	mux.HandleFunc("/api/source", handleSourceStatus(s))
+}
+
+// handleI18n serves /api/i18n/{lang}.json. Unknown languages get the
+// fallback catalog; its lang field tells the client what it received.
+func handleI18n(live *config.Live) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
+		lang, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/i18n/"), ".json")
+		if !ok || !i18n.ValidLang(lang) {
+			http.NotFound(w, r)
+			return
+		}
+		c, err := i18n.Load(cfg.I18nDir, lang)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jsonResponse(w, c)
+	}
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, version.Get())
+}
+
+// HandleHealthz is the liveness probe: it answers as long as the process
+// serves HTTP.
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, "ok\n")
+}
+
+// handleReadyz is the readiness probe: 503 until a snapshot with nodes is
+// loaded and while its data is older than dataStaleAfter, so load
+// balancers skip an instance serving an empty or ancient map.
+func handleReadyz(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.CurrentStats()
+		resp := struct {
+			Ready          bool   `json:"ready"`
+			Reason         string `json:"reason,omitempty"`
+			Nodes          int    `json:"nodes"`
+			DataAgeSeconds int64  `json:"data_age_seconds"`
+		}{Ready: true, Nodes: st.TotalNodes, DataAgeSeconds: st.DataAgeSeconds}
+		switch {
+		case st.TotalNodes == 0:
+			resp.Ready, resp.Reason = false, "no node data loaded"
+		case st.Stale:
+			resp.Ready, resp.Reason = false, "data older than dataStaleAfter"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !resp.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
 }
 
 func jsonResponse(w http.ResponseWriter, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "public, max-age=30")
 	json.NewEncoder(w).Encode(v)
 }
 
-func handleNodes(s *store.Store) http.HandlerFunc {
+// handleNodes serves all nodes; ?role= (comma-separated) limits them to
+// the given Gluon roles, where "node" includes nodes without a role.
+// ?tag= (comma-separated, any matches) and ?maintenance=true filter by
+// annotation, including annotations of aliased former node IDs.
+func handleNodes(live *config.Live, s *store.Store, an *annotations.Store, al *aliases.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
 		snap := s.GetSnapshot()
-		jsonResponse(w, snap.NodeList)
+		q := r.URL.Query()
+		roles, tags := q.Get("role"), q.Get("tag")
+		maintenance := q.Get("maintenance") == "true"
+		if roles == "" && tags == "" && !maintenance {
+			jsonResponse(w, snap.NodeList)
+			return
+		}
+		want := make(map[string]bool)
+		for _, role := range strings.Split(roles, ",") {
+			want[strings.TrimSpace(role)] = true
+		}
+		var wantTags []string
+		for _, t := range strings.Split(tags, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				wantTags = append(wantTags, t)
+			}
+		}
+		var annotated map[string]annotations.Annotation
+		if tags != "" || maintenance {
+			annotated = annotationsByNode(an, al.Map(cfg.NodeAliases))
+		}
+		nodes := make([]*store.Node, 0)
+		for _, n := range snap.NodeList {
+			if roles != "" && !want[n.RoleOrDefault()] {
+				continue
+			}
+			a := annotated[n.NodeID]
+			if maintenance && !a.Maintenance {
+				continue
+			}
+			if len(wantTags) > 0 && !hasAnyTag(a.Tags, wantTags) {
+				continue
+			}
+			nodes = append(nodes, n)
+		}
+		jsonResponse(w, nodes)
 	}
 }
 
-func handleNodeDetail(s *store.Store) http.HandlerFunc {
+func hasAnyTag(tags, want []string) bool {
+	for _, t := range want {
+		if annotations.HasTag(tags, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleNodeDetail serves /api/nodes/{id}. The ID of replaced hardware
+// resolves to its successor once that is in the data, so permalinks keep
+// working.
+func handleNodeDetail(live *config.Live, s *store.Store, fedStore *federation.Store, an *annotations.Store, al *aliases.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
 		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/nodes/"), "/")
 		if len(parts) == 0 || parts[0] == "" {
 			http.Error(w, "node_id required", http.StatusBadRequest)
@@ -94,12 +227,32 @@ func handleNodeDetail(s *store.Store) http.HandlerFunc {
 		nodeID := parts[0]
 
 		snap := s.GetSnapshot()
+		aliasMap := al.Map(cfg.NodeAliases)
+		if to := aliases.Resolve(aliasMap, nodeID); to != nodeID {
+			if _, ok := snap.Nodes[to]; ok {
+				nodeID = to
+			}
+		}
 		node, ok := snap.Nodes[nodeID]
 		if !ok {
 			http.Error(w, "node not found", http.StatusNotFound)
 			return
 		}
 
+		if len(parts) > 1 && parts[1] == "neighbourhood" {
+			depth := 2
+			if v := r.URL.Query().Get("depth"); v != "" {
+				d, err := strconv.Atoi(v)
+				if err != nil || d < 1 {
+					http.Error(w, "invalid depth", http.StatusBadRequest)
+					return
+				}
+				depth = d
+			}
+			jsonResponse(w, store.Neighbourhood(snap, nodeID, depth))
+			return
+		}
+
 		type NeighbourInfo struct {
 			NodeID   string  `json:"node_id"`
 			Hostname string  `json:"hostname"`
@@ -107,25 +260,50 @@ func handleNodeDetail(s *store.Store) http.HandlerFunc {
 			LinkType string  `json:"link_type,omitempty"`
 			TQ       float64 `json:"tq,omitempty"`
 			Distance float64 `json:"distance,omitempty"`
+			// AltDiff is the neighbour's altitude minus this node's, in
+			// meters, when both are known.
+			AltDiff  *float64 `json:"alt_diff,omitempty"`
+			Flapping bool     `json:"flapping,omitempty"`
 		}
 
 		type NodeDetail struct {
 			*store.Node
-			NeighbourDetails []NeighbourInfo `json:"neighbour_details"`
+			NeighbourDetails []NeighbourInfo         `json:"neighbour_details"`
+			VPN              *store.VPNPeer          `json:"vpn,omitempty"`
+			Annotation       *annotations.Annotation `json:"annotation,omitempty"`
+			Aliases          []string                `json:"aliases,omitempty"` // former node IDs
+			GrafanaDashboard string                  `json:"grafana_dashboard_url,omitempty"`
+			GrafanaPanels    []string                `json:"grafana_panels,omitempty"`
 		}
 
-		detail := NodeDetail{Node: node}
+		detail := NodeDetail{
+			Node:             node,
+			GrafanaDashboard: grafanaDashboardURL(cfg, fedStore, node),
+			GrafanaPanels:    renderPanelNames(fedStore, nodeID),
+			Aliases:          aliases.Former(aliasMap, nodeID),
+		}
+		if vp, ok := s.VPNPeer(nodeID); ok {
+			detail.VPN = &vp
+		}
+		if a, ok := nodeAnnotation(an, aliasMap, nodeID); ok {
+			detail.Annotation = &a
+		}
 		for _, nid := range node.Neighbours {
 			ni := NeighbourInfo{NodeID: nid}
 			if nn, ok := snap.Nodes[nid]; ok {
 				ni.Hostname = nn.Hostname
 				ni.IsOnline = nn.IsOnline
+				if nn.Alt != nil && node.Alt != nil {
+					d := *nn.Alt - *node.Alt
+					ni.AltDiff = &d
+				}
 			}
 			for _, l := range snap.Links {
 				if (l.Source == nodeID && l.Target == nid) || (l.Target == nodeID && l.Source == nid) {
 					ni.LinkType = l.Type
 					ni.TQ = (l.SourceTQ + l.TargetTQ) / 2
 					ni.Distance = l.Distance
+					ni.Flapping = l.Flapping
 					break
 				}
 			}
@@ -139,14 +317,140 @@ func handleNodeDetail(s *store.Store) http.HandlerFunc {
 func handleLinks(s *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		snap := s.GetSnapshot()
-		jsonResponse(w, snap.Links)
+		q := r.URL.Query()
+		flapping := q.Get("flapping") == "true"
+		linkType := q.Get("type")
+		community := q.Get("community")
+		minTQ := -1.0
+		if v := q.Get("min_tq"); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				http.Error(w, "invalid min_tq", http.StatusBadRequest)
+				return
+			}
+			minTQ = f
+		}
+		if !flapping && linkType == "" && community == "" && minTQ < 0 {
+			jsonResponse(w, snap.Links)
+			return
+		}
+
+		links := make([]store.Link, 0)
+		for _, l := range snap.Links {
+			if flapping && !l.Flapping {
+				continue
+			}
+			if linkType != "" && l.Type != linkType {
+				continue
+			}
+			if minTQ >= 0 && linkWeight(l) < minTQ {
+				continue
+			}
+			if community != "" && !linkInCommunity(snap, l, community) {
+				continue
+			}
+			links = append(links, l)
+		}
+		jsonResponse(w, links)
+	}
+}
+
+// linkInCommunity reports whether either endpoint belongs to the community.
+func linkInCommunity(snap *store.Snapshot, l store.Link, community string) bool {
+	for _, id := range []string{l.Source, l.Target} {
+		n, ok := snap.Nodes[id]
+		if !ok {
+			continue
+		}
+		for _, c := range n.Communities {
+			if c == community {
+				return true
+			}
+		}
+		if n.Community == community {
+			return true
+		}
 	}
+	return false
 }
 
 func handleStats(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, s.CurrentStats())
+	}
+}
+
+func handleLinkStats(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+		jsonResponse(w, store.ComputeLinkStats(snap))
+	}
+}
+
+func handleChannelStats(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+		jsonResponse(w, store.ComputeChannelStats(snap))
+	}
+}
+
+func handleMeshHealth(s *store.Store) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		snap := s.GetSnapshot()
-		jsonResponse(w, snap.Stats)
+		mh := snap.MeshHealth
+		if mh == nil {
+			mh = store.ComputeMeshHealth(snap)
+		}
+		jsonResponse(w, mh)
+	}
+}
+
+func handleNetworkHealth(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+		jsonResponse(w, store.ComputeNetworkHealth(snap))
+	}
+}
+
+func handleDiagnostics(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := store.ComputeDiagnostics(s.GetSnapshot(), s.SourceWarnings(), time.Now())
+		d.SourceFailures = s.SourceFailures()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+// handleSourceStatus reports fetch results per configured data URL, for
+// debugging an empty or outdated map without access to the server logs.
+func handleSourceStatus(s *store.Store) http.HandlerFunc {
+	type sourceReport struct {
+		Sources        []store.SourceStatus `json:"sources"`
+		Nodes          int                  `json:"nodes"`
+		Timestamp      string               `json:"timestamp"`
+		DataAgeSeconds int64                `json:"data_age_seconds"`
+		Stale          bool                 `json:"stale"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := s.CurrentStats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sourceReport{
+			Sources:        s.SourceStatuses(),
+			Nodes:          st.TotalNodes,
+			Timestamp:      st.Timestamp,
+			DataAgeSeconds: st.DataAgeSeconds,
+			Stale:          st.Stale,
+		})
+	}
+}
+
+func handleDuplicateHostnames(s *store.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dups := s.GetSnapshot().DuplicateHostnames
+		if dups == nil {
+			dups = []store.DuplicateHostname{}
+		}
+		jsonResponse(w, dups)
 	}
 }
 
@@ -155,6 +459,7 @@ func handleCommunities(fs *federation.Store) http.HandlerFunc {
 		communities := fs.GetCommunities()
 		sources := fs.GetSources()
 		grafanaCache := fs.GetGrafanaCache()
+		errs := fs.CommunityErrors()
 
 		sourceMap := make(map[string]string)
 		for _, s := range sources {
@@ -173,6 +478,7 @@ func handleCommunities(fs *federation.Store) http.HandlerFunc {
 			DashboardURL string  `json:"dashboard_url,omitempty"`
 			Meta         string  `json:"metacommunity,omitempty"`
 			Active       bool    `json:"active"`
+			Error        string  `json:"error,omitempty"` // why it has no data
 		}
 
 		result := make([]CommunityInfo, 0, len(communities))
@@ -189,6 +495,7 @@ func handleCommunities(fs *federation.Store) http.HandlerFunc {
 				GrafanaURL: c.GrafanaURL,
 				Meta:       c.Metacommunity,
 				Active:     active,
+				Error:      errs[c.Key],
 			}
 			if info, ok := grafanaCache[c.Key]; ok {
 				if ci.GrafanaURL == "" {
@@ -200,12 +507,57 @@ func handleCommunities(fs *federation.Store) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Cache-Control", "public, max-age=300")
 		json.NewEncoder(w).Encode(result)
 	}
 }
 
-func handleClientConfig(cfg *config.Config) http.HandlerFunc {
+// handleFederationStatus reports the fetch health of every federation
+// source; ?failing=true lists only sources whose last fetch failed.
+func handleFederationStatus(fs *federation.Store) http.HandlerFunc {
+	type statusReport struct {
+		Sources []federation.SourceHealth `json:"sources"`
+		Total   int                       `json:"total"`
+		Failing int                       `json:"failing"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		all := fs.SourceHealth()
+		onlyFailing := r.URL.Query().Get("failing") == "true"
+		rep := statusReport{Sources: make([]federation.SourceHealth, 0, len(all)), Total: len(all)}
+		for _, h := range all {
+			if h.Error != "" {
+				rep.Failing++
+			} else if onlyFailing {
+				continue
+			}
+			rep.Sources = append(rep.Sources, h)
+		}
+		jsonResponse(w, rep)
+	}
+}
+
+func handleMergedDevices(fs *federation.Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Nodes hidden since the last refresh are still in the decisions.
+		merges := []federation.MergeDecision{}
+		for _, m := range fs.GetMergeDecisions() {
+			if !fs.IsHidden(m.KeptID) && !fs.IsHidden(m.MergedID) {
+				merges = append(merges, m)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(merges)
+	}
+}
+
+// clientOverlay tells the frontend where to load an overlay layer from;
+// URL is relative to the page.
+type clientOverlay struct {
+	Name  string `json:"name"`
+	Color string `json:"color,omitempty"`
+	URL   string `json:"url"`
+}
+
+func handleClientConfig(live *config.Live, s *store.Store) http.HandlerFunc {
 	type ClientConfig struct {
 		SiteName         string                `json:"siteName"`
 		MapCenter        [2]float64            `json:"mapCenter"`
@@ -219,202 +571,181 @@ func handleClientConfig(cfg *config.Config) http.HandlerFunc {
 		GrafanaDashboard string                `json:"grafanaDashboard"`
 		HasGrafana       bool                  `json:"hasGrafana"`
 		Federation       bool                  `json:"federation"`
+		BasePath         string                `json:"basePath"`
+		Theme            config.Theme          `json:"theme"`
+		Overlays         []clientOverlay       `json:"overlays"`
+		DataAgeSeconds   int64                 `json:"data_age_seconds"`
+		Stale            bool                  `json:"stale"`
 	}
 
-	cc := ClientConfig{
-		SiteName:         cfg.SiteName,
-		MapCenter:        cfg.MapCenter,
-		MapZoom:          cfg.MapZoom,
-		TileLayers:       cfg.TileLayers,
-		DomainNames:      cfg.DomainNames,
-		Links:            cfg.Links,
-		DevicePictureURL: cfg.DevicePictureURL,
-		EolInfoURL:       cfg.EolInfoURL,
-		GrafanaURL:       cfg.GrafanaURL,
-		GrafanaDashboard: cfg.GrafanaDashboard,
-		HasGrafana:       cfg.GrafanaURL != "",
-		Federation:       cfg.Federation,
-	}
-
-	data, _ := json.Marshal(cc)
-
+	// Built per request so a config reload shows up immediately.
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
+		c := ClientConfig{
+			SiteName:         cfg.SiteName,
+			MapCenter:        cfg.MapCenter,
+			MapZoom:          cfg.MapZoom,
+			TileLayers:       cfg.TileLayers,
+			DomainNames:      cfg.DomainNames,
+			Links:            cfg.Links,
+			DevicePictureURL: cfg.DevicePictureURL,
+			EolInfoURL:       cfg.EolInfoURL,
+			GrafanaURL:       cfg.GrafanaURL,
+			GrafanaDashboard: cfg.GrafanaDashboard,
+			HasGrafana:       cfg.GrafanaURL != "",
+			Federation:       cfg.Federation,
+			BasePath:         cfg.BasePath,
+			Theme:            cfg.Theme,
+			Overlays:         make([]clientOverlay, 0, len(cfg.Overlays)),
+		}
+		for _, o := range cfg.Overlays {
+			c.Overlays = append(c.Overlays, clientOverlay{
+				Name:  o.Name,
+				Color: o.Color,
+				URL:   "api/overlays/" + o.ID + ".geojson",
+			})
+		}
+		st := s.CurrentStats()
+		c.DataAgeSeconds, c.Stale = st.DataAgeSeconds, st.Stale
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Write(data)
+		json.NewEncoder(w).Encode(c)
 	}
 }
 
-func handleNodeMetrics(cfg *config.Config, fedStore *federation.Store) http.HandlerFunc {
-	client := &http.Client{Timeout: 15 * time.Second}
-
-	queries := map[string]string{
-		"clients":         `SELECT round(mean("clients.total")) FROM "node" WHERE ("nodeid" =~ /^%s$/) AND time >= now() - %s GROUP BY time(%s) fill(null)`,
-		"traffic_forward": `SELECT non_negative_derivative(mean("traffic.forward.bytes"), 1s) * 8 FROM "node" WHERE ("nodeid" =~ /^%s$/) AND time >= now() - %s GROUP BY time(%s) fill(none)`,
-		"traffic_rx":      `SELECT non_negative_derivative(mean("traffic.rx.bytes"), 1s) * 8 FROM "node" WHERE ("nodeid" =~ /^%s$/) AND time >= now() - %s GROUP BY time(%s) fill(none)`,
-		"traffic_tx":      `SELECT non_negative_derivative(mean("traffic.tx.bytes"), 1s) * 8 FROM "node" WHERE ("nodeid" =~ /^%s$/) AND time >= now() - %s GROUP BY time(%s) fill(none)`,
-		"load":            `SELECT mean("load") FROM "node" WHERE ("nodeid" =~ /^%s$/) AND time >= now() - %s GROUP BY time(%s) fill(null)`,
-		"memory":          `SELECT mean("memory.usage") FROM "node" WHERE ("nodeid" =~ /^%s$/) AND time >= now() - %s GROUP BY time(%s) fill(null)`,
-	}
+// handleNodeMetrics serves the charts of /api/metrics/{node_id}. For a
+// node replacing aliased hardware, the series cover the former node IDs
+// too, summed, as old and new device do not report at the same time.
+func handleNodeMetrics(live *config.Live, s *store.Store, fedStore *federation.Store, al *aliases.Store) http.HandlerFunc {
+	client := grafanaClient(live)
+	influxClient := fetch.HTTPClient(live.Load(), 15*time.Second)
+	single := newSingleBackend(live)
+	// Popular nodes get the same query from many viewers at once.
+	var inflight flight.Group
+	cache := newResponseCache(metricsCacheTTL, metricsCacheErrorTTL, metricsCacheEntries)
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
 		nodeID := strings.TrimPrefix(r.URL.Path, "/api/metrics/")
 		nodeID = strings.Split(nodeID, "/")[0]
 		if nodeID == "" {
 			http.Error(w, "node_id required", http.StatusBadRequest)
 			return
 		}
+		aliasMap := al.Map(cfg.NodeAliases)
+		nodeID = aliases.Resolve(aliasMap, nodeID)
+		if s.IsHidden(nodeID) {
+			http.Error(w, "node not found", http.StatusNotFound)
+			return
+		}
+		var former []string
+		for _, id := range aliases.Former(aliasMap, nodeID) {
+			if validNodeID(id) && !s.IsHidden(id) {
+				former = append(former, id)
+			}
+		}
 
+		var backend timeseries.Backend
 		var grafanaURL string
-		var dsID int
-		var dbName string
-		var queryNodeID string
+		queryNodeID := nodeID
 
-		if fedStore != nil {
+		switch {
+		case fedStore != nil:
 			info, originalID := fedStore.GrafanaInfoForNode(nodeID)
-			if info.BaseURL == "" || info.DatasourceID == 0 {
+			queryNodeID = originalID
+			// communityMetrics is set by the operator; it takes precedence
+			// and is not held to the checks for discovered Grafana hosts.
+			for _, ck := range fedStore.CommunitiesForNode(nodeID) {
+				if src, ok := cfg.CommunityMetrics[ck]; ok {
+					backend = influxBackend(&src, influxClient)
+					break
+				}
+			}
+			if backend != nil {
+				break
+			}
+			if info.BaseURL == "" || (info.DatasourceID == 0 && info.DatasourceUID == "") {
 				http.Error(w, "no Grafana datasource for this community", http.StatusNotFound)
 				return
 			}
-			grafanaURL = info.BaseURL
-			dsID = info.DatasourceID
-			dbName = info.Database
+			dbName := info.Database
 			if dbName == "" {
 				dbName = "yanic"
 			}
-			queryNodeID = originalID
-		} else {
-			if cfg.GrafanaURL == "" {
-				http.Error(w, "Grafana not configured", http.StatusServiceUnavailable)
+			grafanaURL = info.BaseURL
+			backend = grafanaBackend(client, info.BaseURL, info.DatasourceID, info.DatasourceUID, info.Version, dbName)
+		default:
+			var code int
+			var msg string
+			if backend, code, msg = single.get(); backend == nil {
+				http.Error(w, msg, code)
 				return
 			}
-			grafanaURL = cfg.GrafanaURL
-			dsID = 5
-			dbName = "yanic"
-			queryNodeID = nodeID
 		}
 
-		// Sanitize queryNodeID: allow hex, colons, dashes only
-		for _, c := range queryNodeID {
-			if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') || c == ':' || c == '-') {
-				http.Error(w, "invalid node_id", http.StatusBadRequest)
+		if grafanaURL != "" {
+			if u, err := url.Parse(grafanaURL); err != nil {
+				http.Error(w, "invalid Grafana URL", http.StatusBadGateway)
+				return
+			} else if err := federation.GrafanaTargetAllowed(cfg, u); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
 				return
 			}
 		}
 
+		if !validNodeID(queryNodeID) {
+			http.Error(w, "invalid node_id", http.StatusBadRequest)
+			return
+		}
+
 		metric := r.URL.Query().Get("metric")
 		if metric == "" {
 			metric = "clients"
 		}
 
-		duration := r.URL.Query().Get("duration")
-		if duration == "" {
-			duration = "24h"
-		}
-		validDurations := map[string]string{
-			"6h": "1m", "12h": "2m", "24h": "5m", "48h": "10m",
-			"7d": "30m", "14d": "1h", "30d": "2h",
-		}
-		interval, ok := validDurations[duration]
-		if !ok {
-			duration = "24h"
-			interval = "5m"
-		}
+		duration, interval := chartDuration(r.URL.Query().Get("duration"))
 
-		type MetricResult struct {
-			Name   string    `json:"name"`
-			Times  []int64   `json:"times"`
-			Values []float64 `json:"values"`
+		cacheKey := nodeID + "|" + metric + "|" + duration
+		if body, ok := cache.get(cacheKey); ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
 		}
 
-		var metricNames []string
-		if metric == "traffic" {
-			metricNames = []string{"traffic_forward", "traffic_rx", "traffic_tx"}
-		} else {
+		metricNames, ok := chartMetricGroups[metric]
+		if !ok {
 			metricNames = []string{metric}
 		}
 
-		results := make([]MetricResult, 0, len(metricNames))
+		results := make([]timeseries.Series, 0, len(metricNames))
+		queried, answered := 0, 0
 
 		for _, mn := range metricNames {
-			queryTpl, found := queries[mn]
-			if !found {
-				continue
-			}
-
-			influxQuery := fmt.Sprintf(queryTpl, queryNodeID, duration, interval)
-
-			dsURL := fmt.Sprintf("%s/api/datasources/proxy/%d/query?db=%s&q=%s&epoch=s",
-				grafanaURL, dsID, url.QueryEscape(dbName), url.QueryEscape(influxQuery))
-
-			if !urlcheck.IsSafeURL(dsURL) {
+			if !timeseries.Known(mn) {
 				continue
 			}
-
-			req, err := http.NewRequestWithContext(r.Context(), "GET", dsURL, nil)
-			if err != nil {
-				continue
+			queried++
+			q := timeseries.Query{NodeID: queryNodeID, Metric: mn, Duration: duration, Interval: interval}
+			if len(former) > 0 {
+				q.NodeIDs = append([]string{queryNodeID}, former...)
 			}
-			req.Header.Set("Accept", "application/json")
-
-			resp, err := client.Do(req)
+			v, err, _ := inflight.Do(nodeID+"|"+mn+"|"+duration, func() (interface{}, error) {
+				// Shared by other callers, so one leaving must not cancel it.
+				return backend.Query(context.WithoutCancel(r.Context()), q)
+			})
 			if err != nil {
 				continue
 			}
-			body, err := io.ReadAll(io.LimitReader(resp.Body, 5*1024*1024))
-			resp.Body.Close()
-			if err != nil || resp.StatusCode != 200 {
-				continue
-			}
-
-			var influxResp struct {
-				Results []struct {
-					Series []struct {
-						Name    string          `json:"name"`
-						Columns []string        `json:"columns"`
-						Values  [][]interface{} `json:"values"`
-					} `json:"series"`
-				} `json:"results"`
-			}
-			if err := json.Unmarshal(body, &influxResp); err != nil {
-				continue
-			}
-
-			mr := MetricResult{Name: mn}
-			if len(influxResp.Results) > 0 && len(influxResp.Results[0].Series) > 0 {
-				series := influxResp.Results[0].Series[0]
-				for _, row := range series.Values {
-					if len(row) < 2 {
-						continue
-					}
-					var ts int64
-					switch t := row[0].(type) {
-					case float64:
-						ts = int64(t)
-					case json.Number:
-						ts64, _ := t.Int64()
-						ts = ts64
-					}
-					var val float64
-					if row[1] != nil {
-						switch v := row[1].(type) {
-						case float64:
-							val = v
-						case json.Number:
-							val64, _ := v.Float64()
-							val = val64
-						}
-					}
-					mr.Times = append(mr.Times, ts)
-					mr.Values = append(mr.Values, val)
-				}
-			}
-			results = append(results, mr)
+			results = append(results, v.(timeseries.Series))
+			answered++
 		}
 
+		body, _ := json.Marshal(results)
+		body = append(body, '\n')
+		cache.put(cacheKey, body, queried > 0 && answered == 0)
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Cache-Control", "public, max-age=60")
-		json.NewEncoder(w).Encode(results)
+		w.Write(body)
 	}
 }
 
@@ -502,7 +833,7 @@ func handleDebugCommunities(fs *federation.Store) http.HandlerFunc {
 			}
 
 			status := "active"
-			if len(srcs) == 0 && len(c.MeshviewerURLs)+len(c.NodelistURLs) == 0 {
+			if len(srcs) == 0 && len(c.MeshviewerURLs)+len(c.NodelistURLs)+len(c.TopologyURLs) == 0 {
 				status = "no_data_urls"
 			} else if len(srcs) == 0 {
 				status = "probe_failed"
@@ -527,7 +858,6 @@ func handleDebugCommunities(fs *federation.Store) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("Cache-Control", "no-cache")
 		json.NewEncoder(w).Encode(result)
 	}
 }
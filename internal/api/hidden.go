@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/hidden"
+)
+
+// RegisterHiddenHandlers adds management of the hide list to the admin
+// group returned by RegisterAdminHandlers.
+func RegisterHiddenHandlers(admin *http.ServeMux, live *config.Live, hl *hidden.List) {
+	h := handleAdminHidden(live, hl)
+	admin.HandleFunc("/api/admin/hidden", h)
+	admin.HandleFunc("/api/admin/hidden/", h)
+}
+
+// handleAdminHidden lists the hidden nodes (GET), hides one given as a
+// JSON body with id and reason (POST) or unhides /api/admin/hidden/{id}
+// (DELETE). Changes take effect with the next refresh. Entries of the
+// hiddenNodes setting are listed separately and only change with the
+// config.
+func handleAdminHidden(live *config.Live, hl *hidden.List) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/hidden"), "/")
+		if id != "" {
+			if r.Method != http.MethodDelete {
+				w.Header().Set("Allow", "DELETE")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			found, err := hl.Remove(id)
+			if err != nil {
+				http.Error(w, "saving hidden nodes: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !found {
+				http.Error(w, "node not on the hide list", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			configured := make([]string, 0, len(cfg.HiddenNodes))
+			for _, id := range cfg.HiddenNodes {
+				configured = append(configured, hidden.Normalize(id))
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"configured": configured,
+				"entries":    hl.List(),
+			})
+		case http.MethodPost:
+			var e hidden.Entry
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&e); err != nil {
+				http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := e.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			saved, err := hl.Add(e)
+			if err != nil {
+				http.Error(w, "saving hidden nodes: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(saved)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
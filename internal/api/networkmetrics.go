@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/flight"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/history"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/timeseries"
+)
+
+// aggregateMetrics are the sums /api/metrics/aggregate offers, by the
+// metric parameter.
+var aggregateMetrics = map[string][]string{
+	"clients": {"clients"},
+	"traffic": {"traffic_forward", "traffic_rx", "traffic_tx"},
+}
+
+// aggregateConcurrency bounds the queries one aggregate request runs at
+// once; a network has a handful of gateways and domains.
+const aggregateConcurrency = 4
+
+// metricsGroup is the summed history of the nodes behind one gateway or
+// in one domain.
+type metricsGroup struct {
+	Key     string              `json:"key"` // gateway node ID or domain code
+	Name    string              `json:"name,omitempty"`
+	Nodes   int                 `json:"nodes"`
+	Series  []timeseries.Series `json:"series"`
+	nodeIDs []string
+}
+
+// groupNodes collects the nodes per gateway or domain, sorted by key.
+// Nodes count towards their current gateway, so the history of a node
+// that switched gateways is summed up entirely under the new one.
+func groupNodes(snap *store.Snapshot, group string) []*metricsGroup {
+	byKey := make(map[string]*metricsGroup)
+	for _, n := range snap.NodeList {
+		if n.Placeholder || !validNodeID(n.NodeID) {
+			continue
+		}
+		key, name := "", ""
+		switch group {
+		case "gateway":
+			key = n.Gateway
+			if gw, ok := snap.Nodes[key]; ok {
+				name = gw.Hostname
+			}
+		case "domain":
+			key, name = n.Domain, n.DomainName
+		}
+		if key == "" {
+			continue
+		}
+		g := byKey[key]
+		if g == nil {
+			g = &metricsGroup{Key: key, Name: name}
+			byKey[key] = g
+		}
+		g.Nodes++
+		g.nodeIDs = append(g.nodeIDs, n.NodeID)
+	}
+	groups := make([]*metricsGroup, 0, len(byKey))
+	for _, g := range byKey {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups
+}
+
+// validNodeID reports whether id is safe to put into a query: hex digits,
+// colons and dashes only.
+func validNodeID(id string) bool {
+	for _, c := range id {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F') || c == ':' || c == '-') {
+			return false
+		}
+	}
+	return id != ""
+}
+
+// handleAggregateMetrics serves the summed client and traffic history per
+// gateway or domain for capacity planning, from the single-mode chart
+// backend. Federation mode has no backend covering every community.
+func handleAggregateMetrics(live *config.Live, s *store.Store, fedStore *federation.Store) http.HandlerFunc {
+	single := newSingleBackend(live)
+	var inflight flight.Group
+	cache := newResponseCache(metricsCacheTTL, metricsCacheErrorTTL, metricsCacheEntries)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if fedStore != nil {
+			http.Error(w, "aggregate metrics are not available in federation mode", http.StatusNotImplemented)
+			return
+		}
+		q := r.URL.Query()
+		group := q.Get("group")
+		if group != "gateway" && group != "domain" {
+			http.Error(w, "group must be gateway or domain", http.StatusBadRequest)
+			return
+		}
+		metric := q.Get("metric")
+		if metric == "" {
+			metric = "clients"
+		}
+		metricNames, ok := aggregateMetrics[metric]
+		if !ok {
+			http.Error(w, "metric must be clients or traffic", http.StatusBadRequest)
+			return
+		}
+		key := q.Get("key")
+		duration, interval := chartDuration(q.Get("duration"))
+
+		backend, code, msg := single.get()
+		if backend == nil {
+			http.Error(w, msg, code)
+			return
+		}
+
+		cacheKey := "aggregate|" + group + "|" + key + "|" + metric + "|" + duration
+		if body, ok := cache.get(cacheKey); ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+
+		groups := groupNodes(s.GetSnapshot(), group)
+		if key != "" {
+			filtered := groups[:0]
+			for _, g := range groups {
+				if g.Key == key {
+					filtered = append(filtered, g)
+				}
+			}
+			if len(filtered) == 0 {
+				http.Error(w, group+" not found", http.StatusNotFound)
+				return
+			}
+			groups = filtered
+		}
+
+		v, _, _ := inflight.Do(cacheKey, func() (interface{}, error) {
+			// Shared by other callers, so one leaving must not cancel it.
+			ctx := context.WithoutCancel(r.Context())
+			queried, answered := 0, 0
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			sem := make(chan struct{}, aggregateConcurrency)
+			for _, g := range groups {
+				g.Series = make([]timeseries.Series, len(metricNames))
+				for i, mn := range metricNames {
+					queried++
+					wg.Add(1)
+					go func(g *metricsGroup, i int, mn string) {
+						defer wg.Done()
+						sem <- struct{}{}
+						defer func() { <-sem }()
+						series, err := backend.Query(ctx, timeseries.Query{
+							NodeIDs: g.nodeIDs, Metric: mn, Duration: duration, Interval: interval,
+						})
+						if err != nil {
+							series = timeseries.Series{Name: mn}
+						} else {
+							mu.Lock()
+							answered++
+							mu.Unlock()
+						}
+						g.Series[i] = series
+					}(g, i, mn)
+				}
+			}
+			wg.Wait()
+			body, _ := json.Marshal(groups)
+			body = append(body, '\n')
+			cache.put(cacheKey, body, queried > 0 && answered == 0)
+			return body, nil
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(v.([]byte))
+	}
+}
+
+// RegisterGlobalMetricsHandler registers the network-wide history route.
+// Federation mode answers from the local history only.
+func RegisterGlobalMetricsHandler(mux *http.ServeMux, live *config.Live, h *history.Recorder, federated bool) {
+	mux.HandleFunc("/api/metrics/global", handleGlobalMetrics(live, h, federated))
+}
+
+// handleGlobalMetrics serves the client or online node count of the whole
+// network, for embedding on community homepages. yanic's "global"
+// measurement is used when a chart backend is configured, the history
+// recorded by the map itself when there is none or it has no data.
+func handleGlobalMetrics(live *config.Live, h *history.Recorder, federated bool) http.HandlerFunc {
+	single := newSingleBackend(live)
+	var inflight flight.Group
+	cache := newResponseCache(metricsCacheTTL, metricsCacheErrorTTL, metricsCacheEntries)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		metric := r.URL.Query().Get("metric")
+		if metric == "" {
+			metric = "clients"
+		}
+		if !timeseries.KnownGlobal(metric) {
+			http.Error(w, "metric must be clients or nodes", http.StatusBadRequest)
+			return
+		}
+		duration, interval := chartDuration(r.URL.Query().Get("duration"))
+
+		cacheKey := "global|" + metric + "|" + duration
+		if body, ok := cache.get(cacheKey); ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+			return
+		}
+
+		v, _, _ := inflight.Do(cacheKey, func() (interface{}, error) {
+			var series timeseries.Series
+			if !federated {
+				if backend, _, _ := single.get(); backend != nil {
+					// Shared by other callers, so one leaving must not cancel it.
+					series, _ = backend.Query(context.WithoutCancel(r.Context()), timeseries.Query{
+						Global: true, Metric: metric, Duration: duration, Interval: interval,
+					})
+				}
+			}
+			if len(series.Times) == 0 {
+				series = h.Series(metric, chartSpan(duration), chartSpan(interval))
+			}
+			body, _ := json.Marshal([]timeseries.Series{series})
+			body = append(body, '\n')
+			cache.put(cacheKey, body, false)
+			return body, nil
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(v.([]byte))
+	}
+}
+
+// chartSpan converts a chart duration literal like "30d" or "5m".
+func chartSpan(s string) time.Duration {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, _ := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour
+	}
+	d, _ := time.ParseDuration(s)
+	return d
+}
@@ -0,0 +1,312 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/timeseries"
+)
+
+// grafanaClient queries Grafana for the metrics proxy and checks every
+// redirect like the original target, so an allowed host cannot forward
+// the proxy elsewhere. The checks follow config reloads.
+func grafanaClient(live *config.Live) *http.Client {
+	client := fetch.HTTPClient(live.Load(), 15*time.Second)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("stopped after 5 redirects")
+		}
+		return federation.GrafanaTargetAllowed(live.Load(), req.URL)
+	}
+	return client
+}
+
+// influxBackend queries an InfluxDB configured by the operator.
+func influxBackend(src *config.MetricsSource, client *http.Client) timeseries.Backend {
+	base := strings.TrimSuffix(src.URL, "/")
+	if src.Version == 2 {
+		return &timeseries.Flux{URL: base, Org: src.Org, Bucket: src.Bucket, Token: src.Token, Client: client}
+	}
+	return &timeseries.InfluxQL{
+		Endpoint: base + "/query",
+		Database: src.Database,
+		Username: src.Username,
+		Password: src.Password,
+		Client:   client,
+	}
+}
+
+// grafanaBackend queries an InfluxDB datasource of the Grafana at baseURL,
+// through the datasource proxy by dsID or through /api/ds/query by uid.
+// Grafana 9 and later may have the proxy disabled, so they are asked via
+// /api/ds/query first; older or unknown versions the other way round.
+func grafanaBackend(client *http.Client, baseURL string, dsID int, uid, version, database string) timeseries.Backend {
+	var proxy, dsQuery timeseries.Backend
+	if dsID != 0 {
+		proxy = &timeseries.InfluxQL{
+			Endpoint: fmt.Sprintf("%s/api/datasources/proxy/%d/query", baseURL, dsID),
+			Database: database,
+			Client:   client,
+		}
+	}
+	if uid != "" {
+		dsQuery = &timeseries.GrafanaDSQuery{BaseURL: baseURL, UID: uid, Client: client}
+	}
+	switch {
+	case dsQuery == nil:
+		return proxy
+	case proxy == nil:
+		return dsQuery
+	case grafanaMajor(version) >= 9:
+		return timeseries.FirstOf{dsQuery, proxy}
+	default:
+		return timeseries.FirstOf{proxy, dsQuery}
+	}
+}
+
+// grafanaMajor returns the major version of a Grafana version string
+// like "10.4.2", or 0.
+func grafanaMajor(version string) int {
+	major, _, _ := strings.Cut(version, ".")
+	n, _ := strconv.Atoi(major)
+	return n
+}
+
+// chartMetricGroups are the metric parameters that chart several series
+// together.
+var chartMetricGroups = map[string][]string{
+	"traffic":      {"traffic_forward", "traffic_rx", "traffic_tx"},
+	"clients_wifi": {"clients_wifi24", "clients_wifi5"},
+	"airtime":      {"airtime24", "airtime5"},
+}
+
+// chartIntervals maps the durations charts may ask for to the averaging
+// interval, keeping every series at a few hundred points.
+var chartIntervals = map[string]string{
+	"6h": "1m", "12h": "2m", "24h": "5m", "48h": "10m",
+	"7d": "30m", "14d": "1h", "30d": "2h",
+}
+
+// chartDuration returns the requested duration and its interval, or 24h
+// for an empty or unknown one.
+func chartDuration(duration string) (string, string) {
+	if interval, ok := chartIntervals[duration]; ok {
+		return duration, interval
+	}
+	return "24h", chartIntervals["24h"]
+}
+
+// singleBackend picks the chart backend of single-community mode:
+// influxURL if set, else grafanaDatasourceId of grafanaURL.
+type singleBackend struct {
+	live         *config.Live
+	client       *http.Client // for Grafana
+	influxClient *http.Client
+	probe        grafanaProbe
+}
+
+func newSingleBackend(live *config.Live) *singleBackend {
+	cfg := live.Load()
+	return &singleBackend{live: live, client: grafanaClient(live), influxClient: fetch.HTTPClient(cfg, 15*time.Second)}
+}
+
+// get returns the backend, or nil with the HTTP status and message to
+// answer with.
+func (b *singleBackend) get() (timeseries.Backend, int, string) {
+	cfg := b.live.Load()
+	if src := cfg.InfluxSource(); src != nil {
+		return influxBackend(src, b.influxClient), 0, ""
+	}
+	if cfg.GrafanaURL == "" {
+		return nil, http.StatusServiceUnavailable, "Grafana not configured"
+	}
+	u, err := url.Parse(cfg.GrafanaURL)
+	if err != nil {
+		return nil, http.StatusBadGateway, "invalid Grafana URL"
+	}
+	if err := federation.GrafanaTargetAllowed(cfg, u); err != nil {
+		return nil, http.StatusForbidden, err.Error()
+	}
+	info := b.probe.get(b.client, cfg, cfg.GrafanaURL, cfg.GrafanaDatasourceId)
+	dsID := info.DatasourceID
+	if dsID == 0 {
+		dsID = 5 // not discovered; the ID of ffMUC's yanic datasource
+	}
+	database := cfg.GrafanaDatabase
+	if database == "" {
+		database = info.Database
+	}
+	if database == "" {
+		database = "yanic"
+	}
+	return grafanaBackend(b.client, cfg.GrafanaURL, dsID, info.DatasourceUID, info.Version, database), 0, ""
+}
+
+const (
+	grafanaProbeTTL      = time.Hour
+	grafanaProbeRetryTTL = 5 * time.Minute
+)
+
+// grafanaProbe remembers the version of the Grafana at grafanaURL and its
+// datasource, which single mode does not get from federation discovery.
+type grafanaProbe struct {
+	mu       sync.Mutex
+	baseURL  string
+	dsID     int
+	info     federation.GrafanaInfo
+	probedAt time.Time
+}
+
+// get returns the version of the Grafana at baseURL and the ID, UID and
+// database of datasource dsID, or with dsID 0 of the datasource
+// DiscoverDatasource picks. Grafana is asked again when the answer is
+// incomplete or old.
+func (p *grafanaProbe) get(client *http.Client, cfg *config.Config, baseURL string, dsID int) federation.GrafanaInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ttl := grafanaProbeTTL
+	if p.info.Version == "" || p.info.DatasourceUID == "" {
+		ttl = grafanaProbeRetryTTL
+	}
+	if p.baseURL != baseURL || p.dsID != dsID || time.Since(p.probedAt) > ttl {
+		info := federation.GrafanaInfo{BaseURL: baseURL, DatasourceID: dsID}
+		if dsID == 0 {
+			info = federation.DiscoverDatasource(client, cfg, info)
+		} else {
+			info.DatasourceUID = federation.DatasourceUID(client, cfg, baseURL, dsID)
+		}
+		info.Version = federation.GrafanaVersion(client, cfg, baseURL)
+		p.baseURL, p.dsID, p.info, p.probedAt = baseURL, dsID, info, time.Now()
+	}
+	return p.info
+}
+
+// grafanaDashboardURL returns the Grafana dashboard of node for the node
+// detail view: grafanaDashboard below grafanaURL in single mode; in
+// federation mode the discovered dashboard of the node's first community
+// that has one, else that community's Grafana itself.
+func grafanaDashboardURL(cfg *config.Config, fedStore *federation.Store, node *store.Node) string {
+	if fedStore == nil {
+		if cfg.GrafanaDashboard == "" {
+			return ""
+		}
+		return expandDashboardURL(cfg.GrafanaURL, cfg.GrafanaDashboard, node.NodeID, node.Hostname, cfg.GrafanaOrgId)
+	}
+	// Gateways are merged under "<id>_<community>"; Grafana knows the plain ID.
+	_, originalID := fedStore.GrafanaInfoForNode(node.NodeID)
+	cache := fedStore.GetGrafanaCache()
+	fallback := ""
+	for _, ck := range fedStore.CommunitiesForNode(node.NodeID) {
+		info, ok := cache[ck]
+		if !ok {
+			continue
+		}
+		if info.DashboardURL != "" {
+			return expandDashboardURL(info.BaseURL, info.DashboardURL, originalID, node.Hostname, 0)
+		}
+		if fallback == "" {
+			fallback = info.BaseURL
+		}
+	}
+	return fallback
+}
+
+// expandDashboardURL fills the meshviewer placeholders {NODE_ID} and
+// {NODE_NAME} of template, or sets var-nodeid if it has neither. A
+// template starting with "/" is taken relative to base. orgID, if not 0,
+// is added as orgId unless the template selects an organization itself.
+func expandDashboardURL(base, template, nodeID, hostname string, orgID int) string {
+	hasVar := strings.Contains(template, "{NODE_ID}") || strings.Contains(template, "{NODE_NAME}")
+	s := strings.NewReplacer("{NODE_ID}", url.QueryEscape(nodeID), "{NODE_NAME}", url.QueryEscape(hostname)).Replace(template)
+	if strings.HasPrefix(s, "/") {
+		if base == "" {
+			return ""
+		}
+		s = strings.TrimSuffix(base, "/") + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	q := u.Query()
+	if hasVar && (orgID == 0 || q.Has("orgId")) {
+		return u.String()
+	}
+	if !hasVar {
+		q.Set("var-nodeid", nodeID)
+	}
+	if orgID != 0 && !q.Has("orgId") {
+		q.Set("orgId", strconv.Itoa(orgID))
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+const (
+	metricsCacheTTL      = 60 * time.Second
+	metricsCacheErrorTTL = 10 * time.Second // when Grafana did not answer
+	metricsCacheEntries  = 2000
+)
+
+// responseCache keeps encoded upstream answers for a while, so a node
+// detail page opened by many viewers costs its community's Grafana one
+// query per TTL.
+type responseCache struct {
+	ttl, errorTTL time.Duration
+	max           int
+
+	mu      sync.Mutex
+	entries map[string]responseCacheEntry
+}
+
+type responseCacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(ttl, errorTTL time.Duration, max int) *responseCache {
+	return &responseCache{ttl: ttl, errorTTL: errorTTL, max: max, entries: make(map[string]responseCacheEntry)}
+}
+
+func (c *responseCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return e.body, true
+}
+
+// put stores body; failed responses are kept briefly, long enough to
+// spare a struggling Grafana the retries of every viewer.
+func (c *responseCache) put(key string, body []byte, failed bool) {
+	ttl := c.ttl
+	if failed {
+		ttl = c.errorTTL
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.max {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.max {
+			return
+		}
+	}
+	c.entries[key] = responseCacheEntry{body: body, expires: now.Add(ttl)}
+}
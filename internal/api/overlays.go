@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/overlays"
+)
+
+// RegisterOverlayHandlers serves the configured GeoJSON overlays.
+func RegisterOverlayHandlers(mux *http.ServeMux, set *overlays.Set) {
+	mux.HandleFunc("/api/overlays", func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, set.Statuses())
+	})
+	mux.HandleFunc("/api/overlays/", handleOverlayData(set))
+}
+
+// handleOverlayData serves /api/overlays/{id}.geojson.
+func handleOverlayData(set *overlays.Set) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/overlays/"), ".geojson")
+		data := set.Data(id)
+		if !ok || data == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/metrics"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/sse"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/trace"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/version"
+)
+
+// ServiceMetrics records the HTTP request durations served on /metrics.
+type ServiceMetrics struct {
+	requests *metrics.Histogram
+}
+
+// NewServiceMetrics creates an empty request histogram.
+func NewServiceMetrics() *ServiceMetrics {
+	return &ServiceMetrics{requests: metrics.NewHistogram(metrics.DefaultBuckets, "route", "code")}
+}
+
+// Instrument times and traces every request except SSE streams, which
+// stay open for as long as the client is connected; they are counted as a
+// gauge instead.
+func (m *ServiceMetrics) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/events") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route := metricsRoute(r.URL.Path)
+		ctx, span := trace.StartServer(r.Context(), r.Method+" "+route, r.Header.Get("traceparent"),
+			"http.request.method", r.Method, "http.route", route, "url.path", r.URL.Path)
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		m.requests.Observe(time.Since(start).Seconds(), route, strconv.Itoa(sw.code))
+		span.SetAttr("http.response.status_code", strconv.Itoa(sw.code))
+		if sw.code >= 500 {
+			span.SetError(fmt.Errorf("%s", http.StatusText(sw.code)))
+		}
+		span.End()
+	})
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// metricsRoutes are the API route labels metricsRoute hands out, one per
+// registered endpoint group.
+var metricsRoutes = map[string]bool{
+	"/api/admin":          true,
+	"/api/communities":    true,
+	"/api/config":         true,
+	"/api/debug":          true,
+	"/api/export":         true,
+	"/api/federation":     true,
+	"/api/filters":        true,
+	"/api/grafana-render": true,
+	"/api/health":         true,
+	"/api/i18n":           true,
+	"/api/links":          true,
+	"/api/mesh-health":    true,
+	"/api/metrics":        true,
+	"/api/nodes":          true,
+	"/api/overlays":       true,
+	"/api/reports":        true,
+	"/api/sla":            true,
+	"/api/source":         true,
+	"/api/stats":          true,
+	"/api/version":        true,
+	"/api/watch":          true,
+}
+
+// metricsRoute maps a path to a label of bounded cardinality: the API
+// endpoint without IDs ("/api/nodes/abc" becomes "/api/nodes"), the compat
+// frontend, "/debug" for profiling, or "static" for the web UI. Other
+// paths below /api/ and /compat/ are all "other", so clients probing
+// random URLs cannot add series or span names.
+func metricsRoute(path string) string {
+	switch {
+	case path == "/metrics", path == "/healthz", path == "/readyz":
+		return path
+	case strings.HasPrefix(path, "/debug/"):
+		return "/debug"
+	case strings.HasPrefix(path, "/api/"), strings.HasPrefix(path, "/compat/"):
+		parts := strings.SplitN(path, "/", 4)
+		if len(parts) < 3 {
+			return "other"
+		}
+		route := "/" + parts[1] + "/" + parts[2]
+		if parts[1] == "compat" {
+			if _, ok := frontendAdapters[parts[2]]; ok {
+				return route
+			}
+		} else if metricsRoutes[route] {
+			return route
+		}
+		return "other"
+	}
+	return "static"
+}
+
+// RegisterPrometheusHandler serves /metrics in the Prometheus text format.
+func RegisterPrometheusHandler(mux *http.ServeMux, s *store.Store, hub *sse.Hub, m *ServiceMetrics) {
+	mux.HandleFunc("/metrics", handlePrometheus(s, hub, m))
+}
+
+type nodeCounts struct{ nodes, online, clients, gateways int }
+
+func (c *nodeCounts) add(n *store.Node) {
+	c.nodes++
+	if n.IsOnline {
+		c.online++
+		c.clients += n.Clients
+	}
+	if n.IsGateway {
+		c.gateways++
+	}
+}
+
+func handlePrometheus(s *store.Store, hub *sse.Hub, m *ServiceMetrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := s.GetSnapshot()
+		st := s.CurrentStats()
+
+		var total nodeCounts
+		domains := make(map[string]*nodeCounts)
+		communities := make(map[string]*nodeCounts)
+		group := func(groups map[string]*nodeCounts, key string) *nodeCounts {
+			c := groups[key]
+			if c == nil {
+				c = &nodeCounts{}
+				groups[key] = c
+			}
+			return c
+		}
+		for _, n := range snap.NodeList {
+			if n.Placeholder {
+				continue
+			}
+			total.add(n)
+			if n.Domain != "" {
+				group(domains, n.Domain).add(n)
+			}
+			for _, c := range n.Communities {
+				group(communities, c).add(n)
+			}
+		}
+
+		w.Header().Set("Content-Type", metrics.ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		pw := metrics.NewWriter(w)
+
+		info := version.Get()
+		pw.Family("ffmap_build_info", "gauge", "Build information of the running binary.")
+		pw.Sample("ffmap_build_info", 1, "version", info.Version, "commit", info.Commit, "go_version", info.GoVersion)
+
+		gauge := func(name, help string, v float64) {
+			pw.Family(name, "gauge", help)
+			pw.Sample(name, v)
+		}
+		gauge("ffmap_nodes", "Known nodes.", float64(total.nodes))
+		gauge("ffmap_nodes_online", "Online nodes.", float64(total.online))
+		gauge("ffmap_clients", "Clients on online nodes.", float64(total.clients))
+		gauge("ffmap_gateways", "Gateway nodes.", float64(total.gateways))
+		gauge("ffmap_links", "Mesh links.", float64(len(snap.Links)))
+		gauge("ffmap_snapshot_age_seconds", "Age of the upstream data currently served.", float64(st.DataAgeSeconds))
+		stale := 0.0
+		if st.Stale {
+			stale = 1
+		}
+		gauge("ffmap_data_stale", "1 if the served data is older than dataStaleAfter.", stale)
+		gauge("ffmap_sse_clients", "Connected live update clients.", float64(hub.ClientCount()))
+
+		for _, set := range []struct {
+			label  string
+			groups map[string]*nodeCounts
+		}{{"domain", domains}, {"community", communities}} {
+			keys := make([]string, 0, len(set.groups))
+			for k := range set.groups {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, f := range []struct {
+				suffix, help string
+				value        func(*nodeCounts) int
+			}{
+				{"nodes", "Known nodes per " + set.label + ".", func(c *nodeCounts) int { return c.nodes }},
+				{"nodes_online", "Online nodes per " + set.label + ".", func(c *nodeCounts) int { return c.online }},
+				{"clients", "Clients on online nodes per " + set.label + ".", func(c *nodeCounts) int { return c.clients }},
+				{"gateways", "Gateway nodes per " + set.label + ".", func(c *nodeCounts) int { return c.gateways }},
+			} {
+				if len(keys) == 0 {
+					break
+				}
+				name := "ffmap_" + set.label + "_" + f.suffix
+				pw.Family(name, "gauge", f.help)
+				for _, k := range keys {
+					pw.Sample(name, float64(f.value(set.groups[k])), set.label, k)
+				}
+			}
+		}
+
+		sources := s.AllSourceStatuses()
+		if len(sources) > 0 {
+			pw.Family("ffmap_upstream_fetches_total", "counter", "Upstream fetches per data URL.")
+			for _, src := range sources {
+				pw.Sample("ffmap_upstream_fetches_total", float64(src.Fetches), "url", src.URL)
+			}
+			pw.Family("ffmap_upstream_fetch_failures_total", "counter", "Failed upstream fetches per data URL.")
+			for _, src := range sources {
+				pw.Sample("ffmap_upstream_fetch_failures_total", float64(src.Failures), "url", src.URL)
+			}
+		}
+		s.FetchDurations().Write(pw, "ffmap_upstream_fetch_duration_seconds", "Duration of upstream fetches.")
+		m.requests.Write(pw, "ffmap_http_request_duration_seconds", "Duration of HTTP requests, except live update streams.")
+		pw.Flush()
+	}
+}
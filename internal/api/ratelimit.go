@@ -0,0 +1,143 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+const (
+	// sweepEvery is how often idle buckets are dropped.
+	sweepEvery = time.Minute
+	// maxBuckets bounds the memory a flood of client addresses can take.
+	maxBuckets = 100000
+)
+
+// limiter keeps one token bucket per client.
+type limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(perMinute float64, burst int) *limiter {
+	return &limiter{rate: perMinute / 60, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow takes a token for key. If none is left it returns false and how
+// long until the next one is available.
+func (l *limiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > sweepEvery {
+		l.sweep(now)
+	}
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		if len(l.buckets) >= maxBuckets {
+			// Still full of active clients: make room by forgetting
+			// one, which at worst restarts it with a full bucket.
+			for k := range l.buckets {
+				delete(l.buckets, k)
+				break
+			}
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely; a new bucket starts
+// full, so forgetting them changes nothing.
+func (l *limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// RateLimit applies rateLimit to /api/ requests per client. Static
+// files are not limited. Rejected requests get 429 with Retry-After.
+func RateLimit(cfg *config.Config, next http.Handler) http.Handler {
+	rl := cfg.RateLimit
+	if rl.RequestsPerMinute <= 0 {
+		return next
+	}
+	global := newLimiter(rl.RequestsPerMinute, rl.Burst)
+	type route struct {
+		prefix string
+		l      *limiter
+	}
+	var routes []route
+	for _, r := range rl.Routes {
+		routes = append(routes, route{r.Prefix, newLimiter(r.RequestsPerMinute, r.Burst)})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := clientKey(r)
+		now := time.Now()
+		ok, wait := global.allow(key, now)
+		for _, rt := range routes {
+			if ok && strings.HasPrefix(r.URL.Path, rt.prefix) {
+				ok, wait = rt.l.allow(key, now)
+			}
+		}
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey identifies the client of r for per-client limits: its IPv4
+// address or, as one IPv6 host usually gets a whole /64 to pick addresses
+// from, its IPv6 /64 prefix. The address is the one RealIP resolved behind
+// a trusted proxy.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	prefix, _ := addr.WithZone("").Prefix(64)
+	return prefix.String()
+}
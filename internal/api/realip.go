@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// RealIP replaces r.RemoteAddr with the client address reported by a
+// trusted reverse proxy in X-Forwarded-For or X-Real-IP, so logs and rate
+// limits see clients rather than the proxy. Headers from other peers are
+// ignored: anyone can send them. Peers on a Unix socket are local
+// processes and count as trusted.
+func RealIP(cfg *config.Config, next http.Handler) http.Handler {
+	trusted := cfg.TrustedProxyNets
+	isTrusted := func(addr netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(addr.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer, err := netip.ParseAddrPort(r.RemoteAddr)
+		if err == nil && !isTrusted(peer.Addr()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ip := forwardedFor(r.Header, isTrusted); ip.IsValid() {
+			r = r.WithContext(r.Context())
+			r.RemoteAddr = ip.String() // the client's port is unknown
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns the client address added by the trusted proxies:
+// the rightmost X-Forwarded-For entry that is not itself a trusted proxy,
+// else X-Real-IP.
+func forwardedFor(h http.Header, isTrusted func(netip.Addr) bool) netip.Addr {
+	var hops []string
+	for _, v := range h.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = ip
+		if !isTrusted(ip) {
+			return ip
+		}
+	}
+	if client.IsValid() {
+		return client
+	}
+	ip, _ := netip.ParseAddr(strings.TrimSpace(h.Get("X-Real-IP")))
+	return ip
+}
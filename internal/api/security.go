@@ -0,0 +1,90 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// SecurityHeaders adds Content-Security-Policy, X-Content-Type-Options
+// and Referrer-Policy to every response, unless securityHeaders.disable
+// leaves them to a reverse proxy.
+func SecurityHeaders(live *config.Live, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := live.Load()
+
+		sh := cfg.SecurityHeaders
+		if !sh.Disable {
+			h := w.Header()
+			csp := sh.ContentSecurityPolicy
+			if csp == "" {
+				csp = contentSecurityPolicy(cfg)
+			}
+			h.Set("Content-Security-Policy", csp)
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("Referrer-Policy", sh.ReferrerPolicy)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// contentSecurityPolicy allows scripts and data from this server only and
+// images from the configured tile, device picture and logo hosts. The
+// frontend reaches Grafana through /api/metrics/, so Grafana hosts only
+// appear in links, which the policy does not restrict. Inline styles stay
+// allowed, as the UI and Leaflet set style attributes throughout.
+func contentSecurityPolicy(cfg *config.Config) string {
+	img := map[string]bool{}
+	for _, tl := range cfg.TileLayers {
+		addSource(img, tl.URL)
+	}
+	addSource(img, cfg.DevicePictureURL)
+	addSource(img, cfg.Theme.LogoURL)
+	addSource(img, cfg.Theme.FaviconURL)
+	imgSrc := make([]string, 0, len(img))
+	for src := range img {
+		imgSrc = append(imgSrc, src)
+	}
+	sort.Strings(imgSrc)
+
+	frame := "'self'"
+	if len(cfg.SecurityHeaders.FrameAncestors) > 0 {
+		frame = strings.Join(cfg.SecurityHeaders.FrameAncestors, " ")
+	}
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self'",
+		"style-src 'self' 'unsafe-inline'",
+		strings.TrimSpace("img-src 'self' data: " + strings.Join(imgSrc, " ")),
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors " + frame,
+	}, "; ")
+}
+
+// addSource adds the origin of an absolute URL template to set. A
+// placeholder like {s} in the leftmost host label becomes a wildcard; a
+// placeholder elsewhere in the host allows the whole scheme. Relative
+// URLs are covered by 'self'.
+func addSource(set map[string]bool, template string) {
+	scheme, rest, ok := strings.Cut(template, "://")
+	if !ok || (scheme != "http" && scheme != "https") {
+		return
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	if label, tail, ok := strings.Cut(host, "."); ok && strings.HasPrefix(label, "{") && strings.HasSuffix(label, "}") {
+		host = "*." + tail
+	}
+	if strings.ContainsAny(host, "{}") {
+		set[scheme+":"] = true
+		return
+	}
+	if u, err := url.Parse(scheme + "://" + host); err == nil && u.Host != "" {
+		set[scheme+"://"+u.Host] = true
+	}
+}
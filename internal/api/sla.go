@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/sla"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// RegisterSLAHandlers registers the availability endpoints.
+func RegisterSLAHandlers(mux *http.ServeMux, s *store.Store, tr *sla.Tracker) {
+	mux.HandleFunc("/api/sla", handleSLA(tr))
+	mux.HandleFunc("/api/reports/weekly", handleWeeklyReport(s, tr))
+}
+
+// handleSLA returns gateway and domain availability for ?month=YYYY-MM,
+// defaulting to the current month.
+func handleSLA(tr *sla.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from := time.Now().UTC()
+		from = time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if m := r.URL.Query().Get("month"); m != "" {
+			t, err := time.Parse("2006-01", m)
+			if err != nil {
+				http.Error(w, "month must be YYYY-MM", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
+		jsonResponse(w, tr.Report(from, from.AddDate(0, 1, 0)))
+	}
+}
+
+type weeklyReport struct {
+	From        time.Time                   `json:"from"`
+	To          time.Time                   `json:"to"`
+	Stats       store.Stats                 `json:"stats"`
+	Gateways    map[string]sla.Availability `json:"gateway_availability"`
+	Domains     map[string]sla.Availability `json:"domain_availability"`
+	MonthToDate *sla.Report                 `json:"month_to_date"`
+}
+
+// handleWeeklyReport summarizes the current network state together with
+// availability over the last seven days and the month so far.
+func handleWeeklyReport(s *store.Store, tr *sla.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().UTC()
+		week := tr.Report(now.AddDate(0, 0, -7), now)
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		jsonResponse(w, weeklyReport{
+			From:        week.From,
+			To:          week.To,
+			Stats:       s.CurrentStats(),
+			Gateways:    week.Gateways,
+			Domains:     week.Domains,
+			MonthToDate: tr.Report(month, now),
+		})
+	}
+}
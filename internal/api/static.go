@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compressibleExt lists the static file types worth compressing; images
+// and fonts are already compressed.
+var compressibleExt = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".json": true,
+	".svg": true, ".txt": true, ".map": true, ".xml": true,
+}
+
+// staticEncodings in order of preference. gzip is produced in memory;
+// brotli and zstd need precompressed siblings (app.js.br, app.js.zst), as
+// their maximum levels are too slow to run at startup.
+var staticEncodings = []struct{ name, ext string }{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+// StaticFiles serves fsys like http.FileServer, but keeps compressible
+// files in memory in every encoding the client may ask for, so the web UI
+// costs no compression work per request. Entries are read again when a
+// file's size or modification time changes, so webDir edits show up
+// without a restart. It relies on GzipHandler for the Vary header.
+func StaticFiles(fsys fs.FS) http.Handler {
+	s := &staticFiles{fsys: fsys, fallback: http.FileServer(http.FS(fsys)), cache: make(map[string]*staticEntry)}
+	s.warm()
+	return s
+}
+
+type staticFiles struct {
+	fsys     fs.FS
+	fallback http.Handler
+
+	mu    sync.Mutex
+	cache map[string]*staticEntry
+}
+
+type staticEntry struct {
+	size     int64
+	modTime  time.Time
+	etag     string
+	variants map[string][]byte // by Content-Encoding, "" is identity
+}
+
+// warm compresses all compressible files up front, so the first visitors
+// are not the ones waiting for it.
+func (s *staticFiles) warm() {
+	start := time.Now()
+	n := 0
+	fs.WalkDir(s.fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && compressibleExt[path.Ext(name)] {
+			if _, err := s.entry(name); err == nil {
+				n++
+			}
+		}
+		return nil
+	})
+	log.Printf("Compressed %d static files in %v", n, time.Since(start).Round(time.Millisecond))
+}
+
+func (s *staticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/index.html"):
+		// http.FileServer redirects these to the directory.
+		s.fallback.ServeHTTP(w, r)
+		return
+	case strings.HasSuffix(r.URL.Path, "/"):
+		name = path.Join(name, "index.html")
+	}
+	if !compressibleExt[path.Ext(name)] {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+	e, err := s.entry(name)
+	if err != nil {
+		s.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	h := w.Header()
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	body, enc := e.variants[""], ""
+	accept := r.Header.Get("Accept-Encoding")
+	for _, c := range staticEncodings {
+		if b, ok := e.variants[c.name]; ok && acceptsEncoding(accept, c.name) {
+			body, enc = b, c.name
+			break
+		}
+	}
+	if enc != "" {
+		h.Set("Content-Encoding", enc)
+		h.Set("ETag", `"`+e.etag+"-"+enc+`"`)
+	} else {
+		h.Set("ETag", `"`+e.etag+`"`)
+	}
+	http.ServeContent(w, r, name, e.modTime, bytes.NewReader(body))
+}
+
+// entry returns the cached variants of name, reading and compressing the
+// file if it is new or changed.
+func (s *staticFiles) entry(name string) (*staticEntry, error) {
+	st, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, fs.ErrNotExist
+	}
+	s.mu.Lock()
+	e := s.cache[name]
+	s.mu.Unlock()
+	if e != nil && e.size == st.Size() && e.modTime.Equal(st.ModTime()) {
+		return e, nil
+	}
+
+	data, err := fs.ReadFile(s.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	e = &staticEntry{
+		size:     st.Size(),
+		modTime:  st.ModTime(),
+		etag:     hex.EncodeToString(sum[:8]),
+		variants: map[string][]byte{"": data},
+	}
+	for _, c := range staticEncodings {
+		// A sibling older than the file belongs to a previous version,
+		// e.g. an embedded app.js.br next to an app.js from webDir.
+		if sib, err := fs.Stat(s.fsys, name+c.ext); err == nil && !sib.ModTime().Before(st.ModTime()) {
+			if b, err := fs.ReadFile(s.fsys, name+c.ext); err == nil {
+				e.variants[c.name] = b
+			}
+		}
+	}
+	if _, ok := e.variants["gzip"]; !ok {
+		var buf bytes.Buffer
+		gz, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		gz.Write(data)
+		gz.Close()
+		if buf.Len() < len(data) {
+			e.variants["gzip"] = buf.Bytes()
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[name] = e
+	s.mu.Unlock()
+	return e, nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows enc,
+// honouring q=0 exclusions.
+func acceptsEncoding(header, enc string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), enc) {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
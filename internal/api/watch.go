@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/watch"
+)
+
+// RegisterWatchHandlers registers the area subscription API. Anyone may
+// subscribe, up to maxWatchesPerClient times per address; the returned
+// token is needed to read the feed or unsubscribe.
+func RegisterWatchHandlers(mux *http.ServeMux, wt *watch.Watcher) {
+	mux.HandleFunc("/api/watch", handleWatchCreate(wt))
+	mux.HandleFunc("/api/watch/", handleWatchFeed(wt))
+}
+
+func handleWatchCreate(wt *watch.Watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var sub watch.Subscription
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&sub); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, err := wt.Add(sub, clientKey(r))
+		if errors.Is(err, watch.ErrLimit) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created.Client = ""
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	}
+}
+
+func handleWatchFeed(wt *watch.Watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/watch/"), "/")
+		token := r.URL.Query().Get("token")
+		switch r.Method {
+		case http.MethodGet:
+			sub, ok := wt.Get(id, token)
+			if !ok {
+				http.Error(w, "subscription not found", http.StatusNotFound)
+				return
+			}
+			sub.Token, sub.Client = "", ""
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			json.NewEncoder(w).Encode(sub)
+		case http.MethodDelete:
+			if !wt.Delete(id, token) {
+				http.Error(w, "subscription not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/respondd"
+)
+
+// placeholder matches template fields such as {z} or {MODEL} in URLs.
+var placeholder = regexp.MustCompile(`\{[^}]*\}`)
+
+// Check reports problems that Load tolerates but that are almost
+// certainly mistakes: unparseable values replaced by defaults, malformed
+// URLs, incomplete tile layers and keys that the selected mode ignores.
+func (c *Config) Check() []string {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if _, err := time.ParseDuration(c.RefreshInterval); err != nil {
+		addf("refreshInterval %q is not a duration, using %s", c.RefreshInterval, c.RefreshDuration)
+	} else if c.RefreshDuration < 10*time.Second {
+		addf("refreshInterval %s is very short and may overload the data source", c.RefreshDuration)
+	}
+	if _, err := time.ParseDuration(c.FlapWindow); err != nil {
+		addf("flapWindow %q is not a duration, using %s", c.FlapWindow, c.FlapWindowDuration)
+	}
+
+	if c.Federation && len(c.Sources) > 0 {
+		addf("dataURL and sources are ignored in federation mode")
+	}
+	for _, src := range c.Sources {
+		for _, u := range src.URL {
+			switch {
+			case strings.HasPrefix(u, "respondd://"):
+				if _, err := respondd.ParseURL(u); err != nil {
+					addf("source %s: %v", src.Name, err)
+				}
+			case strings.HasPrefix(u, "yanic://"):
+				if strings.TrimPrefix(u, "yanic://") == "" {
+					addf("source %s: %q names no state file", src.Name, u)
+				}
+			default:
+				checkURL(addf, "source "+src.Name, strings.TrimPrefix(u, "yanic+"))
+			}
+		}
+	}
+	checkURL(addf, "grafanaURL", c.GrafanaURL)
+	checkURL(addf, "influxURL", c.InfluxURL)
+	if c.Federation && c.InfluxURL != "" {
+		addf("influxURL is ignored in federation mode; use communityMetrics")
+	}
+	if !c.Federation && len(c.CommunityMetrics) > 0 {
+		addf("communityMetrics only applies in federation mode; use influxURL")
+	}
+	if !c.Federation && len(c.FederationInclude)+len(c.FederationExclude) > 0 {
+		addf("federationInclude and federationExclude only apply in federation mode")
+	}
+	if !c.Federation && c.FederationDirectory != "" {
+		addf("federationDirectory only applies in federation mode")
+	}
+	for key, src := range c.CommunityMetrics {
+		checkURL(addf, "communityMetrics["+key+"]", src.URL)
+	}
+	checkURL(addf, "devicePictureURL", c.DevicePictureURL)
+	checkURL(addf, "eolInfoURL", c.EolInfoURL)
+	for i, u := range c.WireguardStatsURLs {
+		checkURL(addf, fmt.Sprintf("wireguardStatsURLs[%d]", i), u)
+	}
+	for i, l := range c.Links {
+		if l.Title == "" {
+			addf("links[%d]: title is empty", i)
+		}
+		checkURL(addf, fmt.Sprintf("links[%d]", i), l.Href)
+	}
+	checkURL(addf, "acmeDirectory", c.ACMEDirectory)
+	if len(c.ACMEDomains) > 0 && !strings.HasSuffix(c.Listen, ":443") {
+		addf("acmeDomains is set but listen %s is not port 443; browsers will not find the map at https://%s/", c.Listen, c.ACMEDomains[0])
+	}
+	for _, job := range c.ExportJobs {
+		checkURL(addf, "exportJobs "+job.Name+" webhook", job.Webhook)
+	}
+
+	for i, tl := range c.TileLayers {
+		if tl.Name == "" {
+			addf("tileLayers[%d]: name is empty", i)
+		}
+		if tl.URL == "" {
+			addf("tileLayers[%d]: url is empty", i)
+		} else {
+			checkURL(addf, fmt.Sprintf("tileLayers[%d]", i), tl.URL)
+		}
+		if tl.Attribution == "" {
+			addf("tileLayers[%d]: attribution is empty (most tile providers require one)", i)
+		}
+		if tl.MaxZoom <= 0 {
+			addf("tileLayers[%d]: maxZoom is not set", i)
+		}
+	}
+
+	return problems
+}
+
+// checkURL reports u unless it is empty or an absolute http(s) URL.
+// Template placeholders are allowed anywhere.
+func checkURL(addf func(string, ...interface{}), what, u string) {
+	if u == "" {
+		return
+	}
+	p, err := url.Parse(placeholder.ReplaceAllString(u, "x"))
+	if err != nil {
+		addf("%s: invalid URL %q: %v", what, u, err)
+		return
+	}
+	if (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		addf("%s: %q is not an absolute http(s) URL", what, u)
+	}
+}
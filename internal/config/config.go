@@ -3,7 +3,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,54 +25,677 @@ type ExternalLink struct {
 	Href  string `json:"href"`
 }
 
+// ExportJob periodically delivers the nodes matching a saved filter.
+type ExportJob struct {
+	Name    string `json:"name"`
+	Filter  string `json:"filter"`
+	Every   string `json:"every"`   // interval, e.g. "24h"
+	At      string `json:"at"`      // daily wall-clock time "HH:MM", overrides every
+	Webhook string `json:"webhook"` // URL to POST the result to
+	File    string `json:"file"`    // path to write the result to
+	Format  string `json:"format"`  // "json" (default) or "csv"
+
+	EveryDuration time.Duration `json:"-"`
+	AtHour        int           `json:"-"` // -1 when At is unset
+	AtMinute      int           `json:"-"`
+}
+
+// StringList is a JSON string or array of strings.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*l = nil
+		if one != "" {
+			*l = StringList{one}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("want a string or an array of strings")
+	}
+	*l = many
+	return nil
+}
+
+// Source is one data source merged in single-community mode.
+type Source struct {
+	Name   string     `json:"name"`
+	URL    StringList `json:"url"`    // primary URL, then fallbacks
+	Domain string     `json:"domain"` // assigned to all nodes of this source
+}
+
+// Overlay is a GeoJSON layer shown on top of the map, read from File or
+// fetched from URL.
+type Overlay struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	URL   string `json:"url"`
+	Color string `json:"color"` // CSS color for lines and fills
+
+	ID string `json:"-"` // URL-safe form of Name
+}
+
+// RateLimit limits API requests per client IP with token buckets. Routes
+// add tighter limits for path prefixes; a request there draws from both.
+type RateLimit struct {
+	RequestsPerMinute float64      `json:"requestsPerMinute"` // 0 disables limiting
+	Burst             int          `json:"burst"`
+	Routes            []RouteLimit `json:"routes"`
+}
+
+// RouteLimit is the limit for API paths starting with Prefix.
+type RouteLimit struct {
+	Prefix            string  `json:"prefix"`
+	RequestsPerMinute float64 `json:"requestsPerMinute"`
+	Burst             int     `json:"burst"`
+}
+
+// defaultRouteLimits protect the endpoints that query Prometheus or
+// render the whole map when rateLimit does not list routes.
+var defaultRouteLimits = []RouteLimit{
+	{Prefix: "/api/metrics/", RequestsPerMinute: 30, Burst: 10},
+	{Prefix: "/api/export/", RequestsPerMinute: 6, Burst: 3},
+}
+
+// SecurityHeaders configures the headers sent with every response. The
+// Content-Security-Policy is derived from the tile, device picture and
+// theme URLs unless ContentSecurityPolicy replaces it.
+type SecurityHeaders struct {
+	Disable               bool     `json:"disable"`               // leave them to a reverse proxy
+	ContentSecurityPolicy string   `json:"contentSecurityPolicy"` // replaces the derived policy
+	FrameAncestors        []string `json:"frameAncestors"`        // sites allowed to embed the map, default 'self'
+	ReferrerPolicy        string   `json:"referrerPolicy"`
+}
+
+// MetricsSource is an InfluxDB that node charts are queried from instead
+// of a Grafana datasource proxy. Version 1 speaks InfluxQL with optional
+// basic auth, version 2 speaks Flux with a token.
+type MetricsSource struct {
+	URL      string `json:"url"`
+	Version  int    `json:"version"`  // 1 (default) or 2
+	Database string `json:"database"` // version 1, default "yanic"
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Org      string `json:"org"`    // version 2
+	Bucket   string `json:"bucket"` // version 2, default database
+	Token    string `json:"token"`
+}
+
+// InfluxSource returns the influx* settings of a single-community map,
+// or nil without influxURL.
+func (c *Config) InfluxSource() *MetricsSource {
+	if c.InfluxURL == "" {
+		return nil
+	}
+	return &MetricsSource{
+		URL:      c.InfluxURL,
+		Version:  c.InfluxVersion,
+		Database: c.InfluxDatabase,
+		Username: c.InfluxUsername,
+		Password: c.InfluxPassword,
+		Org:      c.InfluxOrg,
+		Bucket:   c.InfluxBucket,
+		Token:    c.InfluxToken,
+	}
+}
+
+// normalize applies defaults and checks what can be checked offline.
+func (m *MetricsSource) normalize() error {
+	if m.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	if m.Database == "" {
+		m.Database = "yanic"
+	}
+	if m.Bucket == "" {
+		m.Bucket = m.Database
+	}
+	switch m.Version {
+	case 1:
+	case 2:
+		if m.Org == "" {
+			return fmt.Errorf("org is required with version 2")
+		}
+	default:
+		return fmt.Errorf("version must be 1 or 2")
+	}
+	return nil
+}
+
+// Instance is an additional map served by the same process below Path,
+// configured by its own config file.
+type Instance struct {
+	Path   string `json:"path"`
+	Config string `json:"config"`
+}
+
+// Theme brands the frontend. Colors maps CSS variables of app.css
+// (without the leading "--", e.g. "accent") to CSS color values.
+type Theme struct {
+	Colors       map[string]string `json:"colors"`
+	LogoURL      string            `json:"logoURL"`
+	FaviconURL   string            `json:"faviconURL"`
+	FooterText   string            `json:"footerText"`
+	DefaultLayer string            `json:"defaultLayer"` // tile layer name shown first
+}
+
+// themeColors are the CSS variables a theme may override.
+var themeColors = map[string]bool{
+	"bg": true, "bg-secondary": true, "bg-tertiary": true, "fg": true, "fg-muted": true,
+	"border": true, "accent": true, "accent-light": true, "online": true, "offline": true,
+	"new-node": true, "gateway": true, "link-good": true, "link-bad": true,
+}
+
+// cssColor accepts hex, named and functional (rgb(), hsl()) colors.
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|rgba|hsl|hsla)\([0-9.,%\s]+\))$`)
+
+// UpstreamHeaders adds headers or basic auth credentials to data source
+// requests on the scheme and host of URLPrefix whose path is at or below
+// its path.
+type UpstreamHeaders struct {
+	URLPrefix string            `json:"urlPrefix"`
+	Headers   map[string]string `json:"headers"`
+	Username  string            `json:"username"`
+	Password  string            `json:"password"`
+}
+
 type Config struct {
-	Listen           string            `json:"listen"`
-	SiteName         string            `json:"siteName"`
-	DataURL          string            `json:"dataURL"`
-	RefreshInterval  string            `json:"refreshInterval"`
-	GrafanaURL       string            `json:"grafanaURL"`
-	GrafanaDashboard string            `json:"grafanaDashboard"`
-	GrafanaOrgId     int               `json:"grafanaOrgId"`
-	MapCenter        [2]float64        `json:"mapCenter"`
-	MapZoom          int               `json:"mapZoom"`
-	TileLayers       []TileLayer       `json:"tileLayers"`
-	DomainNames      map[string]string `json:"domainNames"`
-	Links            []ExternalLink    `json:"links"`
-	DevicePictureURL string            `json:"devicePictureURL"`
-	EolInfoURL       string            `json:"eolInfoURL"`
-	Federation       bool              `json:"federation"`
+	Listen                string                   `json:"listen"`     // host:port or unix:/path
+	SocketMode            string                   `json:"socketMode"` // octal permissions of a unix: socket
+	SiteName              string                   `json:"siteName"`
+	DataURL               string                   `json:"-"` // first of DataURLs
+	DataURLs              StringList               `json:"dataURL"`
+	RefreshInterval       string                   `json:"refreshInterval"`
+	GrafanaURL            string                   `json:"grafanaURL"`
+	GrafanaDashboard      string                   `json:"grafanaDashboard"`
+	GrafanaOrgId          int                      `json:"grafanaOrgId"`
+	GrafanaDatasourceId   int                      `json:"grafanaDatasourceId"`   // 0 discovers it
+	GrafanaDatabase       string                   `json:"grafanaDatabase"`       // "" uses the datasource's
+	GrafanaProxyAllow     []string                 `json:"grafanaProxyAllow"`     // discovered Grafana hosts the metrics proxy may query
+	GrafanaProxyDeny      []string                 `json:"grafanaProxyDeny"`      // hosts it must not query
+	GrafanaProxyAllowHTTP bool                     `json:"grafanaProxyAllowHTTP"` // permit discovered http:// Grafana URLs
+	InfluxURL             string                   `json:"influxURL"`             // query node metrics from InfluxDB instead of Grafana
+	InfluxDatabase        string                   `json:"influxDatabase"`
+	InfluxUsername        string                   `json:"influxUsername"`
+	InfluxPassword        string                   `json:"influxPassword"`
+	InfluxVersion         int                      `json:"influxVersion"` // 2 queries the v2 API with Flux
+	InfluxOrg             string                   `json:"influxOrg"`
+	InfluxBucket          string                   `json:"influxBucket"` // default influxDatabase
+	InfluxToken           string                   `json:"influxToken"`
+	CommunityMetrics      map[string]MetricsSource `json:"communityMetrics"` // per community key, federation mode
+	MapCenter             [2]float64               `json:"mapCenter"`
+	MapZoom               int                      `json:"mapZoom"`
+	TileLayers            []TileLayer              `json:"tileLayers"`
+	DomainNames           map[string]string        `json:"domainNames"`
+	LocationGrid          float64                  `json:"locationGrid"`       // meters; snaps published coordinates, 0 = exact
+	DomainLocationGrid    map[string]float64       `json:"domainLocationGrid"` // per domain key, overrides locationGrid
+	HiddenNodes           []string                 `json:"hiddenNodes"`        // node IDs or MACs left out of all output
+	NodeAliases           map[string]string        `json:"nodeAliases"`        // old node ID -> replacement
+	Links                 []ExternalLink           `json:"links"`
+	DevicePictureURL      string                   `json:"devicePictureURL"`
+	EolInfoURL            string                   `json:"eolInfoURL"`
+	Federation            bool                     `json:"federation"`
+	FederationInclude     []string                 `json:"federationInclude"` // community keys or metacommunities
+	FederationExclude     []string                 `json:"federationExclude"`
+	FederationDirectory   string                   `json:"federationDirectory"` // URL or local file, default api.freifunk.net
+	FlapWindow            string                   `json:"flapWindow"`
+	FlapThreshold         int                      `json:"flapThreshold"`
+	OrphanLinks           string                   `json:"orphanLinks"` // drop, flag or placeholder
+	WireguardStatsURLs    []string                 `json:"wireguardStatsURLs"`
+	StatsdAddr            string                   `json:"statsdAddr"`
+	StatsdPrefix          string                   `json:"statsdPrefix"`
+	AdminToken            string                   `json:"adminToken"`
+	DebugEndpoints        bool                     `json:"debugEndpoints"` // pprof and /debug/vars, admin only
+	OTLPEndpoint          string                   `json:"otlpEndpoint"`   // e.g. "http://localhost:4318"
+	OTLPHeaders           map[string]string        `json:"otlpHeaders"`
+	OTELServiceName       string                   `json:"otelServiceName"`
+	TraceSampleRatio      float64                  `json:"traceSampleRatio"` // share of new traces recorded
+	FiltersFile           string                   `json:"filtersFile"`
+	HiddenNodesFile       string                   `json:"hiddenNodesFile"`
+	AnnotationsFile       string                   `json:"annotationsFile"`
+	NodeAliasesFile       string                   `json:"nodeAliasesFile"`
+	OfflineAfter          string                   `json:"offlineAfter"`
+	ExportJobs            []ExportJob              `json:"exportJobs"`
+	WatchFile             string                   `json:"watchFile"`
+	MaxWatches            int                      `json:"maxWatches"`
+	MaxWatchesPerClient   int                      `json:"maxWatchesPerClient"`
+	SLAFile               string                   `json:"slaFile"`
+	HistoryFile           string                   `json:"historyFile"`
+	CompatFrontends       []string                 `json:"compatFrontends"` // hopglass, meshviewer
+	PublicURL             string                   `json:"publicURL"`       // scheme and host clients reach this server at
+	FetchRetries          int                      `json:"fetchRetries"`
+	FetchRetryBackoff     string                   `json:"fetchRetryBackoff"`
+	DataStaleAfter        string                   `json:"dataStaleAfter"`
+	Sources               []Source                 `json:"sources"`
+	UpstreamHeaders       []UpstreamHeaders        `json:"upstreamHeaders"`
+	Proxy                 string                   `json:"proxy"` // http(s):// or socks5:// URL
+	FetchTimeout          string                   `json:"fetchTimeout"`
+	ProbeTimeout          string                   `json:"probeTimeout"`
+	MaxDataSizeMB         int                      `json:"maxDataSizeMB"`
+	MaxDirectorySizeMB    int                      `json:"maxDirectorySizeMB"`
+	BasePath              string                   `json:"basePath"` // URL prefix, e.g. "/map"
+	WebDir                string                   `json:"webDir"`   // overrides embedded frontend files
+	Theme                 Theme                    `json:"theme"`
+	I18nDir               string                   `json:"i18nDir"` // {lang}.json catalog overrides
+	Overlays              []Overlay                `json:"overlays"`
+	Instances             []Instance               `json:"instances"`
+	RateLimit             RateLimit                `json:"rateLimit"`
+	CacheControl          map[string]string        `json:"cacheControl"` // Cache-Control by path prefix
+	SecurityHeaders       SecurityHeaders          `json:"securityHeaders"`
+	TrustedProxies        []string                 `json:"trustedProxies"`     // CIDRs or IPs allowed to set X-Forwarded-For
+	TLSCert               string                   `json:"tlsCert"`            // PEM certificate chain, enables HTTPS
+	TLSKey                string                   `json:"tlsKey"`             // PEM private key
+	TLSClientCA           string                   `json:"tlsClientCA"`        // CA for admin client certificates
+	HTTPRedirectListen    string                   `json:"httpRedirectListen"` // e.g. ":80", redirects to HTTPS
+	ACMEDomains           []string                 `json:"acmeDomains"`        // request certificates via ACME
+	ACMECacheDir          string                   `json:"acmeCacheDir"`       // account key and certificates
+	ACMEEmail             string                   `json:"acmeEmail"`
+	ACMEDirectory         string                   `json:"acmeDirectory"` // defaults to Let's Encrypt
+	ACMEAcceptTOS         bool                     `json:"acmeAcceptTOS"` // agree to the CA's terms of service
 
 	// Parsed internally
-	RefreshDuration time.Duration `json:"-"`
+	RefreshDuration           time.Duration  `json:"-"`
+	SocketFileMode            os.FileMode    `json:"-"`
+	TrustedProxyNets          []netip.Prefix `json:"-"`
+	FlapWindowDuration        time.Duration  `json:"-"`
+	OfflineAfterDuration      time.Duration  `json:"-"` // 0 = trust is_online
+	FetchRetryBackoffDuration time.Duration  `json:"-"`
+	DataStaleAfterDuration    time.Duration  `json:"-"` // 0 = never stale
+	ProxyURL                  *url.URL       `json:"-"` // nil = use environment
+	FetchTimeoutDuration      time.Duration  `json:"-"`
+	ProbeTimeoutDuration      time.Duration  `json:"-"`
+	MaxDataBytes              int64          `json:"-"`
+	MaxDirectoryBytes         int64          `json:"-"`
 }
 
+// Load reads the config file and applies FFMAP_* environment overrides.
+// The file may be missing if the environment provides the configuration.
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && hasEnvOverrides() {
+		data, err = []byte("{}"), nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("reading config: %w", err)
 	}
 
 	cfg := &Config{
-		Listen:           ":8080",
-		SiteName:         "Freifunk Map",
-		RefreshInterval:  "60s",
-		MapCenter:        [2]float64{48.1351, 11.5820},
-		MapZoom:          10,
-		GrafanaOrgId:     1,
-		DevicePictureURL: "https://map.aachen.freifunk.net/pictures-svg/{MODEL}.svg",
+		Listen:             ":8080",
+		SocketMode:         "0660",
+		OTELServiceName:    "freifunk-map",
+		TraceSampleRatio:   1,
+		SiteName:           "Freifunk Map",
+		RefreshInterval:    "60s",
+		MapCenter:          [2]float64{48.1351, 11.5820},
+		MapZoom:            10,
+		GrafanaOrgId:       1,
+		InfluxDatabase:     "yanic",
+		InfluxVersion:      1,
+		DevicePictureURL:   "https://map.aachen.freifunk.net/pictures-svg/{MODEL}.svg",
+		FlapWindow:         "1h",
+		FlapThreshold:      4,
+		OrphanLinks:        "flag",
+		StatsdPrefix:       "freifunk_map",
+		FiltersFile:        "filters.json",
+		HiddenNodesFile:    "hidden_nodes.json",
+		AnnotationsFile:    "annotations.json",
+		NodeAliasesFile:    "node_aliases.json",
+		WatchFile:          "watches.json",
+		MaxWatches:         1000,
+		SLAFile:            "sla_history.json",
+		HistoryFile:        "client_history.json",
+		FetchRetries:       2,
+		FetchRetryBackoff:  "1s",
+		DataStaleAfter:     "15m",
+		FetchTimeout:       "30s",
+		ProbeTimeout:       "8s",
+		MaxDataSizeMB:      20,
+		MaxDirectorySizeMB: 10,
+		SecurityHeaders:    SecurityHeaders{ReferrerPolicy: "strict-origin-when-cross-origin"},
 	}
 
 	if err := json.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parsing config: %w", err)
 	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
 
 	cfg.RefreshDuration, err = time.ParseDuration(cfg.RefreshInterval)
 	if err != nil {
 		cfg.RefreshDuration = 60 * time.Second
 	}
 
-	if cfg.DataURL == "" && !cfg.Federation {
-		return nil, fmt.Errorf("dataURL is required in config (or set federation: true)")
+	cfg.FlapWindowDuration, err = time.ParseDuration(cfg.FlapWindow)
+	if err != nil {
+		cfg.FlapWindowDuration = time.Hour
+	}
+
+	if cfg.OfflineAfter != "" {
+		cfg.OfflineAfterDuration, err = time.ParseDuration(cfg.OfflineAfter)
+		if err != nil {
+			return nil, fmt.Errorf("parsing offlineAfter: %w", err)
+		}
+	}
+
+	for i := range cfg.ExportJobs {
+		job := &cfg.ExportJobs[i]
+		if job.Filter == "" || (job.Webhook == "" && job.File == "") {
+			return nil, fmt.Errorf("exportJobs[%d]: filter and webhook or file are required", i)
+		}
+		if job.Name == "" {
+			job.Name = job.Filter
+		}
+		job.AtHour = -1
+		if job.At != "" {
+			t, err := time.Parse("15:04", job.At)
+			if err != nil {
+				return nil, fmt.Errorf("exportJobs[%d]: at must be HH:MM", i)
+			}
+			job.AtHour, job.AtMinute = t.Hour(), t.Minute()
+		}
+		job.EveryDuration, err = time.ParseDuration(job.Every)
+		if err != nil || job.EveryDuration < time.Minute {
+			job.EveryDuration = 24 * time.Hour
+		}
+	}
+
+	switch cfg.OrphanLinks {
+	case "drop", "flag", "placeholder":
+	default:
+		return nil, fmt.Errorf("orphanLinks must be \"drop\", \"flag\" or \"placeholder\", got %q", cfg.OrphanLinks)
+	}
+
+	if cfg.FetchRetries < 0 || cfg.FetchRetries > 10 {
+		return nil, fmt.Errorf("fetchRetries must be between 0 and 10, got %d", cfg.FetchRetries)
+	}
+	cfg.FetchRetryBackoffDuration, err = time.ParseDuration(cfg.FetchRetryBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("parsing fetchRetryBackoff: %w", err)
+	}
+	if cfg.FetchRetryBackoffDuration <= 0 {
+		cfg.FetchRetryBackoffDuration = time.Second
+	}
+	if cfg.MaxWatchesPerClient <= 0 {
+		cfg.MaxWatchesPerClient = 10
+	}
+
+	seenFrontends := make(map[string]bool)
+	for _, f := range cfg.CompatFrontends {
+		if f != "hopglass" && f != "meshviewer" {
+			return nil, fmt.Errorf("compatFrontends: unknown frontend %q (want \"hopglass\" or \"meshviewer\")", f)
+		}
+		// Each frontend registers its routes once.
+		if seenFrontends[f] {
+			return nil, fmt.Errorf("compatFrontends: %q listed twice", f)
+		}
+		seenFrontends[f] = true
+	}
+	if cfg.PublicURL != "" {
+		cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+		u, err := url.Parse(cfg.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("publicURL must be an http:// or https:// URL without a path, got %q", cfg.PublicURL)
+		}
+	}
+
+	if cfg.LocationGrid < 0 {
+		return nil, fmt.Errorf("locationGrid must not be negative")
+	}
+	for d, g := range cfg.DomainLocationGrid {
+		if g < 0 {
+			return nil, fmt.Errorf("domainLocationGrid %q must not be negative", d)
+		}
+	}
+	for from, to := range cfg.NodeAliases {
+		if from == "" || to == "" || from == to {
+			return nil, fmt.Errorf("nodeAliases %q -> %q: old and new node ID must be set and differ", from, to)
+		}
+		id := to
+		for i := 0; i < len(cfg.NodeAliases); i++ {
+			if id == from {
+				return nil, fmt.Errorf("nodeAliases: %q is part of a cycle", from)
+			}
+			next, ok := cfg.NodeAliases[id]
+			if !ok {
+				break
+			}
+			id = next
+		}
+	}
+
+	if cfg.DataStaleAfter != "" {
+		cfg.DataStaleAfterDuration, err = time.ParseDuration(cfg.DataStaleAfter)
+		if err != nil {
+			return nil, fmt.Errorf("parsing dataStaleAfter: %w", err)
+		}
+	}
+
+	cfg.FetchTimeoutDuration, err = time.ParseDuration(cfg.FetchTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("parsing fetchTimeout: %w", err)
+	}
+	cfg.ProbeTimeoutDuration, err = time.ParseDuration(cfg.ProbeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("parsing probeTimeout: %w", err)
+	}
+	if cfg.FetchTimeoutDuration <= 0 || cfg.ProbeTimeoutDuration <= 0 {
+		return nil, fmt.Errorf("fetchTimeout and probeTimeout must be positive")
+	}
+	if cfg.MaxDataSizeMB <= 0 || cfg.MaxDirectorySizeMB <= 0 {
+		return nil, fmt.Errorf("maxDataSizeMB and maxDirectorySizeMB must be positive")
+	}
+	cfg.MaxDataBytes = int64(cfg.MaxDataSizeMB) << 20
+	cfg.MaxDirectoryBytes = int64(cfg.MaxDirectorySizeMB) << 20
+
+	if cfg.Proxy != "" {
+		cfg.ProxyURL, err = url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy: %w", err)
+		}
+		switch cfg.ProxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("proxy must be an http, https or socks5 URL, got %q", cfg.Proxy)
+		}
+	}
+
+	for name, c := range cfg.Theme.Colors {
+		if !themeColors[name] {
+			return nil, fmt.Errorf("theme.colors: unknown color %q", name)
+		}
+		if !cssColor.MatchString(c) {
+			return nil, fmt.Errorf("theme.colors.%s: %q is not a CSS color", name, c)
+		}
+	}
+	if dl := cfg.Theme.DefaultLayer; dl != "" {
+		found := false
+		for _, tl := range cfg.TileLayers {
+			found = found || tl.Name == dl
+		}
+		if !found {
+			return nil, fmt.Errorf("theme.defaultLayer %q does not name a tile layer", dl)
+		}
+	}
+
+	overlayIDs := make(map[string]bool)
+	for i := range cfg.Overlays {
+		o := &cfg.Overlays[i]
+		if o.Name == "" || (o.File == "") == (o.URL == "") {
+			return nil, fmt.Errorf("overlays[%d]: name and exactly one of file or url are required", i)
+		}
+		if o.Color != "" && !cssColor.MatchString(o.Color) {
+			return nil, fmt.Errorf("overlays[%d]: %q is not a CSS color", i, o.Color)
+		}
+		o.ID = slug(o.Name)
+		if o.ID == "" {
+			o.ID = fmt.Sprintf("overlay-%d", i)
+		}
+		if overlayIDs[o.ID] {
+			return nil, fmt.Errorf("overlays[%d]: duplicate name %q", i, o.Name)
+		}
+		overlayIDs[o.ID] = true
+	}
+
+	cfg.BasePath = strings.TrimRight(cfg.BasePath, "/")
+	if cfg.BasePath != "" && !strings.HasPrefix(cfg.BasePath, "/") {
+		return nil, fmt.Errorf("basePath must start with /, got %q", cfg.BasePath)
+	}
+
+	for i, h := range cfg.UpstreamHeaders {
+		// An empty prefix would send credentials to every host.
+		u, err := url.Parse(h.URLPrefix)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("upstreamHeaders[%d]: urlPrefix must be an http:// or https:// URL with a host", i)
+		}
+		if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("upstreamHeaders[%d]: urlPrefix must not contain credentials, a query or a fragment", i)
+		}
+	}
+
+	for _, u := range cfg.DataURLs {
+		if u == "" {
+			return nil, fmt.Errorf("dataURL must not contain empty entries")
+		}
+	}
+	if len(cfg.DataURLs) > 0 {
+		cfg.DataURL = cfg.DataURLs[0]
+	}
+	for i, src := range cfg.Sources {
+		if len(src.URL) == 0 {
+			return nil, fmt.Errorf("sources[%d]: url is required", i)
+		}
+		for _, u := range src.URL {
+			if u == "" {
+				return nil, fmt.Errorf("sources[%d]: url must not contain empty entries", i)
+			}
+		}
+		if src.Name == "" {
+			cfg.Sources[i].Name = src.URL[0]
+		}
+	}
+	if len(cfg.DataURLs) > 0 {
+		// dataURL is the first, untagged source.
+		cfg.Sources = append([]Source{{Name: cfg.DataURL, URL: cfg.DataURLs}}, cfg.Sources...)
+	}
+	for _, tp := range cfg.TrustedProxies {
+		p, err := netip.ParsePrefix(tp)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(tp)
+			if aerr != nil {
+				return nil, fmt.Errorf("trustedProxies: %q is neither a CIDR nor an IP address", tp)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		cfg.TrustedProxyNets = append(cfg.TrustedProxyNets, p.Masked())
+	}
+	if rl := &cfg.RateLimit; rl.RequestsPerMinute > 0 {
+		if rl.Routes == nil {
+			rl.Routes = append([]RouteLimit(nil), defaultRouteLimits...)
+		}
+		if rl.Burst <= 0 {
+			rl.Burst = int(math.Max(1, rl.RequestsPerMinute/6))
+		}
+		for i, r := range rl.Routes {
+			if !strings.HasPrefix(r.Prefix, "/") || r.RequestsPerMinute <= 0 {
+				return nil, fmt.Errorf("rateLimit.routes[%d]: prefix below / and requestsPerMinute are required", i)
+			}
+			if r.Burst <= 0 {
+				rl.Routes[i].Burst = 1
+			}
+		}
+	}
+	if cfg.GrafanaDatasourceId < 0 {
+		return nil, fmt.Errorf("grafanaDatasourceId must not be negative")
+	}
+	for _, h := range append(append([]string(nil), cfg.GrafanaProxyAllow...), cfg.GrafanaProxyDeny...) {
+		if h == "" || strings.ContainsAny(h, "/:@ ") || strings.Contains(h[1:], "*") || (h[0] == '*' && !strings.HasPrefix(h, "*.")) {
+			return nil, fmt.Errorf("grafanaProxyAllow/grafanaProxyDeny: %q is not a host name like stats.example.org or *.example.org", h)
+		}
+	}
+	if src := cfg.InfluxSource(); src != nil {
+		if err := src.normalize(); err != nil {
+			return nil, fmt.Errorf("influx settings: %w", err)
+		}
+		cfg.InfluxBucket = src.Bucket
+	}
+	for key, src := range cfg.CommunityMetrics {
+		if err := src.normalize(); err != nil {
+			return nil, fmt.Errorf("communityMetrics[%q]: %w", key, err)
+		}
+		cfg.CommunityMetrics[key] = src
+	}
+	for _, fa := range cfg.SecurityHeaders.FrameAncestors {
+		if fa == "" || strings.ContainsAny(fa, " ;,") {
+			return nil, fmt.Errorf("securityHeaders.frameAncestors: %q is not a single CSP source like https://example.org or 'self'", fa)
+		}
+	}
+	for prefix := range cfg.CacheControl {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("cacheControl: prefix %q must start with /", prefix)
+		}
+	}
+	if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+		return nil, fmt.Errorf("traceSampleRatio must be between 0 and 1")
+	}
+	mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return nil, fmt.Errorf("socketMode %q is not an octal permission like 0660", cfg.SocketMode)
+	}
+	cfg.SocketFileMode = os.FileMode(mode)
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return nil, fmt.Errorf("tlsCert and tlsKey must be set together")
+	}
+	if len(cfg.ACMEDomains) > 0 {
+		if cfg.TLSCert != "" {
+			return nil, fmt.Errorf("acmeDomains and tlsCert are mutually exclusive")
+		}
+		if cfg.ACMECacheDir == "" {
+			return nil, fmt.Errorf("acmeDomains requires acmeCacheDir")
+		}
+		// Registering an account agrees to the CA's terms on the
+		// operator's behalf, so that needs their explicit consent.
+		if !cfg.ACMEAcceptTOS {
+			return nil, fmt.Errorf("acmeDomains requires acmeAcceptTOS: true, agreeing to the terms of service of the CA at acmeDirectory")
+		}
+		for i, d := range cfg.ACMEDomains {
+			if d == "" || strings.ContainsAny(d, "/:* ") {
+				return nil, fmt.Errorf("acmeDomains[%d]: %q is not a host name", i, d)
+			}
+		}
+		if cfg.ACMEDirectory == "" {
+			cfg.ACMEDirectory = "https://acme-v02.api.letsencrypt.org/directory"
+		}
+		// The http-01 challenge is answered on port 80.
+		if cfg.HTTPRedirectListen == "" {
+			cfg.HTTPRedirectListen = ":80"
+		}
+	}
+	if cfg.TLSCert == "" && len(cfg.ACMEDomains) == 0 && (cfg.TLSClientCA != "" || cfg.HTTPRedirectListen != "") {
+		return nil, fmt.Errorf("tlsClientCA and httpRedirectListen require tlsCert and tlsKey or acmeDomains")
+	}
+
+	paths := make(map[string]bool)
+	for i, in := range cfg.Instances {
+		p := strings.TrimRight(in.Path, "/")
+		if !strings.HasPrefix(p, "/") || in.Config == "" {
+			return nil, fmt.Errorf("instances[%d]: path below / and config are required", i)
+		}
+		if paths[p] {
+			return nil, fmt.Errorf("instances[%d]: duplicate path %q", i, p)
+		}
+		paths[p] = true
+		cfg.Instances[i].Path = p
+	}
+	if !cfg.HasDataSources() && len(cfg.Instances) == 0 {
+		return nil, fmt.Errorf("dataURL or sources is required in config (or set federation: true)")
 	}
 
 	if cfg.Federation && cfg.SiteName == "Freifunk Map" {
@@ -82,3 +711,52 @@ func Load(path string) (*Config, error) {
 
 	return cfg, nil
 }
+
+// slug lower-cases s and replaces runs of other characters than letters
+// and digits with a dash.
+func slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// HasDataSources reports whether the config describes a map of its own,
+// rather than only listing instances.
+func (c *Config) HasDataSources() bool {
+	return len(c.Sources) > 0 || c.Federation
+}
+
+// CheckInstances rejects instance configs that would share state files,
+// and more than one federation instance, whose caches have fixed names.
+func CheckInstances(cfgs []*Config) error {
+	owner := make(map[string]string)
+	federation := ""
+	for _, c := range cfgs {
+		name := c.BasePath
+		if name == "" {
+			name = "/"
+		}
+		if c.Federation {
+			if federation != "" {
+				return fmt.Errorf("%s and %s: only one instance can use federation mode", federation, name)
+			}
+			federation = name
+		}
+		for _, f := range []string{c.FiltersFile, c.HiddenNodesFile, c.AnnotationsFile, c.NodeAliasesFile, c.WatchFile, c.SLAFile, c.HistoryFile} {
+			if prev, ok := owner[f]; ok {
+				return fmt.Errorf("%s and %s both use %s; set filtersFile, hiddenNodesFile, annotationsFile, nodeAliasesFile, watchFile, slaFile and historyFile per instance", prev, name, f)
+			}
+			owner[f] = name
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is prepended to the upper-cased JSON key to form the name of
+// the overriding environment variable, e.g. FFMAP_DATAURL for dataURL.
+const envPrefix = "FFMAP_"
+
+// hasEnvOverrides reports whether any FFMAP_ variable is set.
+func hasEnvOverrides() bool {
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnv overrides config keys from the environment. Strings are taken
+// as is; numbers and booleans are parsed; arrays and objects are JSON,
+// where a bare string is accepted for keys that take a string or list.
+func applyEnv(cfg *Config) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		name := envPrefix + strings.ToUpper(key)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), val); err != nil {
+			return fmt.Errorf("parsing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setFromEnv(f reflect.Value, val string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	default:
+		// Replace rather than merge into a value from the config file.
+		f.Set(reflect.Zero(f.Type()))
+		p := f.Addr().Interface()
+		if err := json.Unmarshal([]byte(val), p); err != nil {
+			quoted, _ := json.Marshal(val)
+			if json.Unmarshal(quoted, p) != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
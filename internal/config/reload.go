@@ -0,0 +1,107 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// reloadable lists the keys that take effect without a restart: they are
+// read per request or per refresh, never captured at startup.
+var reloadable = map[string]bool{
+	"siteName":              true,
+	"refreshInterval":       true,
+	"grafanaURL":            true,
+	"grafanaDashboard":      true,
+	"grafanaOrgId":          true,
+	"grafanaDatasourceId":   true,
+	"grafanaDatabase":       true,
+	"grafanaProxyAllow":     true,
+	"grafanaProxyDeny":      true,
+	"grafanaProxyAllowHTTP": true,
+	"influxURL":             true,
+	"influxDatabase":        true,
+	"influxUsername":        true,
+	"influxPassword":        true,
+	"influxVersion":         true,
+	"influxOrg":             true,
+	"influxBucket":          true,
+	"influxToken":           true,
+	"communityMetrics":      true,
+	"mapCenter":             true,
+	"mapZoom":               true,
+	"tileLayers":            true,
+	"domainNames":           true,
+	"locationGrid":          true,
+	"domainLocationGrid":    true,
+	"hiddenNodes":           true,
+	"nodeAliases":           true,
+	"federationInclude":     true,
+	"federationExclude":     true,
+	"federationDirectory":   true,
+	"links":                 true,
+	"devicePictureURL":      true,
+	"publicURL":             true,
+	"eolInfoURL":            true,
+	"theme":                 true,
+	"i18nDir":               true,
+	"cacheControl":          true,
+	"securityHeaders":       true,
+}
+
+// Live is the current config of an instance. A reload publishes a new
+// *Config and never modifies one already published, so readers load the
+// pointer once per request or refresh and use it without locking.
+type Live struct {
+	p atomic.Pointer[Config]
+}
+
+// NewLive returns a Live publishing c.
+func NewLive(c *Config) *Live {
+	l := &Live{}
+	l.p.Store(c)
+	return l
+}
+
+// Load returns the current config. It must not be modified.
+func (l *Live) Load() *Config {
+	return l.p.Load()
+}
+
+// Reload publishes a copy of the current config with the reloadable keys
+// of next, see ApplyReloadable. Nothing is published if none changed.
+func (l *Live) Reload(next *Config) (applied, ignored []string) {
+	updated, applied, ignored := l.Load().ApplyReloadable(next)
+	if len(applied) > 0 {
+		l.p.Store(updated)
+	}
+	return applied, ignored
+}
+
+// ApplyReloadable returns a copy of c with the reloadable keys that
+// differ in next taken from next, and their names. Keys that differ but
+// need a restart are returned as ignored. c itself is not modified, and
+// the copy shares c's maps and slices, which are never modified either.
+func (c *Config) ApplyReloadable(next *Config) (updated *Config, applied, ignored []string) {
+	cp := *c
+	cur := reflect.ValueOf(&cp).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	t := cur.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		if reflect.DeepEqual(cur.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if !reloadable[key] {
+			ignored = append(ignored, key)
+			continue
+		}
+		cur.Field(i).Set(nv.Field(i))
+		applied = append(applied, key)
+	}
+	cp.RefreshDuration = next.RefreshDuration
+	return &cp, applied, ignored
+}
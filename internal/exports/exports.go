@@ -0,0 +1,152 @@
+// Package exports runs scheduled jobs that evaluate a saved filter and
+// deliver the matching node list to a webhook or a file.
+package exports
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/filters"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// Payload is the JSON document delivered by a job.
+type Payload struct {
+	Job         string        `json:"job"`
+	Filter      string        `json:"filter"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Count       int           `json:"count"`
+	Nodes       []*store.Node `json:"nodes"`
+}
+
+// Scheduler runs the configured export jobs.
+type Scheduler struct {
+	jobs    []config.ExportJob
+	s       *store.Store
+	filters *filters.Store
+	client  *http.Client
+}
+
+func NewScheduler(jobs []config.ExportJob, s *store.Store, fl *filters.Store, client *http.Client) *Scheduler {
+	return &Scheduler{
+		jobs:    jobs,
+		s:       s,
+		filters: fl,
+		client:  client,
+	}
+}
+
+// Run starts one goroutine per job and blocks until ctx is done.
+func (sc *Scheduler) Run(ctx context.Context) {
+	for _, job := range sc.jobs {
+		go sc.runJob(ctx, job)
+	}
+	<-ctx.Done()
+}
+
+func (sc *Scheduler) runJob(ctx context.Context, job config.ExportJob) {
+	for {
+		next := NextRun(job, time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := sc.RunOnce(job); err != nil {
+			log.Printf("Export %s: %v", job.Name, err)
+		}
+	}
+}
+
+// NextRun returns when a job should run next after now: daily at the
+// configured wall-clock time if At is set, otherwise after Every.
+func NextRun(job config.ExportJob, now time.Time) time.Time {
+	if job.AtHour >= 0 {
+		next := time.Date(now.Year(), now.Month(), now.Day(), job.AtHour, job.AtMinute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	}
+	return now.Add(job.EveryDuration)
+}
+
+// RunOnce evaluates the job's filter and delivers the result.
+func (sc *Scheduler) RunOnce(job config.ExportJob) error {
+	f, ok := sc.filters.Get(job.Filter)
+	if !ok {
+		return fmt.Errorf("filter %q not found", job.Filter)
+	}
+	nodes := f.Apply(sc.s.GetSnapshot())
+	p := Payload{
+		Job:         job.Name,
+		Filter:      f.ID,
+		GeneratedAt: time.Now().UTC(),
+		Count:       len(nodes),
+		Nodes:       nodes,
+	}
+
+	body, contentType, err := encode(p, job.Format)
+	if err != nil {
+		return err
+	}
+	if job.File != "" {
+		if err := writeFile(job.File, body); err != nil {
+			return fmt.Errorf("writing %s: %w", job.File, err)
+		}
+	}
+	if job.Webhook != "" {
+		if err := sc.post(job.Webhook, contentType, body); err != nil {
+			return fmt.Errorf("webhook: %w", err)
+		}
+	}
+	log.Printf("Export %s: delivered %d nodes", job.Name, len(nodes))
+	return nil
+}
+
+func encode(p Payload, format string) ([]byte, string, error) {
+	if format != "csv" {
+		data, err := json.MarshalIndent(p, "", "  ")
+		return data, "application/json", err
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"node_id", "hostname", "model", "firmware", "domain", "community", "is_online", "lastseen"})
+	for _, n := range p.Nodes {
+		cw.Write([]string{n.NodeID, n.Hostname, n.Model, n.Firmware, n.Domain, n.Community,
+			strconv.FormatBool(n.IsOnline), n.Lastseen})
+	}
+	cw.Flush()
+	return buf.Bytes(), "text/csv; charset=utf-8", cw.Error()
+}
+
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (sc *Scheduler) post(url, contentType string, body []byte) error {
+	resp, err := sc.client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
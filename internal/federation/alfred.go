@@ -0,0 +1,140 @@
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/respondd"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// A.L.F.R.E.D. dumps, as written by alfred-json, from batman-adv
+// communities predating respondd. Gluon announces the same nodeinfo,
+// statistics and neighbours documents under datatypes 158, 159 and 160,
+// so they are converted like respondd answers.
+
+// alfredDatatypes names the per-datatype dumps of a combined document.
+var alfredDatatypes = map[string]string{
+	"158": "nodeinfo", "nodeinfo": "nodeinfo",
+	"159": "statistics", "statistics": "statistics",
+	"160": "neighbours", "neighbours": "neighbours",
+}
+
+// ParseAlfredToMeshviewer converts alfred-json output. Either one dump
+// (an object keyed by the MAC of the announcing node) or a combined
+// document holding dumps under their datatypes ("158"…"160" or
+// "nodeinfo", "statistics", "neighbours") is accepted. A lone nodeinfo
+// dump yields nodes without statistics. alfred expires data after ten
+// minutes, so every node in a dump is online.
+func ParseAlfredToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	combined := len(top) > 0
+	for k := range top {
+		if _, ok := alfredDatatypes[k]; !ok {
+			combined = false
+			break
+		}
+	}
+
+	nodes := make(map[string]*respondd.Node)
+	if combined {
+		for k, raw := range top {
+			var dump map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &dump); err != nil {
+				return nil, fmt.Errorf("datatype %s: %w", k, err)
+			}
+			addAlfredDump(nodes, dump, alfredDatatypes[k])
+		}
+	} else {
+		addAlfredDump(nodes, top, "")
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no alfred entries")
+	}
+
+	now := time.Now()
+	list := make([]*respondd.Node, 0, len(nodes))
+	for _, n := range nodes {
+		n.Online, n.Lastseen = true, now
+		list = append(list, n)
+	}
+	return store.ResponddToMeshviewer(list, now), nil
+}
+
+// addAlfredDump merges the entries of one dump into nodes. Without a
+// datatype, each entry is classified by its fields. Entries lacking a
+// node_id are keyed by the MAC they are stored under, as Gluon derives
+// the node ID from it.
+func addAlfredDump(nodes map[string]*respondd.Node, dump map[string]json.RawMessage, datatype string) {
+	for mac, raw := range dump {
+		var probe map[string]json.RawMessage
+		if json.Unmarshal(raw, &probe) != nil {
+			continue
+		}
+		kind := datatype
+		if kind == "" {
+			kind = classifyAlfredEntry(probe)
+		}
+		var id string
+		if v, ok := probe["node_id"]; ok {
+			json.Unmarshal(v, &id)
+		}
+		if id == "" {
+			id = strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+		}
+		n := nodes[id]
+		if n == nil {
+			n = &respondd.Node{}
+			nodes[id] = n
+		}
+		switch kind {
+		case "nodeinfo":
+			var ni respondd.NodeInfo
+			if json.Unmarshal(raw, &ni) == nil {
+				ni.NodeID = id
+				if ni.Network.MAC == "" {
+					ni.Network.MAC = strings.ToLower(mac)
+				}
+				n.NodeInfo = &ni
+			}
+		case "statistics":
+			var st respondd.Statistics
+			if json.Unmarshal(raw, &st) == nil {
+				st.NodeID = id
+				n.Statistics = &st
+			}
+		case "neighbours":
+			var nb respondd.Neighbours
+			if json.Unmarshal(raw, &nb) == nil {
+				nb.NodeID = id
+				n.Neighbours = &nb
+			}
+		}
+	}
+}
+
+// classifyAlfredEntry tells the datatype of an entry from its fields.
+func classifyAlfredEntry(e map[string]json.RawMessage) string {
+	has := func(keys ...string) bool {
+		for _, k := range keys {
+			if _, ok := e[k]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("hostname", "network", "software", "hardware"):
+		return "nodeinfo"
+	case has("batadv", "wifi"):
+		return "neighbours"
+	case has("clients", "uptime", "memory", "traffic"):
+		return "statistics"
+	}
+	return ""
+}
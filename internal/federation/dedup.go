@@ -0,0 +1,225 @@
+package federation
+
+import (
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// MergeDecision records why two node records were treated as the same
+// physical device and merged, so operators can review the heuristics.
+type MergeDecision struct {
+	KeptID      string   `json:"kept_id"`
+	MergedID    string   `json:"merged_id"`
+	Hostname    string   `json:"hostname"`
+	Reason      string   `json:"reason"`
+	Communities []string `json:"communities"`
+}
+
+// maxDuplicateDistance is how far apart (in meters) two located records may
+// be and still be considered the same device by the hostname heuristic.
+const maxDuplicateDistance = 1000
+
+// normalizeMAC lowercases a MAC address and strips ":", "-" and "."
+// separators. It returns "" unless the result is exactly 12 hex digits, so
+// it can also be applied to node_ids, which Gluon derives from the MAC.
+func normalizeMAC(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == ':' || r == '-' || r == '.':
+			continue
+		case (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f'):
+			b.WriteRune(r)
+		default:
+			return ""
+		}
+	}
+	if b.Len() != 12 {
+		return ""
+	}
+	return b.String()
+}
+
+// deviceKey is the normalized hardware identity of a record: its MAC, or its
+// node_id when that is MAC-shaped.
+func deviceKey(rn *store.RawNode) string {
+	if k := normalizeMAC(rn.MAC); k != "" {
+		return k
+	}
+	return normalizeMAC(rn.NodeID)
+}
+
+// richness scores how much useful data a record carries, to decide which of
+// two duplicates to keep.
+func richness(rn *store.RawNode) int {
+	score := 0
+	if bool(rn.IsOnline) {
+		score += 4
+	}
+	if rn.Location != nil {
+		score += 2
+	}
+	for _, s := range []string{rn.Hostname, rn.Model, rn.Firmware.Release, rn.Firmware.Base,
+		string(rn.Uptime), string(rn.Lastseen), rn.Owner, rn.Domain, rn.Autoupdater.Branch} {
+		if s != "" {
+			score++
+		}
+	}
+	if len(rn.Addresses) > 0 {
+		score++
+	}
+	if rn.Clients > 0 {
+		score++
+	}
+	return score
+}
+
+// mergeDuplicateDevices merges node records that describe the same device
+// under different node_ids, as happens at community borders where both
+// neighbours publish the same router, or when overlapping sources format
+// node_ids differently. Records with the same normalized MAC are merged;
+// records with the same hostname and model are merged when they come from
+// disjoint communities and are not far apart. Of two duplicates the richer
+// record is kept, and community tags, gateway references and links of the
+// other are moved onto it.
+func mergeDuplicateDevices(merged *store.MeshviewerData, nodeCommMap map[string][]string) []MergeDecision {
+	var decisions []MergeDecision
+	rename := make(map[string]string)
+	byMAC := make(map[string]int)
+	byName := make(map[string]int)
+
+	kept := merged.Nodes[:0]
+	for _, rn := range merged.Nodes {
+		idx, reason := -1, ""
+		mac, nameKey := "", ""
+		// Gateways are deliberately kept per community (see the node_id
+		// suffixing in RefreshAllSources), so never merge them.
+		if !bool(rn.IsGateway) {
+			mac = deviceKey(&rn)
+			nameKey = nameKeyOf(&rn)
+		}
+		if mac != "" {
+			if i, ok := byMAC[mac]; ok {
+				idx, reason = i, "mac"
+			}
+		}
+		if nameKey != "" {
+			if i, ok := byName[nameKey]; ok && idx < 0 {
+				other := &kept[i]
+				if disjoint(nodeCommMap[other.NodeID], nodeCommMap[rn.NodeID]) && closeEnough(other, &rn) {
+					idx, reason = i, "hostname+model"
+				}
+			}
+		}
+
+		if idx < 0 {
+			if mac != "" {
+				byMAC[mac] = len(kept)
+			}
+			if nameKey != "" {
+				byName[nameKey] = len(kept)
+			}
+			kept = append(kept, rn)
+			continue
+		}
+
+		target := &kept[idx]
+		winner, loser := *target, rn
+		if richness(&rn) > richness(target) {
+			winner, loser = rn, *target
+		}
+		if winner.Location == nil && loser.Location != nil {
+			winner.Location = loser.Location
+		}
+		rename[loser.NodeID] = winner.NodeID
+		for _, ck := range nodeCommMap[loser.NodeID] {
+			nodeCommMap[winner.NodeID] = store.AppendUnique(nodeCommMap[winner.NodeID], ck)
+		}
+		delete(nodeCommMap, loser.NodeID)
+		*target = winner
+		// The loser's keys keep pointing here too, so later records
+		// matching either are merged.
+		if k := deviceKey(&winner); k != "" {
+			byMAC[k] = idx
+		}
+		if k := nameKeyOf(&winner); k != "" {
+			byName[k] = idx
+		}
+		decisions = append(decisions, MergeDecision{
+			KeptID:      winner.NodeID,
+			MergedID:    loser.NodeID,
+			Hostname:    loser.Hostname,
+			Reason:      reason,
+			Communities: nodeCommMap[winner.NodeID],
+		})
+	}
+	merged.Nodes = kept
+
+	if len(rename) == 0 {
+		return nil
+	}
+
+	// A record can be replaced by a richer one after absorbing others, so
+	// follow rename chains to the final survivor.
+	resolve := func(id string) (string, bool) {
+		final, ok := rename[id]
+		if !ok {
+			return id, false
+		}
+		for i := 0; i < len(rename); i++ {
+			next, ok := rename[final]
+			if !ok {
+				break
+			}
+			final = next
+		}
+		return final, true
+	}
+
+	for i := range merged.Nodes {
+		if id, ok := resolve(merged.Nodes[i].Gateway); ok {
+			merged.Nodes[i].Gateway = id
+		}
+	}
+	seen := make(map[string]bool, len(merged.Links))
+	links := merged.Links[:0]
+	for _, l := range merged.Links {
+		l.Source, _ = resolve(l.Source)
+		l.Target, _ = resolve(l.Target)
+		lk := l.Source + ">" + l.Target
+		if l.Source == l.Target || seen[lk] {
+			continue
+		}
+		seen[lk] = true
+		links = append(links, l)
+	}
+	merged.Links = links
+
+	return decisions
+}
+
+// nameKeyOf is the hostname+model key of the hostname heuristic.
+func nameKeyOf(rn *store.RawNode) string {
+	if rn.Hostname == "" || rn.Model == "" {
+		return ""
+	}
+	return strings.ToLower(rn.Hostname) + "\x00" + rn.Model
+}
+
+func disjoint(a, b []string) bool {
+	for _, x := range a {
+		if containsStr(b, x) {
+			return false
+		}
+	}
+	return true
+}
+
+func closeEnough(a, b *store.RawNode) bool {
+	if a.Location == nil || b.Location == nil {
+		return true
+	}
+	return store.Haversine(a.Location.Latitude, a.Location.Longitude,
+		b.Location.Latitude, b.Location.Longitude) <= maxDuplicateDistance
+}
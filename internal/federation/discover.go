@@ -8,32 +8,77 @@ import (
 	"math"
 	"net/http"
 	"net/url"
+	"os"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/respondd"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
 )
 
+// FFDirectoryURL is the public community directory, read unless
+// federationDirectory names another URL or a local file.
 const FFDirectoryURL = "https://api.freifunk.net/data/ffSummarizedDir.json"
 
+// DirectoryLocation returns where the community directory is read from:
+// dir, the federationDirectory setting, or FFDirectoryURL if it is empty.
+func DirectoryLocation(dir string) string {
+	if dir == "" {
+		return FFDirectoryURL
+	}
+	return dir
+}
+
 // Community represents a discovered Freifunk community.
 type Community struct {
-	Key            string   `json:"key"`
-	Name           string   `json:"name"`
-	URL            string   `json:"url"`
-	Lat            float64  `json:"lat,omitempty"`
-	Lng            float64  `json:"lng,omitempty"`
-	Nodes          int      `json:"nodes,omitempty"`
-	MeshviewerURLs []string `json:"meshviewer_urls,omitempty"`
-	NodelistURLs   []string `json:"nodelist_urls,omitempty"`
-	GrafanaURL     string   `json:"grafana_url,omitempty"`
-	Metacommunity  string   `json:"metacommunity,omitempty"`
-	AllKeys        []string `json:"all_keys,omitempty"`
-	HasError       bool     `json:"has_error,omitempty"`
-	LastChanged    string   `json:"last_changed,omitempty"`
+	Key            string        `json:"key"`
+	Name           string        `json:"name"`
+	URL            string        `json:"url"`
+	Lat            float64       `json:"lat,omitempty"`
+	Lng            float64       `json:"lng,omitempty"`
+	Nodes          int           `json:"nodes,omitempty"`
+	MeshviewerURLs []string      `json:"meshviewer_urls,omitempty"`
+	NodelistURLs   []string      `json:"nodelist_urls,omitempty"`
+	TopologyURLs   []TopologyURL `json:"topology_urls,omitempty"`
+	GrafanaURL     string        `json:"grafana_url,omitempty"`
+	Metacommunity  string        `json:"metacommunity,omitempty"`
+	AllKeys        []string      `json:"all_keys,omitempty"`
+	HasError       bool          `json:"has_error,omitempty"`
+	LastChanged    string        `json:"last_changed,omitempty"`
+}
+
+// TopologyURL is a fallback data source for communities without
+// meshviewer data: routing-daemon topology (olsrd, NetJSON, bmx7, babeld),
+// alfred dumps, an openwifimap collector or a nodewatcher/netmon export.
+type TopologyURL struct {
+	URL      string `json:"url"`
+	DataType string `json:"data_type"`
+}
+
+// topologyDataType maps a directory technicalType onto a fallback DataType.
+func topologyDataType(technicalType string) string {
+	switch technicalType {
+	case "olsr", "olsrd", "jsoninfo":
+		return "olsr"
+	case "netjson", "olsrd2", "olsrv2":
+		return "netjson"
+	case "bmx", "bmx6", "bmx7":
+		return "bmx7"
+	case "babel", "babeld":
+		return "babel"
+	case "alfred", "alfred-json":
+		return "alfred"
+	case "openwifimap", "owm":
+		return "openwifimap"
+	case "nodewatcher", "netmon":
+		return technicalType
+	}
+	return ""
 }
 
 // CommunitySource is a resolved data source for node data.
@@ -41,7 +86,7 @@ type CommunitySource struct {
 	CommunityKey  string
 	CommunityKeys []string
 	DataURL       string
-	DataType      string // "meshviewer" or "nodelist"
+	DataType      string // "meshviewer", "nodes", "nodelist", "olsr", "netjson", "bmx7", "babel", "alfred", "openwifimap", "nodewatcher" or "netmon"
 	GrafanaURL    string
 	MapURLs       []string
 }
@@ -118,28 +163,13 @@ type NodelistPosition struct {
 	Lon  interface{} `json:"lon"`
 }
 
-// DiscoverCommunities fetches the Freifunk API directory.
-func DiscoverCommunities(client *http.Client) ([]Community, error) {
-	req, err := http.NewRequest("GET", FFDirectoryURL, nil)
+// DiscoverCommunities fetches the Freifunk API directory, reading at most
+// maxBytes of it.
+func DiscoverCommunities(client *http.Client, dir string, maxBytes int64) ([]Community, error) {
+	body, err := readDirectory(client, DirectoryLocation(dir), maxBytes)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "freifunk-map-modern/1.0")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("fetching freifunk directory: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("freifunk directory returned %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
-	if err != nil {
-		return nil, fmt.Errorf("reading directory body: %w", err)
-	}
 
 	var directory map[string]ffAPIEntry
 	if err := json.Unmarshal(body, &directory); err != nil {
@@ -192,6 +222,8 @@ func DiscoverCommunities(client *http.Client) ([]Community, error) {
 				c.NodelistURLs = append(c.NodelistURLs, u)
 			} else if tt == "nodelist" {
 				c.NodelistURLs = append(c.NodelistURLs, u)
+			} else if dt := topologyDataType(tt); dt != "" {
+				c.TopologyURLs = append(c.TopologyURLs, TopologyURL{URL: u, DataType: dt})
 			} else if tt == "meshviewer" || tt == "ffmap" || tt == "hopglass" {
 				base := strings.TrimSuffix(u, "/")
 				c.MeshviewerURLs = append(c.MeshviewerURLs, base+"/data/meshviewer.json")
@@ -221,7 +253,7 @@ func DiscoverCommunities(client *http.Client) ([]Community, error) {
 			}
 		}
 
-		if len(c.MeshviewerURLs) > 0 || len(c.NodelistURLs) > 0 {
+		if len(c.MeshviewerURLs) > 0 || len(c.NodelistURLs) > 0 || len(c.TopologyURLs) > 0 {
 			communities = append(communities, c)
 		}
 	}
@@ -260,6 +292,7 @@ func DiscoverCommunities(client *http.Client) ([]Community, error) {
 				existing.NodelistURLs = append(existing.NodelistURLs, u)
 			}
 		}
+		existing.TopologyURLs = append(existing.TopologyURLs, c.TopologyURLs...)
 		if c.GrafanaURL != "" && existing.GrafanaURL == "" {
 			existing.GrafanaURL = c.GrafanaURL
 		}
@@ -279,21 +312,56 @@ func DiscoverCommunities(client *http.Client) ([]Community, error) {
 	return communities, nil
 }
 
+// FilterCommunities keeps the communities matching include, or all if it
+// is empty, minus those matching exclude. An entry matches a community's
+// key, one of its merged keys or its metacommunity, ignoring case.
+func FilterCommunities(communities []Community, include, exclude []string) []Community {
+	if len(include) == 0 && len(exclude) == 0 {
+		return communities
+	}
+	matches := func(c Community, list []string) bool {
+		for _, v := range list {
+			if strings.EqualFold(v, c.Key) || (c.Metacommunity != "" && strings.EqualFold(v, c.Metacommunity)) {
+				return true
+			}
+			for _, k := range c.AllKeys {
+				if strings.EqualFold(v, k) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	out := make([]Community, 0, len(communities))
+	for _, c := range communities {
+		if (len(include) == 0 || matches(c, include)) && !matches(c, exclude) {
+			out = append(out, c)
+		}
+	}
+	for _, v := range include {
+		found := false
+		for _, c := range communities {
+			if matches(c, []string{v}) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Printf("Federation: federationInclude entry %q matches no community", v)
+		}
+	}
+	return out
+}
+
 // ResolveBestSources picks the best data source for each community.
-func ResolveBestSources(client *http.Client, communities []Community, maxConcurrency int) []CommunitySource {
+// failures tells, by community key, why communities without a source have
+// none: the first failed probe, which is of a URL from the directory.
+func ResolveBestSources(client *http.Client, communities []Community, maxConcurrency int) (sources []CommunitySource, failures map[string]string) {
 	type result struct {
 		source CommunitySource
 		ok     bool
-	}
-
-	// Shared probe client with generous timeout and connection pooling
-	probeClient := &http.Client{
-		Timeout: 8 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        200,
-			MaxIdleConnsPerHost: 4,
-			IdleConnTimeout:     30 * time.Second,
-		},
+		key    string
+		err    error
 	}
 
 	// Buffer generously — communities can produce multiple sources
@@ -313,17 +381,21 @@ func ResolveBestSources(client *http.Client, communities []Community, maxConcurr
 
 			// Track hosts that timed out — skip other URLs on the same host.
 			deadHosts := make(map[string]bool)
+			var firstErr error
 			probe := func(u string) bool {
 				if parsed, err := url.Parse(u); err == nil {
 					if deadHosts[parsed.Hostname()] {
 						return false
 					}
 				}
-				ok, deadHost := ProbeURL(probeClient, u)
+				deadHost, err := ProbeURL(client, u)
 				if deadHost != "" {
 					deadHosts[deadHost] = true
 				}
-				return ok
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				return err == nil
 			}
 
 			// Probe ALL meshviewer URLs — communities may have multiple
@@ -431,8 +503,26 @@ func ResolveBestSources(client *http.Client, communities []Community, maxConcurr
 				}
 			}
 
+			// Routing-daemon topology (olsrd/bmx7/babeld) or alfred dumps
+			// for communities without meshviewer data.
 			if !found {
-				ch <- result{ok: false}
+				for _, t := range c.TopologyURLs {
+					if probe(t.URL) {
+						ch <- result{source: CommunitySource{
+							CommunityKey: c.Key, CommunityKeys: c.AllKeys,
+							DataURL: t.URL, DataType: t.DataType,
+							GrafanaURL: c.GrafanaURL, MapURLs: mapURLs,
+						}, ok: true}
+						found = true
+					}
+				}
+			}
+
+			if !found {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("no data URL to probe")
+				}
+				ch <- result{key: c.Key, err: firstErr}
 			}
 		}(c)
 	}
@@ -442,10 +532,12 @@ func ResolveBestSources(client *http.Client, communities []Community, maxConcurr
 		close(ch)
 	}()
 
-	var sources []CommunitySource
+	failures = make(map[string]string)
 	for r := range ch {
 		if r.ok {
 			sources = append(sources, r.source)
+		} else {
+			failures[r.key] = r.err.Error()
 		}
 	}
 
@@ -467,7 +559,7 @@ func ResolveBestSources(client *http.Client, communities []Community, maxConcurr
 		}
 	}
 
-	return deduped
+	return deduped, failures
 }
 
 // ParseNodelistToMeshviewer converts nodelist.json to MeshviewerData.
@@ -483,37 +575,42 @@ func ParseNodelistToMeshviewer(data []byte) (*store.MeshviewerData, error) {
 	}
 
 	for _, n := range nl.Nodes {
-		nodeID := ifaceToString(n.ID)
-		if nodeID == "" {
-			continue
-		}
-		rn := store.RawNode{
-			NodeID:   nodeID,
-			Hostname: n.Name,
-			IsOnline: store.FlexBool(ifaceToBool(n.Status.Online)),
-			Clients:  store.FlexInt(ifaceToInt(n.Status.Clients)),
-			Lastseen: ifaceToString(n.Status.Lastcontact),
-			MAC:      nodeID,
+		if rn, ok := nodelistNodeToRaw(n); ok {
+			mv.Nodes = append(mv.Nodes, rn)
 		}
+	}
 
-		if n.Position != nil {
-			lat := ifaceToFloat(n.Position.Lat)
-			lng := ifaceToFloat(n.Position.Long)
-			if lng == 0 {
-				lng = ifaceToFloat(n.Position.Lon)
-			}
-			if lat != 0 || lng != 0 {
-				rn.Location = &store.RawLocation{
-					Latitude:  lat,
-					Longitude: lng,
-				}
-			}
-		}
+	return mv, nil
+}
 
-		mv.Nodes = append(mv.Nodes, rn)
+func nodelistNodeToRaw(n NodelistNode) (store.RawNode, bool) {
+	nodeID := ifaceToString(n.ID)
+	if nodeID == "" {
+		return store.RawNode{}, false
+	}
+	rn := store.RawNode{
+		NodeID:   nodeID,
+		Hostname: n.Name,
+		IsOnline: store.FlexBool(ifaceToBool(n.Status.Online)),
+		Clients:  store.FlexInt(ifaceToInt(n.Status.Clients)),
+		Lastseen: store.FlexTime(ifaceToString(n.Status.Lastcontact)),
+		MAC:      nodeID,
 	}
 
-	return mv, nil
+	if n.Position != nil {
+		lat := ifaceToFloat(n.Position.Lat)
+		lng := ifaceToFloat(n.Position.Long)
+		if lng == 0 {
+			lng = ifaceToFloat(n.Position.Lon)
+		}
+		if lat != 0 || lng != 0 {
+			rn.Location = &store.RawLocation{
+				Latitude:  lat,
+				Longitude: lng,
+			}
+		}
+	}
+	return rn, true
 }
 
 // --- nodes.json format (Yanic/hopglass output) ---
@@ -525,16 +622,16 @@ type NodesJSONData struct {
 }
 
 type NodesJSONNode struct {
-	Firstseen  string              `json:"firstseen"`
-	Lastseen   string              `json:"lastseen"`
+	Firstseen  store.FlexTime      `json:"firstseen"`
+	Lastseen   store.FlexTime      `json:"lastseen"`
 	Flags      NodesJSONFlags      `json:"flags"`
 	Statistics NodesJSONStatistics `json:"statistics"`
 	Nodeinfo   NodesJSONNodeinfo   `json:"nodeinfo"`
 }
 
 type NodesJSONFlags struct {
-	Online  bool `json:"online"`
-	Gateway bool `json:"gateway"`
+	Online  store.FlexBool `json:"online"`
+	Gateway store.FlexBool `json:"gateway"`
 }
 
 type NodesJSONStatistics struct {
@@ -547,6 +644,9 @@ type NodesJSONStatistics struct {
 	Gateway     string      `json:"gateway"`
 	Gateway6    string      `json:"gateway6"`
 	Processes   interface{} `json:"processes"`
+	// Wireless is relayed from respondd: raw survey counters (hopglass) or
+	// with the utilisations yanic computed.
+	Wireless []*respondd.Airtime `json:"wireless"`
 }
 
 type NodesJSONNodeinfo struct {
@@ -558,7 +658,8 @@ type NodesJSONNodeinfo struct {
 	Location *NodesJSONLocation `json:"location"`
 	Software NodesJSONSoftware  `json:"software"`
 	Hardware NodesJSONHardware  `json:"hardware"`
-	VPN      bool               `json:"vpn"`
+	VPN      store.FlexBool     `json:"vpn"`
+	Wireless *store.Wireless    `json:"wireless"`
 }
 
 type NodesJSONNetwork struct {
@@ -573,11 +674,13 @@ type NodesJSONOwner struct {
 type NodesJSONSystem struct {
 	SiteCode   string `json:"site_code"`
 	DomainCode string `json:"domain_code"`
+	Role       string `json:"role"`
 }
 
 type NodesJSONLocation struct {
-	Longitude float64 `json:"longitude"`
-	Latitude  float64 `json:"latitude"`
+	Longitude store.FlexFloat64  `json:"longitude"`
+	Latitude  store.FlexFloat64  `json:"latitude"`
+	Altitude  *store.FlexFloat64 `json:"altitude,omitempty"`
 }
 
 type NodesJSONSoftware struct {
@@ -586,8 +689,8 @@ type NodesJSONSoftware struct {
 }
 
 type NodesJSONAutoUpdater struct {
-	Branch  string `json:"branch"`
-	Enabled bool   `json:"enabled"`
+	Branch  string         `json:"branch"`
+	Enabled store.FlexBool `json:"enabled"`
 }
 
 type NodesJSONFirmware struct {
@@ -596,8 +699,8 @@ type NodesJSONFirmware struct {
 }
 
 type NodesJSONHardware struct {
-	Nproc int    `json:"nproc"`
-	Model string `json:"model"`
+	Nproc store.FlexInt `json:"nproc"`
+	Model string        `json:"model"`
 }
 
 // ParseNodesJSONToMeshviewer converts Yanic nodes.json to MeshviewerData.
@@ -613,90 +716,163 @@ func ParseNodesJSONToMeshviewer(data []byte) (*store.MeshviewerData, error) {
 	}
 
 	for _, n := range nj.Nodes {
-		nodeID := n.Nodeinfo.NodeID
-		if nodeID == "" {
-			nodeID = n.Statistics.NodeID
-		}
-		if nodeID == "" {
-			continue
+		if rn, ok := nodesJSONNodeToRaw(n); ok {
+			mv.Nodes = append(mv.Nodes, rn)
 		}
+	}
 
-		mac := n.Nodeinfo.Network.MAC
-		if mac == "" {
-			mac = nodeID
-		}
+	return mv, nil
+}
 
-		rn := store.RawNode{
-			NodeID:    nodeID,
-			Hostname:  n.Nodeinfo.Hostname,
-			IsOnline:  store.FlexBool(n.Flags.Online),
-			IsGateway: store.FlexBool(n.Flags.Gateway),
-			Clients:   store.FlexInt(ifaceToInt(n.Statistics.Clients)),
-			Firstseen: n.Firstseen,
-			Lastseen:  n.Lastseen,
-			MAC:       mac,
-			Addresses: n.Nodeinfo.Network.Addresses,
-			Gateway:   n.Statistics.Gateway,
-			Gateway6:  n.Statistics.Gateway6,
-			Domain:    n.Nodeinfo.System.SiteCode,
-		}
+// nodesJSONAirtime uses the utilisations yanic computed, or else the
+// counters since boot, which average over the node's uptime.
+func nodesJSONAirtime(a *respondd.Airtime) store.Airtime {
+	out := store.Airtime{Frequency: a.Frequency, ChanUtil: a.ChanUtil, RxUtil: a.RxUtil, TxUtil: a.TxUtil}
+	if out.ChanUtil == 0 && a.Active > 0 {
+		active := float64(a.Active)
+		out.ChanUtil = float64(a.Busy) / active
+		out.RxUtil = float64(a.Rx) / active
+		out.TxUtil = float64(a.Tx) / active
+	}
+	return out
+}
 
-		if n.Statistics.LoadAvg != nil {
-			rn.LoadAvg = store.FlexFloat64(ifaceToFloat(n.Statistics.LoadAvg))
-		}
-		if n.Statistics.MemoryUsage != nil {
-			rn.MemoryUsage = store.FlexFloat64(ifaceToFloat(n.Statistics.MemoryUsage))
-		}
-		if n.Statistics.RootfsUsage != nil {
-			rn.RootfsUsage = store.FlexFloat64(ifaceToFloat(n.Statistics.RootfsUsage))
-		}
-		if n.Statistics.Uptime != nil {
-			rn.Uptime = fmt.Sprintf("%v", n.Statistics.Uptime)
-		}
-		if n.Nodeinfo.Hardware.Model != "" {
-			rn.Model = n.Nodeinfo.Hardware.Model
+func nodesJSONNodeToRaw(n NodesJSONNode) (store.RawNode, bool) {
+	nodeID := n.Nodeinfo.NodeID
+	if nodeID == "" {
+		nodeID = n.Statistics.NodeID
+	}
+	if nodeID == "" {
+		return store.RawNode{}, false
+	}
+
+	mac := n.Nodeinfo.Network.MAC
+	if mac == "" {
+		mac = nodeID
+	}
+
+	rn := store.RawNode{
+		NodeID:     nodeID,
+		Hostname:   n.Nodeinfo.Hostname,
+		IsOnline:   n.Flags.Online,
+		IsGateway:  n.Flags.Gateway,
+		Clients:    store.FlexInt(ifaceToInt(n.Statistics.Clients)),
+		Firstseen:  n.Firstseen,
+		Lastseen:   n.Lastseen,
+		MAC:        mac,
+		Addresses:  n.Nodeinfo.Network.Addresses,
+		Gateway:    n.Statistics.Gateway,
+		Gateway6:   n.Statistics.Gateway6,
+		Domain:     n.Nodeinfo.System.DomainCode,
+		SiteCode:   n.Nodeinfo.System.SiteCode,
+		DomainCode: n.Nodeinfo.System.DomainCode,
+		IsUplink:   n.Nodeinfo.VPN,
+		Role:       n.Nodeinfo.System.Role,
+	}
+	if rn.Domain == "" {
+		rn.Domain = rn.SiteCode
+	}
+
+	if n.Statistics.LoadAvg != nil {
+		rn.LoadAvg = store.FlexFloat64(ifaceToFloat(n.Statistics.LoadAvg))
+	}
+	if n.Statistics.MemoryUsage != nil {
+		rn.MemoryUsage = store.FlexFloat64(ifaceToFloat(n.Statistics.MemoryUsage))
+	}
+	if n.Statistics.RootfsUsage != nil {
+		rn.RootfsUsage = store.FlexFloat64(ifaceToFloat(n.Statistics.RootfsUsage))
+	}
+	if n.Statistics.Uptime != nil {
+		rn.Uptime = store.FlexTime(fmt.Sprintf("%v", n.Statistics.Uptime))
+	}
+	if n.Nodeinfo.Hardware.Model != "" {
+		rn.Model = n.Nodeinfo.Hardware.Model
+	}
+	if n.Nodeinfo.Hardware.Nproc > 0 {
+		rn.Nproc = n.Nodeinfo.Hardware.Nproc
+	}
+	if n.Nodeinfo.Software.Firmware != nil {
+		rn.Firmware = store.RawFirmware{
+			Release: n.Nodeinfo.Software.Firmware.Release,
+			Base:    n.Nodeinfo.Software.Firmware.Base,
 		}
-		if n.Nodeinfo.Hardware.Nproc > 0 {
-			rn.Nproc = store.FlexInt(n.Nodeinfo.Hardware.Nproc)
+	}
+	if n.Nodeinfo.Software.Autoupdater != nil {
+		rn.Autoupdater = store.RawAutoUpd{
+			Enabled: n.Nodeinfo.Software.Autoupdater.Enabled,
+			Branch:  n.Nodeinfo.Software.Autoupdater.Branch,
 		}
-		if n.Nodeinfo.Software.Firmware != nil {
-			rn.Firmware = store.RawFirmware{
-				Release: n.Nodeinfo.Software.Firmware.Release,
-				Base:    n.Nodeinfo.Software.Firmware.Base,
-			}
+	}
+	rn.Wireless = n.Nodeinfo.Wireless
+	for _, a := range n.Statistics.Wireless {
+		if a == nil {
+			continue
 		}
-		if n.Nodeinfo.Software.Autoupdater != nil {
-			rn.Autoupdater = store.RawAutoUpd{
-				Enabled: store.FlexBool(n.Nodeinfo.Software.Autoupdater.Enabled),
-				Branch:  n.Nodeinfo.Software.Autoupdater.Branch,
-			}
+		rn.Airtime = append(rn.Airtime, nodesJSONAirtime(a))
+	}
+	if n.Nodeinfo.Owner != nil {
+		rn.Owner = n.Nodeinfo.Owner.Contact
+	}
+	if n.Nodeinfo.Location != nil && (n.Nodeinfo.Location.Latitude != 0 || n.Nodeinfo.Location.Longitude != 0) {
+		rn.Location = &store.RawLocation{
+			Latitude:  float64(n.Nodeinfo.Location.Latitude),
+			Longitude: float64(n.Nodeinfo.Location.Longitude),
+			Altitude:  n.Nodeinfo.Location.Altitude,
 		}
-		if n.Nodeinfo.Owner != nil {
-			rn.Owner = n.Nodeinfo.Owner.Contact
+	}
+
+	return rn, true
+}
+
+// --- Helpers ---
+
+// readDirectory returns the directory at loc, an http(s) URL or else a
+// local file path, such as a mirror of the public directory or a
+// hand-written one for a private network.
+func readDirectory(client *http.Client, loc string, maxBytes int64) ([]byte, error) {
+	if !strings.HasPrefix(loc, "http://") && !strings.HasPrefix(loc, "https://") {
+		f, err := os.Open(loc)
+		if err != nil {
+			return nil, fmt.Errorf("reading freifunk directory: %w", err)
 		}
-		if n.Nodeinfo.Location != nil && (n.Nodeinfo.Location.Latitude != 0 || n.Nodeinfo.Location.Longitude != 0) {
-			rn.Location = &store.RawLocation{
-				Latitude:  n.Nodeinfo.Location.Latitude,
-				Longitude: n.Nodeinfo.Location.Longitude,
-			}
+		defer f.Close()
+		body, err := io.ReadAll(fetch.LimitReader(f, maxBytes))
+		if err != nil {
+			return nil, fmt.Errorf("reading directory file: %w", err)
 		}
+		return body, nil
+	}
 
-		mv.Nodes = append(mv.Nodes, rn)
+	req, err := http.NewRequest("GET", loc, nil)
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("User-Agent", "freifunk-map-modern/1.0")
 
-	return mv, nil
-}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching freifunk directory: %w", err)
+	}
+	defer resp.Body.Close()
 
-// --- Helpers ---
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("freifunk directory returned %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(fetch.LimitReader(resp.Body, maxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading directory body: %w", err)
+	}
+	return body, nil
+}
 
-// ProbeURL checks if a URL returns a non-HTML 200 response.
-// Returns (true, "") on success.
-// Returns (false, hostname) if the host is unreachable (timeout/connection error)
-// so the caller can skip other URLs on that host.
-// Returns (false, "") for non-fatal failures (404, HTML, etc.).
-func ProbeURL(client *http.Client, u string) (bool, string) {
+// ProbeURL checks if a URL returns a non-HTML 200 response; err says why
+// not. deadHost is the hostname if the host is unreachable
+// (timeout/connection error), so the caller can skip other URLs on that
+// host, and empty for non-fatal failures (404, HTML, etc.).
+func ProbeURL(client *http.Client, u string) (deadHost string, err error) {
 	if !urlcheck.IsSafeURL(u) {
-		return false, ""
+		return "", fmt.Errorf("blocked unsafe URL: %s", u)
 	}
 	parsed, _ := url.Parse(u)
 	host := ""
@@ -706,7 +882,7 @@ func ProbeURL(client *http.Client, u string) (bool, string) {
 
 	req, err := http.NewRequest("HEAD", u, nil)
 	if err != nil {
-		return false, ""
+		return "", fmt.Errorf("HEAD %s: %w", u, err)
 	}
 	req.Header.Set("User-Agent", "freifunk-map-modern/1.0")
 
@@ -720,24 +896,24 @@ func ProbeURL(client *http.Client, u string) (bool, string) {
 			strings.Contains(errStr, "no route to host") ||
 			strings.Contains(errStr, "network is unreachable") ||
 			strings.Contains(errStr, "tls:") {
-			return false, host
+			return host, err
 		}
-		return false, ""
+		return "", err
 	}
 	resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return false, ""
+		return "", fmt.Errorf("HEAD %s: status %d", u, resp.StatusCode)
 	}
 
 	// Reject HTML responses — SPA meshviewers (e.g. Bremen) return 200
 	// with text/html for any path, including /data/meshviewer.json.
 	ct := resp.Header.Get("Content-Type")
 	if strings.Contains(ct, "text/html") {
-		return false, ""
+		return "", fmt.Errorf("HEAD %s: got HTML, not JSON", u)
 	}
 
-	return true, ""
+	return "", nil
 }
 
 func CollectMapBases(c Community) []string {
@@ -855,3 +1031,14 @@ func ifaceToFloat(v interface{}) float64 {
 		return 0
 	}
 }
+
+// newProbeClient returns the shared client for discovery probes, with the
+// probe timeout and a connection pool sized for many hosts.
+func newProbeClient(cfg *config.Config) *http.Client {
+	c := fetch.HTTPClient(cfg, cfg.ProbeTimeoutDuration)
+	t := c.Transport.(*http.Transport)
+	t.MaxIdleConns = 200
+	t.MaxIdleConnsPerHost = 4
+	t.IdleConnTimeout = 30 * time.Second
+	return c
+}
@@ -0,0 +1,31 @@
+package federation
+
+import (
+	"testing"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+func TestParseNodesJSONTimestamps(t *testing.T) {
+	const doc = `{"version":2,"timestamp":"2024-05-01T12:00:00Z","nodes":[
+		{"firstseen":"2024-01-01T00:00:00Z","lastseen":"2024-05-01T11:59:00Z",
+		 "nodeinfo":{"node_id":"a","hostname":"a"}},
+		{"firstseen":1704067200,"lastseen":1714564740.5,
+		 "nodeinfo":{"node_id":"b","hostname":"b"}}
+	]}`
+	mv, err := ParseNodesJSONToMeshviewer([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mv.Nodes) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(mv.Nodes))
+	}
+	for _, n := range mv.Nodes {
+		if got := store.NormalizeTimestamp(string(n.Firstseen)); got != "2024-01-01T00:00:00Z" {
+			t.Errorf("%s: firstseen = %q", n.NodeID, got)
+		}
+		if got := store.NormalizeTimestamp(string(n.Lastseen)); got != "2024-05-01T11:59:00Z" {
+			t.Errorf("%s: lastseen = %q", n.NodeID, got)
+		}
+	}
+}
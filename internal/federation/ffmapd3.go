@@ -0,0 +1,77 @@
+package federation
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// --- ffmap-d3 nodes.json (v1) ---
+//
+// The format of ffmap-backend, still published by some small communities:
+// nodes and links live in one file, links refer to nodes by array position
+// (resolved by store.DecodeMeshviewerStream) and clients appear as nodes
+// of their own, flagged "client".
+
+type FfmapD3Node struct {
+	ID          string        `json:"id"` // primary MAC
+	Name        string        `json:"name"`
+	Flags       FfmapD3Flags  `json:"flags"`
+	Geo         []interface{} `json:"geo"` // [lat, lng] or null
+	Firmware    string        `json:"firmware"`
+	ClientCount interface{}   `json:"clientcount"`
+	Uptime      interface{}   `json:"uptime"`
+	Lastseen    interface{}   `json:"lastseen"`
+}
+
+type FfmapD3Flags struct {
+	Online  store.FlexBool `json:"online"`
+	Gateway store.FlexBool `json:"gateway"`
+	Client  store.FlexBool `json:"client"`
+}
+
+// ffmapD3NodeToRaw converts a node entry; client entries return client
+// true. Node IDs are derived from the MAC as Gluon does.
+func ffmapD3NodeToRaw(n FfmapD3Node) (rn store.RawNode, ok, client bool) {
+	if n.Flags.Client {
+		return store.RawNode{}, false, true
+	}
+	mac := strings.ToLower(n.ID)
+	nodeID := strings.ReplaceAll(mac, ":", "")
+	if nodeID == "" {
+		return store.RawNode{}, false, false
+	}
+	hostname := n.Name
+	if hostname == "" {
+		hostname = nodeID
+	}
+	rn = store.RawNode{
+		NodeID:    nodeID,
+		Hostname:  hostname,
+		MAC:       mac,
+		IsOnline:  n.Flags.Online,
+		IsGateway: n.Flags.Gateway,
+		Clients:   store.FlexInt(ifaceToInt(n.ClientCount)),
+		Firmware:  store.RawFirmware{Release: n.Firmware},
+		Lastseen:  store.FlexTime(ifaceToString(n.Lastseen)),
+		Uptime:    store.FlexTime(ifaceToString(n.Uptime)),
+	}
+	if len(n.Geo) == 2 {
+		lat, lng := ifaceToFloat(n.Geo[0]), ifaceToFloat(n.Geo[1])
+		if lat != 0 || lng != 0 {
+			rn.Location = &store.RawLocation{Latitude: lat, Longitude: lng}
+		}
+	}
+	return rn, true, false
+}
+
+// decodeFfmapD3Node is the decoding step of decodeAnyNode for entries
+// carrying ffmap-d3 flags.
+func decodeFfmapD3Node(raw json.RawMessage) (store.RawNode, bool, bool) {
+	var n FfmapD3Node
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return store.RawNode{}, false, false
+	}
+	return ffmapD3NodeToRaw(n)
+}
@@ -2,6 +2,8 @@ package federation
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -10,19 +12,24 @@ import (
 	"regexp"
 	"strings"
 	"sync"
-	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
 )
 
 const grafanaCacheFile = "grafana_cache.json"
 
 // GrafanaInfo stores discovered Grafana info for a community.
 type GrafanaInfo struct {
-	BaseURL      string               `json:"base_url"`
-	DashboardURL string               `json:"dashboard_url,omitempty"`
-	DatasourceID int                  `json:"datasource_id,omitempty"`
-	Database     string               `json:"database,omitempty"`
-	DataPaths    []string             `json:"data_paths,omitempty"`
-	RenderImages []GrafanaRenderImage `json:"render_images,omitempty"`
+	BaseURL       string               `json:"base_url"`
+	DashboardURL  string               `json:"dashboard_url,omitempty"`
+	DashboardUID  string               `json:"dashboard_uid,omitempty"`
+	DatasourceID  int                  `json:"datasource_id,omitempty"`
+	DatasourceUID string               `json:"datasource_uid,omitempty"`
+	Version       string               `json:"version,omitempty"` // from /api/health
+	Database      string               `json:"database,omitempty"`
+	DataPaths     []string             `json:"data_paths,omitempty"`
+	RenderImages  []GrafanaRenderImage `json:"render_images,omitempty"`
 }
 
 // GrafanaRenderImage is a Grafana render/image URL template.
@@ -34,6 +41,10 @@ type GrafanaRenderImage struct {
 // GrafanaCache maps community key -> GrafanaInfo.
 type GrafanaCache map[string]GrafanaInfo
 
+// inlineRenderImage matches {name:"…",…,image:"…/render/…"} in a
+// meshviewer bundle's nodeInfos.
+var inlineRenderImage = regexp.MustCompile(`name:"([^"]*)"(?:[^{}]|\{[A-Z_]+\})*?image:"(https?://[^"]+/render/[^"]+)"`)
+
 var grafanaURLPattern = regexp.MustCompile(`https?://[^"'\s,}]+(?:grafana|stats)[^"'\s,}]*`)
 
 func LoadGrafanaCache() GrafanaCache {
@@ -71,7 +82,7 @@ func SaveGrafanaCache(cache GrafanaCache) {
 
 // DiscoverGrafanaURLs probes meshviewer config.json for each community to find
 // Grafana base URLs and per-node dashboard templates.
-func DiscoverGrafanaURLs(client *http.Client, sources []CommunitySource, communities []Community) GrafanaCache {
+func DiscoverGrafanaURLs(client *http.Client, cfg *config.Config, sources []CommunitySource, communities []Community) GrafanaCache {
 	cache := LoadGrafanaCache()
 
 	for _, c := range communities {
@@ -161,45 +172,72 @@ func DiscoverGrafanaURLs(client *http.Client, sources []CommunitySource, communi
 
 	log.Printf("Grafana discovery: found %d new Grafana entries (total cached: %d)", newFound, len(cache))
 
-	// Discover datasource IDs
-	probeDS := 0
-	for _, info := range cache {
-		if info.BaseURL != "" && info.DatasourceID == 0 {
-			probeDS++
-		}
-	}
-	if probeDS > 0 {
-		log.Printf("Grafana discovery: probing /api/datasources for %d communities...", probeDS)
-		dsCh := make(chan result, probeDS)
-		dsSem := make(chan struct{}, 40)
-		var dsWg sync.WaitGroup
-		for key, info := range cache {
-			if info.BaseURL != "" && info.DatasourceID == 0 {
-				dsWg.Add(1)
-				go func(key string, info GrafanaInfo) {
-					defer dsWg.Done()
-					dsSem <- struct{}{}
-					defer func() { <-dsSem }()
-					updated := discoverDatasource(client, info)
-					if updated.DatasourceID != 0 {
-						dsCh <- result{key: key, info: updated}
-					}
-				}(key, info)
-			}
-		}
-		go func() { dsWg.Wait(); close(dsCh) }()
-		dsFound := 0
-		for r := range dsCh {
-			cache[r.key] = r.info
-			dsFound++
-		}
-		log.Printf("Grafana discovery: found %d datasources", dsFound)
-	}
+	// Discover datasource IDs. Entries cached before the version and UID
+	// were recorded are probed again.
+	probeGrafana(cache, "/api/datasources", "datasources",
+		func(info GrafanaInfo) bool {
+			return info.BaseURL != "" && (info.DatasourceID == 0 || info.Version == "")
+		},
+		func(info GrafanaInfo) (GrafanaInfo, bool) {
+			updated := DiscoverDatasource(client, cfg, info)
+			updated.Version = GrafanaVersion(client, cfg, info.BaseURL)
+			return updated, updated.DatasourceID != 0 || updated.Version != ""
+		})
+
+	// Many meshviewer configs link no node dashboard; ask Grafana itself.
+	probeGrafana(cache, "/api/search", "node dashboards",
+		func(info GrafanaInfo) bool {
+			return info.BaseURL != "" && info.DashboardURL == ""
+		},
+		func(info GrafanaInfo) (GrafanaInfo, bool) {
+			updated := discoverDashboard(client, cfg, info)
+			return updated, updated.DashboardURL != ""
+		})
 
 	SaveGrafanaCache(cache)
 	return cache
 }
 
+// probeGrafana runs probe concurrently for every cache entry that needs
+// it and stores the entries it reports as updated.
+func probeGrafana(cache GrafanaCache, endpoint, what string, needs func(GrafanaInfo) bool, probe func(GrafanaInfo) (GrafanaInfo, bool)) {
+	type result struct {
+		key  string
+		info GrafanaInfo
+	}
+	var pending []string
+	for key, info := range cache {
+		if needs(info) {
+			pending = append(pending, key)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	log.Printf("Grafana discovery: probing %s for %d communities...", endpoint, len(pending))
+	ch := make(chan result, len(pending))
+	sem := make(chan struct{}, 40)
+	var wg sync.WaitGroup
+	for _, key := range pending {
+		wg.Add(1)
+		go func(key string, info GrafanaInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if updated, ok := probe(info); ok {
+				ch <- result{key: key, info: updated}
+			}
+		}(key, cache[key])
+	}
+	go func() { wg.Wait(); close(ch) }()
+	found := 0
+	for r := range ch {
+		cache[r.key] = r.info
+		found++
+	}
+	log.Printf("Grafana discovery: found %d %s", found, what)
+}
+
 func discoverGrafanaForSource(client *http.Client, src CommunitySource) GrafanaInfo {
 	seen := make(map[string]bool)
 	var baseURLs []string
@@ -216,7 +254,6 @@ func discoverGrafanaForSource(client *http.Client, src CommunitySource) GrafanaI
 		}
 	}
 
-	probeClient := &http.Client{Timeout: 8 * time.Second}
 	deadHosts := make(map[string]bool)
 
 	isHostDead := func(rawURL string) bool {
@@ -253,7 +290,7 @@ func discoverGrafanaForSource(client *http.Client, src CommunitySource) GrafanaI
 		}
 		req.Header.Set("User-Agent", "freifunk-map-modern/1.0")
 
-		resp, err := probeClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			markDead(configURL, err)
 			continue
@@ -288,7 +325,7 @@ func discoverGrafanaForSource(client *http.Client, src CommunitySource) GrafanaI
 		}
 		req.Header.Set("User-Agent", "freifunk-map-modern/1.0")
 
-		resp, err := probeClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			markDead(base, err)
 			continue
@@ -351,6 +388,9 @@ func extractGrafanaFromInlineConfig(body []byte, baseURL string) GrafanaInfo {
 				info.BaseURL = m[1][:didx]
 			}
 		}
+		for _, m := range inlineRenderImage.FindAllStringSubmatch(sub, 10) {
+			info.RenderImages = append(info.RenderImages, GrafanaRenderImage{Name: m[1], Image: m[2]})
+		}
 	}
 
 	if info.BaseURL == "" {
@@ -400,6 +440,10 @@ func extractGrafanaFromConfig(body []byte) GrafanaInfo {
 				if !ok {
 					continue
 				}
+				if image, _ := m["image"].(string); strings.Contains(image, "/render/") {
+					name, _ := m["name"].(string)
+					info.RenderImages = append(info.RenderImages, GrafanaRenderImage{Name: name, Image: image})
+				}
 				href, _ := m["href"].(string)
 				if href == "" {
 					continue
@@ -476,18 +520,24 @@ func deepScanForGrafana(v interface{}) string {
 	return ""
 }
 
-func discoverDatasource(client *http.Client, info GrafanaInfo) GrafanaInfo {
-	probeClient := &http.Client{Timeout: 8 * time.Second}
-	dsURL := strings.TrimSuffix(info.BaseURL, "/") + "/api/datasources"
+// DiscoverDatasource fills in the InfluxDB datasource of info.BaseURL
+// that holds yanic data: one named after or storing database "yanic",
+// else the default or the first InfluxDB datasource. Like grafanaGet, it
+// only asks hosts GrafanaTargetAllowed permits.
+func DiscoverDatasource(client *http.Client, cfg *config.Config, info GrafanaInfo) GrafanaInfo {
+	dsURL, err := url.Parse(strings.TrimSuffix(info.BaseURL, "/") + "/api/datasources")
+	if err != nil || GrafanaTargetAllowed(cfg, dsURL) != nil {
+		return info
+	}
 
-	req, err := http.NewRequest("GET", dsURL, nil)
+	req, err := http.NewRequest("GET", dsURL.String(), nil)
 	if err != nil {
 		return info
 	}
 	req.Header.Set("User-Agent", "freifunk-map-modern/1.0")
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := probeClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return info
 	}
@@ -499,6 +549,7 @@ func discoverDatasource(client *http.Client, info GrafanaInfo) GrafanaInfo {
 
 	var datasources []struct {
 		ID        int    `json:"id"`
+		UID       string `json:"uid"`
 		Name      string `json:"name"`
 		Type      string `json:"type"`
 		Database  string `json:"database"`
@@ -523,6 +574,7 @@ func discoverDatasource(client *http.Client, info GrafanaInfo) GrafanaInfo {
 		dbLower := strings.ToLower(dbName)
 		if strings.Contains(nameLower, "yanic") || strings.Contains(dbLower, "yanic") {
 			info.DatasourceID = ds.ID
+			info.DatasourceUID = ds.UID
 			info.Database = dbName
 			return info
 		}
@@ -535,6 +587,7 @@ func discoverDatasource(client *http.Client, info GrafanaInfo) GrafanaInfo {
 				dbName = ds.JsonData.DBName
 			}
 			info.DatasourceID = ds.ID
+			info.DatasourceUID = ds.UID
 			info.Database = dbName
 			return info
 		}
@@ -547,6 +600,7 @@ func discoverDatasource(client *http.Client, info GrafanaInfo) GrafanaInfo {
 				dbName = ds.JsonData.DBName
 			}
 			info.DatasourceID = ds.ID
+			info.DatasourceUID = ds.UID
 			info.Database = dbName
 			return info
 		}
@@ -554,3 +608,169 @@ func discoverDatasource(client *http.Client, info GrafanaInfo) GrafanaInfo {
 
 	return info
 }
+
+// GrafanaTargetAllowed reports why the Grafana at u must not be queried,
+// by the metrics proxy or by discovery, or nil. Every target has to pass
+// urlcheck.IsSafeURL and carry no credentials. grafanaURL is set by the
+// operator and trusted as is; hosts found by federation discovery come
+// from upstream configs, so they must use https unless
+// grafanaProxyAllowHTTP is set, must not match grafanaProxyDeny and, if
+// grafanaProxyAllow is not empty, must match it.
+func GrafanaTargetAllowed(cfg *config.Config, u *url.URL) error {
+	if u.User != nil {
+		return errors.New("Grafana URL with credentials not allowed")
+	}
+	if !urlcheck.IsSafeURL(u.String()) {
+		return fmt.Errorf("Grafana host %s not allowed: private or unsupported address", u.Host)
+	}
+	if own, err := url.Parse(cfg.GrafanaURL); err == nil && cfg.GrafanaURL != "" &&
+		own.Scheme == u.Scheme && strings.EqualFold(own.Host, u.Host) {
+		return nil
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && cfg.GrafanaProxyAllowHTTP) {
+		return fmt.Errorf("Grafana URL scheme %s not allowed, set grafanaProxyAllowHTTP to permit http", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if matchHost(cfg.GrafanaProxyDeny, host) {
+		return fmt.Errorf("Grafana host %s is denied by grafanaProxyDeny", host)
+	}
+	if len(cfg.GrafanaProxyAllow) > 0 && !matchHost(cfg.GrafanaProxyAllow, host) {
+		return fmt.Errorf("Grafana host %s is not in grafanaProxyAllow", host)
+	}
+	return nil
+}
+
+// matchHost reports whether host equals one of patterns or, for a
+// pattern "*.example.org", is a subdomain of example.org.
+func matchHost(patterns []string, host string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if suffix, ok := strings.CutPrefix(p, "*"); ok {
+			if strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+// grafanaGet fetches a small JSON document from the Grafana at baseURL,
+// if GrafanaTargetAllowed permits it.
+func grafanaGet(client *http.Client, cfg *config.Config, baseURL, path string, v interface{}) error {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + path)
+	if err != nil {
+		return err
+	}
+	if err := GrafanaTargetAllowed(cfg, u); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "freifunk-map-modern/1.0")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%s returned %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(v)
+}
+
+// GrafanaVersion returns the version reported by /api/health, which
+// needs no authentication, or "" if Grafana does not answer.
+func GrafanaVersion(client *http.Client, cfg *config.Config, baseURL string) string {
+	var health struct {
+		Version string `json:"version"`
+	}
+	if grafanaGet(client, cfg, baseURL, "/api/health", &health) != nil {
+		return ""
+	}
+	return health.Version
+}
+
+// DatasourceUID looks up the UID of datasource id, which /api/ds/query
+// needs in place of the numeric ID.
+func DatasourceUID(client *http.Client, cfg *config.Config, baseURL string, id int) string {
+	var ds struct {
+		UID string `json:"uid"`
+	}
+	if grafanaGet(client, cfg, baseURL, fmt.Sprintf("/api/datasources/%d", id), &ds) != nil {
+		return ""
+	}
+	return ds.UID
+}
+
+// discoverDashboard searches the Grafana at info.BaseURL for a node
+// dashboard and derives a DashboardURL template from its URL and its node
+// ID variable. Titles equal to or starting with "node" are preferred.
+func discoverDashboard(client *http.Client, cfg *config.Config, info GrafanaInfo) GrafanaInfo {
+	var hits []struct {
+		UID   string `json:"uid"`
+		Title string `json:"title"`
+		URL   string `json:"url"`
+		Type  string `json:"type"`
+	}
+	if grafanaGet(client, cfg, info.BaseURL, "/api/search?type=dash-db&query=node", &hits) != nil {
+		return info
+	}
+	best, bestScore := -1, 0
+	for i, h := range hits {
+		title := strings.ToLower(h.Title)
+		score := 0
+		switch {
+		case h.Type != "" && h.Type != "dash-db", h.URL == "" || h.UID == "":
+			continue
+		case title == "node" || title == "nodes":
+			score = 3
+		case strings.HasPrefix(title, "node"):
+			score = 2
+		case strings.Contains(title, "node"):
+			score = 1
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return info
+	}
+	hit := hits[best]
+	// Grafana includes its sub path (root_url) in hit.URL.
+	base, err := url.Parse(info.BaseURL)
+	ref, err2 := url.Parse(hit.URL)
+	if err != nil || err2 != nil {
+		return info
+	}
+	info.DashboardUID = hit.UID
+	info.DashboardURL = base.ResolveReference(ref).String() + "?var-" + dashboardNodeVar(client, cfg, info.BaseURL, hit.UID) + "={NODE_ID}"
+	return info
+}
+
+// dashboardNodeVar returns the name of the template variable selecting
+// the node in dashboard uid, or "nodeid", the name yanic's dashboards use.
+func dashboardNodeVar(client *http.Client, cfg *config.Config, baseURL, uid string) string {
+	var dash struct {
+		Dashboard struct {
+			Templating struct {
+				List []struct {
+					Name string `json:"name"`
+				} `json:"list"`
+			} `json:"templating"`
+		} `json:"dashboard"`
+	}
+	if grafanaGet(client, cfg, baseURL, "/api/dashboards/uid/"+url.PathEscape(uid), &dash) == nil {
+		for _, v := range dash.Dashboard.Templating.List {
+			if strings.Contains(strings.ToLower(v.Name), "node") {
+				return v.Name
+			}
+		}
+	}
+	return "nodeid"
+}
@@ -0,0 +1,96 @@
+package federation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+func TestDiscoverDashboardChecksTarget(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/api/search":
+			w.Write([]byte(`[{"uid":"n1","title":"Node","url":"/d/n1/node","type":"dash-db"}]`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+	// Loopback servers are refused by urlcheck, so reach this one under a
+	// public-looking name through a proxy.
+	proxy, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	info := GrafanaInfo{BaseURL: "http://grafana.example.test"}
+
+	tests := []struct {
+		name  string
+		cfg   config.Config
+		found bool
+	}{
+		{"allowed", config.Config{GrafanaProxyAllowHTTP: true}, true},
+		{"http not allowed", config.Config{}, false},
+		{"denied", config.Config{GrafanaProxyAllowHTTP: true, GrafanaProxyDeny: []string{"*.example.test"}}, false},
+		{"not in allow list", config.Config{GrafanaProxyAllowHTTP: true, GrafanaProxyAllow: []string{"stats.ffmuc.net"}}, false},
+	}
+	for _, tt := range tests {
+		hits = 0
+		got := discoverDashboard(client, &tt.cfg, info)
+		if found := got.DashboardURL != ""; found != tt.found {
+			t.Errorf("%s: DashboardURL = %q", tt.name, got.DashboardURL)
+		}
+		if !tt.found && hits != 0 {
+			t.Errorf("%s: %d requests reached Grafana", tt.name, hits)
+		}
+	}
+
+	hits = 0
+	loopback := GrafanaInfo{BaseURL: srv.URL}
+	if got := discoverDashboard(http.DefaultClient, &config.Config{GrafanaProxyAllowHTTP: true}, loopback); got.DashboardURL != "" || hits != 0 {
+		t.Errorf("loopback Grafana was queried: %d requests, DashboardURL %q", hits, got.DashboardURL)
+	}
+}
+
+func TestVersionProbeChecksTarget(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/api/health":
+			w.Write([]byte(`{"version":"10.4.1"}`))
+		case "/api/datasources":
+			w.Write([]byte(`[{"id":3,"uid":"abc","name":"yanic","type":"influxdb"}]`))
+		}
+	}))
+	defer srv.Close()
+	proxy, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	base := "http://grafana.example.test"
+
+	allowed := &config.Config{GrafanaProxyAllowHTTP: true}
+	if v := GrafanaVersion(client, allowed, base); v != "10.4.1" {
+		t.Errorf("GrafanaVersion = %q, want 10.4.1", v)
+	}
+	if ds := DiscoverDatasource(client, allowed, GrafanaInfo{BaseURL: base}); ds.DatasourceUID != "abc" {
+		t.Errorf("DiscoverDatasource UID = %q, want abc", ds.DatasourceUID)
+	}
+
+	hits = 0
+	denied := &config.Config{GrafanaProxyAllowHTTP: true, GrafanaProxyDeny: []string{"grafana.example.test"}}
+	if v := GrafanaVersion(client, denied, base); v != "" {
+		t.Errorf("GrafanaVersion of a denied host = %q", v)
+	}
+	if ds := DiscoverDatasource(client, denied, GrafanaInfo{BaseURL: base}); ds.DatasourceID != 0 {
+		t.Errorf("DiscoverDatasource of a denied host = %+v", ds)
+	}
+	if v := GrafanaVersion(http.DefaultClient, allowed, srv.URL); v != "" {
+		t.Errorf("GrafanaVersion of a loopback host = %q", v)
+	}
+	if hits != 0 {
+		t.Errorf("%d requests reached refused hosts", hits)
+	}
+}
@@ -0,0 +1,196 @@
+package federation
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
+)
+
+// --- graph.json (yanic/hopglass link graph) ---
+//
+// yanic and hopglass-server publish the links of a nodes.json source in a
+// sibling graph.json, whose links refer to its own node list by index.
+
+// graphRetry is how long a source whose graph.json is missing goes
+// without another attempt.
+const graphRetry = time.Hour
+
+type GraphJSONData struct {
+	Batadv struct {
+		Nodes []struct {
+			ID     string `json:"id"` // MAC
+			NodeID string `json:"node_id"`
+		} `json:"nodes"`
+		Links []struct {
+			Source   int               `json:"source"`
+			Target   int               `json:"target"`
+			TQ       store.FlexFloat64 `json:"tq"`
+			VPN      bool              `json:"vpn"`
+			Bidirect bool              `json:"bidirect"`
+			Type     string            `json:"type"`
+		} `json:"links"`
+	} `json:"batadv"`
+}
+
+// graphURL returns the graph.json next to a nodes.json URL.
+func graphURL(nodesURL string) string {
+	i := strings.LastIndex(nodesURL, "/")
+	if i < 0 {
+		return ""
+	}
+	return nodesURL[:i+1] + "graph.json"
+}
+
+// graphDoc is a downloaded graph.json with the sha256 of its body. data
+// is nil when the body matched the graph already merged.
+type graphDoc struct {
+	data *GraphJSONData
+	hash [32]byte
+}
+
+// graphDue fetches the graph.json of a nodes source unless a missing graph
+// is still waiting for its retry. Without a graph to use it returns nil
+// and when to try next.
+func (fs *Store) graphDue(src CommunitySource, prev *sourceCache) (*graphDoc, time.Time) {
+	if prev != nil && time.Now().Before(prev.graphRetryAt) {
+		return nil, prev.graphRetryAt
+	}
+	var known [32]byte
+	if prev != nil {
+		known = prev.graphHash
+	}
+	g, err := fs.fetchGraph(graphURL(src.DataURL), known)
+	if err != nil {
+		return nil, time.Now().Add(graphRetry)
+	}
+	return g, time.Time{}
+}
+
+// mergeGraph adds the links of the sibling graph.json to freshly parsed
+// nodes.json data, using g if it was fetched already. A missing graph is
+// retried after graphRetry; prev carries that state between refreshes.
+func (fs *Store) mergeGraph(src CommunitySource, data *store.MeshviewerData, c, prev *sourceCache, g *graphDoc) {
+	if g == nil {
+		if prev != nil && time.Now().Before(prev.graphRetryAt) {
+			c.graphRetryAt = prev.graphRetryAt
+			return
+		}
+		var err error
+		if g, err = fs.fetchGraph(graphURL(src.DataURL), [32]byte{}); err != nil {
+			c.graphRetryAt = time.Now().Add(graphRetry)
+			return
+		}
+	}
+	data.Links = append(data.Links, graphLinks(g.data, data.Nodes)...)
+	c.graphHash = g.hash
+}
+
+// fetchGraph downloads the graph.json at u. A body with hash known is
+// not decoded again.
+func (fs *Store) fetchGraph(u string, known [32]byte) (*graphDoc, error) {
+	if u == "" || !urlcheck.IsSafeURL(u) {
+		return nil, fmt.Errorf("no graph URL")
+	}
+	resp, err := fs.fetcher.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: status %d", u, resp.StatusCode)
+	}
+	body, err := io.ReadAll(fetch.LimitReader(resp.Body, fs.Cfg().MaxDataBytes))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %w", u, err)
+	}
+	g := &graphDoc{hash: sha256.Sum256(body)}
+	if known != ([32]byte{}) && g.hash == known {
+		return g, nil
+	}
+	g.data = &GraphJSONData{}
+	if err := json.Unmarshal(body, g.data); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", u, err)
+	}
+	return g, nil
+}
+
+// graphLinks resolves graph.json links to node IDs, joining the two
+// directions of a directed graph into one link. graph.json stores the
+// inverse TQ like batman-adv's quality, 1 being best; entries without a
+// node_id are matched by MAC.
+func graphLinks(g *GraphJSONData, nodes []store.RawNode) []store.RawLink {
+	byMAC := make(map[string]string, len(nodes))
+	for _, n := range nodes {
+		if n.MAC != "" {
+			byMAC[strings.ToLower(n.MAC)] = n.NodeID
+		}
+	}
+	ids := make([]string, len(g.Batadv.Nodes))
+	for i, n := range g.Batadv.Nodes {
+		switch {
+		case n.NodeID != "":
+			ids[i] = n.NodeID
+		case byMAC[strings.ToLower(n.ID)] != "":
+			ids[i] = byMAC[strings.ToLower(n.ID)]
+		default:
+			ids[i] = strings.ReplaceAll(strings.ToLower(n.ID), ":", "")
+		}
+	}
+
+	links := make(map[string]*store.RawLink)
+	for _, l := range g.Batadv.Links {
+		if l.Source < 0 || l.Target < 0 || l.Source >= len(ids) || l.Target >= len(ids) {
+			continue
+		}
+		src, dst := ids[l.Source], ids[l.Target]
+		if src == "" || dst == "" || src == dst {
+			continue
+		}
+		tq := float64(l.TQ)
+		if tq >= 1 {
+			tq = 1 / tq
+		}
+		typ := l.Type
+		if typ == "" {
+			typ = "other"
+			if l.VPN {
+				typ = "vpn"
+			}
+		}
+		reverse := src > dst
+		if reverse {
+			src, dst = dst, src
+		}
+		key := src + ">" + dst
+		link := links[key]
+		if link == nil {
+			link = &store.RawLink{Source: src, Target: dst, Type: typ}
+			links[key] = link
+		}
+		if reverse || l.Bidirect {
+			link.TargetTQ = max(link.TargetTQ, tq)
+		}
+		if !reverse || l.Bidirect {
+			link.SourceTQ = max(link.SourceTQ, tq)
+		}
+	}
+	keys := make([]string, 0, len(links))
+	for k := range links {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]store.RawLink, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *links[k])
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package federation
+
+import (
+	"sort"
+	"time"
+)
+
+// SourceHealth is the fetch status of one active source, as served by
+// /api/federation/status.
+type SourceHealth struct {
+	CommunityKey        string     `json:"community_key"`
+	DataURL             string     `json:"data_url"`
+	DataType            string     `json:"data_type"`
+	Manual              bool       `json:"manual,omitempty"`
+	LastFetch           *time.Time `json:"last_fetch,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	NextFetch           *time.Time `json:"next_fetch,omitempty"`
+	IntervalSeconds     int64      `json:"interval_seconds,omitempty"`
+	DurationMS          int64      `json:"duration_ms"`
+	HTTPStatus          int        `json:"http_status,omitempty"`
+	Error               string     `json:"error,omitempty"` // of the last fetch
+	ParseWarnings       []string   `json:"parse_warnings,omitempty"`
+	Nodes               int        `json:"nodes"` // in the source, before deduplication
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// SourceHealth reports every active source sorted by community key and
+// URL. Sources not fetched yet have no last_fetch.
+func (fs *Store) SourceHealth() []SourceHealth {
+	lastSuccess := make(map[string]*time.Time)
+	for _, st := range fs.AllSourceStatuses() {
+		lastSuccess[st.URL] = st.LastSuccess
+	}
+
+	sources := fs.GetSources()
+	fs.fedMu.RLock()
+	manual := make(map[string]bool, len(fs.manual))
+	for _, m := range fs.manual {
+		manual[sourceCacheKey(m)] = true
+	}
+	parsed, schedule := fs.parsed, fs.schedule
+	fs.fedMu.RUnlock()
+
+	out := make([]SourceHealth, 0, len(sources))
+	for _, src := range sources {
+		key := sourceCacheKey(src)
+		h := SourceHealth{
+			CommunityKey: src.CommunityKey,
+			DataURL:      src.DataURL,
+			DataType:     src.DataType,
+			Manual:       manual[key],
+			LastSuccess:  lastSuccess[src.DataURL],
+		}
+		if st := schedule[key]; st != nil {
+			fetched, next := st.fetchedAt.UTC(), st.next.UTC()
+			h.LastFetch, h.NextFetch = &fetched, &next
+			h.IntervalSeconds = int64(st.interval / time.Second)
+			h.DurationMS = st.duration.Milliseconds()
+			h.HTTPStatus = st.status
+			h.ConsecutiveFailures = st.failures
+			if st.err != nil {
+				h.Error = st.err.Error()
+			}
+		}
+		// A failed source keeps its old data for comparison only; none
+		// of it is on the map.
+		if c := parsed[key]; c != nil && c.data != nil && h.Error == "" {
+			h.Nodes = len(c.data.Nodes)
+			h.ParseWarnings = c.warnings
+		}
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CommunityKey != out[j].CommunityKey {
+			return out[i].CommunityKey < out[j].CommunityKey
+		}
+		return out[i].DataURL < out[j].DataURL
+	})
+	return out
+}
+
+// CommunityErrors tells, by community key, why communities contribute no
+// nodes: the probe failure of the last discovery for communities without
+// a source, or the last fetch error if every source serving a community
+// failed.
+func (fs *Store) CommunityErrors() map[string]string {
+	sources := fs.GetSources()
+	fs.fedMu.RLock()
+	probeFailures, schedule := fs.probeFailures, fs.schedule
+	fs.fedMu.RUnlock()
+
+	out := make(map[string]string, len(probeFailures))
+	served := make(map[string]bool)
+	for _, src := range sources {
+		st := schedule[sourceCacheKey(src)]
+		for _, k := range append([]string{src.CommunityKey}, src.CommunityKeys...) {
+			switch {
+			case st == nil || st.err == nil:
+				// Not fetched yet counts as served, so no error is
+				// shown before the first refresh.
+				served[k] = true
+			case out[k] == "":
+				out[k] = st.err.Error()
+			}
+		}
+	}
+	for k, msg := range probeFailures {
+		if _, ok := out[k]; !ok {
+			out[k] = msg
+		}
+	}
+	for k := range served {
+		delete(out, k)
+	}
+	return out
+}
@@ -0,0 +1,175 @@
+package federation
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// Exports of the two node databases that predate meshviewer: nodewatcher
+// (wlan slovenija and a few Freifunk communities) and netmon (Freifunk
+// Oldenburg and derivatives). Both track nodes centrally instead of
+// collecting respondd, so statistics are limited to what they monitor.
+
+// --- nodewatcher ---
+
+type nodewatcherResponse struct {
+	Results []nodewatcherNode `json:"results"`
+}
+
+type nodewatcherNode struct {
+	ID     string `json:"@id"`
+	Config struct {
+		General struct {
+			Name   string `json:"name"`
+			Router string `json:"router"`
+		} `json:"core.general"`
+		Location struct {
+			Geolocation *struct {
+				Coordinates []float64 `json:"coordinates"` // GeoJSON: lng, lat[, alt]
+			} `json:"geolocation"`
+		} `json:"core.location"`
+	} `json:"config"`
+	Monitoring struct {
+		General struct {
+			FirstSeen string `json:"first_seen"`
+			LastSeen  string `json:"last_seen"`
+		} `json:"core.general"`
+		Status struct {
+			Network string `json:"network"` // "up", "down" or "unknown"
+		} `json:"core.status"`
+		Clients struct {
+			ClientCount store.FlexInt `json:"client_count"`
+		} `json:"core.clients"`
+		Topology []struct {
+			Protocol string `json:"protocol"`
+			Links    []struct {
+				Peer string      `json:"peer"`
+				LQ   interface{} `json:"lq"`
+				ILQ  interface{} `json:"ilq"`
+			} `json:"links"`
+		} `json:"network.routing.topology"`
+	} `json:"monitoring"`
+}
+
+// ParseNodewatcherToMeshviewer converts a page of the nodewatcher node API
+// (/api/v2/node/?format=json) or a plain array of its nodes. Only the
+// given page is read, so the URL should set a limit covering all nodes.
+func ParseNodewatcherToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	var nodes []nodewatcherNode
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &nodes); err != nil {
+			return nil, err
+		}
+	} else {
+		var resp nodewatcherResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return nil, err
+		}
+		nodes = resp.Results
+	}
+
+	b := newTopologyBuilder()
+	for _, n := range nodes {
+		if n.ID == "" {
+			continue
+		}
+		b.node(n.ID, n.Config.General.Name)
+		rn := b.nodes[n.ID]
+		rn.Model = n.Config.General.Router
+		rn.Firstseen = store.FlexTime(n.Monitoring.General.FirstSeen)
+		rn.Lastseen = store.FlexTime(n.Monitoring.General.LastSeen)
+		rn.IsOnline = n.Monitoring.Status.Network == "up"
+		rn.Clients = n.Monitoring.Clients.ClientCount
+		rn.ClientsOth = n.Monitoring.Clients.ClientCount
+		if g := n.Config.Location.Geolocation; g != nil && len(g.Coordinates) >= 2 {
+			rn.Location = &store.RawLocation{Latitude: g.Coordinates[1], Longitude: g.Coordinates[0]}
+			if len(g.Coordinates) >= 3 {
+				alt := store.FlexFloat64(g.Coordinates[2])
+				rn.Location.Altitude = &alt
+			}
+		}
+	}
+	for _, n := range nodes {
+		for _, t := range n.Monitoring.Topology {
+			for _, l := range t.Links {
+				// Peers outside the export are other networks' nodes.
+				if _, ok := b.nodes[l.Peer]; ok {
+					b.link(n.ID, l.Peer, ifaceToFloat(l.LQ), ifaceToFloat(l.ILQ), strings.ToLower(t.Protocol))
+				}
+			}
+		}
+	}
+	if len(b.order) == 0 {
+		return nil, fmt.Errorf("no nodewatcher nodes")
+	}
+	return b.result(), nil
+}
+
+// --- netmon ---
+
+type netmonResponse struct {
+	Routers []netmonRouter `xml:"routerlist>router"`
+}
+
+type netmonRouter struct {
+	ID        string  `xml:"router_id"`
+	Hostname  string  `xml:"hostname"`
+	Latitude  float64 `xml:"latitude"`
+	Longitude float64 `xml:"longitude"`
+	Chipset   string  `xml:"chipset>hardware_name"`
+	Nickname  string  `xml:"user>nickname"`
+	Status    struct {
+		Status      string  `xml:"status"` // "online", "offline" or "unknown"
+		ClientCount int     `xml:"client_count"`
+		CreateDate  string  `xml:"create_date"`
+		Uptime      float64 `xml:"uptime"` // seconds
+		Loadavg     float64 `xml:"loadavg"`
+		Firmware    string  `xml:"firmware_version"`
+	} `xml:"statusdata"`
+}
+
+// ParseNetmonToMeshviewer converts the XML router list of netmon's REST
+// API (/api/rest/routerlist). netmon keeps the batman-adv neighbours of a
+// router behind separate per-router calls, so the nodes come without
+// links.
+func ParseNetmonToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	var resp netmonResponse
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+
+	b := newTopologyBuilder()
+	for _, r := range resp.Routers {
+		if r.ID == "" {
+			continue
+		}
+		b.node(r.ID, r.Hostname)
+		rn := b.nodes[r.ID]
+		rn.Model = r.Chipset
+		rn.Owner = r.Nickname
+		rn.IsOnline = r.Status.Status == "online"
+		rn.Clients = store.FlexInt(r.Status.ClientCount)
+		rn.ClientsOth = store.FlexInt(r.Status.ClientCount)
+		rn.LoadAvg = store.FlexFloat64(r.Status.Loadavg)
+		rn.Firmware = store.RawFirmware{Release: r.Status.Firmware}
+		// statusdata is the router's last report.
+		if ts, ok := store.ParseTimestamp(r.Status.CreateDate); ok {
+			rn.Lastseen = store.FlexTime(ts.Format(time.RFC3339))
+			if rn.IsOnline && r.Status.Uptime > 0 {
+				rn.Uptime = store.FlexTime(ts.Add(-time.Duration(r.Status.Uptime * float64(time.Second))).Format(time.RFC3339))
+			}
+		}
+		if r.Latitude != 0 || r.Longitude != 0 {
+			rn.Location = &store.RawLocation{Latitude: r.Latitude, Longitude: r.Longitude}
+		}
+	}
+	if len(b.order) == 0 {
+		return nil, fmt.Errorf("no netmon routers")
+	}
+	return b.result(), nil
+}
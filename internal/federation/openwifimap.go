@@ -0,0 +1,148 @@
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// --- openwifimap ---
+//
+// The openwifimap collector keeps one CouchDB document per node that the
+// node itself updates periodically. Its view API answers with CouchDB
+// rows; nodes carry their links as neighbour IDs with a quality from 0 to
+// 1, as olsrd reports it.
+
+// owmOfflineFactor marks a node offline once it missed this many of its
+// update intervals.
+const owmOfflineFactor = 3
+
+// owmDefaultInterval applies to documents that do not state updateInterval.
+const owmDefaultInterval = time.Hour
+
+type owmViewResponse struct {
+	Rows []struct {
+		ID    string          `json:"id"`
+		Value json.RawMessage `json:"value"`
+		Doc   json.RawMessage `json:"doc"` // with include_docs=true
+	} `json:"rows"`
+}
+
+type owmNode struct {
+	ID             string            `json:"_id"`
+	AltID          string            `json:"id"` // view values
+	Type           string            `json:"type"`
+	Hostname       string            `json:"hostname"`
+	Latitude       store.FlexFloat64 `json:"latitude"`
+	Longitude      store.FlexFloat64 `json:"longitude"`
+	LatLng         []float64         `json:"latlng"`
+	LastUpdate     string            `json:"lastupdate"`
+	MTime          string            `json:"mtime"`
+	UpdateInterval store.FlexInt     `json:"updateInterval"` // seconds
+	Links          []struct {
+		ID      string      `json:"id"`
+		Quality interface{} `json:"quality"`
+	} `json:"links"`
+	Hardware *struct {
+		Model string `json:"model"`
+	} `json:"hardware"`
+	Firmware *struct {
+		Name     string `json:"name"`
+		Revision string `json:"revision"`
+	} `json:"firmware"`
+}
+
+// ParseOpenWiFiMapToMeshviewer converts an openwifimap view response
+// (e.g. /view_nodes_spatial) or a JSON array of node documents.
+func ParseOpenWiFiMapToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	var docs []json.RawMessage
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &docs); err != nil {
+			return nil, err
+		}
+	} else {
+		var view owmViewResponse
+		if err := json.Unmarshal(data, &view); err != nil {
+			return nil, err
+		}
+		for _, row := range view.Rows {
+			if len(row.Doc) > 0 && string(row.Doc) != "null" {
+				docs = append(docs, row.Doc)
+			} else {
+				docs = append(docs, row.Value)
+			}
+		}
+	}
+
+	b := newTopologyBuilder()
+	now := time.Now()
+	type owmLink struct {
+		src, dst string
+		quality  float64
+	}
+	var links []owmLink
+	for _, raw := range docs {
+		var n owmNode
+		if err := json.Unmarshal(raw, &n); err != nil {
+			continue
+		}
+		if n.Type != "" && n.Type != "node" {
+			continue
+		}
+		id := n.ID
+		if id == "" {
+			id = n.AltID
+		}
+		if id == "" {
+			continue
+		}
+		b.node(id, n.Hostname)
+		rn := b.nodes[id]
+		if n.Hostname != "" {
+			rn.Hostname = n.Hostname
+		}
+
+		lat, lng := float64(n.Latitude), float64(n.Longitude)
+		if len(n.LatLng) == 2 {
+			lat, lng = n.LatLng[0], n.LatLng[1]
+		}
+		if lat != 0 || lng != 0 {
+			rn.Location = &store.RawLocation{Latitude: lat, Longitude: lng}
+		}
+
+		seen := n.LastUpdate
+		if seen == "" {
+			seen = n.MTime
+		}
+		rn.Lastseen = store.FlexTime(seen)
+		interval := owmDefaultInterval
+		if n.UpdateInterval > 0 {
+			interval = time.Duration(n.UpdateInterval) * time.Second
+		}
+		if ts, ok := store.ParseTimestamp(seen); ok {
+			rn.IsOnline = store.FlexBool(now.Sub(ts) <= owmOfflineFactor*interval)
+		}
+		if n.Hardware != nil {
+			rn.Model = n.Hardware.Model
+		}
+		if n.Firmware != nil {
+			rn.Firmware = store.RawFirmware{Base: n.Firmware.Name, Release: n.Firmware.Revision}
+		}
+
+		for _, l := range n.Links {
+			links = append(links, owmLink{id, l.ID, ifaceToFloat(l.Quality)})
+		}
+	}
+	// Neighbours without a document of their own are not on the map.
+	for _, l := range links {
+		if _, ok := b.nodes[l.dst]; ok {
+			b.link(l.src, l.dst, l.quality, 0, "other")
+		}
+	}
+	if len(b.order) == 0 {
+		return nil, fmt.Errorf("no openwifimap nodes")
+	}
+	return b.result(), nil
+}
@@ -0,0 +1,202 @@
+package federation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
+)
+
+// SourceInfo describes a data source as listed by the admin API.
+type SourceInfo struct {
+	CommunityKey string `json:"community_key"`
+	DataURL      string `json:"data_url"`
+	DataType     string `json:"data_type"`
+	Manual       bool   `json:"manual,omitempty"`
+	Disabled     bool   `json:"disabled,omitempty"`
+}
+
+// sourceDataTypes lists the DataType values parseSource understands.
+var sourceDataTypes = map[string]bool{
+	"meshviewer": true, "nodes": true, "nodelist": true,
+	"olsr": true, "bmx7": true, "babel": true, "alfred": true,
+	"netjson": true, "openwifimap": true, "nodewatcher": true, "netmon": true,
+}
+
+// ListSources returns discovered and manually added sources, including
+// disabled ones.
+func (fs *Store) ListSources() []SourceInfo {
+	fs.fedMu.RLock()
+	defer fs.fedMu.RUnlock()
+	out := make([]SourceInfo, 0, len(fs.sources)+len(fs.manual))
+	add := func(src CommunitySource, manual bool) {
+		out = append(out, SourceInfo{
+			CommunityKey: src.CommunityKey,
+			DataURL:      src.DataURL,
+			DataType:     src.DataType,
+			Manual:       manual,
+			Disabled:     fs.disabled[src.DataURL],
+		})
+	}
+	for _, src := range fs.sources {
+		add(src, false)
+	}
+	for _, src := range fs.manual {
+		add(src, true)
+	}
+	return out
+}
+
+// AddSource registers a manually configured source. It is kept across
+// rediscovery and restarts until removed.
+func (fs *Store) AddSource(src CommunitySource) error {
+	src.DataURL = strings.TrimSpace(src.DataURL)
+	src.CommunityKey = strings.TrimSpace(src.CommunityKey)
+	if src.DataType == "" {
+		src.DataType = "meshviewer"
+	}
+	if !sourceDataTypes[src.DataType] {
+		return fmt.Errorf("unknown data type %q", src.DataType)
+	}
+	if src.CommunityKey == "" {
+		return fmt.Errorf("community key is required")
+	}
+	if !urlcheck.IsSafeURL(src.DataURL) {
+		return fmt.Errorf("data URL %q is not a public http(s) URL", src.DataURL)
+	}
+
+	fs.fedMu.Lock()
+	for _, s := range fs.sources {
+		if s.DataURL == src.DataURL {
+			fs.fedMu.Unlock()
+			return fmt.Errorf("%s is already a discovered source", src.DataURL)
+		}
+	}
+	replaced := false
+	for i, s := range fs.manual {
+		if s.DataURL == src.DataURL {
+			fs.manual[i] = src
+			replaced = true
+		}
+	}
+	if !replaced {
+		fs.manual = append(fs.manual, src)
+	}
+	fs.fedMu.Unlock()
+
+	fs.SaveState()
+	return nil
+}
+
+// RemoveSource deletes a manually added source. It reports false if url
+// is not one.
+func (fs *Store) RemoveSource(url string) bool {
+	fs.fedMu.Lock()
+	found := false
+	manual := fs.manual[:0:0]
+	for _, s := range fs.manual {
+		if s.DataURL == url {
+			found = true
+			continue
+		}
+		manual = append(manual, s)
+	}
+	fs.manual = manual
+	fs.fedMu.Unlock()
+
+	if found {
+		fs.SaveState()
+	}
+	return found
+}
+
+// SetSourceDisabled excludes a source from (or returns it to) refreshes.
+// It reports false if no source has that URL.
+func (fs *Store) SetSourceDisabled(url string, disabled bool) bool {
+	if !fs.hasSource(url) {
+		return false
+	}
+	fs.fedMu.Lock()
+	if disabled {
+		fs.disabled[url] = true
+	} else {
+		delete(fs.disabled, url)
+	}
+	fs.fedMu.Unlock()
+
+	fs.SaveState()
+	return true
+}
+
+// InvalidateSource drops the cached copy of a source so the next refresh
+// downloads and parses it in full, due or not. It reports false if no
+// source has that URL. A running refresh is waited for, as it would store
+// the results of fetches started with the old copy.
+func (fs *Store) InvalidateSource(url string) bool {
+	if !fs.hasSource(url) {
+		return false
+	}
+	fs.refreshMu.Lock()
+	defer fs.refreshMu.Unlock()
+	fs.fedMu.Lock()
+	// The maps are replaced, not modified, as refreshes read them
+	// without holding fedMu.
+	parsed := make(map[string]*sourceCache, len(fs.parsed))
+	for key, c := range fs.parsed {
+		if !strings.HasPrefix(key, url+"|") {
+			parsed[key] = c
+		}
+	}
+	schedule := make(map[string]*sourceSchedule, len(fs.schedule))
+	for key, st := range fs.schedule {
+		if !strings.HasPrefix(key, url+"|") {
+			schedule[key] = st
+		}
+	}
+	fs.parsed, fs.schedule = parsed, schedule
+	fs.fedMu.Unlock()
+	return true
+}
+
+func (fs *Store) hasSource(url string) bool {
+	fs.fedMu.RLock()
+	defer fs.fedMu.RUnlock()
+	for _, s := range fs.sources {
+		if s.DataURL == url {
+			return true
+		}
+	}
+	for _, s := range fs.manual {
+		if s.DataURL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// activeSources returns the sources a refresh fetches: the discovered ones
+// and the manual ones, minus those disabled. The caller holds fedMu.
+func (fs *Store) activeSources() []CommunitySource {
+	if len(fs.manual) == 0 && len(fs.disabled) == 0 {
+		return fs.sources
+	}
+	out := make([]CommunitySource, 0, len(fs.sources)+len(fs.manual))
+	for _, list := range [][]CommunitySource{fs.sources, fs.manual} {
+		for _, s := range list {
+			if !fs.disabled[s.DataURL] {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// disabledList returns the disabled URLs for the state cache. The caller
+// holds fedMu.
+func (fs *Store) disabledList() []string {
+	out := make([]string, 0, len(fs.disabled))
+	for url := range fs.disabled {
+		out = append(out, url)
+	}
+	return out
+}
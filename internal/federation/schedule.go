@@ -0,0 +1,71 @@
+package federation
+
+import (
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sourceTick is how often due sources are looked for between merges.
+	sourceTick = 10 * time.Second
+	// maxSourceInterval caps source-declared intervals and failure backoff.
+	maxSourceInterval = 15 * time.Minute
+)
+
+// sourceSchedule is when a source is fetched next and how its last fetch
+// went. Entries are replaced, never modified, once stored in fs.schedule.
+type sourceSchedule struct {
+	interval time.Duration
+	next     time.Time
+	failures int   // consecutive
+	err      error // of the last fetch
+
+	fetchedAt time.Time
+	duration  time.Duration
+	status    int // HTTP status, 0 without a response
+}
+
+// sourceInterval is the refresh interval of a source: the max-age its
+// server declared, but no less than refreshInterval and no more than
+// maxSourceInterval, doubled per consecutive failure up to eight times.
+func (fs *Store) sourceInterval(c *sourceCache, failures int) time.Duration {
+	base := fs.Cfg().RefreshDuration
+	d := base
+	if c != nil && c.maxAge > d {
+		d = c.maxAge
+	}
+	if failures > 0 {
+		d <<= min(failures-1, 3)
+	}
+	return max(min(d, maxSourceInterval), base)
+}
+
+// nextFetch schedules a source fetched at now. Sources fetched together,
+// at startup or by a forced refresh, are spread over the second half of
+// their interval by a hash of key, so they do not stay in lockstep.
+func nextFetch(key string, now time.Time, interval time.Duration, spread bool) time.Time {
+	if !spread {
+		return now.Add(interval)
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	half := interval / 2
+	return now.Add(half + time.Duration(h.Sum64()%uint64(half+1)))
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header, or 0.
+func cacheMaxAge(h http.Header) time.Duration {
+	for _, dir := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, val, ok := strings.Cut(strings.TrimSpace(dir), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.Trim(val, `"`)); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 0
+}
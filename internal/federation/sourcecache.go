@@ -0,0 +1,68 @@
+package federation
+
+import (
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// sourceCache is the parsed and converted data of one source, kept so an
+// unchanged source is neither decoded nor converted again.
+type sourceCache struct {
+	etag         string
+	lastModified string
+	hash         [32]byte // sha256 of the body
+	data         *store.MeshviewerData
+	warnings     []string
+
+	// graphSource is set for nodes.json data without links, which takes
+	// them from the sibling graph.json.
+	graphSource bool
+	// graphRetryAt delays probing a missing graph.json of a nodes source.
+	graphRetryAt time.Time
+	// graphHash is the sha256 of the graph.json merged into data, zero
+	// without one.
+	graphHash [32]byte
+
+	maxAge time.Duration // from Cache-Control, see sourceInterval
+	merged bool          // data is in the published snapshot
+}
+
+// sourceCacheKey identifies a cache entry. The community key is part of it
+// because conversion suffixes gateway IDs with it.
+func sourceCacheKey(src CommunitySource) string {
+	return src.DataURL + "|" + src.CommunityKey
+}
+
+// convertSourceData prepares freshly parsed data for merging: gateway
+// node_ids are suffixed with the community key, so gateways with the same
+// ID in different communities stay apart, and nodes without a domain are
+// assigned the community.
+func convertSourceData(data *store.MeshviewerData, communityKey string) {
+	gwRename := make(map[string]string)
+	for i := range data.Nodes {
+		if bool(data.Nodes[i].IsGateway) && data.Nodes[i].NodeID != "" {
+			orig := data.Nodes[i].NodeID
+			suffixed := orig + "_" + communityKey
+			gwRename[orig] = suffixed
+			data.Nodes[i].NodeID = suffixed
+		}
+	}
+
+	for i := range data.Nodes {
+		if newGW, ok := gwRename[data.Nodes[i].Gateway]; ok {
+			data.Nodes[i].Gateway = newGW
+		}
+		if data.Nodes[i].NodeID != "" && data.Nodes[i].Domain == "" {
+			data.Nodes[i].Domain = communityKey
+		}
+	}
+	for i := range data.Links {
+		if newID, ok := gwRename[data.Links[i].Source]; ok {
+			data.Links[i].Source = newID
+		}
+		if newID, ok := gwRename[data.Links[i].Target]; ok {
+			data.Links[i].Target = newID
+		}
+	}
+}
@@ -1,19 +1,25 @@
 package federation
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/flight"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/trace"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
 )
 
@@ -27,27 +33,63 @@ type Store struct {
 	sources      []CommunitySource
 	grafanaCache GrafanaCache
 	nodeCommMap  map[string][]string
+	merges       []MergeDecision
 	fedMu        sync.RWMutex
+	fetcher      *fetch.Client
+	probes       *http.Client // short timeout, for discovery probes
+	flights      flight.Group
+
+	// parsed caches the converted data of each source between refreshes,
+	// keyed by sourceCacheKey.
+	parsed map[string]*sourceCache
+	// schedule holds when each source is due, keyed like parsed.
+	// refreshMu serializes fetching and merging.
+	schedule  map[string]*sourceSchedule
+	refreshMu sync.Mutex
+
+	// probeFailures tells why discovery found no source for a
+	// community, by community key.
+	probeFailures map[string]string
+	// scope is the discoveryScope of the last discovery.
+	scope string
+
+	// Operator overrides made through the admin API, persisted with the
+	// state cache: sources added by hand and DataURLs excluded from
+	// refreshes.
+	manual   []CommunitySource
+	disabled map[string]bool
+
+	// noSave makes SaveState a no-op, for one-shot commands that never
+	// restored the state and must not overwrite it.
+	noSave bool
 }
 
-func NewStore(cfg *config.Config) *Store {
+func NewStore(live *config.Live) *Store {
+	cfg := live.Load()
 	return &Store{
-		Store: store.New(cfg),
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		Store:        store.New(live),
+		client:       fetch.HTTPClient(cfg, cfg.FetchTimeoutDuration),
+		fetcher:      fetch.New(cfg),
+		probes:       newProbeClient(cfg),
 		grafanaCache: make(GrafanaCache),
 		nodeCommMap:  make(map[string][]string),
+		parsed:       make(map[string]*sourceCache),
+		schedule:     make(map[string]*sourceSchedule),
+		disabled:     make(map[string]bool),
 	}
 }
 
 // stateCache is the on-disk format for fast startup.
 type stateCache struct {
-	Communities []Community         `json:"communities"`
-	Sources     []CommunitySource   `json:"sources"`
-	NodeCommMap map[string][]string `json:"node_comm_map"`
-	Snapshot    *snapshotCache      `json:"snapshot"`
-	SavedAt     string              `json:"saved_at"`
+	Communities   []Community         `json:"communities"`
+	Sources       []CommunitySource   `json:"sources"`
+	ProbeFailures map[string]string   `json:"probe_failures,omitempty"`
+	NodeCommMap   map[string][]string `json:"node_comm_map"`
+	Snapshot      *snapshotCache      `json:"snapshot"`
+	SavedAt       string              `json:"saved_at"`
+
+	ManualSources   []CommunitySource `json:"manual_sources,omitempty"`
+	DisabledSources []string          `json:"disabled_sources,omitempty"`
 }
 
 type snapshotCache struct {
@@ -69,14 +111,24 @@ func (fs *Store) RestoreState() bool {
 		return false
 	}
 
+	// Overrides apply even when the cached snapshot is unusable.
+	fs.fedMu.Lock()
+	fs.manual = cache.ManualSources
+	for _, url := range cache.DisabledSources {
+		fs.disabled[url] = true
+	}
+	fs.fedMu.Unlock()
+
 	if len(cache.Communities) == 0 || len(cache.Sources) == 0 || cache.Snapshot == nil {
 		return false
 	}
+	fs.filterRestored(&cache)
 
 	// Restore communities and sources
 	fs.fedMu.Lock()
 	fs.communities = cache.Communities
 	fs.sources = cache.Sources
+	fs.probeFailures = cache.ProbeFailures
 	fs.nodeCommMap = cache.NodeCommMap
 	// Grafana cache is loaded separately by its own file
 	fs.grafanaCache = LoadGrafanaCache()
@@ -95,13 +147,10 @@ func (fs *Store) RestoreState() bool {
 	for _, c := range communities {
 		domainNames[c.Key] = c.Name
 	}
-	for k, v := range fs.Cfg.DomainNames {
+	for k, v := range fs.Cfg().DomainNames {
 		domainNames[k] = v
 	}
-	origDomains := fs.Cfg.DomainNames
-	fs.Cfg.DomainNames = domainNames
-	snap := fs.ProcessData(raw)
-	fs.Cfg.DomainNames = origDomains
+	snap := fs.ProcessDataWithNames(raw, domainNames)
 
 	// Re-apply community tags
 	communityStats := make(map[string]int)
@@ -124,12 +173,82 @@ func (fs *Store) RestoreState() bool {
 	return true
 }
 
+// filterRestored applies the current federationInclude/federationExclude
+// to cached state, which may have been discovered with other lists.
+// Communities they now exclude are dropped with their sources and nodes;
+// ones they now include appear with the discovery that follows a restore.
+func (fs *Store) filterRestored(cache *stateCache) {
+	cfg := fs.Cfg()
+	if len(cfg.FederationInclude) == 0 && len(cfg.FederationExclude) == 0 {
+		return
+	}
+	cache.Communities = FilterCommunities(cache.Communities, cfg.FederationInclude, cfg.FederationExclude)
+	keep := make(map[string]bool)
+	for _, c := range cache.Communities {
+		keep[c.Key] = true
+		for _, k := range c.AllKeys {
+			keep[k] = true
+		}
+	}
+	// Manual sources are kept regardless, like in discovery.
+	for _, m := range cache.ManualSources {
+		keep[m.CommunityKey] = true
+		for _, k := range m.CommunityKeys {
+			keep[k] = true
+		}
+	}
+	kept := func(keys ...string) bool {
+		for _, k := range keys {
+			if keep[k] {
+				return true
+			}
+		}
+		return false
+	}
+
+	var sources []CommunitySource
+	for _, src := range cache.Sources {
+		if kept(append([]string{src.CommunityKey}, src.CommunityKeys...)...) {
+			sources = append(sources, src)
+		}
+	}
+	cache.Sources = sources
+
+	nodes := make([]store.RawNode, 0, len(cache.Snapshot.Nodes))
+	ids := make(map[string]bool, len(cache.Snapshot.Nodes))
+	for _, n := range cache.Snapshot.Nodes {
+		if comms := cache.NodeCommMap[n.NodeID]; len(comms) == 0 || kept(comms...) {
+			nodes = append(nodes, n)
+			ids[n.NodeID] = true
+		}
+	}
+	links := make([]store.RawLink, 0, len(cache.Snapshot.Links))
+	for _, l := range cache.Snapshot.Links {
+		if ids[l.Source] && ids[l.Target] {
+			links = append(links, l)
+		}
+	}
+	cache.Snapshot = &snapshotCache{Nodes: nodes, Links: links}
+}
+
+// DisableSaving turns SaveState into a no-op. Call it before the first
+// refresh.
+func (fs *Store) DisableSaving() {
+	fs.noSave = true
+}
+
 // SaveState persists the current federation state to disk for fast restart.
 func (fs *Store) SaveState() {
+	if fs.noSave {
+		return
+	}
 	fs.fedMu.RLock()
 	communities := fs.communities
 	sources := fs.sources
+	probeFailures := fs.probeFailures
 	nodeCommMap := fs.nodeCommMap
+	manual := fs.manual
+	disabled := fs.disabledList()
 	fs.fedMu.RUnlock()
 
 	snap := fs.GetSnapshot()
@@ -140,6 +259,9 @@ func (fs *Store) SaveState() {
 	// Convert processed nodes back to raw format for compact storage
 	rawNodes := make([]store.RawNode, 0, len(snap.NodeList))
 	for _, n := range snap.NodeList {
+		if n.Placeholder {
+			continue
+		}
 		rn := store.RawNode{
 			NodeID:      n.NodeID,
 			Hostname:    n.Hostname,
@@ -152,13 +274,13 @@ func (fs *Store) SaveState() {
 			Domain:      n.Domain,
 			MAC:         n.MAC,
 			Owner:       n.Owner,
-			Uptime:      n.Uptime,
+			Uptime:      store.FlexTime(n.Uptime),
 			LoadAvg:     store.FlexFloat64(n.LoadAvg),
 			MemoryUsage: store.FlexFloat64(n.MemUsage),
 			RootfsUsage: store.FlexFloat64(n.RootfsUsage),
 			Gateway:     n.Gateway,
-			Lastseen:    n.Lastseen,
-			Firstseen:   n.Firstseen,
+			Lastseen:    store.FlexTime(n.Lastseen),
+			Firstseen:   store.FlexTime(n.Firstseen),
 			Nproc:       store.FlexInt(n.Nproc),
 			Addresses:   n.Addresses,
 			Model:       n.Model,
@@ -174,24 +296,36 @@ func (fs *Store) SaveState() {
 		}
 		if n.Lat != nil {
 			rn.Location = &store.RawLocation{Latitude: *n.Lat, Longitude: *n.Lng}
+			if n.Alt != nil {
+				alt := store.FlexFloat64(*n.Alt)
+				rn.Location.Altitude = &alt
+			}
 		}
 		rawNodes = append(rawNodes, rn)
 	}
 
 	rawLinks := make([]store.RawLink, 0, len(snap.Links))
 	for _, l := range snap.Links {
+		lt := l.Type
+		if l.RawType != "" {
+			lt = l.RawType
+		}
 		rawLinks = append(rawLinks, store.RawLink{
 			Source: l.Source, Target: l.Target,
-			SourceTQ: l.SourceTQ, TargetTQ: l.TargetTQ, Type: l.Type,
+			SourceTQ: l.SourceTQ, TargetTQ: l.TargetTQ, Type: lt,
 		})
 	}
 
 	cache := stateCache{
-		Communities: communities,
-		Sources:     sources,
-		NodeCommMap: nodeCommMap,
-		Snapshot:    &snapshotCache{Nodes: rawNodes, Links: rawLinks},
-		SavedAt:     time.Now().UTC().Format(time.RFC3339),
+		Communities:   communities,
+		Sources:       sources,
+		ProbeFailures: probeFailures,
+		NodeCommMap:   nodeCommMap,
+		Snapshot:      &snapshotCache{Nodes: rawNodes, Links: rawLinks},
+		SavedAt:       time.Now().UTC().Format(time.RFC3339),
+
+		ManualSources:   manual,
+		DisabledSources: disabled,
 	}
 
 	data, err := json.Marshal(cache)
@@ -218,10 +352,19 @@ func (fs *Store) GetCommunities() []Community {
 	return fs.communities
 }
 
+// GetSources returns the sources in use: discovered and manually added
+// ones that are not disabled.
 func (fs *Store) GetSources() []CommunitySource {
 	fs.fedMu.RLock()
 	defer fs.fedMu.RUnlock()
-	return fs.sources
+	return fs.activeSources()
+}
+
+// GetMergeDecisions returns the duplicate-device merges of the last refresh.
+func (fs *Store) GetMergeDecisions() []MergeDecision {
+	fs.fedMu.RLock()
+	defer fs.fedMu.RUnlock()
+	return fs.merges
 }
 
 func (fs *Store) GetGrafanaCache() GrafanaCache {
@@ -261,21 +404,35 @@ func (fs *Store) GrafanaInfoForNode(nodeID string) (GrafanaInfo, string) {
 	return bestInfo, originalID
 }
 
-// DiscoverAndRefresh discovers communities and fetches all data.
+// CommunitiesForNode returns the keys of the communities serving nodeID.
+func (fs *Store) CommunitiesForNode(nodeID string) []string {
+	fs.fedMu.RLock()
+	defer fs.fedMu.RUnlock()
+	return append([]string(nil), fs.nodeCommMap[nodeID]...)
+}
+
+// DiscoverAndRefresh discovers communities and fetches all data. Calls
+// overlapping a running discovery wait for it.
 func (fs *Store) DiscoverAndRefresh() error {
-	log.Println("Federation: discovering communities from api.freifunk.net...")
+	_, err, _ := fs.flights.Do("discover", func() (interface{}, error) {
+		return nil, fs.discoverAndRefresh()
+	})
+	return err
+}
+
+func (fs *Store) discoverAndRefresh() (err error) {
+	ctx, span := trace.Start(context.Background(), "federation.discover")
+	defer func() { span.SetError(err); span.End() }()
 
-	communities, err := DiscoverCommunities(fs.client)
+	scope := discoveryScope(fs.Cfg())
+	communities, sources, failures, err := fs.discover(ctx)
 	if err != nil {
-		return fmt.Errorf("discovering communities: %w", err)
+		return err
 	}
-	log.Printf("Federation: found %d communities with data URLs", len(communities))
-
-	log.Println("Federation: probing data source URLs...")
-	sources := ResolveBestSources(fs.client, communities, 30)
-	log.Printf("Federation: %d communities have reachable data sources", len(sources))
 
-	grafanaCache := DiscoverGrafanaURLs(fs.client, sources, communities)
+	_, gs := trace.Start(ctx, "grafana")
+	grafanaCache := DiscoverGrafanaURLs(fs.probes, fs.Cfg(), sources, communities)
+	gs.End()
 
 	for _, c := range communities {
 		if info, ok := grafanaCache[c.Key]; ok {
@@ -344,45 +501,148 @@ func (fs *Store) DiscoverAndRefresh() error {
 	fs.fedMu.Lock()
 	fs.communities = communities
 	fs.sources = sources
+	fs.probeFailures = failures
 	fs.grafanaCache = grafanaCache
+	fs.scope = scope
 	fs.fedMu.Unlock()
 
-	return fs.RefreshAllSources()
+	return fs.RefreshSources()
+}
+
+// discoveryScope identifies the keys that decide which communities a
+// discovery finds, so a reload changing them can trigger a new one.
+func discoveryScope(cfg *config.Config) string {
+	return strings.Join([]string{
+		cfg.FederationDirectory,
+		strings.Join(cfg.FederationInclude, "\x00"),
+		strings.Join(cfg.FederationExclude, "\x00"),
+	}, "\x01")
+}
+
+// scopeChanged reports whether the config now selects other communities
+// than the last discovery used.
+func (fs *Store) scopeChanged() bool {
+	fs.fedMu.RLock()
+	defer fs.fedMu.RUnlock()
+	return fs.scope != "" && fs.scope != discoveryScope(fs.Cfg())
+}
+
+// Discover reads the community directory and probes each community for
+// its best data source. failures tells why communities have none, by
+// community key. It does not change the store.
+func (fs *Store) Discover() (communities []Community, sources []CommunitySource, failures map[string]string, err error) {
+	return fs.discover(context.Background())
+}
+
+func (fs *Store) discover(ctx context.Context) ([]Community, []CommunitySource, map[string]string, error) {
+	dir := DirectoryLocation(fs.Cfg().FederationDirectory)
+	log.Printf("Federation: discovering communities from %s...", dir)
+
+	_, span := trace.StartClient(ctx, "directory", "url", dir)
+	communities, err := DiscoverCommunities(fs.client, dir, fs.Cfg().MaxDirectoryBytes)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("discovering communities: %w", err)
+	}
+	log.Printf("Federation: found %d communities with data URLs", len(communities))
+	if len(fs.Cfg().FederationInclude) > 0 || len(fs.Cfg().FederationExclude) > 0 {
+		communities = FilterCommunities(communities, fs.Cfg().FederationInclude, fs.Cfg().FederationExclude)
+		log.Printf("Federation: %d communities left after federationInclude/federationExclude", len(communities))
+	}
+
+	log.Println("Federation: probing data source URLs...")
+	_, span = trace.Start(ctx, "probe", "communities", strconv.Itoa(len(communities)))
+	sources, failures := ResolveBestSources(fs.probes, communities, 30)
+	span.End()
+	log.Printf("Federation: %d communities have reachable data sources", len(sources))
+	return communities, sources, failures, nil
 }
 
-// RefreshAllSources fetches node data from all discovered sources and merges.
+// RefreshAllSources fetches node data from all discovered sources, due or
+// not, and merges. Calls overlapping a running refresh wait for it.
 func (fs *Store) RefreshAllSources() error {
-	sources := fs.GetSources()
-	if len(sources) == 0 {
-		return fmt.Errorf("no data sources available")
+	return fs.refresh("refresh", true)
+}
+
+// RefreshSources fetches the sources that are due and merges the latest
+// data of all of them.
+func (fs *Store) RefreshSources() error {
+	return fs.refresh("refresh-due", false)
+}
+
+func (fs *Store) refresh(key string, force bool) error {
+	_, err, _ := fs.flights.Do(key, func() (interface{}, error) {
+		fs.refreshMu.Lock()
+		defer fs.refreshMu.Unlock()
+		return nil, fs.refreshSources(force)
+	})
+	return err
+}
+
+// FetchDueSources fetches the sources whose interval has passed without
+// merging; the next refresh publishes their data. It returns at once while
+// a refresh or another fetch is running.
+func (fs *Store) FetchDueSources() {
+	if !fs.refreshMu.TryLock() {
+		return
 	}
+	defer fs.refreshMu.Unlock()
+	sources := fs.GetSources()
+	ctx, span := trace.Start(context.Background(), "federation.fetch_due", "sources", strconv.Itoa(len(sources)))
+	n := fs.fetchSources(ctx, sources, false)
+	span.SetAttr("fetched", strconv.Itoa(n))
+	span.End()
+}
 
+// fetchSources fetches the sources that are due, or all of them if force,
+// and stores the results in fs.parsed and fs.schedule, dropping sources no
+// longer listed. It returns the number of sources fetched. The caller
+// holds refreshMu.
+func (fs *Store) fetchSources(ctx context.Context, sources []CommunitySource, force bool) int {
 	type fetchResult struct {
-		communityKey string
-		source       CommunitySource
-		data         *store.MeshviewerData
-		err          error
+		key      string
+		cache    *sourceCache
+		changed  bool
+		status   int
+		duration time.Duration
+		err      error
 	}
 
+	now := time.Now()
+	fs.fedMu.RLock()
+	prevParsed, prevSchedule := fs.parsed, fs.schedule
+	fs.fedMu.RUnlock()
+
 	ch := make(chan fetchResult, len(sources))
 	sem := make(chan struct{}, 50)
 	var wg sync.WaitGroup
 
 	for _, src := range sources {
+		key := sourceCacheKey(src)
+		if st := prevSchedule[key]; !force && st != nil && now.Before(st.next) {
+			continue
+		}
 		wg.Add(1)
-		go func(src CommunitySource) {
+		go func(src CommunitySource, key string) {
 			defer wg.Done()
 			sem <- struct{}{}
 			defer func() { <-sem }()
 
-			data, err := fs.fetchSource(src)
-			ch <- fetchResult{
-				communityKey: src.CommunityKey,
-				source:       src,
-				data:         data,
-				err:          err,
+			_, span := trace.StartClient(ctx, "fetch", "community", src.CommunityKey, "url", src.DataURL, "type", src.DataType)
+			start := time.Now()
+			c, changed, status, err := fs.fetchSource(src, prevParsed[key])
+			d := time.Since(start)
+			fs.RecordFetch(src.DataURL, d, err)
+			span.SetAttr("changed", strconv.FormatBool(changed))
+			span.SetError(err)
+			span.End()
+			if err == nil && changed && c != nil && c.data != nil {
+				c.warnings = store.CheckRawData(c.data)
+				convertSourceData(c.data, src.CommunityKey)
 			}
-		}(src)
+			ch <- fetchResult{key: key, cache: c, changed: changed, status: status, duration: d, err: err}
+		}(src, key)
 	}
 
 	go func() {
@@ -390,63 +650,119 @@ func (fs *Store) RefreshAllSources() error {
 		close(ch)
 	}()
 
+	var results []fetchResult
+	for r := range ch {
+		results = append(results, r)
+	}
+
+	// Entries are carried over from the current maps rather than the
+	// snapshot, and results for sources invalidated since are dropped, so
+	// an invalidation is never undone by old data.
+	fs.fedMu.Lock()
+	defer fs.fedMu.Unlock()
+	parsed := make(map[string]*sourceCache, len(sources))
+	schedule := make(map[string]*sourceSchedule, len(sources))
+	for _, src := range sources {
+		key := sourceCacheKey(src)
+		if c := fs.parsed[key]; c != nil {
+			parsed[key] = c
+		}
+		if st := fs.schedule[key]; st != nil {
+			schedule[key] = st
+		}
+	}
+
+	fetched := 0
+	for _, r := range results {
+		if prevSchedule[r.key] != nil && schedule[r.key] == nil {
+			continue
+		}
+		fetched++
+		prev := schedule[r.key]
+		st := &sourceSchedule{fetchedAt: now, duration: r.duration, status: r.status, err: r.err}
+		if r.err != nil {
+			// Keep the old entry so an identical body next time is
+			// still recognized.
+			if prev != nil {
+				st.failures = prev.failures + 1
+			} else {
+				st.failures = 1
+			}
+		} else if r.cache == nil || r.cache.data == nil {
+			delete(parsed, r.key)
+		} else {
+			parsed[r.key] = r.cache
+		}
+		st.interval = fs.sourceInterval(parsed[r.key], st.failures)
+		st.next = nextFetch(r.key, now, st.interval, force || prev == nil)
+		schedule[r.key] = st
+	}
+	fs.parsed = parsed
+	fs.schedule = schedule
+	return fetched
+}
+
+// refreshSources fetches the due sources, or all of them if force, and
+// merges the data of every source whose last fetch succeeded.
+func (fs *Store) refreshSources(force bool) (err error) {
+	sources := fs.GetSources()
+	ctx, span := trace.Start(context.Background(), "federation.refresh", "sources", strconv.Itoa(len(sources)))
+	defer func() { span.SetError(err); span.End() }()
+	if len(sources) == 0 {
+		return fmt.Errorf("no data sources available")
+	}
+
+	fetched := fs.fetchSources(ctx, sources, force)
+	span.SetAttr("fetched", strconv.Itoa(fetched))
+
+	fs.fedMu.RLock()
+	parsed, schedule := fs.parsed, fs.schedule
+	fs.fedMu.RUnlock()
+
 	merged := &store.MeshviewerData{
 		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
 
+	warnings := make(map[string][]string)
 	nodeCommMap := make(map[string][]string)
 	seenNodes := make(map[string]bool)
 	seenLinks := make(map[string]bool)
 
 	successCount := 0
 	failCount := 0
-	for r := range ch {
-		if r.err != nil {
+	unchangedCount := 0
+	for _, src := range sources {
+		key := sourceCacheKey(src)
+		if st := schedule[key]; st != nil && st.err != nil {
+			warnings[src.DataURL] = []string{st.err.Error()}
 			failCount++
 			continue
 		}
-		if r.data == nil {
+		c := parsed[key]
+		if c == nil || c.data == nil {
 			continue
 		}
-
-		allComms := r.source.CommunityKeys
-		if len(allComms) == 0 {
-			allComms = []string{r.communityKey}
+		if c.merged {
+			unchangedCount++
 		}
-
-		// Suffix gateway node_ids with community key
-		gwRename := make(map[string]string)
-		for i := range r.data.Nodes {
-			if bool(r.data.Nodes[i].IsGateway) && r.data.Nodes[i].NodeID != "" {
-				orig := r.data.Nodes[i].NodeID
-				suffixed := orig + "_" + r.communityKey
-				gwRename[orig] = suffixed
-				r.data.Nodes[i].NodeID = suffixed
-			}
+		c.merged = true
+		if len(c.warnings) > 0 {
+			warnings[src.DataURL] = c.warnings
 		}
 
-		for i := range r.data.Nodes {
-			if newGW, ok := gwRename[r.data.Nodes[i].Gateway]; ok {
-				r.data.Nodes[i].Gateway = newGW
-			}
-		}
-		for i := range r.data.Links {
-			if newID, ok := gwRename[r.data.Links[i].Source]; ok {
-				r.data.Links[i].Source = newID
-			}
-			if newID, ok := gwRename[r.data.Links[i].Target]; ok {
-				r.data.Links[i].Target = newID
-			}
+		allComms := src.CommunityKeys
+		if len(allComms) == 0 {
+			allComms = []string{src.CommunityKey}
 		}
 
-		for i := range r.data.Nodes {
-			nid := r.data.Nodes[i].NodeID
+		// Nodes and links are copied by value into merged, so the cached
+		// data is never modified by deduplication.
+		data := c.data
+		for i := range data.Nodes {
+			nid := data.Nodes[i].NodeID
 			if nid == "" {
 				continue
 			}
-			if r.data.Nodes[i].Domain == "" {
-				r.data.Nodes[i].Domain = r.communityKey
-			}
 
 			if seenNodes[nid] {
 				for _, ck := range allComms {
@@ -457,36 +773,40 @@ func (fs *Store) RefreshAllSources() error {
 				for _, ck := range allComms {
 					nodeCommMap[nid] = store.AppendUnique(nodeCommMap[nid], ck)
 				}
-				merged.Nodes = append(merged.Nodes, r.data.Nodes[i])
+				merged.Nodes = append(merged.Nodes, data.Nodes[i])
 			}
 		}
 
-		for i := range r.data.Links {
-			lk := r.data.Links[i].Source + ">" + r.data.Links[i].Target
+		for i := range data.Links {
+			lk := data.Links[i].Source + ">" + data.Links[i].Target
 			if !seenLinks[lk] {
 				seenLinks[lk] = true
-				merged.Links = append(merged.Links, r.data.Links[i])
+				merged.Links = append(merged.Links, data.Links[i])
 			}
 		}
 
 		successCount++
 	}
 
-	log.Printf("Federation: merged data from %d/%d sources (%d failed, %d unique nodes, %d links)",
-		successCount, len(sources), failCount, len(merged.Nodes), len(merged.Links))
+	_, ms := trace.Start(ctx, "merge")
+	merges := mergeDuplicateDevices(merged, nodeCommMap)
+	ms.End()
+
+	log.Printf("Federation: merged data from %d/%d sources (%d failed, %d unchanged, %d unique nodes, %d links, %d duplicate devices merged)",
+		successCount, len(sources), failCount, unchangedCount, len(merged.Nodes), len(merged.Links), len(merges))
 
 	communities := fs.GetCommunities()
 	domainNames := make(map[string]string)
 	for _, c := range communities {
 		domainNames[c.Key] = c.Name
 	}
-	for k, v := range fs.Cfg.DomainNames {
+	for k, v := range fs.Cfg().DomainNames {
 		domainNames[k] = v
 	}
-	origDomains := fs.Cfg.DomainNames
-	fs.Cfg.DomainNames = domainNames
-	snap := fs.ProcessData(merged)
-	fs.Cfg.DomainNames = origDomains
+	_, ps := trace.Start(ctx, "process")
+	snap := fs.ProcessDataWithNames(merged, domainNames)
+	ps.SetAttr("nodes", strconv.Itoa(len(snap.NodeList)))
+	ps.End()
 
 	communityStats := make(map[string]int)
 	for _, n := range snap.Nodes {
@@ -500,124 +820,318 @@ func (fs *Store) RefreshAllSources() error {
 		}
 	}
 	snap.Stats.Communities = communityStats
+	if snap.Hidden > 0 {
+		// Forget the communities and merges of hidden nodes too, so the
+		// saved state holds nothing about them.
+		for id := range nodeCommMap {
+			if _, ok := snap.Nodes[id]; !ok {
+				delete(nodeCommMap, id)
+			}
+		}
+		kept := merges[:0]
+		for _, m := range merges {
+			if _, ok := snap.Nodes[m.KeptID]; ok && !fs.IsHidden(m.MergedID) {
+				kept = append(kept, m)
+			}
+		}
+		merges = kept
+	}
 
 	fs.fedMu.Lock()
 	fs.nodeCommMap = nodeCommMap
+	fs.merges = merges
 	fs.fedMu.Unlock()
 
+	fs.SetSourceWarnings(warnings)
+	_, ps = trace.Start(ctx, "publish")
 	fs.SetSnapshot(snap)
+	ps.End()
 
 	// Persist state for fast restart
+	_, ps = trace.Start(ctx, "save_state")
 	fs.SaveState()
+	ps.End()
 
 	return nil
 }
 
-func (fs *Store) fetchSource(src CommunitySource) (*store.MeshviewerData, error) {
+// decodeAnyNode decodes a node entry of meshviewer.json, Yanic nodes.json,
+// nodelist.json or ffmap-d3 nodes.json, telling them apart by their
+// identifying keys. client reports ffmap-d3 client entries, which are
+// left out without being malformed.
+func decodeAnyNode(raw json.RawMessage) (rn store.RawNode, ok, client bool) {
+	var probe struct {
+		NodeID   json.RawMessage `json:"node_id"`
+		Nodeinfo json.RawMessage `json:"nodeinfo"`
+		ID       json.RawMessage `json:"id"`
+		Flags    json.RawMessage `json:"flags"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return store.RawNode{}, false, false
+	}
+	switch {
+	case probe.Nodeinfo != nil:
+		var n NodesJSONNode
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return store.RawNode{}, false, false
+		}
+		rn, ok = nodesJSONNodeToRaw(n)
+	case probe.NodeID != nil:
+		rn, ok = store.DecodeRawNode(raw)
+	case probe.ID != nil && probe.Flags != nil:
+		return decodeFfmapD3Node(raw)
+	case probe.ID != nil:
+		var n NodelistNode
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return store.RawNode{}, false, false
+		}
+		rn, ok = nodelistNodeToRaw(n)
+	}
+	return rn, ok, false
+}
+
+// fetchSource downloads and parses a source. When the server answers a
+// conditional request with 304 or returns a body with the same hash as
+// prev, and the source's graph.json is unchanged too, prev's parsed data
+// is reused and changed is false. status is the HTTP status code, 0 if no
+// response arrived.
+func (fs *Store) fetchSource(src CommunitySource, prev *sourceCache) (c *sourceCache, changed bool, status int, err error) {
+	return fs.fetchSourceGraph(src, prev, nil)
+}
+
+// fetchSourceGraph is fetchSource with the graph.json already fetched, or
+// nil.
+func (fs *Store) fetchSourceGraph(src CommunitySource, prev *sourceCache, g *graphDoc) (c *sourceCache, changed bool, status int, err error) {
 	if !urlcheck.IsSafeURL(src.DataURL) {
-		return nil, fmt.Errorf("blocked unsafe URL: %s", src.DataURL)
+		return nil, false, status, fmt.Errorf("blocked unsafe URL: %s", src.DataURL)
 	}
-	resp, err := fs.client.Get(src.DataURL)
+	req, err := http.NewRequest(http.MethodGet, src.DataURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("GET %s: %w", src.DataURL, err)
+		return nil, false, status, fmt.Errorf("GET %s: %w", src.DataURL, err)
+	}
+	if prev != nil {
+		if prev.etag != "" {
+			req.Header.Set("If-None-Match", prev.etag)
+		}
+		if prev.lastModified != "" {
+			req.Header.Set("If-Modified-Since", prev.lastModified)
+		}
+	}
+	resp, err := fs.fetcher.Do(req)
+	if err != nil {
+		return nil, false, status, fmt.Errorf("GET %s: %w", src.DataURL, err)
 	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
 
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		// g is set when this is the full fetch below; a server answering
+		// it with 304 anyway gets no further attempt.
+		if prev.graphHash == ([32]byte{}) || g != nil {
+			return prev, false, status, nil
+		}
+		var retryAt time.Time
+		g, retryAt = fs.graphDue(src, prev)
+		if g == nil || g.hash == prev.graphHash {
+			// The last links are kept while graph.json fails.
+			c := *prev
+			c.graphRetryAt = retryAt
+			return &c, false, status, nil
+		}
+		// Only the links changed, but they are merged into the nodes
+		// data, so the nodes are needed in full again.
+		resp.Body.Close()
+		full := *prev
+		full.etag, full.lastModified = "", ""
+		return fs.fetchSourceGraph(src, &full, g)
+	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("GET %s: status %d", src.DataURL, resp.StatusCode)
+		return nil, false, status, fmt.Errorf("GET %s: status %d", src.DataURL, resp.StatusCode)
 	}
 
 	// Reject HTML responses (SPA meshviewers return index.html for all URLs)
 	ct := resp.Header.Get("Content-Type")
 	if strings.Contains(ct, "text/html") {
-		return nil, fmt.Errorf("GET %s: got HTML, not JSON", src.DataURL)
+		return nil, false, status, fmt.Errorf("GET %s: got HTML, not JSON", src.DataURL)
 	}
 
-	const maxBodySize = 20 * 1024 * 1024 // 20 MB
-	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	// The body is hashed before decoding, so an unchanged one is not
+	// decoded again.
+	body, err := io.ReadAll(fetch.LimitReader(resp.Body, fs.Cfg().MaxDataBytes))
 	if err != nil {
-		return nil, err
+		return nil, false, status, fmt.Errorf("GET %s: %w", src.DataURL, err)
+	}
+	c = &sourceCache{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		hash:         sha256.Sum256(body),
+		maxAge:       cacheMaxAge(resp.Header),
+	}
+	if prev != nil && prev.hash == c.hash {
+		retryAt := prev.graphRetryAt
+		if prev.graphSource && g == nil {
+			g, retryAt = fs.graphDue(src, prev)
+		}
+		if g == nil || g.hash == prev.graphHash {
+			c.data = prev.data
+			c.graphSource = prev.graphSource
+			c.graphHash = prev.graphHash
+			c.warnings = prev.warnings
+			c.graphRetryAt = retryAt
+			c.merged = prev.merged
+			return c, false, status, nil
+		}
 	}
 
-	switch src.DataType {
-	case "meshviewer":
-		// Auto-detect: if URL ends in nodes.json, or meshviewer parse yields
-		// no nodes but nodes.json parse succeeds, use nodes.json format.
-		if strings.HasSuffix(src.DataURL, "/nodes.json") {
-			if mv, err := ParseNodesJSONToMeshviewer(body); err == nil && len(mv.Nodes) > 0 {
-				return mv, nil
+	data, err := parseSource(src.DataType, bytes.NewReader(body))
+	if err != nil {
+		return nil, false, status, err
+	}
+	c.data = data
+	// yanic nodes.json carries no links; they are in graph.json.
+	c.graphSource = src.DataType == "nodes" && len(data.Links) == 0
+	if c.graphSource {
+		fs.mergeGraph(src, data, c, prev, g)
+	}
+	return c, true, status, nil
+}
+
+func parseSource(dataType string, r io.Reader) (*store.MeshviewerData, error) {
+	switch dataType {
+	case "meshviewer", "nodelist", "nodes":
+		// Communities mislabel their formats freely, so the format is
+		// detected per node entry while decoding instead of trusting
+		// DataType or trying several parsers on the body.
+		clients := 0
+		mv, err := store.DecodeMeshviewerStream(r, func(raw json.RawMessage) (store.RawNode, bool) {
+			rn, ok, client := decodeAnyNode(raw)
+			if client {
+				clients++
 			}
+			return rn, ok
+		})
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s JSON: %w", dataType, err)
 		}
-		var mv store.MeshviewerData
-		if err := json.Unmarshal(body, &mv); err != nil {
-			// Fallback: try nodes.json format
-			if mv2, err2 := ParseNodesJSONToMeshviewer(body); err2 == nil && len(mv2.Nodes) > 0 {
-				return mv2, nil
-			}
-			return nil, fmt.Errorf("parsing meshviewer JSON: %w", err)
+		mv.Skipped -= clients
+		return mv, nil
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch dataType {
+	case "olsr":
+		mv, err := ParseOLSRToMeshviewer(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing olsr jsoninfo: %w", err)
 		}
-		if len(mv.Nodes) == 0 {
-			// Meshviewer parsed but empty — try nodes.json format
-			if mv2, err := ParseNodesJSONToMeshviewer(body); err == nil && len(mv2.Nodes) > 0 {
-				return mv2, nil
-			}
+		return mv, nil
+
+	case "netjson":
+		mv, err := ParseNetJSONToMeshviewer(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing netjson: %w", err)
 		}
-		return &mv, nil
+		return mv, nil
 
-	case "nodelist":
-		mv, err := ParseNodelistToMeshviewer(body)
-		if err == nil && len(mv.Nodes) > 0 {
-			return mv, nil
+	case "bmx7":
+		mv, err := ParseBMX7ToMeshviewer(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing bmx7 json: %w", err)
 		}
-		// Fallback: some communities mislabel nodes.json as "nodelist"
-		if mv2, err2 := ParseNodesJSONToMeshviewer(body); err2 == nil && len(mv2.Nodes) > 0 {
-			return mv2, nil
+		return mv, nil
+
+	case "babel":
+		mv, err := ParseBabelToMeshviewer(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing babel dump: %w", err)
 		}
+		return mv, nil
+
+	case "alfred":
+		mv, err := ParseAlfredToMeshviewer(body)
 		if err != nil {
-			return nil, fmt.Errorf("parsing nodelist JSON: %w", err)
+			return nil, fmt.Errorf("parsing alfred json: %w", err)
 		}
 		return mv, nil
 
-	case "nodes":
-		mv, err := ParseNodesJSONToMeshviewer(body)
+	case "openwifimap":
+		mv, err := ParseOpenWiFiMapToMeshviewer(body)
 		if err != nil {
-			return nil, fmt.Errorf("parsing nodes.json: %w", err)
+			return nil, fmt.Errorf("parsing openwifimap json: %w", err)
+		}
+		return mv, nil
+
+	case "nodewatcher":
+		mv, err := ParseNodewatcherToMeshviewer(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing nodewatcher json: %w", err)
+		}
+		return mv, nil
+
+	case "netmon":
+		mv, err := ParseNetmonToMeshviewer(body)
+		if err != nil {
+			return nil, fmt.Errorf("parsing netmon xml: %w", err)
 		}
 		return mv, nil
 
 	default:
-		return nil, fmt.Errorf("unknown data type: %s", src.DataType)
+		return nil, fmt.Errorf("unknown data type: %s", dataType)
 	}
 }
 
 // RunRefreshLoop periodically re-discovers communities and refreshes data.
+// Each source is fetched when its own interval has passed; the merged map
+// is published every refreshInterval.
 func (fs *Store) RunRefreshLoop(ctx context.Context, hub store.SSEBroadcaster) {
 	discoveryTicker := time.NewTicker(30 * time.Minute)
-	dataTicker := time.NewTicker(fs.Cfg.RefreshDuration)
+	dataTicker := time.NewTicker(fs.Cfg().RefreshDuration)
+	fetchTicker := time.NewTicker(sourceTick)
 	defer discoveryTicker.Stop()
 	defer dataTicker.Stop()
+	defer fetchTicker.Stop()
+
+	rediscover := func() {
+		old := fs.GetSnapshot()
+		if err := fs.DiscoverAndRefresh(); err != nil {
+			log.Printf("Federation discovery error: %v", err)
+			return
+		}
+		snap := fs.GetSnapshot()
+		log.Printf("Federation re-discovery: %d nodes (%d online), %d clients, %d SSE clients",
+			snap.Stats.TotalNodes, snap.Stats.OnlineNodes, snap.Stats.TotalClients, hub.ClientCount())
+		diff := store.ComputeDiff(old, snap)
+		if diff != nil {
+			hub.Broadcast(diff)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-discoveryTicker.C:
-			old := fs.GetSnapshot()
-			if err := fs.DiscoverAndRefresh(); err != nil {
-				log.Printf("Federation discovery error: %v", err)
-				continue
-			}
-			snap := fs.GetSnapshot()
-			log.Printf("Federation re-discovery: %d nodes (%d online), %d clients, %d SSE clients",
-				snap.Stats.TotalNodes, snap.Stats.OnlineNodes, snap.Stats.TotalClients, hub.ClientCount())
-			diff := store.ComputeDiff(old, snap)
-			if diff != nil {
-				hub.Broadcast(diff)
+		case <-fs.Reloaded():
+			dataTicker.Reset(fs.Cfg().RefreshDuration)
+			// Other federation lists or another directory take effect
+			// now rather than with the next scheduled discovery.
+			if fs.scopeChanged() {
+				log.Println("Federation: community selection changed, re-discovering")
+				rediscover()
+				discoveryTicker.Reset(30 * time.Minute)
 			}
+		case <-discoveryTicker.C:
+			rediscover()
+
+		case <-fetchTicker.C:
+			go fs.FetchDueSources()
 
 		case <-dataTicker.C:
 			old := fs.GetSnapshot()
-			if err := fs.RefreshAllSources(); err != nil {
+			if err := fs.RefreshSources(); err != nil {
 				log.Printf("Federation data refresh error: %v", err)
 				continue
 			}
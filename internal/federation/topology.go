@@ -0,0 +1,399 @@
+package federation
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// Topology-only sources from non-batman routing daemons. They carry no
+// nodeinfo, so nodes are identified by router address/ID and have no
+// location unless the daemon (or a bridge in front of it) publishes one.
+
+// topologyBuilder collects nodes and de-duplicated links for topology sources.
+type topologyBuilder struct {
+	nodes map[string]*store.RawNode
+	order []string
+	links map[string]int
+	mv    *store.MeshviewerData
+}
+
+func newTopologyBuilder() *topologyBuilder {
+	return &topologyBuilder{
+		nodes: make(map[string]*store.RawNode),
+		links: make(map[string]int),
+		mv:    &store.MeshviewerData{Timestamp: time.Now().UTC().Format(time.RFC3339)},
+	}
+}
+
+func (b *topologyBuilder) node(id, hostname string) {
+	if id == "" {
+		return
+	}
+	if n, ok := b.nodes[id]; ok {
+		if hostname != "" && n.Hostname == id {
+			n.Hostname = hostname
+		}
+		return
+	}
+	if hostname == "" {
+		hostname = id
+	}
+	b.nodes[id] = &store.RawNode{NodeID: id, Hostname: hostname, IsOnline: true}
+	b.order = append(b.order, id)
+}
+
+func (b *topologyBuilder) link(src, dst string, srcTQ, dstTQ float64, linkType string) {
+	if src == "" || dst == "" || src == dst {
+		return
+	}
+	b.node(src, "")
+	b.node(dst, "")
+	key := src + ">" + dst
+	if dst < src {
+		key = dst + ">" + src
+		srcTQ, dstTQ = dstTQ, srcTQ
+		src, dst = dst, src
+	}
+	srcTQ, dstTQ = clampTQ(srcTQ), clampTQ(dstTQ)
+	if idx, ok := b.links[key]; ok {
+		// Seen from the other side: keep the better estimate per direction.
+		l := &b.mv.Links[idx]
+		if srcTQ > l.SourceTQ {
+			l.SourceTQ = srcTQ
+		}
+		if dstTQ > l.TargetTQ {
+			l.TargetTQ = dstTQ
+		}
+		return
+	}
+	b.links[key] = len(b.mv.Links)
+	b.mv.Links = append(b.mv.Links, store.RawLink{
+		Source: src, Target: dst, SourceTQ: srcTQ, TargetTQ: dstTQ, Type: linkType,
+	})
+}
+
+func (b *topologyBuilder) result() *store.MeshviewerData {
+	b.mv.Nodes = make([]store.RawNode, 0, len(b.order))
+	for _, id := range b.order {
+		b.mv.Nodes = append(b.mv.Nodes, *b.nodes[id])
+	}
+	return b.mv
+}
+
+func clampTQ(tq float64) float64 {
+	if tq < 0 {
+		return 0
+	}
+	if tq > 1 {
+		return 1
+	}
+	return tq
+}
+
+// --- olsrd jsoninfo ---
+
+type olsrJSONInfo struct {
+	Topology []olsrTopologyEntry `json:"topology"`
+	Links    []olsrLinkEntry     `json:"links"`
+	// olsrd 0.6 wraps everything in "data"
+	Data []struct {
+		Topology []olsrTopologyEntry `json:"topology"`
+		Links    []olsrLinkEntry     `json:"links"`
+	} `json:"data"`
+	Config struct {
+		MainIP string `json:"mainIpAddress"`
+	} `json:"config"`
+}
+
+type olsrTopologyEntry struct {
+	LastHopIP           string      `json:"lastHopIP"`
+	DestinationIP       string      `json:"destinationIP"`
+	LinkQuality         interface{} `json:"linkQuality"`
+	NeighborLinkQuality interface{} `json:"neighborLinkQuality"`
+}
+
+type olsrLinkEntry struct {
+	LocalIP             string      `json:"localIP"`
+	RemoteIP            string      `json:"remoteIP"`
+	LinkQuality         interface{} `json:"linkQuality"`
+	NeighborLinkQuality interface{} `json:"neighborLinkQuality"`
+}
+
+// ParseOLSRToMeshviewer converts olsrd jsoninfo output (/topology, /links or
+// /all) into MeshviewerData. Nodes are keyed by their main IP address.
+func ParseOLSRToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	if isNetJSON(data) {
+		// olsrd's netjson plugin and olsrd2's netjsoninfo
+		return ParseNetJSONToMeshviewer(data)
+	}
+	var info olsrJSONInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	topology, links := info.Topology, info.Links
+	for _, d := range info.Data {
+		topology = append(topology, d.Topology...)
+		links = append(links, d.Links...)
+	}
+	if len(topology) == 0 && len(links) == 0 {
+		return nil, fmt.Errorf("no olsr topology or links")
+	}
+
+	b := newTopologyBuilder()
+	for _, t := range topology {
+		b.link(t.LastHopIP, t.DestinationIP,
+			ifaceToFloat(t.LinkQuality), ifaceToFloat(t.NeighborLinkQuality), "olsr")
+	}
+	for _, l := range links {
+		local := l.LocalIP
+		if info.Config.MainIP != "" {
+			local = info.Config.MainIP
+		}
+		b.link(local, l.RemoteIP,
+			ifaceToFloat(l.LinkQuality), ifaceToFloat(l.NeighborLinkQuality), "olsr")
+	}
+	return b.result(), nil
+}
+
+// --- NetJSON NetworkGraph ---
+
+type netJSONGraph struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Metric   string `json:"metric"`
+	RouterID string `json:"router_id"`
+	Nodes    []struct {
+		ID         string                 `json:"id"`
+		Label      string                 `json:"label"`
+		Properties map[string]interface{} `json:"properties"`
+	} `json:"nodes"`
+	Links []struct {
+		Source string      `json:"source"`
+		Target string      `json:"target"`
+		Cost   interface{} `json:"cost"`
+	} `json:"links"`
+	// NetworkCollection, e.g. one graph per routing domain
+	Collection []netJSONGraph `json:"collection"`
+}
+
+// isNetJSON reports whether data is a NetJSON NetworkGraph or
+// NetworkCollection rather than olsrd jsoninfo.
+func isNetJSON(data []byte) bool {
+	var probe struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(data, &probe)
+	return probe.Type == "NetworkGraph" || probe.Type == "NetworkCollection"
+}
+
+// ParseNetJSONToMeshviewer converts a NetJSON NetworkGraph (or a
+// NetworkCollection of them) into MeshviewerData. Nodes are keyed by their
+// NetJSON id; link costs are mapped onto TQ according to the graph's metric.
+func ParseNetJSONToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	var g netJSONGraph
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	graphs := []netJSONGraph{g}
+	if g.Type == "NetworkCollection" {
+		graphs = g.Collection
+	}
+
+	b := newTopologyBuilder()
+	for _, g := range graphs {
+		linkType := strings.ToLower(g.Protocol)
+		if linkType == "" {
+			linkType = "other"
+		}
+		for _, n := range g.Nodes {
+			name := n.Label
+			if name == "" {
+				name = ifaceToString(n.Properties["hostname"])
+			}
+			b.node(n.ID, name)
+		}
+		// Links are usually listed once per direction; a link without its
+		// reverse counts for both.
+		cost := make(map[string]float64, len(g.Links))
+		for _, l := range g.Links {
+			cost[l.Source+">"+l.Target] = ifaceToFloat(l.Cost)
+		}
+		for _, l := range g.Links {
+			fwd := cost[l.Source+">"+l.Target]
+			rev, ok := cost[l.Target+">"+l.Source]
+			if !ok {
+				rev = fwd
+			}
+			b.link(l.Source, l.Target, netJSONCostToTQ(g.Metric, fwd), netJSONCostToTQ(g.Metric, rev), linkType)
+		}
+	}
+	if len(b.order) == 0 {
+		return nil, fmt.Errorf("no netjson nodes")
+	}
+	return b.result(), nil
+}
+
+// netJSONCostToTQ maps a link cost onto 0..1. ETX costs start at 1 for a
+// perfect link; olsrd2's DAT metric scales the same way by 1024.
+func netJSONCostToTQ(metric string, cost float64) float64 {
+	if cost <= 0 {
+		return 0
+	}
+	switch strings.ToLower(metric) {
+	case "ff_dat_metric", "dat":
+		return clampTQ(1024 / cost)
+	case "tq":
+		return clampTQ(cost / 255)
+	default:
+		return clampTQ(1 / cost)
+	}
+}
+
+// --- bmx7 json ---
+
+type bmx7Report struct {
+	Self struct {
+		ShortID   string `json:"shortId"`
+		Name      string `json:"name"`
+		PrimaryIP string `json:"primaryIp"`
+	} `json:"self"`
+	Status struct {
+		ShortID string `json:"shortId"`
+		Name    string `json:"name"`
+	} `json:"status"`
+	Links []struct {
+		ShortID string      `json:"shortId"`
+		Name    string      `json:"name"`
+		RxRate  interface{} `json:"rxRate"`
+		TxRate  interface{} `json:"txRate"`
+	} `json:"links"`
+	Originators []struct {
+		ShortID string `json:"shortId"`
+		Name    string `json:"name"`
+	} `json:"originators"`
+}
+
+// ParseBMX7ToMeshviewer converts bmx7 JSON (status/links/originators of one
+// router, or an array of such reports collected by a bridge).
+// Link rates are percentages (0–100) and map onto TQ.
+func ParseBMX7ToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	var reports []bmx7Report
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &reports); err != nil {
+			return nil, err
+		}
+	} else {
+		var r bmx7Report
+		if err := json.Unmarshal(trimmed, &r); err != nil {
+			return nil, err
+		}
+		reports = []bmx7Report{r}
+	}
+
+	b := newTopologyBuilder()
+	for _, r := range reports {
+		self, name := r.Self.ShortID, r.Self.Name
+		if self == "" {
+			self, name = r.Status.ShortID, r.Status.Name
+		}
+		b.node(self, name)
+		for _, o := range r.Originators {
+			b.node(o.ShortID, o.Name)
+		}
+		for _, l := range r.Links {
+			b.node(l.ShortID, l.Name)
+			b.link(self, l.ShortID, ifaceToFloat(l.TxRate)/100, ifaceToFloat(l.RxRate)/100, "bmx7")
+		}
+	}
+	if len(b.order) == 0 {
+		return nil, fmt.Errorf("no bmx7 nodes")
+	}
+	return b.result(), nil
+}
+
+// --- babeld dump ---
+
+// ParseBabelToMeshviewer converts the text output of babeld's local
+// interface ("dump"), as served over HTTP by a bridge. Multiple routers'
+// dumps may be concatenated, each starting with its "my-id" line.
+// Neighbour router IDs are learned from routes with refmetric 0 (routes
+// originated by the adjacent router); link quality is derived from cost.
+func ParseBabelToMeshviewer(data []byte) (*store.MeshviewerData, error) {
+	b := newTopologyBuilder()
+
+	type neighbour struct {
+		rxcost, txcost float64
+	}
+	var self string
+	neighbours := make(map[string]neighbour) // address -> costs
+	addrToID := make(map[string]string)
+
+	flush := func() {
+		for addr, nb := range neighbours {
+			if id, ok := addrToID[addr]; ok {
+				b.link(self, id, babelCostToTQ(nb.txcost), babelCostToTQ(nb.rxcost), "babel")
+			}
+		}
+		neighbours = make(map[string]neighbour)
+		addrToID = make(map[string]string)
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if fields[0] == "my-id" {
+			flush()
+			self = fields[1]
+			b.node(self, "")
+			continue
+		}
+		if fields[0] != "add" || len(fields) < 3 {
+			continue
+		}
+		kv := make(map[string]string)
+		for i := 3; i+1 < len(fields); i += 2 {
+			kv[fields[i]] = fields[i+1]
+		}
+		switch fields[1] {
+		case "neighbour":
+			rx, _ := strconv.ParseFloat(kv["rxcost"], 64)
+			tx, _ := strconv.ParseFloat(kv["txcost"], 64)
+			neighbours[kv["address"]] = neighbour{rxcost: rx, txcost: tx}
+		case "route":
+			if kv["id"] != "" {
+				b.node(kv["id"], "")
+			}
+			if kv["refmetric"] == "0" && kv["via"] != "" {
+				addrToID[kv["via"]] = kv["id"]
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if self == "" {
+		return nil, fmt.Errorf("no babel my-id line")
+	}
+	flush()
+	return b.result(), nil
+}
+
+// babelCostToTQ maps a babel link cost onto 0..1. A cost of 256 is a
+// perfect wireless link (ETX 1); 96 is the default wired cost.
+func babelCostToTQ(cost float64) float64 {
+	if cost <= 0 || cost >= 0xFFFF {
+		return 0
+	}
+	return clampTQ(256 / cost)
+}
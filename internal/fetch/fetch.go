@@ -0,0 +1,292 @@
+// Package fetch performs upstream HTTP requests for data sources.
+package fetch
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// acceptEncoding lists the codings decompress handles.
+const acceptEncoding = "zstd, gzip, deflate"
+
+// zstdMaxWindow is the largest zstd window accepted, the 8 MB RFC 9659
+// expects HTTP senders to keep to; larger ones would let a small body
+// claim that much memory.
+const zstdMaxWindow = 8 << 20
+
+// Client performs upstream requests with retries.
+type Client struct {
+	HTTP    *http.Client
+	Retries int           // additional attempts after a failure
+	Backoff time.Duration // delay before the first retry, doubled each time
+
+	// headers holds the upstreamHeaders rules, shortest prefix first so
+	// more specific rules override broader ones.
+	headers []headerRule
+}
+
+// headerRule is an upstreamHeaders rule with its parsed prefix.
+type headerRule struct {
+	prefix *url.URL
+	config.UpstreamHeaders
+}
+
+// New creates a Client from the fetch settings in cfg.
+func New(cfg *config.Config) *Client {
+	var headers []headerRule
+	for _, h := range cfg.UpstreamHeaders {
+		// Load rejects prefixes that do not parse.
+		if u, err := url.Parse(h.URLPrefix); err == nil {
+			headers = append(headers, headerRule{u, h})
+		}
+	}
+	sort.SliceStable(headers, func(i, j int) bool {
+		return len(headers[i].URLPrefix) < len(headers[j].URLPrefix)
+	})
+	c := &Client{
+		HTTP:    HTTPClient(cfg, cfg.FetchTimeoutDuration),
+		Retries: cfg.FetchRetries,
+		Backoff: cfg.FetchRetryBackoffDuration,
+		headers: headers,
+	}
+	c.HTTP.CheckRedirect = c.checkRedirect
+	return c
+}
+
+// checkRedirect keeps the headers of upstreamHeaders rules to the URLs
+// they are configured for. The standard client copies every header to the
+// redirect target and only drops Authorization and cookies when the host
+// changes, so an API key set for one source would reach any host it
+// redirects to.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	for _, h := range c.headers {
+		if matchPrefix(h.prefix, req.URL) {
+			continue
+		}
+		for k := range h.Headers {
+			req.Header.Del(k)
+		}
+		if h.Username != "" || h.Password != "" {
+			req.Header.Del("Authorization")
+		}
+	}
+	// Rules of the target that the original URL did not match.
+	c.applyHeaders(req)
+	return nil
+}
+
+// applyHeaders sets the headers and credentials of every rule whose
+// prefix matches the request URL.
+func (c *Client) applyHeaders(req *http.Request) {
+	for _, h := range c.headers {
+		if !matchPrefix(h.prefix, req.URL) {
+			continue
+		}
+		for k, v := range h.Headers {
+			req.Header.Set(k, v)
+		}
+		if h.Username != "" || h.Password != "" {
+			req.SetBasicAuth(h.Username, h.Password)
+		}
+	}
+}
+
+// matchPrefix reports whether u is below prefix: same scheme and host,
+// including the port, and a path equal to the prefix path or continuing it
+// after a "/". A plain string prefix would let https://example.org match
+// https://example.org.evil.net or /api match /api-internal.
+func matchPrefix(prefix, u *url.URL) bool {
+	if !strings.EqualFold(prefix.Scheme, u.Scheme) || hostPort(prefix) != hostPort(u) {
+		return false
+	}
+	p := strings.TrimSuffix(prefix.Path, "/")
+	return p == "" || u.Path == p || strings.HasPrefix(u.Path, p+"/")
+}
+
+// hostPort returns the lowercased host of u with an explicit port, so
+// https://example.org and https://example.org:443 compare equal.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// Do sends req asking for a compressed response and returns the response
+// with a transparently decompressed Body. Go's transport only negotiates
+// gzip on its own when no custom transport or Accept-Encoding is in play,
+// so the header is set explicitly.
+//
+// Network errors, 5xx and 429 responses are retried with exponential
+// backoff and jitter; the last response is returned as is. Callers should
+// still bound what they read: size limits apply to the decompressed stream.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	c.applyHeaders(req)
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.HTTP.Do(req.Clone(req.Context()))
+		if attempt < c.Retries && retryable(resp, err) {
+			if resp != nil {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+				resp.Body.Close()
+			}
+			if !sleep(req.Context(), c.backoff(attempt)) {
+				return nil, req.Context().Err()
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := decompress(resp); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// Get is Do for a plain GET request.
+func (c *Client) Get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// maxBackoff caps the delay between two attempts.
+const maxBackoff = 5 * time.Minute
+
+// backoff returns Backoff·2^attempt, at most maxBackoff, randomized by
+// ±50% so sources failing together do not retry in lockstep.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.Backoff
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func decompress(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotModified || resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return nil
+	}
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		// HTTP "deflate" is the zlib format.
+		r, err = zlib.NewReader(resp.Body)
+	case "zstd":
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(resp.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err == nil {
+			r = d.IOReadCloser()
+		}
+	default:
+		return fmt.Errorf("unsupported Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if err == io.EOF {
+		// Empty body, e.g. on error responses.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("decoding %s response: %w", resp.Header.Get("Content-Encoding"), err)
+	}
+	resp.Body = &decodedBody{ReadCloser: r, raw: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decodedBody closes both the decoder and the underlying connection body.
+type decodedBody struct {
+	io.ReadCloser
+	raw io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.raw.Close()
+}
+
+// LimitReader reads at most limit bytes from r. Unlike io.LimitReader it
+// fails instead of reporting a clean EOF when r holds more data, so an
+// oversized body is not mistaken for a truncated document.
+func LimitReader(r io.Reader, limit int64) io.Reader {
+	return &limitedReader{r: r, left: limit, limit: limit}
+}
+
+type limitedReader struct {
+	r     io.Reader
+	left  int64
+	limit int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		var b [1]byte
+		if n, _ := io.ReadFull(l.r, b[:]); n > 0 {
+			return 0, fmt.Errorf("response exceeds the %d MB size limit", l.limit>>20)
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,76 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+func TestDecompress(t *testing.T) {
+	const doc = `{"nodes":[],"links":[]}`
+	encode := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			enc, _ := zstd.NewWriter(w)
+			return enc
+		},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		coding := strings.TrimPrefix(r.URL.Path, "/")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), coding) {
+			t.Errorf("Accept-Encoding %q lacks %s", r.Header.Get("Accept-Encoding"), coding)
+		}
+		w.Header().Set("Content-Encoding", coding)
+		enc := encode[coding](w)
+		io.WriteString(enc, doc)
+		enc.Close()
+	}))
+	defer srv.Close()
+
+	c := New(&config.Config{})
+	for coding := range encode {
+		resp, err := c.Get(srv.URL + "/" + coding)
+		if err != nil {
+			t.Fatalf("%s: %v", coding, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != doc {
+			t.Errorf("%s: body %q, %v", coding, body, err)
+		}
+		if resp.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: Content-Encoding left set", coding)
+		}
+	}
+}
+
+func TestDecompressZstdWindow(t *testing.T) {
+	var buf bytes.Buffer
+	enc, _ := zstd.NewWriter(&buf, zstd.WithWindowSize(32<<20), zstd.WithSingleSegment(false))
+	enc.Write(bytes.Repeat([]byte("freifunk "), 8<<20))
+	enc.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Write(buf.Bytes())
+	}))
+	defer srv.Close()
+
+	resp, err := New(&config.Config{}).Get(srv.URL)
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("zstd frame with a 32 MB window was decoded")
+	}
+}
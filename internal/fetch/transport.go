@@ -0,0 +1,26 @@
+package fetch
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// Transport returns an HTTP transport for outbound requests. It uses the
+// configured proxy (http, https or socks5) or, when none is set, the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func Transport(cfg *config.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	if cfg.ProxyURL != nil {
+		t.Proxy = http.ProxyURL(cfg.ProxyURL)
+	}
+	return t
+}
+
+// HTTPClient returns a client using Transport with the given timeout, for
+// outbound requests that do not need retries, such as probes and webhooks.
+func HTTPClient(cfg *config.Config, timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport(cfg)}
+}
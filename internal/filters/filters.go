@@ -0,0 +1,202 @@
+// Package filters stores named node filter definitions that can be shared
+// via permalink and evaluated server-side.
+package filters
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/jsonfile"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// Badges understood by Matches.
+var knownBadges = map[string]bool{
+	"online":               true,
+	"offline":              true,
+	"gateway":              true,
+	"new":                  true,
+	"no_location":          true,
+	"duplicate_hostname":   true,
+	"autoupdater_disabled": true,
+}
+
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Filter is a saved node filter. All set criteria must match.
+type Filter struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Community   string   `json:"community,omitempty"`
+	Firmware    string   `json:"firmware,omitempty"` // prefix of release or Gluon base
+	Models      []string `json:"models,omitempty"`
+	Badges      []string `json:"badges,omitempty"`
+	// BBox is south, west, north, east in degrees.
+	BBox      *[4]float64 `json:"bbox,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+// Validate checks the definition and derives an ID from the name if unset.
+func (f *Filter) Validate() error {
+	f.Name = strings.TrimSpace(f.Name)
+	if f.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if f.ID == "" {
+		f.ID = slugify(f.Name)
+	}
+	if !idPattern.MatchString(f.ID) {
+		return fmt.Errorf("id must be 1-64 lowercase letters, digits, '-' or '_'")
+	}
+	for _, b := range f.Badges {
+		if !knownBadges[b] {
+			return fmt.Errorf("unknown badge %q", b)
+		}
+	}
+	if f.BBox != nil {
+		b := f.BBox
+		if b[0] > b[2] || b[1] > b[3] || b[0] < -90 || b[2] > 90 || b[1] < -180 || b[3] > 180 {
+			return fmt.Errorf("bbox must be [south, west, north, east]")
+		}
+	}
+	return nil
+}
+
+func slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	out := strings.TrimSuffix(b.String(), "-")
+	if len(out) > 64 {
+		out = strings.TrimSuffix(out[:64], "-")
+	}
+	return out
+}
+
+// Matches reports whether a node satisfies every criterion of the filter.
+func (f *Filter) Matches(n *store.Node) bool {
+	if n.Placeholder {
+		return false
+	}
+	if f.Domain != "" && n.Domain != f.Domain {
+		return false
+	}
+	if f.Community != "" && n.Community != f.Community && !contains(n.Communities, f.Community) {
+		return false
+	}
+	if f.Firmware != "" && !strings.HasPrefix(n.Firmware, f.Firmware) && !strings.HasPrefix(n.FWBase, f.Firmware) {
+		return false
+	}
+	if len(f.Models) > 0 && !contains(f.Models, n.Model) {
+		return false
+	}
+	for _, b := range f.Badges {
+		if !hasBadge(n, b) {
+			return false
+		}
+	}
+	if f.BBox != nil {
+		if n.Lat == nil || *n.Lat < f.BBox[0] || *n.Lat > f.BBox[2] || *n.Lng < f.BBox[1] || *n.Lng > f.BBox[3] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasBadge(n *store.Node, badge string) bool {
+	switch badge {
+	case "online":
+		return n.IsOnline
+	case "offline":
+		return !n.IsOnline
+	case "gateway":
+		return n.IsGateway
+	case "new":
+		t, err := time.Parse(time.RFC3339, n.Firstseen)
+		return err == nil && time.Since(t) < 7*24*time.Hour
+	case "no_location":
+		return n.Lat == nil
+	case "duplicate_hostname":
+		return n.DuplicateHostname
+	case "autoupdater_disabled":
+		return !n.Autoupdater
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the nodes of a snapshot matching the filter.
+func (f *Filter) Apply(snap *store.Snapshot) []*store.Node {
+	out := make([]*store.Node, 0)
+	for _, n := range snap.NodeList {
+		if f.Matches(n) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// Store keeps filters in memory and persists them to a JSON file.
+type Store struct {
+	filters *jsonfile.Map[Filter]
+}
+
+// Open loads filters from path. A missing file yields an empty store.
+func Open(path string) *Store {
+	return &Store{filters: jsonfile.Open(path, "Filters", func(f Filter) string { return f.ID })}
+}
+
+// List returns all filters sorted by name.
+func (fs *Store) List() []Filter {
+	out := fs.filters.Values()
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (fs *Store) Get(id string) (Filter, bool) {
+	return fs.filters.Get(id)
+}
+
+// Put validates and stores a filter, replacing any filter with the same ID.
+// If saving fails, the previous filter is restored.
+func (fs *Store) Put(f Filter) (Filter, error) {
+	if err := f.Validate(); err != nil {
+		return f, err
+	}
+	return fs.filters.Put(f.ID, func(old Filter, existed bool) Filter {
+		now := time.Now().UTC()
+		f.CreatedAt = now
+		if existed {
+			f.CreatedAt = old.CreatedAt
+		}
+		f.UpdatedAt = now
+		return f
+	})
+}
+
+// Delete removes a filter and reports whether it existed.
+func (fs *Store) Delete(id string) (bool, error) {
+	return fs.filters.Delete(id)
+}
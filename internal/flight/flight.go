@@ -0,0 +1,46 @@
+// Package flight deduplicates concurrent calls doing the same work, in the
+// manner of golang.org/x/sync/singleflight.
+package flight
+
+import "sync"
+
+// Group runs at most one call per key at a time. Callers arriving while a
+// call is in flight wait for it and receive its result.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Do runs fn for key unless a call for key is already running, in which
+// case it waits for that call. shared reports whether the result came
+// from another caller's call.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+	c := &call{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		c.wg.Done()
+	}()
+	c.val, c.err = fn()
+	return c.val, c.err, false
+}
@@ -0,0 +1,106 @@
+// Package hidden keeps the nodes whose owners asked to be removed from the
+// map. Entries are node IDs or MACs; hidden nodes are left out while the
+// data is processed, so no API, export or cache ever sees them.
+package hidden
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/jsonfile"
+)
+
+// Entry is one hidden node ID or MAC.
+type Entry struct {
+	ID      string    `json:"id"`
+	Reason  string    `json:"reason,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Validate normalizes the entry and checks it is usable.
+func (e *Entry) Validate() error {
+	e.ID = Normalize(e.ID)
+	e.Reason = strings.TrimSpace(e.Reason)
+	if e.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	if len(e.ID) > 128 {
+		return fmt.Errorf("id must be at most 128 characters")
+	}
+	if len(e.Reason) > 500 {
+		return fmt.Errorf("reason must be at most 500 characters")
+	}
+	return nil
+}
+
+// Normalize lowercases id and writes MACs the way Gluon derives node IDs,
+// as twelve hex digits without separators, so a MAC entry also matches
+// the node ID and the other way round.
+func Normalize(id string) string {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if hw, err := net.ParseMAC(id); err == nil && len(hw) == 6 {
+		return hex.EncodeToString(hw)
+	}
+	return id
+}
+
+// List keeps hidden nodes added through the admin API and persists them to
+// a JSON file.
+type List struct {
+	entries *jsonfile.Map[Entry]
+}
+
+// Open loads the list from path. A missing file yields an empty list.
+func Open(path string) *List {
+	return &List{entries: jsonfile.Open(path, "Hidden nodes", func(e Entry) string { return Normalize(e.ID) })}
+}
+
+// List returns the stored entries sorted by ID.
+func (l *List) List() []Entry {
+	out := l.entries.Values()
+	for i := range out {
+		out[i].ID = Normalize(out[i].ID)
+	}
+	return out
+}
+
+// Add validates and stores an entry. Adding an existing ID updates its
+// reason. If saving fails, the previous entry is kept.
+func (l *List) Add(e Entry) (Entry, error) {
+	if err := e.Validate(); err != nil {
+		return e, err
+	}
+	return l.entries.Put(e.ID, func(old Entry, existed bool) Entry {
+		e.AddedAt = time.Now().UTC()
+		if existed {
+			e.AddedAt = old.AddedAt
+		}
+		return e
+	})
+}
+
+// Remove unhides a node and reports whether it was listed.
+func (l *List) Remove(id string) (bool, error) {
+	return l.entries.Delete(Normalize(id))
+}
+
+// Set returns the normalized IDs of the stored entries and of configured,
+// the hiddenNodes setting. l may be nil.
+func (l *List) Set(configured []string) map[string]bool {
+	set := make(map[string]bool, len(configured))
+	for _, id := range configured {
+		if id = Normalize(id); id != "" {
+			set[id] = true
+		}
+	}
+	if l == nil {
+		return set
+	}
+	for _, e := range l.entries.Values() {
+		set[Normalize(e.ID)] = true
+	}
+	return set
+}
@@ -0,0 +1,124 @@
+// Package history keeps a local record of network-wide totals, so client
+// history charts work without an InfluxDB behind the map.
+package history
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/timeseries"
+)
+
+const (
+	// resolution is the spacing of recorded points; snapshots within one
+	// step replace each other.
+	resolution = 5 * time.Minute
+	// retention covers the longest chart duration, 30 days.
+	retention = 31 * 24 * time.Hour
+)
+
+// point is stored as [unix seconds, online nodes, clients] to keep the
+// file small: a month is about 9000 points.
+type point [3]int64
+
+// Recorder observes snapshots and persists the network totals.
+type Recorder struct {
+	path   string
+	mu     sync.Mutex
+	points []point
+}
+
+// Open loads the history from path. A missing file starts empty.
+func Open(path string) *Recorder {
+	r := &Recorder{path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("History: read error: %v", err)
+		}
+		return r
+	}
+	if err := json.Unmarshal(data, &r.points); err != nil {
+		log.Printf("History: corrupt %s, ignoring (%v)", path, err)
+		r.points = nil
+	}
+	return r
+}
+
+// Observe records the online nodes and their clients of a snapshot. The
+// file is written whenever a new step begins.
+func (r *Recorder) Observe(snap *store.Snapshot) {
+	var online, clients int64
+	for _, n := range snap.NodeList {
+		if n.Placeholder || !n.IsOnline {
+			continue
+		}
+		online++
+		clients += int64(n.Clients)
+	}
+	at := time.Now().Truncate(resolution).Unix()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n := len(r.points); n > 0 && r.points[n-1][0] == at {
+		r.points[n-1] = point{at, online, clients}
+		return
+	}
+	r.points = append(r.points, point{at, online, clients})
+	cutoff := time.Now().Add(-retention).Unix()
+	if i := sort.Search(len(r.points), func(i int) bool { return r.points[i][0] >= cutoff }); i > 0 {
+		r.points = append([]point(nil), r.points[i:]...)
+	}
+	r.saveLocked()
+}
+
+func (r *Recorder) saveLocked() {
+	data, err := json.Marshal(r.points)
+	if err != nil {
+		log.Printf("History: save error: %v", err)
+		return
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("History: write error: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		log.Printf("History: rename error: %v", err)
+	}
+}
+
+// Series returns metric ("clients" or "nodes") over the last d, averaged
+// per step. Steps without recorded points are left out.
+func (r *Recorder) Series(metric string, d, step time.Duration) timeseries.Series {
+	col := 2
+	if metric == "nodes" {
+		col = 1
+	}
+	s := timeseries.Series{Name: metric}
+	if step < resolution {
+		step = resolution
+	}
+	since := time.Now().Add(-d).Unix()
+	stepSec := int64(step / time.Second)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i][0] >= since })
+	for i < len(r.points) {
+		bucket := r.points[i][0] - r.points[i][0]%stepSec
+		var sum, n int64
+		for ; i < len(r.points) && r.points[i][0] < bucket+stepSec; i++ {
+			sum += r.points[i][col]
+			n++
+		}
+		s.Times = append(s.Times, bucket)
+		s.Values = append(s.Values, float64(sum)/float64(n))
+	}
+	return s
+}
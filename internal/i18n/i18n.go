@@ -0,0 +1,110 @@
+// Package i18n serves the frontend's string catalogs. Built-in catalogs
+// are embedded; files named {lang}.json in a configured directory add
+// languages or override single strings and domain names.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//go:embed catalogs/*.json
+var builtin embed.FS
+
+// Fallback is the language whose strings fill gaps in other catalogs.
+const Fallback = "en"
+
+// Catalog holds UI strings and per-language domain display names.
+type Catalog struct {
+	Lang        string            `json:"lang"`
+	Strings     map[string]string `json:"strings"`
+	DomainNames map[string]string `json:"domainNames,omitempty"`
+}
+
+var langTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-zA-Z0-9]{2,8})?$`)
+
+// ValidLang reports whether lang looks like a language tag ("de", "pt-BR").
+// Only valid tags are turned into file names.
+func ValidLang(lang string) bool {
+	return langTag.MatchString(lang)
+}
+
+// Load builds the catalog for lang: the fallback strings, overlaid with
+// lang's built-in catalog and then dir's file, for the full tag and its
+// base language. Lang is the best match found, Fallback if none.
+func Load(dir, lang string) (*Catalog, error) {
+	if !ValidLang(lang) {
+		return nil, fmt.Errorf("invalid language %q", lang)
+	}
+	c := &Catalog{Lang: Fallback, Strings: map[string]string{}, DomainNames: map[string]string{}}
+	if _, err := c.overlay(dir, Fallback); err != nil {
+		return nil, err
+	}
+
+	candidates := []string{lang}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		candidates = []string{base, lang}
+	}
+	for _, l := range candidates {
+		if l == Fallback {
+			continue
+		}
+		found, err := c.overlay(dir, l)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			c.Lang = l
+		}
+	}
+	return c, nil
+}
+
+// overlay merges the built-in and directory catalogs for lang into c and
+// reports whether either exists.
+func (c *Catalog) overlay(dir, lang string) (bool, error) {
+	found := false
+	data, err := builtin.ReadFile("catalogs/" + lang + ".json")
+	if err == nil {
+		if err := c.merge(data); err != nil {
+			return false, fmt.Errorf("built-in catalog %s: %w", lang, err)
+		}
+		found = true
+	}
+	if dir == "" {
+		return found, nil
+	}
+	path := filepath.Join(dir, lang+".json")
+	data, err = os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return found, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := c.merge(data); err != nil {
+		return false, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return true, nil
+}
+
+func (c *Catalog) merge(data []byte) error {
+	var f Catalog
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	for k, v := range f.Strings {
+		c.Strings[k] = v
+	}
+	for k, v := range f.DomainNames {
+		c.DomainNames[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// Package jsonfile keeps small keyed record sets in memory and persists
+// them to a JSON file, for the stores edited through the admin API.
+package jsonfile
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Map holds records keyed by a string. Each change is written to disk
+// before it returns; if writing fails, the change is undone, so memory
+// always matches the file.
+type Map[T any] struct {
+	path  string
+	key   func(T) string
+	mu    sync.RWMutex
+	items map[string]T
+}
+
+// Open loads the records in path, a JSON array, keyed by key. A missing
+// file yields an empty map; unreadable or corrupt files are logged under
+// name and ignored.
+func Open[T any](path, name string, key func(T) string) *Map[T] {
+	m := &Map[T]{path: path, key: key, items: make(map[string]T)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("%s: read error: %v", name, err)
+		}
+		return m
+	}
+	var list []T
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("%s: corrupt %s, ignoring (%v)", name, path, err)
+		return m
+	}
+	for _, v := range list {
+		m.items[key(v)] = v
+	}
+	return m
+}
+
+// Get returns the record of k.
+func (m *Map[T]) Get(k string) (T, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.items[k]
+	return v, ok
+}
+
+// Values returns all records sorted by key.
+func (m *Map[T]) Values() []T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.valuesLocked()
+}
+
+// Put stores the record that update returns for k, given the current
+// record and whether there is one, and saves the file.
+func (m *Map[T]) Put(k string, update func(old T, ok bool) T) (T, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.items[k]
+	v := update(old, ok)
+	m.items[k] = v
+	if err := m.saveLocked(); err != nil {
+		if ok {
+			m.items[k] = old
+		} else {
+			delete(m.items, k)
+		}
+		return v, err
+	}
+	return v, nil
+}
+
+// Delete removes the record of k, saves the file and reports whether
+// there was one.
+func (m *Map[T]) Delete(k string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.items[k]
+	if !ok {
+		return false, nil
+	}
+	delete(m.items, k)
+	if err := m.saveLocked(); err != nil {
+		m.items[k] = old
+		return true, err
+	}
+	return true, nil
+}
+
+func (m *Map[T]) valuesLocked() []T {
+	keys := make([]string, 0, len(m.items))
+	for k := range m.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]T, len(keys))
+	for i, k := range keys {
+		out[i] = m.items[k]
+	}
+	return out
+}
+
+// saveLocked writes the file through a temporary one, so a crash never
+// leaves it half written.
+func (m *Map[T]) saveLocked() error {
+	data, err := json.MarshalIndent(m.valuesLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
@@ -0,0 +1,66 @@
+package jsonfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type record struct {
+	ID   string `json:"id"`
+	Note string `json:"note"`
+}
+
+func recordKey(r record) string { return r.ID }
+
+func TestPutDeleteReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.json")
+	m := Open(path, "Records", recordKey)
+	for _, id := range []string{"b", "a"} {
+		if _, err := m.Put(id, func(record, bool) record { return record{ID: id} }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, err := m.Delete("b"); !ok || err != nil {
+		t.Fatalf("Delete(b) = %v, %v", ok, err)
+	}
+	if ok, _ := m.Delete("b"); ok {
+		t.Error("Delete(b) found it twice")
+	}
+
+	got := Open(path, "Records", recordKey).Values()
+	if len(got) != 1 || got[0].ID != "a" {
+		t.Errorf("reloaded %v, want [a]", got)
+	}
+	if tmp, _ := filepath.Glob(path + ".tmp"); len(tmp) != 0 {
+		t.Errorf("temporary file left: %v", tmp)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "records.json")
+	m := Open(path, "Records", recordKey)
+	if _, err := m.Put("a", func(record, bool) record { return record{ID: "a", Note: "old"} }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving fails once the directory is gone.
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Put("a", func(record, bool) record { return record{ID: "a", Note: "new"} }); err == nil {
+		t.Fatal("Put succeeded without a directory")
+	}
+	if _, err := m.Put("b", func(record, bool) record { return record{ID: "b"} }); err == nil {
+		t.Fatal("Put succeeded without a directory")
+	}
+	if _, err := m.Delete("a"); err == nil {
+		t.Fatal("Delete succeeded without a directory")
+	}
+
+	got := m.Values()
+	if len(got) != 1 || got[0] != (record{ID: "a", Note: "old"}) {
+		t.Errorf("after failed saves: %v, want [{a old}]", got)
+	}
+}
@@ -0,0 +1,149 @@
+// Package metrics writes the Prometheus text exposition format and keeps
+// the histograms the service records about itself. Everything else on
+// /metrics is derived from the current snapshot when it is scraped.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are upper bounds in seconds for request and fetch
+// durations.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Writer emits metric families in the text format, version 0.0.4.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// ContentType is the media type of the text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// NewWriter returns a Writer on w; call Flush when done.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Flush writes buffered output.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Family starts a metric family; typ is "gauge", "counter" or "histogram".
+func (w *Writer) Family(name, typ, help string) {
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample writes one sample. labels alternate between names and values.
+func (w *Writer) Sample(name string, v float64, labels ...string) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[i])
+			w.w.WriteString(`="`)
+			w.w.WriteString(escape(labels[i+1]))
+			w.w.WriteByte('"')
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatFloat(v))
+	w.w.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Histogram counts observations in cumulative buckets, per combination of
+// label values.
+type Histogram struct {
+	buckets []float64
+	labels  []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	counts []uint64 // per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds and
+// label names.
+func NewHistogram(buckets []float64, labels ...string) *Histogram {
+	return &Histogram{buckets: buckets, labels: labels, series: make(map[string]*series)}
+}
+
+// Observe records v for the given label values, in the order of the
+// label names.
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[key]
+	if s == nil {
+		s = &series{values: values, counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// Write emits the histogram as the family name.
+func (h *Histogram) Write(w *Writer, name, help string) {
+	w.Family(name, "histogram", help)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		labels := make([]string, 0, 2*len(h.labels)+2)
+		for i, l := range h.labels {
+			labels = append(labels, l, s.values[i])
+		}
+		var cum uint64
+		for i, n := range s.counts {
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			cum += n
+			w.Sample(name+"_bucket", float64(cum), append(labels, "le", formatFloat(le))...)
+		}
+		w.Sample(name+"_sum", s.sum, labels...)
+		w.Sample(name+"_count", float64(s.count), labels...)
+	}
+}
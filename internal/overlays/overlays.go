@@ -0,0 +1,178 @@
+// Package overlays loads the GeoJSON overlay layers from the config,
+// validates them and keeps the last good copy of each for serving.
+package overlays
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+)
+
+// refreshEvery is how often overlays are loaded again.
+const refreshEvery = time.Hour
+
+// geoJSONTypes are the valid top-level GeoJSON object types.
+var geoJSONTypes = map[string]bool{
+	"FeatureCollection": true, "Feature": true, "GeometryCollection": true,
+	"Point": true, "MultiPoint": true, "LineString": true, "MultiLineString": true,
+	"Polygon": true, "MultiPolygon": true,
+}
+
+// Status describes one overlay for the listing endpoint.
+type Status struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Color     string     `json:"color,omitempty"`
+	Features  int        `json:"features"`
+	Bytes     int        `json:"bytes"`
+	LoadedAt  *time.Time `json:"loaded_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+type overlay struct {
+	cfg    config.Overlay
+	data   []byte
+	status Status
+}
+
+// Set holds the configured overlays.
+type Set struct {
+	client   *fetch.Client
+	maxBytes int64
+	mu       sync.RWMutex
+	items    []*overlay
+}
+
+// Open loads the file overlays; URL overlays are fetched by Run, so a slow
+// host does not delay startup. Failures are logged and kept in the
+// status; the overlay is served as soon as a later load succeeds.
+func Open(cfg *config.Config) *Set {
+	s := &Set{client: fetch.New(cfg), maxBytes: cfg.MaxDataBytes}
+	for _, o := range cfg.Overlays {
+		s.items = append(s.items, &overlay{
+			cfg:    o,
+			status: Status{ID: o.ID, Name: o.Name, Color: o.Color},
+		})
+	}
+	s.loadAll(func(o config.Overlay) bool { return o.File != "" })
+	return s
+}
+
+// Run fetches the URL overlays, then reloads all overlays periodically
+// until ctx is done.
+func (s *Set) Run(ctx context.Context) {
+	s.loadAll(func(o config.Overlay) bool { return o.URL != "" })
+	ticker := time.NewTicker(refreshEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.loadAll(func(config.Overlay) bool { return true })
+		}
+	}
+}
+
+func (s *Set) loadAll(match func(config.Overlay) bool) {
+	for _, o := range s.items {
+		if !match(o.cfg) {
+			continue
+		}
+		data, features, err := s.load(o.cfg)
+		s.mu.Lock()
+		if err != nil {
+			o.status.LastError = err.Error()
+			log.Printf("Overlay %s: %v", o.cfg.Name, err)
+		} else {
+			o.data = data
+			o.status.Features = features
+			o.status.Bytes = len(data)
+			now := time.Now()
+			o.status.LoadedAt = &now
+			o.status.LastError = ""
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Set) load(o config.Overlay) ([]byte, int, error) {
+	var data []byte
+	var err error
+	if o.File != "" {
+		data, err = os.ReadFile(o.File)
+	} else {
+		data, err = s.fetch(o.URL)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	features, err := validate(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, features, nil
+}
+
+func (s *Set) fetch(url string) ([]byte, error) {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(fetch.LimitReader(resp.Body, s.maxBytes))
+}
+
+// validate checks that data is a GeoJSON object and returns its number of
+// features (1 for a single Feature or geometry).
+func validate(data []byte) (int, error) {
+	var obj struct {
+		Type     string            `json:"type"`
+		Features []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return 0, fmt.Errorf("parsing GeoJSON: %w", err)
+	}
+	if !geoJSONTypes[obj.Type] {
+		return 0, fmt.Errorf("not a GeoJSON object (type %q)", obj.Type)
+	}
+	if obj.Type == "FeatureCollection" {
+		return len(obj.Features), nil
+	}
+	return 1, nil
+}
+
+// Statuses lists every overlay in config order.
+func (s *Set) Statuses() []Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Status, len(s.items))
+	for i, o := range s.items {
+		out[i] = o.status
+	}
+	return out
+}
+
+// Data returns the GeoJSON of the overlay with the given ID, or nil if it
+// does not exist or has not loaded yet.
+func (s *Set) Data(id string) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, o := range s.items {
+		if o.cfg.ID == id {
+			return o.data
+		}
+	}
+	return nil
+}
@@ -0,0 +1,483 @@
+// Package respondd queries Gluon nodes directly over the respondd protocol,
+// so a small community can run the map without yanic or hopglass-server.
+// Nodes are asked by multicast on a mesh interface or by unicast; their
+// answers are remembered for a week so nodes that stop answering are shown
+// offline instead of disappearing.
+package respondd
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGroup is the multicast group Gluon nodes listen on.
+	DefaultGroup = "ff05::2:1001"
+	// DefaultPort is the respondd UDP port.
+	DefaultPort = 1001
+	// defaultWait is how long replies are collected after asking.
+	defaultWait = 3 * time.Second
+	// pruneAfter drops nodes that have not answered for this long.
+	pruneAfter = 7 * 24 * time.Hour
+	// maxResponseBytes bounds one inflated reply.
+	maxResponseBytes = 1 << 20
+
+	request = "GET nodeinfo statistics neighbours"
+)
+
+// Target describes whom to ask: a multicast group on each of Interfaces
+// and every address of Unicast.
+type Target struct {
+	Interfaces []string
+	Group      net.IP
+	Unicast    []*net.UDPAddr
+	Port       int
+	Wait       time.Duration
+}
+
+// ParseURL reads a respondd:// source URL. The host part is a comma
+// separated list of mesh interfaces to ask by multicast and node addresses
+// to ask by unicast ("[fd00::1]", "[fd00::1]:1001", "10.0.0.1"), e.g.
+// "respondd://bat0" or "respondd://[fd00::1],[fd00::2]". The query may set
+// group, port and wait (a duration).
+func ParseURL(raw string) (*Target, error) {
+	rest, ok := strings.CutPrefix(raw, "respondd://")
+	if !ok {
+		return nil, fmt.Errorf("%q is not a respondd:// URL", raw)
+	}
+	hosts, query, _ := strings.Cut(rest, "?")
+	hosts = strings.TrimSuffix(hosts, "/")
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("respondd URL %q: %w", raw, err)
+	}
+
+	t := &Target{Group: net.ParseIP(DefaultGroup), Port: DefaultPort, Wait: defaultWait}
+	if v := q.Get("group"); v != "" {
+		if t.Group = net.ParseIP(v); t.Group == nil || !t.Group.IsMulticast() {
+			return nil, fmt.Errorf("respondd URL %q: group %q is not a multicast address", raw, v)
+		}
+	}
+	if v := q.Get("port"); v != "" {
+		if t.Port, err = strconv.Atoi(v); err != nil || t.Port <= 0 || t.Port > 65535 {
+			return nil, fmt.Errorf("respondd URL %q: invalid port %q", raw, v)
+		}
+	}
+	if v := q.Get("wait"); v != "" {
+		if t.Wait, err = time.ParseDuration(v); err != nil || t.Wait <= 0 {
+			return nil, fmt.Errorf("respondd URL %q: invalid wait %q", raw, v)
+		}
+	}
+
+	for _, h := range strings.Split(hosts, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if addr, ok := parseAddr(h, t.Port); ok {
+			t.Unicast = append(t.Unicast, addr)
+		} else {
+			t.Interfaces = append(t.Interfaces, h)
+		}
+	}
+	if len(t.Interfaces) == 0 && len(t.Unicast) == 0 {
+		return nil, fmt.Errorf("respondd URL %q names no interface or address", raw)
+	}
+	return t, nil
+}
+
+// parseAddr reads a node address with optional port and zone; anything
+// else is taken for an interface name.
+func parseAddr(h string, port int) (*net.UDPAddr, bool) {
+	host, p := h, port
+	if hp, ps, err := net.SplitHostPort(h); err == nil {
+		n, err := strconv.Atoi(ps)
+		if err != nil {
+			return nil, false
+		}
+		host, p = hp, n
+	} else {
+		host = strings.Trim(h, "[]")
+	}
+	ip, zone, _ := strings.Cut(host, "%")
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return &net.UDPAddr{IP: parsed, Port: p, Zone: zone}, true
+	}
+	return nil, false
+}
+
+// Response is one decoded respondd reply. Gluon answers a combined
+// request with all three parts in one packet; older firmware may split
+// them, so parts are merged by node ID.
+type Response struct {
+	NodeInfo   *NodeInfo   `json:"nodeinfo"`
+	Statistics *Statistics `json:"statistics"`
+	Neighbours *Neighbours `json:"neighbours"`
+}
+
+type NodeInfo struct {
+	NodeID   string `json:"node_id"`
+	Hostname string `json:"hostname"`
+	// Wireless is sent by nodes with a respondd module for radio settings.
+	Wireless *struct {
+		Channel24 int `json:"channel24"`
+		TxPower24 int `json:"txpower24"`
+		Channel5  int `json:"channel5"`
+		TxPower5  int `json:"txpower5"`
+	} `json:"wireless"`
+	Network struct {
+		MAC            string   `json:"mac"`
+		Addresses      []string `json:"addresses"`
+		MeshInterfaces []string `json:"mesh_interfaces"` // before Gluon 2016.2
+		Mesh           map[string]struct {
+			Interfaces struct {
+				Wireless []string `json:"wireless"`
+				Tunnel   []string `json:"tunnel"`
+				Other    []string `json:"other"`
+			} `json:"interfaces"`
+		} `json:"mesh"`
+	} `json:"network"`
+	Owner *struct {
+		Contact string `json:"contact"`
+	} `json:"owner"`
+	System struct {
+		SiteCode   string `json:"site_code"`
+		DomainCode string `json:"domain_code"`
+		Role       string `json:"role"`
+	} `json:"system"`
+	Location *struct {
+		Latitude  float64  `json:"latitude"`
+		Longitude float64  `json:"longitude"`
+		Altitude  *float64 `json:"altitude"`
+	} `json:"location"`
+	Software struct {
+		Firmware struct {
+			Base      string `json:"base"`
+			Release   string `json:"release"`
+			Target    string `json:"target"`
+			Subtarget string `json:"subtarget"`
+			ImageName string `json:"image_name"`
+		} `json:"firmware"`
+		Autoupdater struct {
+			Enabled bool   `json:"enabled"`
+			Branch  string `json:"branch"`
+		} `json:"autoupdater"`
+	} `json:"software"`
+	Hardware struct {
+		Model string `json:"model"`
+		Nproc int    `json:"nproc"`
+	} `json:"hardware"`
+	VPN bool `json:"vpn"`
+}
+
+// InterfaceTypes maps every mesh interface MAC of the node to "wifi",
+// "vpn" or "other", the primary MAC included.
+func (ni *NodeInfo) InterfaceTypes() map[string]string {
+	types := make(map[string]string)
+	add := func(macs []string, typ string) {
+		for _, m := range macs {
+			types[strings.ToLower(m)] = typ
+		}
+	}
+	for _, mesh := range ni.Network.Mesh {
+		add(mesh.Interfaces.Wireless, "wifi")
+		add(mesh.Interfaces.Tunnel, "vpn")
+		add(mesh.Interfaces.Other, "other")
+	}
+	add(ni.Network.MeshInterfaces, "other")
+	if m := strings.ToLower(ni.Network.MAC); m != "" {
+		if _, ok := types[m]; !ok {
+			types[m] = "other"
+		}
+	}
+	return types
+}
+
+type Statistics struct {
+	NodeID  string `json:"node_id"`
+	Clients struct {
+		Total  int `json:"total"`
+		Wifi   int `json:"wifi"`
+		Wifi24 int `json:"wifi24"`
+		Wifi5  int `json:"wifi5"`
+	} `json:"clients"`
+	RootfsUsage float64 `json:"rootfs_usage"`
+	LoadAvg     float64 `json:"loadavg"`
+	Memory      struct {
+		Total     int64 `json:"total"`
+		Free      int64 `json:"free"`
+		Buffers   int64 `json:"buffers"`
+		Cached    int64 `json:"cached"`
+		Available int64 `json:"available"`
+	} `json:"memory"`
+	Uptime         float64             `json:"uptime"` // seconds
+	Gateway        string              `json:"gateway"`
+	Gateway6       string              `json:"gateway6"`
+	GatewayNexthop string              `json:"gateway_nexthop"`
+	Traffic        map[string]*Counter `json:"traffic"` // rx, tx, forward, mgmt_rx, mgmt_tx
+	Wireless       []*Airtime          `json:"wireless"`
+}
+
+// Counter is a traffic counter since boot.
+type Counter struct {
+	Bytes   int64 `json:"bytes"`
+	Packets int64 `json:"packets"`
+}
+
+// Airtime holds the survey counters of one radio, in milliseconds. The
+// utilisations are shares of the active time between two samples; yanic
+// keeps them in its state file under its Go field names.
+type Airtime struct {
+	Frequency int   `json:"frequency"`
+	Active    int64 `json:"active"`
+	Busy      int64 `json:"busy"`
+	Rx        int64 `json:"rx"`
+	Tx        int64 `json:"tx"`
+
+	ChanUtil float64 `json:"ChanUtil"`
+	RxUtil   float64 `json:"RxUtil"`
+	TxUtil   float64 `json:"TxUtil"`
+}
+
+// setUtilization computes the utilisations from the counters of the
+// previous sample of the same radio. Counters reset by a reboot are skipped.
+func (a *Airtime) setUtilization(prev *Airtime) {
+	active := a.Active - prev.Active
+	if active <= 0 || a.Busy < prev.Busy || a.Rx < prev.Rx || a.Tx < prev.Tx {
+		return
+	}
+	a.ChanUtil = float64(a.Busy-prev.Busy) / float64(active)
+	a.RxUtil = float64(a.Rx-prev.Rx) / float64(active)
+	a.TxUtil = float64(a.Tx-prev.Tx) / float64(active)
+}
+
+// MemoryUsage is the used share of memory, as yanic computes it.
+func (st *Statistics) MemoryUsage() float64 {
+	m := st.Memory
+	if m.Total <= 0 {
+		return 0
+	}
+	if m.Available > 0 {
+		return 1 - float64(m.Available)/float64(m.Total)
+	}
+	return 1 - float64(m.Free+m.Buffers+m.Cached)/float64(m.Total)
+}
+
+type Neighbours struct {
+	NodeID string `json:"node_id"`
+	// Batadv holds the batman-adv originators seen per mesh interface MAC.
+	Batadv map[string]struct {
+		Neighbours map[string]struct {
+			TQ float64 `json:"tq"` // 0-255
+		} `json:"neighbours"`
+	} `json:"batadv"`
+}
+
+// Node is everything known about one node.
+type Node struct {
+	NodeInfo   *NodeInfo
+	Statistics *Statistics
+	Neighbours *Neighbours
+	Firstseen  time.Time
+	Lastseen   time.Time
+	Online     bool
+}
+
+// Collector asks one target and keeps the nodes that answered.
+type Collector struct {
+	target *Target
+	mu     sync.Mutex
+	nodes  map[string]*Node
+}
+
+func NewCollector(t *Target) *Collector {
+	return &Collector{target: t, nodes: make(map[string]*Node)}
+}
+
+// Poll sends a request, collects replies for the wait time of the target
+// and returns every node seen within the last week, together with the
+// time of this poll. Nodes are online when they answered this poll.
+func (c *Collector) Poll(ctx context.Context) ([]*Node, time.Time, error) {
+	t := c.target
+	var conns []*net.UDPConn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	var lastErr error
+	send := func(conn *net.UDPConn, addr *net.UDPAddr) {
+		if _, err := conn.WriteToUDP([]byte(request), addr); err != nil {
+			conn.Close()
+			lastErr = err
+			return
+		}
+		conns = append(conns, conn)
+	}
+	for _, iface := range t.Interfaces {
+		conn, err := listenOn(iface)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		send(conn, &net.UDPAddr{IP: t.Group, Port: t.Port, Zone: iface})
+	}
+	if len(t.Unicast) > 0 {
+		if conn, err := net.ListenUDP("udp", &net.UDPAddr{}); err != nil {
+			lastErr = err
+		} else {
+			for _, addr := range t.Unicast {
+				// A failing address leaves the socket to the others.
+				conn.WriteToUDP([]byte(request), addr)
+			}
+			conns = append(conns, conn)
+		}
+	}
+	if len(conns) == 0 {
+		return nil, time.Time{}, fmt.Errorf("respondd: sending request: %w", lastErr)
+	}
+
+	now := time.Now()
+	deadline := now.Add(t.Wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	answers := make(map[string]*Node)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, conn := range conns {
+		conn.SetReadDeadline(deadline)
+		wg.Add(1)
+		go func(conn *net.UDPConn) {
+			defer wg.Done()
+			buf := make([]byte, 65536)
+			for {
+				n, _, err := conn.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				if resp, err := decode(buf[:n]); err == nil {
+					mu.Lock()
+					mergeResponse(answers, resp)
+					mu.Unlock()
+				}
+			}
+		}(conn)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, a := range answers {
+		known := c.nodes[id]
+		if known == nil {
+			known = &Node{Firstseen: now}
+			c.nodes[id] = known
+		}
+		if a.NodeInfo != nil {
+			known.NodeInfo = a.NodeInfo
+		}
+		if a.Statistics != nil && known.Statistics != nil {
+			for _, w := range a.Statistics.Wireless {
+				for _, pw := range known.Statistics.Wireless {
+					if pw.Frequency == w.Frequency {
+						w.setUtilization(pw)
+					}
+				}
+			}
+		}
+		known.Statistics, known.Neighbours = a.Statistics, a.Neighbours
+		known.Lastseen = now
+	}
+	nodes := make([]*Node, 0, len(c.nodes))
+	for id, n := range c.nodes {
+		if now.Sub(n.Lastseen) > pruneAfter {
+			delete(c.nodes, id)
+			continue
+		}
+		cp := *n
+		cp.Online = cp.Lastseen.Equal(now)
+		if !cp.Online {
+			cp.Statistics, cp.Neighbours = nil, nil
+		}
+		nodes = append(nodes, &cp)
+	}
+	if len(nodes) == 0 {
+		return nil, now, fmt.Errorf("respondd: no node answered within %s", t.Wait)
+	}
+	return nodes, now, nil
+}
+
+// listenOn opens a socket on the link-local address of iface. Linux only
+// honours the zone of link-local destinations, so binding is what sends
+// site-local multicast out of the mesh interface.
+func listenOn(iface string) (*net.UDPConn, error) {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && ipn.IP.To4() == nil && ipn.IP.IsLinkLocalUnicast() {
+			return net.ListenUDP("udp6", &net.UDPAddr{IP: ipn.IP, Zone: iface})
+		}
+	}
+	return nil, fmt.Errorf("%s has no IPv6 link-local address", iface)
+}
+
+// decode reads a reply, which is raw deflate compressed JSON for a "GET"
+// request and plain JSON from older respondd implementations.
+func decode(pkt []byte) (*Response, error) {
+	data, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(pkt)), maxResponseBytes))
+	if err != nil {
+		data = pkt
+	}
+	var resp Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// mergeResponse adds the parts of resp to the node they belong to.
+func mergeResponse(into map[string]*Node, resp *Response) {
+	id := ""
+	switch {
+	case resp.NodeInfo != nil:
+		id = resp.NodeInfo.NodeID
+	case resp.Statistics != nil:
+		id = resp.Statistics.NodeID
+	case resp.Neighbours != nil:
+		id = resp.Neighbours.NodeID
+	}
+	if id == "" {
+		return
+	}
+	n := into[id]
+	if n == nil {
+		n = &Node{}
+		into[id] = n
+	}
+	if resp.NodeInfo != nil {
+		n.NodeInfo = resp.NodeInfo
+	}
+	if resp.Statistics != nil {
+		n.Statistics = resp.Statistics
+	}
+	if resp.Neighbours != nil {
+		n.Neighbours = resp.Neighbours
+	}
+}
@@ -0,0 +1,283 @@
+// Package sla records up/down transitions of gateways and domains and
+// computes availability percentages from that history.
+package sla
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+const (
+	// retention is how long transitions are kept; enough for a year of
+	// monthly reports plus the month in progress.
+	retention = 400 * 24 * time.Hour
+	// missingExpiry is how long a series is tracked while its gateway or
+	// domain is missing from the data. Data sources keep offline nodes
+	// listed, so one missing for longer was removed or hidden.
+	missingExpiry = 24 * time.Hour
+)
+
+// Transition is a change of state at a point in time. Gone marks the end
+// of tracking; the time until the next transition is not observed.
+type Transition struct {
+	At   time.Time `json:"at"`
+	Up   bool      `json:"up"`
+	Gone bool      `json:"gone,omitempty"`
+}
+
+// Series is the transition history of one gateway or domain.
+type Series struct {
+	Name        string       `json:"name"`
+	Transitions []Transition `json:"transitions"`
+	// LastSeen is when the gateway or domain was last in a snapshot.
+	LastSeen time.Time `json:"last_seen,omitempty"`
+}
+
+// Availability is the computed SLA of one gateway or domain in a period.
+type Availability struct {
+	Name            string  `json:"name"`
+	Availability    float64 `json:"availability"` // percent of observed time
+	DowntimeMinutes int     `json:"downtime_minutes"`
+	ObservedHours   float64 `json:"observed_hours"`
+	Outages         int     `json:"outages"`
+}
+
+// Report holds availabilities of all gateways and domains for a period.
+type Report struct {
+	From     time.Time               `json:"from"`
+	To       time.Time               `json:"to"`
+	Gateways map[string]Availability `json:"gateways"`
+	Domains  map[string]Availability `json:"domains"`
+}
+
+// Tracker observes snapshots and persists the transition history.
+type Tracker struct {
+	path string
+	mu   sync.Mutex
+	// series is keyed by "gateway:<node_id>" or "domain:<domain>".
+	series map[string]*Series
+}
+
+// Open loads the history from path. A missing file starts empty.
+func Open(path string) *Tracker {
+	t := &Tracker{path: path, series: make(map[string]*Series)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("SLA: read error: %v", err)
+		}
+		return t
+	}
+	if err := json.Unmarshal(data, &t.series); err != nil {
+		log.Printf("SLA: corrupt %s, ignoring (%v)", path, err)
+		t.series = make(map[string]*Series)
+	}
+	return t
+}
+
+// Observe records state changes found in a snapshot. A gateway is up while
+// it is online; a tracked gateway missing from the snapshot is down until
+// missingExpiry has passed, when its series ends. A domain is up while at
+// least one gateway its nodes use is online; domains without known
+// gateways are up while any node is online.
+func (t *Tracker) Observe(snap *store.Snapshot) {
+	now := time.Now().UTC()
+	states := make(map[string]bool)
+	names := make(map[string]string)
+
+	domainGWs := make(map[string]map[string]bool)
+	domainAny := make(map[string]bool)
+	for _, n := range snap.NodeList {
+		if n.Placeholder {
+			continue
+		}
+		if n.IsGateway {
+			states["gateway:"+n.NodeID] = n.IsOnline
+			names["gateway:"+n.NodeID] = n.Hostname
+		}
+		if n.Domain == "" {
+			continue
+		}
+		domainAny[n.Domain] = domainAny[n.Domain] || n.IsOnline
+		gw := n.Gateway
+		if n.IsGateway {
+			gw = n.NodeID
+		}
+		if gw != "" {
+			if domainGWs[n.Domain] == nil {
+				domainGWs[n.Domain] = make(map[string]bool)
+			}
+			domainGWs[n.Domain][gw] = true
+		}
+	}
+	for d, any := range domainAny {
+		up := any
+		if gws := domainGWs[d]; len(gws) > 0 {
+			up = false
+			for gw := range gws {
+				if g, ok := snap.Nodes[gw]; ok && g.IsOnline {
+					up = true
+					break
+				}
+			}
+		}
+		key := "domain:" + d
+		states[key] = up
+		names[key] = d
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	changed := false
+	for key, s := range t.series {
+		if _, seen := states[key]; seen {
+			continue
+		}
+		n := len(s.Transitions)
+		if n == 0 || s.Transitions[n-1].Gone {
+			continue
+		}
+		if s.LastSeen.IsZero() {
+			// Recorded before LastSeen was kept.
+			s.LastSeen = now
+		}
+		if now.Sub(s.LastSeen) > missingExpiry {
+			at := s.LastSeen.Add(missingExpiry)
+			if last := s.Transitions[n-1].At; at.Before(last) {
+				at = last
+			}
+			s.Transitions = append(s.Transitions, Transition{At: at, Gone: true})
+			changed = true
+		} else if strings.HasPrefix(key, "gateway:") {
+			states[key] = false
+		}
+	}
+	for key, up := range states {
+		s := t.series[key]
+		if s == nil {
+			s = &Series{Name: names[key]}
+			t.series[key] = s
+		}
+		if name, seen := names[key]; seen {
+			s.LastSeen = now
+			if name != "" {
+				s.Name = name
+			}
+		}
+		if n := len(s.Transitions); n == 0 || s.Transitions[n-1].Up != up || s.Transitions[n-1].Gone {
+			s.Transitions = append(s.Transitions, Transition{At: now, Up: up})
+			changed = true
+		}
+	}
+	if changed {
+		t.pruneLocked(now)
+		t.saveLocked()
+	}
+}
+
+// pruneLocked drops transitions older than the retention, keeping the last
+// old one so the state at the retention boundary stays known, and series
+// that ended before it.
+func (t *Tracker) pruneLocked(now time.Time) {
+	cutoff := now.Add(-retention)
+	for key, s := range t.series {
+		if n := len(s.Transitions); n > 0 && s.Transitions[n-1].Gone && s.Transitions[n-1].At.Before(cutoff) {
+			delete(t.series, key)
+			continue
+		}
+		i := sort.Search(len(s.Transitions), func(i int) bool { return s.Transitions[i].At.After(cutoff) })
+		if i > 1 {
+			s.Transitions = s.Transitions[i-1:]
+		}
+	}
+}
+
+func (t *Tracker) saveLocked() {
+	data, err := json.Marshal(t.series)
+	if err != nil {
+		log.Printf("SLA: save error: %v", err)
+		return
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("SLA: write error: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		log.Printf("SLA: rename error: %v", err)
+	}
+}
+
+// Report computes availabilities for [from, to). Time before a series was
+// first observed is excluded; time after now is not counted.
+func (t *Tracker) Report(from, to time.Time) *Report {
+	now := time.Now().UTC()
+	if to.After(now) {
+		to = now
+	}
+	r := &Report{
+		From:     from,
+		To:       to,
+		Gateways: make(map[string]Availability),
+		Domains:  make(map[string]Availability),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, s := range t.series {
+		a, ok := availability(s, from, to)
+		if !ok {
+			continue
+		}
+		kind, id, _ := strings.Cut(key, ":")
+		switch kind {
+		case "gateway":
+			r.Gateways[id] = a
+		case "domain":
+			r.Domains[id] = a
+		}
+	}
+	return r
+}
+
+func availability(s *Series, from, to time.Time) (Availability, bool) {
+	a := Availability{Name: s.Name}
+	var up, observed time.Duration
+	for i, tr := range s.Transitions {
+		start := tr.At
+		end := to
+		if i+1 < len(s.Transitions) {
+			end = s.Transitions[i+1].At
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if tr.Gone || !end.After(start) {
+			continue
+		}
+		observed += end.Sub(start)
+		if tr.Up {
+			up += end.Sub(start)
+		} else if !tr.At.Before(from) {
+			a.Outages++
+		}
+	}
+	if observed <= 0 {
+		return a, false
+	}
+	a.Availability = math.Round(float64(up)/float64(observed)*100000) / 1000
+	a.DowntimeMinutes = int((observed - up).Minutes())
+	a.ObservedHours = math.Round(observed.Hours()*10) / 10
+	return a, true
+}
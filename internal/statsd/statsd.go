@@ -0,0 +1,126 @@
+// Package statsd pushes headline map metrics to a StatsD or Telegraf
+// listener after every data refresh.
+package statsd
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+)
+
+// maxPacket keeps datagrams below the common 1500 byte Ethernet MTU.
+const maxPacket = 1400
+
+// Emitter sends gauges over UDP. Sending is fire-and-forget: a missing
+// listener must never slow down or break a refresh.
+type Emitter struct {
+	prefix string
+	conn   net.Conn
+}
+
+// New dials the StatsD address. UDP dialing only resolves the address, so
+// errors here mean a malformed address or unresolvable host.
+func New(addr, prefix string) (*Emitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	return &Emitter{prefix: strings.TrimSuffix(prefix, "."), conn: conn}, nil
+}
+
+// Emit sends the gauges derived from a snapshot.
+func (e *Emitter) Emit(snap *store.Snapshot) {
+	type group struct{ nodes, online, clients int }
+	domains := make(map[string]*group)
+	communities := make(map[string]*group)
+	add := func(m map[string]*group, k string, n *store.Node) {
+		g := m[k]
+		if g == nil {
+			g = &group{}
+			m[k] = g
+		}
+		g.nodes++
+		if n.IsOnline {
+			g.online++
+			g.clients += n.Clients
+		}
+	}
+	for _, n := range snap.NodeList {
+		if n.Placeholder {
+			continue
+		}
+		if n.Domain != "" {
+			add(domains, n.Domain, n)
+		}
+		for _, c := range n.Communities {
+			add(communities, c, n)
+		}
+	}
+
+	var lines []string
+	gauge := func(name string, v int) {
+		lines = append(lines, fmt.Sprintf("%s.%s:%d|g", e.prefix, name, v))
+	}
+	gauge("nodes.total", snap.Stats.TotalNodes)
+	gauge("nodes.online", snap.Stats.OnlineNodes)
+	gauge("clients", snap.Stats.TotalClients)
+	gauge("gateways", snap.Stats.Gateways)
+	gauge("links", len(snap.Links))
+	for _, set := range []struct {
+		name   string
+		groups map[string]*group
+	}{{"domain", domains}, {"community", communities}} {
+		keys := make([]string, 0, len(set.groups))
+		for k := range set.groups {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			g := set.groups[k]
+			base := set.name + "." + sanitize(k)
+			gauge(base+".nodes.total", g.nodes)
+			gauge(base+".nodes.online", g.online)
+			gauge(base+".clients", g.clients)
+		}
+	}
+
+	e.send(lines)
+}
+
+func (e *Emitter) send(lines []string) {
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, err := e.conn.Write([]byte(buf.String())); err != nil {
+			log.Printf("StatsD: send error: %v", err)
+		}
+		buf.Reset()
+	}
+	for _, l := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(l) > maxPacket {
+			flush()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(l)
+	}
+	flush()
+}
+
+// sanitize makes a key safe as a StatsD metric path segment.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,131 @@
+package store
+
+// ChannelUsage counts the online nodes configured for one channel.
+type ChannelUsage struct {
+	Nodes      int     `json:"nodes"`
+	Clients    int     `json:"clients"` // clients on that band
+	AvgTxPower float64 `json:"avg_txpower,omitempty"`
+	// AvgChanUtil averages the radios on this channel that report airtime.
+	AvgChanUtil float64 `json:"avg_chan_util,omitempty"`
+}
+
+// ChannelStats maps channel numbers onto their usage, per band.
+type ChannelStats struct {
+	Band24 map[int]ChannelUsage `json:"2.4ghz"`
+	Band5  map[int]ChannelUsage `json:"5ghz"`
+}
+
+// ChannelStatsReport holds channel usage globally and per domain/community,
+// for finding congested channels in dense areas.
+type ChannelStatsReport struct {
+	Global      ChannelStats            `json:"global"`
+	Domains     map[string]ChannelStats `json:"domains"`
+	Communities map[string]ChannelStats `json:"communities,omitempty"`
+}
+
+type channelAcc struct {
+	usage     [2]map[int]ChannelUsage
+	txSum     [2]map[int]int
+	txCount   [2]map[int]int
+	utilSum   [2]map[int]float64
+	utilCount [2]map[int]int
+}
+
+func newChannelAcc() *channelAcc {
+	a := &channelAcc{}
+	for i := range a.usage {
+		a.usage[i] = make(map[int]ChannelUsage)
+		a.txSum[i] = make(map[int]int)
+		a.txCount[i] = make(map[int]int)
+		a.utilSum[i] = make(map[int]float64)
+		a.utilCount[i] = make(map[int]int)
+	}
+	return a
+}
+
+func (a *channelAcc) addBand(band, channel, txPower, clients int) {
+	if channel <= 0 {
+		return
+	}
+	u := a.usage[band][channel]
+	u.Nodes++
+	u.Clients += clients
+	a.usage[band][channel] = u
+	if txPower > 0 {
+		a.txSum[band][channel] += txPower
+		a.txCount[band][channel]++
+	}
+}
+
+func (a *channelAcc) add(n *Node) {
+	w := n.Wireless
+	a.addBand(0, w.Channel24, w.TxPower24, n.ClientsW24)
+	a.addBand(1, w.Channel5, w.TxPower5, n.ClientsW5)
+	for _, r := range n.Airtime {
+		band, channel := 0, w.Channel24
+		if r.Frequency >= 3000 {
+			band, channel = 1, w.Channel5
+		}
+		if channel > 0 {
+			a.utilSum[band][channel] += r.ChanUtil
+			a.utilCount[band][channel]++
+		}
+	}
+}
+
+func (a *channelAcc) result() ChannelStats {
+	for band := range a.usage {
+		for ch, u := range a.usage[band] {
+			if c := a.txCount[band][ch]; c > 0 {
+				u.AvgTxPower = round3(float64(a.txSum[band][ch]) / float64(c))
+			}
+			if c := a.utilCount[band][ch]; c > 0 {
+				u.AvgChanUtil = round3(a.utilSum[band][ch] / float64(c))
+			}
+			a.usage[band][ch] = u
+		}
+	}
+	return ChannelStats{Band24: a.usage[0], Band5: a.usage[1]}
+}
+
+// ComputeChannelStats counts online nodes and their clients per channel.
+// Only nodes whose source reports radio settings are included.
+func ComputeChannelStats(snap *Snapshot) *ChannelStatsReport {
+	global := newChannelAcc()
+	domains := make(map[string]*channelAcc)
+	communities := make(map[string]*channelAcc)
+
+	for _, n := range snap.NodeList {
+		if !n.IsOnline || n.Wireless == nil {
+			continue
+		}
+		global.add(n)
+		if n.Domain != "" {
+			if domains[n.Domain] == nil {
+				domains[n.Domain] = newChannelAcc()
+			}
+			domains[n.Domain].add(n)
+		}
+		for _, c := range n.Communities {
+			if communities[c] == nil {
+				communities[c] = newChannelAcc()
+			}
+			communities[c].add(n)
+		}
+	}
+
+	rep := &ChannelStatsReport{
+		Global:  global.result(),
+		Domains: make(map[string]ChannelStats, len(domains)),
+	}
+	for k, a := range domains {
+		rep.Domains[k] = a.result()
+	}
+	if len(communities) > 0 {
+		rep.Communities = make(map[string]ChannelStats, len(communities))
+		for k, a := range communities {
+			rep.Communities[k] = a.result()
+		}
+	}
+	return rep
+}
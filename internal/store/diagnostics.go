@@ -0,0 +1,121 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxDiagnosticSamples caps the example IDs listed per diagnostic category.
+const maxDiagnosticSamples = 20
+
+// DiagnosticCount is a problem count with a few example node IDs or links.
+type DiagnosticCount struct {
+	Count   int      `json:"count"`
+	Samples []string `json:"samples,omitempty"`
+}
+
+func (d *DiagnosticCount) add(sample string) {
+	d.Count++
+	if len(d.Samples) < maxDiagnosticSamples {
+		d.Samples = append(d.Samples, sample)
+	}
+}
+
+// Diagnostics summarizes data quality problems in the current snapshot.
+type Diagnostics struct {
+	Nodes                int                 `json:"nodes"`
+	Links                int                 `json:"links"`
+	NodesWithoutLocation DiagnosticCount     `json:"nodes_without_location"`
+	UnknownLinkNodes     DiagnosticCount     `json:"links_unknown_nodes"`
+	ImpossibleUptime     DiagnosticCount     `json:"impossible_uptime"`
+	ImpossibleLastseen   DiagnosticCount     `json:"impossible_lastseen"`
+	HiddenNodes          int                 `json:"hidden_nodes"`
+	SourceWarnings       map[string][]string `json:"source_warnings"`
+	SourceFailures       map[string]int      `json:"source_failures"` // consecutive failed fetches
+	SnapshotTimestamp    time.Time           `json:"snapshot_timestamp"`
+}
+
+// clockSkew is how far in the future a timestamp may be before it is flagged.
+const clockSkew = 10 * time.Minute
+
+// ComputeDiagnostics checks a snapshot for missing locations, dangling link
+// endpoints and timestamps that cannot be right.
+func ComputeDiagnostics(snap *Snapshot, warnings map[string][]string, now time.Time) *Diagnostics {
+	d := &Diagnostics{
+		Nodes:             len(snap.NodeList),
+		Links:             len(snap.Links),
+		SourceWarnings:    warnings,
+		SnapshotTimestamp: snap.Timestamp,
+	}
+	if d.SourceWarnings == nil {
+		d.SourceWarnings = map[string][]string{}
+	}
+
+	future := now.Add(clockSkew)
+	for _, n := range snap.NodeList {
+		if n.Placeholder {
+			continue
+		}
+		if n.Lat == nil {
+			d.NodesWithoutLocation.add(n.NodeID)
+		}
+		first, firstOK := ParseTimestamp(n.Firstseen)
+		if up, ok := ParseTimestamp(n.Uptime); ok && up.After(future) {
+			d.ImpossibleUptime.add(n.NodeID)
+		}
+		if last, ok := ParseTimestamp(n.Lastseen); ok {
+			if last.After(future) || (firstOK && last.Before(first)) {
+				d.ImpossibleLastseen.add(n.NodeID)
+			}
+		}
+	}
+
+	d.UnknownLinkNodes = snap.Orphans
+	d.HiddenNodes = snap.Hidden
+	return d
+}
+
+// CheckRawData returns human-readable warnings about a parsed source:
+// nodes without ID, duplicate IDs and out-of-range coordinates.
+func CheckRawData(raw *MeshviewerData) []string {
+	var warnings []string
+	missingID, badLocation := 0, 0
+	seen := make(map[string]bool, len(raw.Nodes))
+	dups := 0
+	for i := range raw.Nodes {
+		rn := &raw.Nodes[i]
+		if rn.NodeID == "" {
+			missingID++
+			continue
+		}
+		if seen[rn.NodeID] {
+			dups++
+		}
+		seen[rn.NodeID] = true
+		if rn.Location != nil && (math.Abs(rn.Location.Latitude) >= 90 || math.Abs(rn.Location.Longitude) >= 180) {
+			badLocation++
+		}
+	}
+	if raw.Skipped > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d node entries could not be parsed", raw.Skipped))
+	}
+	if missingID > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d nodes without node_id", missingID))
+	}
+	if dups > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d duplicate node_ids", dups))
+	}
+	if badLocation > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d nodes with out-of-range coordinates", badLocation))
+	}
+	if len(raw.Nodes) == 0 {
+		warnings = append(warnings, "source contains no nodes")
+	}
+	if raw.Timestamp != "" {
+		if _, ok := ParseTimestamp(raw.Timestamp); !ok {
+			warnings = append(warnings, fmt.Sprintf("unparseable timestamp %q", raw.Timestamp))
+		}
+	}
+	return warnings
+}
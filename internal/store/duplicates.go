@@ -0,0 +1,57 @@
+package store
+
+import (
+	"sort"
+	"strings"
+)
+
+// DuplicateHostname is a hostname shared by several node_ids in one domain.
+type DuplicateHostname struct {
+	Hostname string   `json:"hostname"`
+	Domain   string   `json:"domain"`
+	NodeIDs  []string `json:"node_ids"`
+}
+
+// findDuplicateHostnames groups nodes by domain and case-insensitive hostname
+// and returns groups with more than one node_id, sorted by hostname.
+func findDuplicateHostnames(nodes []*Node) []DuplicateHostname {
+	type key struct{ domain, name string }
+	groups := make(map[key][]*Node)
+	for _, n := range nodes {
+		if n.Hostname == "" || n.Placeholder {
+			continue
+		}
+		k := key{n.Domain, strings.ToLower(n.Hostname)}
+		groups[k] = append(groups[k], n)
+	}
+
+	dups := make([]DuplicateHostname, 0)
+	for k, g := range groups {
+		if len(g) < 2 {
+			continue
+		}
+		d := DuplicateHostname{Hostname: g[0].Hostname, Domain: k.domain}
+		for _, n := range g {
+			d.NodeIDs = append(d.NodeIDs, n.NodeID)
+		}
+		sort.Strings(d.NodeIDs)
+		dups = append(dups, d)
+	}
+	sort.Slice(dups, func(i, j int) bool {
+		if dups[i].Hostname != dups[j].Hostname {
+			return dups[i].Hostname < dups[j].Hostname
+		}
+		return dups[i].Domain < dups[j].Domain
+	})
+	return dups
+}
+
+// markDuplicateHostnames flags every node in a duplicate group.
+func markDuplicateHostnames(snap *Snapshot) {
+	snap.DuplicateHostnames = findDuplicateHostnames(snap.NodeList)
+	for _, d := range snap.DuplicateHostnames {
+		for _, id := range d.NodeIDs {
+			snap.Nodes[id].DuplicateHostname = true
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// flapTracker remembers link presence across snapshots and counts how often
+// each link appeared or disappeared within the configured window.
+type flapTracker struct {
+	mu    sync.Mutex
+	links map[string]*linkPresence
+}
+
+type linkPresence struct {
+	present bool
+	changes []time.Time
+}
+
+func newFlapTracker() *flapTracker {
+	return &flapTracker{links: make(map[string]*linkPresence)}
+}
+
+// LinkKey returns a direction-independent key for a link.
+func LinkKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// observe records the link set of a new snapshot and marks flapping links in
+// place. The first sighting of a link is not counted as a change.
+func (t *flapTracker) observe(links []Link, now time.Time, window time.Duration, threshold int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := make(map[string]bool, len(links))
+	for _, l := range links {
+		current[LinkKey(l.Source, l.Target)] = true
+	}
+
+	for key, lp := range t.links {
+		if lp.present && !current[key] {
+			lp.present = false
+			lp.changes = append(lp.changes, now)
+		}
+	}
+	for key := range current {
+		lp, ok := t.links[key]
+		if !ok {
+			t.links[key] = &linkPresence{present: true}
+			continue
+		}
+		if !lp.present {
+			lp.present = true
+			lp.changes = append(lp.changes, now)
+		}
+	}
+
+	cutoff := now.Add(-window)
+	for key, lp := range t.links {
+		i := 0
+		for i < len(lp.changes) && lp.changes[i].Before(cutoff) {
+			i++
+		}
+		lp.changes = lp.changes[i:]
+		if !lp.present && len(lp.changes) == 0 {
+			delete(t.links, key)
+		}
+	}
+
+	for i := range links {
+		lp := t.links[LinkKey(links[i].Source, links[i].Target)]
+		links[i].Flaps = len(lp.changes)
+		links[i].Flapping = threshold > 0 && links[i].Flaps >= threshold
+	}
+}
@@ -0,0 +1,173 @@
+package store
+
+import "sort"
+
+// maxClusteringDegree excludes hubs such as VPN gateways from the clustering
+// coefficient: their neighbour pairs grow quadratically and they would
+// dominate the refresh time while saying little about mesh structure.
+const maxClusteringDegree = 256
+
+// GraphMetrics describes the structure of a mesh graph.
+type GraphMetrics struct {
+	Nodes                 int     `json:"nodes"`
+	Links                 int     `json:"links"`
+	AvgDegree             float64 `json:"avg_degree"`
+	Components            int     `json:"components"`
+	LargestComponent      int     `json:"largest_component"`
+	Diameter              int     `json:"diameter"` // lower bound, see approxDiameter
+	ClusteringCoefficient float64 `json:"clustering_coefficient"`
+}
+
+// MeshHealth holds graph metrics globally and per domain.
+type MeshHealth struct {
+	Global  GraphMetrics            `json:"global"`
+	Domains map[string]GraphMetrics `json:"domains"`
+}
+
+// ComputeMeshHealth builds the undirected node graph from the snapshot and
+// derives global and per-domain metrics. Links count once per node pair.
+func ComputeMeshHealth(snap *Snapshot) *MeshHealth {
+	adj := make(map[string]map[string]bool, len(snap.Nodes))
+	for id, n := range snap.Nodes {
+		if !n.Placeholder {
+			adj[id] = make(map[string]bool)
+		}
+	}
+	for _, l := range snap.Links {
+		if l.Source == l.Target || adj[l.Source] == nil || adj[l.Target] == nil {
+			continue
+		}
+		adj[l.Source][l.Target] = true
+		adj[l.Target][l.Source] = true
+	}
+
+	mh := &MeshHealth{
+		Global:  graphMetrics(adj),
+		Domains: make(map[string]GraphMetrics),
+	}
+
+	byDomain := make(map[string]map[string]bool)
+	for id := range adj {
+		d := snap.Nodes[id].Domain
+		if d == "" {
+			continue
+		}
+		if byDomain[d] == nil {
+			byDomain[d] = make(map[string]bool)
+		}
+		byDomain[d][id] = true
+	}
+	for d, members := range byDomain {
+		sub := make(map[string]map[string]bool, len(members))
+		for id := range members {
+			nb := make(map[string]bool)
+			for other := range adj[id] {
+				if members[other] {
+					nb[other] = true
+				}
+			}
+			sub[id] = nb
+		}
+		mh.Domains[d] = graphMetrics(sub)
+	}
+	return mh
+}
+
+func graphMetrics(adj map[string]map[string]bool) GraphMetrics {
+	m := GraphMetrics{Nodes: len(adj)}
+	if m.Nodes == 0 {
+		return m
+	}
+
+	degSum := 0
+	ccSum := 0.0
+	ccNodes := 0
+	for _, nb := range adj {
+		degSum += len(nb)
+		k := len(nb)
+		if k > maxClusteringDegree {
+			continue
+		}
+		ccNodes++
+		if k < 2 {
+			continue
+		}
+		// Local clustering: share of neighbour pairs that are linked too.
+		closed := 0
+		for a := range nb {
+			for b := range nb {
+				if a < b && adj[a][b] {
+					closed++
+				}
+			}
+		}
+		ccSum += float64(closed) / float64(k*(k-1)/2)
+	}
+	m.Links = degSum / 2
+	m.AvgDegree = round3(float64(degSum) / float64(m.Nodes))
+	if ccNodes > 0 {
+		m.ClusteringCoefficient = round3(ccSum / float64(ccNodes))
+	}
+
+	// Connected components, iterating IDs in order for stable results.
+	ids := make([]string, 0, len(adj))
+	for id := range adj {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	seen := make(map[string]bool, len(adj))
+	var largest string
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		m.Components++
+		dist := bfs(adj, id)
+		for n := range dist {
+			seen[n] = true
+		}
+		if len(dist) > m.LargestComponent {
+			m.LargestComponent = len(dist)
+			largest = id
+		}
+	}
+	m.Diameter = approxDiameter(adj, largest)
+	return m
+}
+
+// bfs returns hop distances from start to every reachable node.
+func bfs(adj map[string]map[string]bool, start string) map[string]int {
+	dist := map[string]int{start: 0}
+	queue := []string{start}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for nb := range adj[cur] {
+			if _, ok := dist[nb]; !ok {
+				dist[nb] = dist[cur] + 1
+				queue = append(queue, nb)
+			}
+		}
+	}
+	return dist
+}
+
+// approxDiameter estimates the diameter of the component containing start
+// with a double BFS sweep: the eccentricity of the farthest node from an
+// arbitrary start. It is a lower bound and exact on trees, which mesh
+// clouds usually resemble, at the cost of two BFS runs instead of N.
+func approxDiameter(adj map[string]map[string]bool, start string) int {
+	far, _ := farthest(bfs(adj, start))
+	_, d := farthest(bfs(adj, far))
+	return d
+}
+
+func farthest(dist map[string]int) (string, int) {
+	best, bestD := "", -1
+	for id, d := range dist {
+		if d > bestD || (d == bestD && id < best) {
+			best, bestD = id, d
+		}
+	}
+	return best, bestD
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// HealthScore aggregates mesh quality indicators for a group of nodes.
+// Score is 0–100; ratios are 0–1. Components without data (e.g. no links,
+// no gateway information) are left out of the weighted score.
+type HealthScore struct {
+	Score            float64 `json:"score"`
+	Nodes            int     `json:"nodes"`
+	OnlineNodes      int     `json:"online_nodes"`
+	OfflineRatio     float64 `json:"offline_ratio"`
+	Links            int     `json:"links"`
+	AvgTQ            float64 `json:"avg_tq"`
+	OutdatedFirmware int     `json:"outdated_firmware"`
+	OutdatedRatio    float64 `json:"outdated_ratio"`
+	SingleHomed      int     `json:"single_homed"`
+	SingleHomedRatio float64 `json:"single_homed_ratio"`
+	GatewayReachable float64 `json:"gateway_reachable"`
+}
+
+// NetworkHealth is the health score of the whole network and its parts.
+type NetworkHealth struct {
+	Global          HealthScore            `json:"global"`
+	Domains         map[string]HealthScore `json:"domains"`
+	Communities     map[string]HealthScore `json:"communities,omitempty"`
+	CurrentFirmware string                 `json:"current_firmware,omitempty"`
+	Timestamp       string                 `json:"timestamp"`
+}
+
+// Score weights. Online ratio dominates; the structural indicators refine it.
+const (
+	weightOnline   = 0.40
+	weightTQ       = 0.20
+	weightFirmware = 0.15
+	weightHoming   = 0.10
+	weightGateway  = 0.15
+)
+
+type healthAcc struct {
+	nodes, online          int
+	links                  int
+	tqSum                  float64
+	outdated, withFirmware int
+	singleHomed, meshNodes int
+	gwReachable, gwKnown   int
+}
+
+func (a *healthAcc) score() HealthScore {
+	h := HealthScore{
+		Nodes:            a.nodes,
+		OnlineNodes:      a.online,
+		Links:            a.links,
+		OutdatedFirmware: a.outdated,
+		SingleHomed:      a.singleHomed,
+	}
+	var score, weight float64
+	if a.nodes > 0 {
+		online := float64(a.online) / float64(a.nodes)
+		h.OfflineRatio = round3(1 - online)
+		score += weightOnline * online
+		weight += weightOnline
+	}
+	if a.links > 0 {
+		h.AvgTQ = round3(a.tqSum / float64(a.links))
+		score += weightTQ * math.Min(h.AvgTQ, 1)
+		weight += weightTQ
+	}
+	if a.withFirmware > 0 {
+		h.OutdatedRatio = round3(float64(a.outdated) / float64(a.withFirmware))
+		score += weightFirmware * (1 - h.OutdatedRatio)
+		weight += weightFirmware
+	}
+	if a.meshNodes > 0 {
+		h.SingleHomedRatio = round3(float64(a.singleHomed) / float64(a.meshNodes))
+		score += weightHoming * (1 - h.SingleHomedRatio)
+		weight += weightHoming
+	}
+	if a.gwKnown > 0 {
+		h.GatewayReachable = round3(float64(a.gwReachable) / float64(a.gwKnown))
+		score += weightGateway * h.GatewayReachable
+		weight += weightGateway
+	}
+	if weight > 0 {
+		h.Score = math.Round(score/weight*1000) / 10
+	}
+	return h
+}
+
+// ComputeNetworkHealth derives per-domain and per-community health scores
+// from a snapshot. "Outdated" firmware means a Gluon base older than the most
+// widely deployed one; "single-homed" means an online non-gateway node that
+// hangs off exactly one link.
+func ComputeNetworkHealth(snap *Snapshot) *NetworkHealth {
+	current := ""
+	best := 0
+	for base, count := range snap.Stats.GluonVersions {
+		if count > best || (count == best && CompareVersions(base, current) > 0) {
+			current, best = base, count
+		}
+	}
+
+	global := &healthAcc{}
+	domains := make(map[string]*healthAcc)
+	communities := make(map[string]*healthAcc)
+
+	groupsOf := func(n *Node) []*healthAcc {
+		accs := []*healthAcc{global}
+		if n.Domain != "" {
+			if domains[n.Domain] == nil {
+				domains[n.Domain] = &healthAcc{}
+			}
+			accs = append(accs, domains[n.Domain])
+		}
+		for _, c := range n.Communities {
+			if communities[c] == nil {
+				communities[c] = &healthAcc{}
+			}
+			accs = append(accs, communities[c])
+		}
+		return accs
+	}
+
+	for _, n := range snap.NodeList {
+		accs := groupsOf(n)
+		for _, a := range accs {
+			a.nodes++
+			if n.IsOnline {
+				a.online++
+			}
+			if n.FWBase != "" && current != "" {
+				a.withFirmware++
+				if CompareVersions(n.FWBase, current) < 0 {
+					a.outdated++
+				}
+			}
+			if n.IsOnline && !n.IsGateway {
+				a.meshNodes++
+				if len(n.Neighbours) == 1 {
+					a.singleHomed++
+				}
+				if n.Gateway != "" {
+					a.gwKnown++
+					if gw, ok := snap.Nodes[n.Gateway]; ok && gw.IsOnline {
+						a.gwReachable++
+					}
+				}
+			}
+		}
+	}
+
+	for _, l := range snap.Links {
+		src, ok := snap.Nodes[l.Source]
+		if !ok {
+			continue
+		}
+		tq := (l.SourceTQ + l.TargetTQ) / 2
+		for _, a := range groupsOf(src) {
+			a.links++
+			a.tqSum += tq
+		}
+	}
+
+	nh := &NetworkHealth{
+		Global:          global.score(),
+		Domains:         make(map[string]HealthScore, len(domains)),
+		CurrentFirmware: current,
+		Timestamp:       snap.Stats.Timestamp,
+	}
+	for k, a := range domains {
+		nh.Domains[k] = a.score()
+	}
+	if len(communities) > 0 {
+		nh.Communities = make(map[string]HealthScore, len(communities))
+		for k, a := range communities {
+			nh.Communities[k] = a.score()
+		}
+	}
+	return nh
+}
+
+// CompareVersions compares firmware version strings such as "v2023.2.3" or
+// "2022.1.4" by their numeric components. Returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	fields := strings.FieldsFunc(v, func(r rune) bool { return !unicode.IsDigit(r) })
+	parts := make([]int, 0, len(fields))
+	for _, f := range fields {
+		n, _ := strconv.Atoi(f)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func round3(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
@@ -0,0 +1,44 @@
+package store
+
+import "sync"
+
+// interner deduplicates strings that repeat across nodes, such as models,
+// firmware releases and domain codes, so they share one backing array
+// instead of one copy per decoded node.
+//
+// It keeps two generations: strings seen in the current refresh and those
+// of the previous one. Values unused for a whole refresh are dropped, so
+// the table does not grow with churn.
+type interner struct {
+	mu   sync.Mutex
+	cur  map[string]string
+	prev map[string]string
+}
+
+func newInterner() *interner {
+	return &interner{cur: make(map[string]string)}
+}
+
+// rotate starts a new generation; call once per processed snapshot.
+func (in *interner) rotate() {
+	in.mu.Lock()
+	in.prev = in.cur
+	in.cur = make(map[string]string, len(in.prev))
+	in.mu.Unlock()
+}
+
+func (in *interner) get(s string) string {
+	if s == "" {
+		return ""
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if v, ok := in.cur[s]; ok {
+		return v
+	}
+	if v, ok := in.prev[s]; ok {
+		s = v
+	}
+	in.cur[s] = s
+	return s
+}
@@ -0,0 +1,106 @@
+package store
+
+import "math"
+
+// tqBuckets is the number of TQ histogram buckets (0–10%, 10–20%, …).
+const tqBuckets = 10
+
+// LinkStats summarizes link quality for a group of links.
+type LinkStats struct {
+	Links       int            `json:"links"`
+	AvgTQ       float64        `json:"avg_tq"`
+	TQHistogram [tqBuckets]int `json:"tq_histogram"`
+	AvgDistance float64        `json:"avg_distance"`
+	ByType      map[string]int `json:"by_type"`
+}
+
+// LinkStatsReport holds link statistics globally and per domain/community.
+// A link is attributed to the groups of its source node.
+type LinkStatsReport struct {
+	Global      LinkStats            `json:"global"`
+	Domains     map[string]LinkStats `json:"domains"`
+	Communities map[string]LinkStats `json:"communities,omitempty"`
+}
+
+type linkAcc struct {
+	stats     LinkStats
+	tqSum     float64
+	distSum   float64
+	distCount int
+}
+
+func (a *linkAcc) add(l Link) {
+	tq := (l.SourceTQ + l.TargetTQ) / 2
+	a.stats.Links++
+	a.tqSum += tq
+	b := int(tq * tqBuckets)
+	if b < 0 {
+		b = 0
+	}
+	if b >= tqBuckets {
+		b = tqBuckets - 1
+	}
+	a.stats.TQHistogram[b]++
+	if l.Distance > 0 {
+		a.distSum += l.Distance
+		a.distCount++
+	}
+	a.stats.ByType[l.Type]++
+}
+
+func (a *linkAcc) result() LinkStats {
+	st := a.stats
+	if st.Links > 0 {
+		st.AvgTQ = round3(a.tqSum / float64(st.Links))
+	}
+	if a.distCount > 0 {
+		st.AvgDistance = math.Round(a.distSum / float64(a.distCount))
+	}
+	return st
+}
+
+func newLinkAcc() *linkAcc {
+	return &linkAcc{stats: LinkStats{ByType: make(map[string]int)}}
+}
+
+// ComputeLinkStats builds TQ histograms, average distances and type counts.
+func ComputeLinkStats(snap *Snapshot) *LinkStatsReport {
+	global := newLinkAcc()
+	domains := make(map[string]*linkAcc)
+	communities := make(map[string]*linkAcc)
+
+	for _, l := range snap.Links {
+		global.add(l)
+		src, ok := snap.Nodes[l.Source]
+		if !ok {
+			continue
+		}
+		if src.Domain != "" {
+			if domains[src.Domain] == nil {
+				domains[src.Domain] = newLinkAcc()
+			}
+			domains[src.Domain].add(l)
+		}
+		for _, c := range src.Communities {
+			if communities[c] == nil {
+				communities[c] = newLinkAcc()
+			}
+			communities[c].add(l)
+		}
+	}
+
+	rep := &LinkStatsReport{
+		Global:  global.result(),
+		Domains: make(map[string]LinkStats, len(domains)),
+	}
+	for k, a := range domains {
+		rep.Domains[k] = a.result()
+	}
+	if len(communities) > 0 {
+		rep.Communities = make(map[string]LinkStats, len(communities))
+		for k, a := range communities {
+			rep.Communities[k] = a.result()
+		}
+	}
+	return rep
+}
@@ -0,0 +1,62 @@
+package store
+
+import "strings"
+
+// Canonical link types. Upstream data uses many spellings for the same kind
+// of link; everything is mapped onto this small set for coloring and stats.
+const (
+	LinkTypeWifi  = "wifi"
+	LinkTypeVPN   = "vpn"
+	LinkTypeCable = "cable"
+	LinkTypeOther = "other"
+)
+
+// linkTypeAliases maps lowercased raw link types onto canonical types.
+var linkTypeAliases = map[string]string{
+	"wifi":         LinkTypeWifi,
+	"wireless":     LinkTypeWifi,
+	"wlan":         LinkTypeWifi,
+	"mesh_wifi":    LinkTypeWifi,
+	"wifi_mesh":    LinkTypeWifi,
+	"wifi_adhoc":   LinkTypeWifi,
+	"802.11s":      LinkTypeWifi,
+	"ibss":         LinkTypeWifi,
+	"vpn":          LinkTypeVPN,
+	"mesh_vpn":     LinkTypeVPN,
+	"fastd":        LinkTypeVPN,
+	"wireguard":    LinkTypeVPN,
+	"wg":           LinkTypeVPN,
+	"l2tp":         LinkTypeVPN,
+	"tunneldigger": LinkTypeVPN,
+	"tunnel":       LinkTypeVPN,
+	"tinc":         LinkTypeVPN,
+	"gre":          LinkTypeVPN,
+	"vxlan":        LinkTypeVPN,
+	"cable":        LinkTypeCable,
+	"ethernet":     LinkTypeCable,
+	"eth":          LinkTypeCable,
+	"lan":          LinkTypeCable,
+	"wired":        LinkTypeCable,
+	"mesh_lan":     LinkTypeCable,
+	"mesh_wan":     LinkTypeCable,
+	"other":        LinkTypeOther,
+}
+
+// ClassifyLinkType maps a raw upstream link type onto a canonical type.
+// Unknown values fall back to prefix matches ("vpn-fastd", "wifi5") and
+// finally to "other".
+func ClassifyLinkType(raw string) string {
+	t := strings.ToLower(strings.TrimSpace(raw))
+	if c, ok := linkTypeAliases[t]; ok {
+		return c
+	}
+	switch {
+	case strings.HasPrefix(t, "vpn"), strings.Contains(t, "fastd"), strings.Contains(t, "wireguard"), strings.Contains(t, "tunnel"):
+		return LinkTypeVPN
+	case strings.HasPrefix(t, "wifi"), strings.Contains(t, "wireless"), strings.HasPrefix(t, "wlan"):
+		return LinkTypeWifi
+	case strings.HasPrefix(t, "eth"), strings.Contains(t, "cable"):
+		return LinkTypeCable
+	}
+	return LinkTypeOther
+}
@@ -0,0 +1,31 @@
+package store
+
+import "math"
+
+// metersPerDegree is the length of a degree of latitude.
+const metersPerDegree = 111320
+
+// locationGrid returns the grid size in meters that the coordinates of
+// nodes in domain are snapped to, 0 for exact coordinates.
+func (s *Store) locationGrid(domain string) float64 {
+	if g, ok := s.Cfg().DomainLocationGrid[domain]; ok {
+		return g
+	}
+	return s.Cfg().LocationGrid
+}
+
+// snapToGrid moves a coordinate to the centre of its cell in a grid of
+// cells about size meters wide. Cells are fixed, so a node stays put
+// between refreshes and snapping a snapped coordinate changes nothing.
+func snapToGrid(lat, lng, size float64) (float64, float64) {
+	if size <= 0 {
+		return lat, lng
+	}
+	latStep := size / metersPerDegree
+	lat = (math.Floor(lat/latStep) + 0.5) * latStep
+	// Longitude cells narrow towards the poles; size them at the snapped
+	// latitude so every node of a row uses the same width.
+	lngStep := latStep / math.Max(math.Cos(lat*math.Pi/180), 0.01)
+	lng = (math.Floor(lng/lngStep) + 0.5) * lngStep
+	return lat, lng
+}
@@ -0,0 +1,63 @@
+package store
+
+// MaxNeighbourhoodDepth bounds subgraph queries; a few hops already cover
+// most of a mesh cloud, and larger values approach the global link list.
+const MaxNeighbourhoodDepth = 4
+
+// Subgraph is a subset of the mesh: nodes plus the links between them.
+type Subgraph struct {
+	Center string  `json:"center"`
+	Depth  int     `json:"depth"`
+	Nodes  []*Node `json:"nodes"`
+	Links  []Link  `json:"links"`
+	// Hops maps each node ID to its distance from the center.
+	Hops map[string]int `json:"hops"`
+}
+
+// Neighbourhood returns all nodes within depth hops of the center node and
+// the links among them. It returns nil when the center is unknown.
+func Neighbourhood(snap *Snapshot, center string, depth int) *Subgraph {
+	start, ok := snap.Nodes[center]
+	if !ok {
+		return nil
+	}
+	if depth < 1 {
+		depth = 1
+	}
+	if depth > MaxNeighbourhoodDepth {
+		depth = MaxNeighbourhoodDepth
+	}
+
+	hops := map[string]int{center: 0}
+	nodes := []*Node{start}
+	frontier := []*Node{start}
+	for d := 1; d <= depth && len(frontier) > 0; d++ {
+		var next []*Node
+		for _, n := range frontier {
+			for _, id := range n.Neighbours {
+				if _, seen := hops[id]; seen {
+					continue
+				}
+				nn, ok := snap.Nodes[id]
+				if !ok {
+					continue
+				}
+				hops[id] = d
+				nodes = append(nodes, nn)
+				next = append(next, nn)
+			}
+		}
+		frontier = next
+	}
+
+	links := make([]Link, 0)
+	for _, l := range snap.Links {
+		_, sok := hops[l.Source]
+		_, tok := hops[l.Target]
+		if sok && tok {
+			links = append(links, l)
+		}
+	}
+
+	return &Subgraph{Center: center, Depth: depth, Nodes: nodes, Links: links, Hops: hops}
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/respondd"
+)
+
+// fetchRespondd polls the nodes of a respondd:// source. The collector of
+// each URL is kept so nodes that stop answering stay known as offline.
+func (s *Store) fetchRespondd(url string) (*MeshviewerData, error) {
+	s.respondMu.Lock()
+	c, ok := s.responders[url]
+	if !ok {
+		t, err := respondd.ParseURL(url)
+		if err != nil {
+			s.respondMu.Unlock()
+			return nil, err
+		}
+		c = respondd.NewCollector(t)
+		if s.responders == nil {
+			s.responders = make(map[string]*respondd.Collector)
+		}
+		s.responders[url] = c
+	}
+	s.respondMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Cfg().RefreshDuration)
+	defer cancel()
+	nodes, at, err := c.Poll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ResponddToMeshviewer(nodes, at), nil
+}
+
+// ResponddToMeshviewer converts respondd answers the way yanic builds its
+// meshviewer.json: gateways and links are resolved from interface MACs to
+// node IDs, and links of both directions are joined into one. at is the
+// time the answers were collected.
+func ResponddToMeshviewer(nodes []*respondd.Node, at time.Time) *MeshviewerData {
+	byMAC := make(map[string]string)
+	ifaceType := make(map[string]string)
+	for _, n := range nodes {
+		if n.NodeInfo == nil {
+			continue
+		}
+		for mac, typ := range n.NodeInfo.InterfaceTypes() {
+			byMAC[mac] = n.NodeInfo.NodeID
+			ifaceType[mac] = typ
+		}
+	}
+	nodeForMAC := func(mac string) string {
+		mac = strings.ToLower(mac)
+		if id, ok := byMAC[mac]; ok {
+			return id
+		}
+		return strings.ReplaceAll(mac, ":", "")
+	}
+
+	data := &MeshviewerData{Timestamp: at.UTC().Format(time.RFC3339)}
+	for _, n := range nodes {
+		if n.NodeInfo == nil {
+			continue // statistics without nodeinfo until it arrives
+		}
+		ni := n.NodeInfo
+		rn := RawNode{
+			NodeID:     ni.NodeID,
+			Hostname:   ni.Hostname,
+			MAC:        ni.Network.MAC,
+			Addresses:  ni.Network.Addresses,
+			Domain:     ni.System.DomainCode,
+			SiteCode:   ni.System.SiteCode,
+			DomainCode: ni.System.DomainCode,
+			IsUplink:   FlexBool(ni.VPN),
+			Role:       ni.System.Role,
+			IsOnline:   FlexBool(n.Online),
+			IsGateway:  FlexBool(ni.VPN),
+			Firstseen:  FlexTime(formatSeen(n.Firstseen)),
+			Lastseen:   FlexTime(formatSeen(n.Lastseen)),
+			Model:      ni.Hardware.Model,
+			Nproc:      FlexInt(ni.Hardware.Nproc),
+			Firmware: RawFirmware{
+				Base:      ni.Software.Firmware.Base,
+				Release:   ni.Software.Firmware.Release,
+				Target:    ni.Software.Firmware.Target,
+				Subtarget: ni.Software.Firmware.Subtarget,
+				ImageName: ni.Software.Firmware.ImageName,
+			},
+			Autoupdater: RawAutoUpd{
+				Enabled: FlexBool(ni.Software.Autoupdater.Enabled),
+				Branch:  ni.Software.Autoupdater.Branch,
+			},
+		}
+		if rn.Domain == "" {
+			rn.Domain = ni.System.SiteCode
+		}
+		if w := ni.Wireless; w != nil {
+			rn.Wireless = &Wireless{Channel24: w.Channel24, TxPower24: w.TxPower24, Channel5: w.Channel5, TxPower5: w.TxPower5}
+		}
+		if ni.Owner != nil {
+			rn.Owner = ni.Owner.Contact
+		}
+		if l := ni.Location; l != nil {
+			rn.Location = &RawLocation{Latitude: l.Latitude, Longitude: l.Longitude}
+			if l.Altitude != nil {
+				alt := FlexFloat64(*l.Altitude)
+				rn.Location.Altitude = &alt
+			}
+		}
+		if st := n.Statistics; st != nil {
+			rn.Clients = FlexInt(st.Clients.Total)
+			rn.ClientsW24 = FlexInt(st.Clients.Wifi24)
+			rn.ClientsW5 = FlexInt(st.Clients.Wifi5)
+			rn.ClientsOth = FlexInt(st.Clients.Total - st.Clients.Wifi)
+			rn.RootfsUsage = FlexFloat64(st.RootfsUsage)
+			rn.LoadAvg = FlexFloat64(st.LoadAvg)
+			rn.MemoryUsage = FlexFloat64(st.MemoryUsage())
+			if st.Uptime > 0 {
+				rn.Uptime = FlexTime(at.Add(-time.Duration(st.Uptime * float64(time.Second))).UTC().Format(time.RFC3339))
+			}
+			if st.Gateway != "" {
+				rn.Gateway = nodeForMAC(st.Gateway)
+			}
+			if st.Gateway6 != "" {
+				rn.Gateway6 = nodeForMAC(st.Gateway6)
+			}
+			if st.GatewayNexthop != "" {
+				rn.GwNexthop = nodeForMAC(st.GatewayNexthop)
+			}
+			for _, w := range st.Wireless {
+				rn.Airtime = append(rn.Airtime, Airtime{
+					Frequency: w.Frequency, ChanUtil: w.ChanUtil, RxUtil: w.RxUtil, TxUtil: w.TxUtil,
+				})
+			}
+			if len(st.Traffic) > 0 {
+				rn.Traffic = make(map[string]TrafficCounter, len(st.Traffic))
+				for kind, c := range st.Traffic {
+					if c != nil {
+						rn.Traffic[kind] = TrafficCounter{Bytes: c.Bytes, Packets: c.Packets}
+					}
+				}
+			}
+		}
+		data.Nodes = append(data.Nodes, rn)
+	}
+	data.Links = responddLinks(nodes, byMAC, ifaceType)
+	return data
+}
+
+// formatSeen formats t as RFC 3339, leaving unknown times empty.
+func formatSeen(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// responddLinks joins the batman-adv neighbour tables into links. TQ is
+// scaled from 0-255 to 0-1; the type is that of the source interface.
+func responddLinks(nodes []*respondd.Node, byMAC, ifaceType map[string]string) []RawLink {
+	links := make(map[string]*RawLink)
+	for _, n := range nodes {
+		nb := n.Neighbours
+		if nb == nil {
+			continue
+		}
+		for ifMAC, iface := range nb.Batadv {
+			ifMAC = strings.ToLower(ifMAC)
+			typ := ifaceType[ifMAC]
+			if typ == "" {
+				typ = "other"
+			}
+			for mac, neigh := range iface.Neighbours {
+				target, ok := byMAC[strings.ToLower(mac)]
+				if !ok || target == nb.NodeID {
+					continue
+				}
+				tq := neigh.TQ / 255
+				src, dst := nb.NodeID, target
+				reverse := src > dst
+				if reverse {
+					src, dst = dst, src
+				}
+				key := src + ">" + dst + ">" + typ
+				l := links[key]
+				if l == nil {
+					l = &RawLink{Source: src, Target: dst, Type: typ}
+					links[key] = l
+				}
+				if reverse {
+					l.TargetTQ = max(l.TargetTQ, tq)
+				} else {
+					l.SourceTQ = max(l.SourceTQ, tq)
+				}
+			}
+		}
+	}
+	keys := make([]string, 0, len(links))
+	for k := range links {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]RawLink, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *links[k])
+	}
+	return out
+}
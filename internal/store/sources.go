@@ -0,0 +1,39 @@
+package store
+
+// mergeSources combines the data of several sources. The first source
+// listing a node_id wins, links are deduplicated by their endpoints and
+// the newest source timestamp is kept.
+func mergeSources(parts []*MeshviewerData) *MeshviewerData {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	merged := &MeshviewerData{}
+	seenNodes := make(map[string]bool)
+	seenLinks := make(map[string]bool)
+	var newest string
+	for _, p := range parts {
+		merged.Skipped += p.Skipped
+		if ts, ok := ParseTimestamp(p.Timestamp); ok {
+			if cur, ok := ParseTimestamp(newest); !ok || ts.After(cur) {
+				newest = p.Timestamp
+			}
+		}
+		for _, n := range p.Nodes {
+			if n.NodeID == "" || seenNodes[n.NodeID] {
+				continue
+			}
+			seenNodes[n.NodeID] = true
+			merged.Nodes = append(merged.Nodes, n)
+		}
+		for _, l := range p.Links {
+			lk := l.Source + ">" + l.Target
+			if seenLinks[lk] {
+				continue
+			}
+			seenLinks[lk] = true
+			merged.Links = append(merged.Links, l)
+		}
+	}
+	merged.Timestamp = newest
+	return merged
+}
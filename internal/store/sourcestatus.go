@@ -0,0 +1,149 @@
+package store
+
+import (
+	"io"
+	"sort"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/metrics"
+)
+
+// SourceStatus describes the recent fetches of one data source URL.
+type SourceStatus struct {
+	Source              string     `json:"source,omitempty"`
+	URL                 string     `json:"url"`
+	InUse               bool       `json:"in_use"` // served the current data of its source
+	LastAttempt         *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	DurationMS          int64      `json:"duration_ms"`
+	Bytes               int64      `json:"bytes"` // decompressed payload size
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+
+	// Totals since start, for /metrics.
+	Fetches  int64 `json:"-"`
+	Failures int64 `json:"-"`
+}
+
+// sourceLocked returns the status entry of url, creating it.
+func (s *Store) sourceLocked(url string) *SourceStatus {
+	if s.sources == nil {
+		s.sources = make(map[string]*SourceStatus)
+	}
+	st := s.sources[url]
+	if st == nil {
+		st = &SourceStatus{URL: url}
+		s.sources[url] = st
+	}
+	return st
+}
+
+// RecordFetch records the outcome of a fetch that took d: an error
+// increments the consecutive failure count, success resets it.
+func (s *Store) RecordFetch(url string, d time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	s.fetches.Observe(d.Seconds(), result)
+
+	now := time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.sourceLocked(url)
+	st.LastAttempt = &now
+	st.Fetches++
+	if err != nil {
+		st.Failures++
+		st.ConsecutiveFailures++
+		st.LastError = err.Error()
+		st.LastErrorAt = &now
+		return
+	}
+	st.ConsecutiveFailures = 0
+	st.LastSuccess = &now
+}
+
+func (s *Store) recordTransfer(url string, d time.Duration, bytes int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.sourceLocked(url)
+	st.DurationMS = d.Milliseconds()
+	st.Bytes = bytes
+}
+
+// markInUse flags url as the one whose data src currently contributes;
+// an empty url means none did.
+func (s *Store) markInUse(src config.Source, url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range src.URL {
+		st := s.sourceLocked(u)
+		st.Source = src.Name
+		st.InUse = u == url
+	}
+}
+
+// SourceFailures returns the consecutive failure count of every source
+// whose last fetch failed.
+func (s *Store) SourceFailures() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]int)
+	for url, st := range s.sources {
+		if st.ConsecutiveFailures > 0 {
+			out[url] = st.ConsecutiveFailures
+		}
+	}
+	return out
+}
+
+// FetchDurations is the histogram of upstream fetch durations.
+func (s *Store) FetchDurations() *metrics.Histogram {
+	return s.fetches
+}
+
+// AllSourceStatuses returns the status of every URL fetched so far, sorted
+// by URL. Unlike SourceStatuses it covers federation sources too.
+func (s *Store) AllSourceStatuses() []SourceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]SourceStatus, 0, len(s.sources))
+	for _, st := range s.sources {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].URL < out[j].URL })
+	return out
+}
+
+// SourceStatuses returns the status of every configured source URL in
+// configuration order, including URLs not fetched yet.
+func (s *Store) SourceStatuses() []SourceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []SourceStatus
+	for _, src := range s.Cfg().Sources {
+		for _, u := range src.URL {
+			st := SourceStatus{Source: src.Name, URL: u}
+			if cur := s.sources[u]; cur != nil {
+				st = *cur
+			}
+			out = append(out, st)
+		}
+	}
+	return out
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
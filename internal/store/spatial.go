@@ -0,0 +1,46 @@
+package store
+
+import "math"
+
+// spatialCell is the grid cell size in degrees (about 11 km north-south).
+const spatialCell = 0.1
+
+type cellKey struct{ lat, lng int }
+
+// SpatialIndex is a uniform grid over located nodes for bounding-box queries.
+type SpatialIndex struct {
+	cells map[cellKey][]*Node
+}
+
+// NewSpatialIndex indexes all nodes that have a location.
+func NewSpatialIndex(nodes []*Node) *SpatialIndex {
+	idx := &SpatialIndex{cells: make(map[cellKey][]*Node)}
+	for _, n := range nodes {
+		if n.Lat == nil {
+			continue
+		}
+		k := cellOf(*n.Lat, *n.Lng)
+		idx.cells[k] = append(idx.cells[k], n)
+	}
+	return idx
+}
+
+func cellOf(lat, lng float64) cellKey {
+	return cellKey{int(math.Floor(lat / spatialCell)), int(math.Floor(lng / spatialCell))}
+}
+
+// Within returns the nodes inside the bounding box.
+func (idx *SpatialIndex) Within(south, west, north, east float64) []*Node {
+	lo, hi := cellOf(south, west), cellOf(north, east)
+	var out []*Node
+	for la := lo.lat; la <= hi.lat; la++ {
+		for ln := lo.lng; ln <= hi.lng; ln++ {
+			for _, n := range idx.cells[cellKey{la, ln}] {
+				if *n.Lat >= south && *n.Lat <= north && *n.Lng >= west && *n.Lng <= east {
+					out = append(out, n)
+				}
+			}
+		}
+	}
+	return out
+}
@@ -1,19 +1,25 @@
 package store
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"math"
-	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/flight"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/hidden"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/metrics"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/respondd"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/trace"
 )
 
 // FlexBool handles JSON booleans that may be encoded as bool, string ("1"/"0"/""), or number.
@@ -28,7 +34,12 @@ func (fb *FlexBool) UnmarshalJSON(data []byte) error {
 	case bool:
 		*fb = FlexBool(v)
 	case string:
-		*fb = FlexBool(v == "true" || v == "1" || v == "yes")
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "1", "yes", "on", "online":
+			*fb = true
+		default:
+			*fb = false
+		}
 	case float64:
 		*fb = FlexBool(v != 0)
 	default:
@@ -49,9 +60,13 @@ func (fi *FlexInt) UnmarshalJSON(data []byte) error {
 	case float64:
 		*fi = FlexInt(int(v))
 	case string:
-		n := 0
-		fmt.Sscanf(v, "%d", &n)
-		*fi = FlexInt(n)
+		f := 0.0
+		fmt.Sscanf(strings.TrimSpace(v), "%g", &f)
+		*fi = FlexInt(int(f))
+	case bool:
+		if v {
+			*fi = 1
+		}
 	default:
 		*fi = 0
 	}
@@ -71,7 +86,7 @@ func (ff *FlexFloat64) UnmarshalJSON(data []byte) error {
 		*ff = FlexFloat64(v)
 	case string:
 		f := 0.0
-		fmt.Sscanf(v, "%f", &f)
+		fmt.Sscanf(strings.TrimSpace(v), "%g", &f)
 		*ff = FlexFloat64(f)
 	case bool:
 		if v {
@@ -83,34 +98,66 @@ func (ff *FlexFloat64) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// FlexTime handles JSON timestamps that may be encoded as a string or as a
+// number, such as a unix epoch or seconds since boot. Numbers keep their
+// decimal text; parsing is left to ParseTimestamp.
+type FlexTime string
+
+func (ft *FlexTime) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case string:
+		*ft = FlexTime(v)
+	case json.Number:
+		*ft = FlexTime(v.String())
+	default:
+		*ft = ""
+	}
+	return nil
+}
+
 // --- Raw JSON from meshviewer.json ---
 
 type MeshviewerData struct {
 	Timestamp string    `json:"timestamp"`
 	Nodes     []RawNode `json:"nodes"`
 	Links     []RawLink `json:"links,omitempty"`
+
+	// Skipped counts node entries that could not be decoded.
+	Skipped int `json:"-"`
 }
 
 type RawNode struct {
-	Firstseen   string       `json:"firstseen"`
-	Lastseen    string       `json:"lastseen"`
-	IsOnline    FlexBool     `json:"is_online"`
-	IsGateway   FlexBool     `json:"is_gateway"`
-	Clients     FlexInt      `json:"clients"`
-	ClientsW24  FlexInt      `json:"clients_wifi24"`
-	ClientsW5   FlexInt      `json:"clients_wifi5"`
-	ClientsOth  FlexInt      `json:"clients_other"`
-	RootfsUsage FlexFloat64  `json:"rootfs_usage"`
-	LoadAvg     FlexFloat64  `json:"loadavg"`
-	MemoryUsage FlexFloat64  `json:"memory_usage"`
-	Uptime      string       `json:"uptime"`
-	GwNexthop   string       `json:"gateway_nexthop"`
-	Gateway     string       `json:"gateway"`
-	Gateway6    string       `json:"gateway6"`
-	NodeID      string       `json:"node_id"`
-	MAC         string       `json:"mac"`
-	Addresses   []string     `json:"addresses"`
-	Domain      string       `json:"domain"`
+	Firstseen   FlexTime    `json:"firstseen"`
+	Lastseen    FlexTime    `json:"lastseen"`
+	IsOnline    FlexBool    `json:"is_online"`
+	IsGateway   FlexBool    `json:"is_gateway"`
+	Clients     FlexInt     `json:"clients"`
+	ClientsW24  FlexInt     `json:"clients_wifi24"`
+	ClientsW5   FlexInt     `json:"clients_wifi5"`
+	ClientsOth  FlexInt     `json:"clients_other"`
+	RootfsUsage FlexFloat64 `json:"rootfs_usage"`
+	LoadAvg     FlexFloat64 `json:"loadavg"`
+	MemoryUsage FlexFloat64 `json:"memory_usage"`
+	Uptime      FlexTime    `json:"uptime"`
+	GwNexthop   string      `json:"gateway_nexthop"`
+	Gateway     string      `json:"gateway"`
+	Gateway6    string      `json:"gateway6"`
+	NodeID      string      `json:"node_id"`
+	MAC         string      `json:"mac"`
+	Addresses   []string    `json:"addresses"`
+	Domain      string      `json:"domain"`
+	// SiteCode, DomainCode and IsUplink are set by sources with nodeinfo;
+	// Domain is the domain code there, or the site code without domains.
+	SiteCode    string       `json:"site_code,omitempty"`
+	DomainCode  string       `json:"domain_code,omitempty"`
+	IsUplink    FlexBool     `json:"vpn,omitempty"`
+	Role        string       `json:"role,omitempty"` // Gluon system.role
 	Hostname    string       `json:"hostname"`
 	Owner       string       `json:"owner"`
 	Location    *RawLocation `json:"location,omitempty"`
@@ -118,11 +165,35 @@ type RawNode struct {
 	Autoupdater RawAutoUpd   `json:"autoupdater"`
 	Nproc       FlexInt      `json:"nproc"`
 	Model       string       `json:"model"`
+
+	// Airtime and Traffic are only filled by sources reading respondd
+	// statistics; meshviewer.json carries neither.
+	Airtime []Airtime                 `json:"airtime,omitempty"`
+	Traffic map[string]TrafficCounter `json:"traffic,omitempty"`
+
+	Wireless *Wireless `json:"wireless,omitempty"`
 }
 
 type RawLocation struct {
-	Longitude float64 `json:"longitude"`
-	Latitude  float64 `json:"latitude"`
+	Longitude float64      `json:"longitude"`
+	Latitude  float64      `json:"latitude"`
+	Altitude  *FlexFloat64 `json:"altitude,omitempty"`
+}
+
+// UnmarshalJSON accepts coordinates encoded as numbers or strings.
+func (l *RawLocation) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Longitude FlexFloat64  `json:"longitude"`
+		Latitude  FlexFloat64  `json:"latitude"`
+		Altitude  *FlexFloat64 `json:"altitude"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	l.Longitude = float64(aux.Longitude)
+	l.Latitude = float64(aux.Latitude)
+	l.Altitude = aux.Altitude
+	return nil
 }
 
 type RawFirmware struct {
@@ -138,16 +209,183 @@ type RawAutoUpd struct {
 	Branch  string   `json:"branch"`
 }
 
+// Airtime is the utilisation of one radio as shares of its active time.
+type Airtime struct {
+	Frequency int     `json:"frequency"` // MHz
+	ChanUtil  float64 `json:"chan_util"`
+	RxUtil    float64 `json:"rx_util"`
+	TxUtil    float64 `json:"tx_util"`
+}
+
+// Wireless holds the configured channel and transmit power (dBm) per band.
+type Wireless struct {
+	Channel24 int `json:"channel24,omitempty"`
+	TxPower24 int `json:"txpower24,omitempty"`
+	Channel5  int `json:"channel5,omitempty"`
+	TxPower5  int `json:"txpower5,omitempty"`
+
+	// airtime24 and airtime5 are the channel utilisations meshviewer.json
+	// carries in the same block; they end up in Node.Airtime.
+	airtime24, airtime5 float64
+}
+
+// UnmarshalJSON accepts the nodeinfo names (channel24, txpower24) as well as
+// the short ones some meshviewer generators write (chan2, txpower2, chan5).
+func (w *Wireless) UnmarshalJSON(data []byte) error {
+	var aux map[string]FlexFloat64
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	first := func(keys ...string) float64 {
+		for _, k := range keys {
+			if v, ok := aux[k]; ok {
+				return float64(v)
+			}
+		}
+		return 0
+	}
+	*w = Wireless{
+		Channel24: int(first("channel24", "chan24", "chan2")),
+		TxPower24: int(first("txpower24", "txpower2")),
+		Channel5:  int(first("channel5", "chan5")),
+		TxPower5:  int(first("txpower5")),
+		airtime24: first("airtime24", "airtime2"),
+		airtime5:  first("airtime5"),
+	}
+	return nil
+}
+
+// airtime turns the utilisations of the wireless block into radios,
+// deriving the frequency from the configured channel.
+func (w *Wireless) airtime() []Airtime {
+	var out []Airtime
+	for _, r := range []struct {
+		util float64
+		freq int
+	}{
+		{w.airtime24, 2407 + 5*w.Channel24},
+		{w.airtime5, 5000 + 5*w.Channel5},
+	} {
+		if r.util <= 0 {
+			continue
+		}
+		if r.util > 1 {
+			r.util /= 100 // percent
+		}
+		out = append(out, Airtime{Frequency: r.freq, ChanUtil: r.util})
+	}
+	return out
+}
+
+// maxChanUtil is the channel utilisation of the busiest radio.
+func maxChanUtil(radios []Airtime) float64 {
+	var m float64
+	for _, a := range radios {
+		m = max(m, a.ChanUtil)
+	}
+	return m
+}
+
+// TrafficCounter is the traffic of one kind since boot.
+type TrafficCounter struct {
+	Bytes   int64 `json:"bytes"`
+	Packets int64 `json:"packets"`
+}
+
 type RawLink struct {
 	Source   string  `json:"source"`
 	Target   string  `json:"target"`
 	SourceTQ float64 `json:"source_tq"`
 	TargetTQ float64 `json:"target_tq"`
 	Type     string  `json:"type"`
+
+	// sourceIdx and targetIdx are one past the node array positions
+	// ffmap-d3 nodes.json uses instead of IDs, 0 for endpoints given by
+	// ID. DecodeMeshviewerStream resolves them.
+	sourceIdx, targetIdx int
+}
+
+// UnmarshalJSON accepts TQ values encoded as numbers or strings, and the
+// index endpoints and "quality" of ffmap-d3 nodes.json.
+func (l *RawLink) UnmarshalJSON(data []byte) error {
+	var aux struct {
+		Source   json.RawMessage `json:"source"`
+		Target   json.RawMessage `json:"target"`
+		SourceTQ FlexFloat64     `json:"source_tq"`
+		TargetTQ FlexFloat64     `json:"target_tq"`
+		Type     string          `json:"type"`
+		Quality  string          `json:"quality"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*l = RawLink{
+		SourceTQ: float64(aux.SourceTQ),
+		TargetTQ: float64(aux.TargetTQ),
+		Type:     aux.Type,
+	}
+	var err error
+	if l.Source, l.sourceIdx, err = linkEndpoint(aux.Source); err != nil {
+		return err
+	}
+	if l.Target, l.targetIdx, err = linkEndpoint(aux.Target); err != nil {
+		return err
+	}
+	if aux.Quality != "" && l.SourceTQ == 0 && l.TargetTQ == 0 {
+		l.SourceTQ, l.TargetTQ = parseLinkQuality(aux.Quality)
+	}
+	return nil
+}
+
+// linkEndpoint reads a link endpoint given as node ID or array index,
+// returning the index one-based.
+func linkEndpoint(raw json.RawMessage) (string, int, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", 0, nil
+	}
+	if raw[0] == '"' {
+		var id string
+		err := json.Unmarshal(raw, &id)
+		return id, 0, err
+	}
+	var idx int
+	if err := json.Unmarshal(raw, &idx); err != nil || idx < 0 {
+		return "", 0, fmt.Errorf("invalid link endpoint %s", raw)
+	}
+	return "", idx + 1, nil
+}
+
+// parseLinkQuality converts ffmap-d3 link quality, the batman-adv
+// "1.000, 1.230" pair of inverse TQs per direction, to TQs between 0
+// and 1. A single value applies to both directions.
+func parseLinkQuality(q string) (float64, float64) {
+	tq := func(s string) float64 {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || v < 1 {
+			return 0
+		}
+		return 1 / v
+	}
+	src, dst, ok := strings.Cut(q, ",")
+	if !ok {
+		dst = src
+	}
+	return tq(src), tq(dst)
 }
 
 // --- Processed API types ---
 
+// DefaultRole is the Gluon role of nodes that do not report one.
+const DefaultRole = "node"
+
+// RoleOrDefault returns the node's role, DefaultRole when it has none.
+func (n *Node) RoleOrDefault() string {
+	if n.Role == "" {
+		return DefaultRole
+	}
+	return n.Role
+}
+
 type Node struct {
 	NodeID      string   `json:"node_id"`
 	Hostname    string   `json:"hostname"`
@@ -159,6 +397,10 @@ type Node struct {
 	ClientsOth  int      `json:"clients_other"`
 	Domain      string   `json:"domain"`
 	DomainName  string   `json:"domain_name,omitempty"`
+	SiteCode    string   `json:"site_code,omitempty"`
+	DomainCode  string   `json:"domain_code,omitempty"`
+	IsUplink    bool     `json:"is_uplink,omitempty"` // nodeinfo vpn flag
+	Role        string   `json:"role,omitempty"`      // node, uplink, offloader, ...
 	Community   string   `json:"community,omitempty"`
 	Communities []string `json:"communities,omitempty"`
 	Model       string   `json:"model,omitempty"`
@@ -170,6 +412,7 @@ type Node struct {
 	MAC         string   `json:"mac"`
 	Lat         *float64 `json:"lat,omitempty"`
 	Lng         *float64 `json:"lng,omitempty"`
+	Alt         *float64 `json:"alt,omitempty"`
 	Uptime      string   `json:"uptime,omitempty"`
 	LoadAvg     float64  `json:"load_avg"`
 	MemUsage    float64  `json:"mem_usage"`
@@ -181,6 +424,16 @@ type Node struct {
 	Addresses   []string `json:"addresses,omitempty"`
 	ImageName   string   `json:"image_name,omitempty"`
 	Neighbours  []string `json:"neighbours,omitempty"`
+	Placeholder bool     `json:"placeholder,omitempty"`
+
+	Airtime  []Airtime                 `json:"airtime,omitempty"`
+	Traffic  map[string]TrafficCounter `json:"traffic,omitempty"`
+	Wireless *Wireless                 `json:"wireless,omitempty"`
+	// AirtimeUtil is the channel utilisation of the busiest radio, to spot
+	// overloaded nodes without looking at each radio.
+	AirtimeUtil float64 `json:"airtime_util,omitempty"`
+
+	DuplicateHostname bool `json:"duplicate_hostname,omitempty"`
 }
 
 type Link struct {
@@ -189,7 +442,11 @@ type Link struct {
 	SourceTQ float64 `json:"source_tq"`
 	TargetTQ float64 `json:"target_tq"`
 	Type     string  `json:"type"`
+	RawType  string  `json:"raw_type,omitempty"`
 	Distance float64 `json:"distance,omitempty"`
+	Flaps    int     `json:"flaps,omitempty"`
+	Flapping bool    `json:"flapping,omitempty"`
+	Orphan   bool    `json:"orphan,omitempty"`
 }
 
 type Stats struct {
@@ -202,7 +459,19 @@ type Stats struct {
 	Firmwares     map[string]int `json:"firmwares"`
 	GluonVersions map[string]int `json:"gluon_versions"`
 	Communities   map[string]int `json:"communities"`
-	Timestamp     string         `json:"timestamp"`
+	// SiteCodes and DomainCodes count the raw codes of nodes that report
+	// them, Uplinks the nodes with the nodeinfo vpn flag.
+	SiteCodes   map[string]int `json:"site_codes"`
+	DomainCodes map[string]int `json:"domain_codes"`
+	Uplinks     int            `json:"uplinks"`
+	// Roles counts Gluon node roles; nodes without one count as "node".
+	Roles     map[string]int `json:"roles"`
+	Timestamp string         `json:"timestamp"`
+
+	// DataAgeSeconds is the age of the upstream data; Stale is set once
+	// it exceeds dataStaleAfter. Both are recomputed when served.
+	DataAgeSeconds int64 `json:"data_age_seconds"`
+	Stale          bool  `json:"stale"`
 }
 
 type Snapshot struct {
@@ -211,6 +480,15 @@ type Snapshot struct {
 	Links     []Link           `json:"links"`
 	Stats     Stats            `json:"stats"`
 	Timestamp time.Time        `json:"timestamp"`
+
+	// Orphans counts links that referenced a node missing from the data.
+	Orphans DiagnosticCount `json:"-"`
+	// DuplicateHostnames lists hostnames used by several nodes in a domain.
+	DuplicateHostnames []DuplicateHostname `json:"-"`
+	// MeshHealth holds graph metrics computed once per refresh.
+	MeshHealth *MeshHealth `json:"-"`
+	// Hidden counts nodes left out because they are on the hide list.
+	Hidden int `json:"-"`
 }
 
 // --- SSE diff types ---
@@ -241,16 +519,54 @@ type SSEBroadcaster interface {
 // --- Store ---
 
 type Store struct {
-	Cfg      *config.Config
+	live     *config.Live
 	mu       sync.RWMutex
 	snapshot *Snapshot
-	client   *http.Client
+	setAt    time.Time // when snapshot was published
+	client   *fetch.Client
+	flaps    *flapTracker
+	warnings map[string][]string
+	vpnPeers map[string]VPNPeer
+	sources  map[string]*SourceStatus // keyed by URL
+	fetches  *metrics.Histogram       // fetch durations by result
+	interned *interner
+	hide     *hidden.List // admin-managed hide list, see SetHideList
+
+	respondMu  sync.Mutex
+	responders map[string]*respondd.Collector // keyed by respondd:// URL
+
+	listeners []func(*Snapshot)
+	refreshes flight.Group
+	reloaded  chan struct{} // signalled by ConfigReloaded
+
+	// lastGood holds the data of each source from its last successful
+	// fetch, keyed by its URLs. Only refresh, which runs one at a time,
+	// uses it.
+	lastGood map[string]*MeshviewerData
+}
+
+// VPNPeer is the wireguard session state of a node as seen by a gateway.
+type VPNPeer struct {
+	PublicKey       string    `json:"public_key,omitempty"`
+	Gateway         string    `json:"gateway"`
+	LatestHandshake time.Time `json:"latest_handshake"`
+	HandshakeAge    int64     `json:"handshake_age"`
+	RxBytes         int64     `json:"rx_bytes"`
+	TxBytes         int64     `json:"tx_bytes"`
+	Healthy         bool      `json:"healthy"`
 }
 
-func New(cfg *config.Config) *Store {
+// New returns a store reading its settings from live. Keys that need a
+// restart are taken from the config current at this call.
+func New(live *config.Live) *Store {
+	cfg := live.Load()
 	return &Store{
-		Cfg:    cfg,
-		client: &http.Client{Timeout: 30 * time.Second},
+		live:     live,
+		client:   fetch.New(cfg),
+		flaps:    newFlapTracker(),
+		interned: newInterner(),
+		reloaded: make(chan struct{}, 1),
+		fetches:  metrics.NewHistogram(metrics.DefaultBuckets, "result"),
 		snapshot: &Snapshot{
 			Nodes: make(map[string]*Node),
 			Stats: Stats{
@@ -259,61 +575,272 @@ func New(cfg *config.Config) *Store {
 				Firmwares:     map[string]int{},
 				GluonVersions: map[string]int{},
 				Communities:   map[string]int{},
+				SiteCodes:     map[string]int{},
+				DomainCodes:   map[string]int{},
+				Roles:         map[string]int{},
 			},
 		},
 	}
 }
 
+// Cfg returns the current config, which may change with each reload.
+func (s *Store) Cfg() *config.Config {
+	return s.live.Load()
+}
+
 func (s *Store) GetSnapshot() *Snapshot {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.snapshot
 }
 
+// SetSnapshot publishes a new snapshot, updating link flap state first,
+// and then notifies snapshot listeners.
 func (s *Store) SetSnapshot(snap *Snapshot) {
+	s.flaps.observe(snap.Links, time.Now(), s.Cfg().FlapWindowDuration, s.Cfg().FlapThreshold)
 	s.mu.Lock()
 	s.snapshot = snap
+	s.setAt = time.Now()
+	listeners := s.listeners
 	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(snap)
+	}
+}
+
+// CurrentStats returns the statistics of the current snapshot with the data
+// age as of now. The last good snapshot keeps being served while fetches
+// fail or upstream stops advancing its timestamp, so age and staleness are
+// derived from the time of the data rather than of the last refresh.
+func (s *Store) CurrentStats() Stats {
+	s.mu.RLock()
+	snap, setAt := s.snapshot, s.setAt
+	s.mu.RUnlock()
+	st := snap.Stats
+	st.DataAgeSeconds, st.Stale = s.dataAge(snap, setAt, time.Now())
+	return st
+}
+
+func (s *Store) dataAge(snap *Snapshot, setAt, now time.Time) (int64, bool) {
+	ref := snap.Timestamp
+	if ref.IsZero() {
+		ref = setAt
+	}
+	if ref.IsZero() {
+		return 0, false
+	}
+	age := now.Sub(ref)
+	if age < 0 {
+		age = 0
+	}
+	stale := s.Cfg().DataStaleAfterDuration > 0 && age > s.Cfg().DataStaleAfterDuration
+	return int64(age.Seconds()), stale
 }
 
+// OnSnapshot registers fn to be called with every newly published snapshot.
+// Listeners run synchronously on the refresh goroutine and must be quick.
+func (s *Store) OnSnapshot(fn func(*Snapshot)) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, fn)
+	s.mu.Unlock()
+}
+
+// SetSourceWarnings replaces the per-source parse warnings shown in diagnostics.
+func (s *Store) SetSourceWarnings(w map[string][]string) {
+	s.mu.Lock()
+	s.warnings = w
+	s.mu.Unlock()
+}
+
+// SourceWarnings returns the parse warnings recorded during the last refresh.
+func (s *Store) SourceWarnings() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.warnings
+}
+
+// SetVPNPeers replaces the wireguard peer statistics, keyed by node ID.
+func (s *Store) SetVPNPeers(peers map[string]VPNPeer) {
+	s.mu.Lock()
+	s.vpnPeers = peers
+	s.mu.Unlock()
+}
+
+// VPNPeer returns the wireguard peer statistics of a node, if known.
+func (s *Store) VPNPeer(nodeID string) (VPNPeer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.vpnPeers[nodeID]
+	return p, ok
+}
+
+// Refresh fetches all configured sources, merges them and publishes the
+// result. A source that fails entirely is merged with the data of its last
+// successful fetch, so its nodes do not drop off the map. Calls
+// overlapping a running refresh wait for it instead of starting another.
 func (s *Store) Refresh() error {
-	resp, err := s.client.Get(s.Cfg.DataURL)
+	_, err, _ := s.refreshes.Do("refresh", func() (interface{}, error) {
+		return nil, s.refresh()
+	})
+	return err
+}
+
+func (s *Store) refresh() (err error) {
+	ctx, span := trace.Start(context.Background(), "refresh", "sources", strconv.Itoa(len(s.Cfg().Sources)))
+	defer func() { span.SetError(err); span.End() }()
+
+	warnings := make(map[string][]string)
+	var parts []*MeshviewerData
+	var lastErr error
+	good := make(map[string]*MeshviewerData, len(s.Cfg().Sources))
+	for _, src := range s.Cfg().Sources {
+		key := strings.Join(src.URL, " ")
+		data, err := s.fetchSource(ctx, src, warnings)
+		if err != nil {
+			lastErr = err
+			if data = s.lastGood[key]; data == nil {
+				log.Printf("Source %s failed: %v", src.Name, err)
+				continue
+			}
+			log.Printf("Source %s failed, keeping its last data: %v", src.Name, err)
+		} else if src.Domain != "" {
+			for i := range data.Nodes {
+				data.Nodes[i].Domain = src.Domain
+			}
+		}
+		good[key] = data
+		parts = append(parts, data)
+	}
+	// Sources removed by a reload are forgotten.
+	s.lastGood = good
+	s.SetSourceWarnings(warnings)
+	if len(parts) == 0 {
+		return lastErr
+	}
+
+	_, ps := trace.Start(ctx, "process")
+	snap := s.ProcessData(mergeSources(parts))
+	ps.SetAttr("nodes", strconv.Itoa(len(snap.NodeList)))
+	ps.End()
+	_, ps = trace.Start(ctx, "publish")
+	s.SetSnapshot(snap)
+	ps.End()
+
+	return nil
+}
+
+// fetchSource tries the URLs of a source in order and returns the first
+// that succeeds with current data. When every URL fails or is stale, the
+// freshest stale data is used rather than none.
+func (s *Store) fetchSource(ctx context.Context, src config.Source, warnings map[string][]string) (*MeshviewerData, error) {
+	var fallback *MeshviewerData
+	var fallbackTS time.Time
+	var fallbackURL string
+	var lastErr error
+	for i, url := range src.URL {
+		_, span := trace.StartClient(ctx, "fetch", "source", src.Name, "url", url)
+		start := time.Now()
+		data, err := s.fetch(url)
+		s.RecordFetch(url, time.Since(start), err)
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			warnings[url] = []string{err.Error()}
+			lastErr = err
+			continue
+		}
+		warnings[url] = CheckRawData(data)
+		if ts, stale := s.isStale(data); stale {
+			warnings[url] = append(warnings[url], fmt.Sprintf("stale data from %s", ts.Format(time.RFC3339)))
+			if fallback == nil || ts.After(fallbackTS) {
+				fallback, fallbackTS, fallbackURL = data, ts, url
+			}
+			continue
+		}
+		if i > 0 {
+			log.Printf("Source %s: using fallback %s", src.Name, url)
+		}
+		s.markInUse(src, url)
+		return data, nil
+	}
+	if fallback == nil {
+		s.markInUse(src, "")
+		return nil, lastErr
+	}
+	log.Printf("Source %s: all URLs stale, using data from %s", src.Name, fallbackTS.Format(time.RFC3339))
+	s.markInUse(src, fallbackURL)
+	return fallback, nil
+}
+
+// isStale reports whether data carries a timestamp older than
+// DataStaleAfter. Data without a usable timestamp is never stale.
+func (s *Store) isStale(data *MeshviewerData) (time.Time, bool) {
+	ts, ok := ParseTimestamp(data.Timestamp)
+	if !ok || s.Cfg().DataStaleAfterDuration <= 0 {
+		return ts, false
+	}
+	return ts, time.Since(ts) > s.Cfg().DataStaleAfterDuration
+}
+
+// Probe fetches and parses one data URL without touching the snapshot.
+func (s *Store) Probe(url string) (*MeshviewerData, error) {
+	return s.fetch(url)
+}
+
+func (s *Store) fetch(url string) (*MeshviewerData, error) {
+	switch {
+	case strings.HasPrefix(url, "respondd://"):
+		return s.fetchRespondd(url)
+	case strings.HasPrefix(url, "yanic://"):
+		return s.fetchYanicState(url)
+	case strings.HasPrefix(url, "yanic+"):
+		return s.fetchYanicInflux(url)
+	}
+	start := time.Now()
+	resp, err := s.client.Get(url)
 	if err != nil {
-		return fmt.Errorf("fetching data: %w", err)
+		return nil, fmt.Errorf("fetching data: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return fmt.Errorf("unexpected status %d from data source", resp.StatusCode)
+		return nil, fmt.Errorf("unexpected status %d from data source", resp.StatusCode)
 	}
 
-	const maxBodySize = 20 * 1024 * 1024 // 20 MB
-	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	body := &countingReader{r: fetch.LimitReader(resp.Body, s.Cfg().MaxDataBytes)}
+	raw, err := DecodeMeshviewerStream(body, nil)
+	s.recordTransfer(url, time.Since(start), body.n)
 	if err != nil {
-		return fmt.Errorf("reading body: %w", err)
+		return nil, fmt.Errorf("parsing JSON: %w", err)
 	}
+	return raw, nil
+}
 
-	var raw MeshviewerData
-	if err := json.Unmarshal(body, &raw); err != nil {
-		return fmt.Errorf("parsing JSON: %w", err)
+// ConfigReloaded tells the refresh loop that the config changed, so it picks up
+// a new refresh interval without waiting for the old one to elapse.
+func (s *Store) ConfigReloaded() {
+	select {
+	case s.reloaded <- struct{}{}:
+	default:
 	}
+}
 
-	snap := s.ProcessData(&raw)
-
-	s.mu.Lock()
-	s.snapshot = snap
-	s.mu.Unlock()
-
-	return nil
+// Reloaded is signalled after ConfigReloaded, for refresh loops built
+// around the store.
+func (s *Store) Reloaded() <-chan struct{} {
+	return s.reloaded
 }
 
 func (s *Store) RunRefreshLoop(ctx context.Context, hub SSEBroadcaster) {
-	ticker := time.NewTicker(s.Cfg.RefreshDuration)
+	ticker := time.NewTicker(s.Cfg().RefreshDuration)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-s.reloaded:
+			ticker.Reset(s.Cfg().RefreshDuration)
 		case <-ticker.C:
 			old := s.GetSnapshot()
 			if err := s.Refresh(); err != nil {
@@ -334,6 +861,12 @@ func (s *Store) RunRefreshLoop(ctx context.Context, hub SSEBroadcaster) {
 }
 
 func (s *Store) ProcessData(raw *MeshviewerData) *Snapshot {
+	return s.ProcessDataWithNames(raw, s.Cfg().DomainNames)
+}
+
+// ProcessDataWithNames is ProcessData with an explicit domain name map,
+// used by federation mode to add community names to the configured ones.
+func (s *Store) ProcessDataWithNames(raw *MeshviewerData, domainNames map[string]string) *Snapshot {
 	nodes := make(map[string]*Node, len(raw.Nodes))
 	nodeList := make([]*Node, 0, len(raw.Nodes))
 
@@ -343,41 +876,70 @@ func (s *Store) ProcessData(raw *MeshviewerData) *Snapshot {
 		Firmwares:     make(map[string]int),
 		GluonVersions: make(map[string]int),
 		Communities:   make(map[string]int),
+		SiteCodes:     make(map[string]int),
+		DomainCodes:   make(map[string]int),
+		Roles:         make(map[string]int),
 		Timestamp:     raw.Timestamp,
 	}
 
+	in := s.interned
+	in.rotate()
+
+	hide := s.hiddenSet()
+	var hiddenIDs map[string]bool
+
+	now := time.Now()
 	for i := range raw.Nodes {
 		rn := &raw.Nodes[i]
+		if len(hide) > 0 && (hide[hidden.Normalize(rn.NodeID)] || (rn.MAC != "" && hide[hidden.Normalize(rn.MAC)])) {
+			if hiddenIDs == nil {
+				hiddenIDs = make(map[string]bool)
+			}
+			hiddenIDs[rn.NodeID] = true
+			continue
+		}
+		online := s.isOnline(rn, now)
 		n := &Node{
 			NodeID:      rn.NodeID,
 			Hostname:    rn.Hostname,
-			IsOnline:    bool(rn.IsOnline),
+			IsOnline:    online,
 			IsGateway:   bool(rn.IsGateway),
 			Clients:     int(rn.Clients),
 			ClientsW24:  int(rn.ClientsW24),
 			ClientsW5:   int(rn.ClientsW5),
 			ClientsOth:  int(rn.ClientsOth),
-			Domain:      rn.Domain,
-			Model:       rn.Model,
-			Firmware:    rn.Firmware.Release,
-			FWBase:      rn.Firmware.Base,
+			Domain:      in.get(rn.Domain),
+			SiteCode:    in.get(rn.SiteCode),
+			DomainCode:  in.get(rn.DomainCode),
+			IsUplink:    bool(rn.IsUplink),
+			Role:        in.get(rn.Role),
+			Model:       in.get(rn.Model),
+			Firmware:    in.get(rn.Firmware.Release),
+			FWBase:      in.get(rn.Firmware.Base),
 			Autoupdater: bool(rn.Autoupdater.Enabled),
-			Branch:      rn.Autoupdater.Branch,
+			Branch:      in.get(rn.Autoupdater.Branch),
 			Owner:       rn.Owner,
 			MAC:         rn.MAC,
-			Uptime:      rn.Uptime,
+			Uptime:      normalizeUptime(string(rn.Uptime), now),
 			LoadAvg:     float64(rn.LoadAvg),
 			MemUsage:    float64(rn.MemoryUsage),
 			RootfsUsage: float64(rn.RootfsUsage),
-			Gateway:     rn.Gateway,
-			Firstseen:   rn.Firstseen,
-			Lastseen:    rn.Lastseen,
+			Gateway:     in.get(rn.Gateway),
+			Firstseen:   NormalizeTimestamp(string(rn.Firstseen)),
+			Lastseen:    NormalizeTimestamp(string(rn.Lastseen)),
 			Nproc:       int(rn.Nproc),
 			Addresses:   rn.Addresses,
-			ImageName:   rn.Firmware.ImageName,
+			ImageName:   in.get(rn.Firmware.ImageName),
+			Airtime:     rn.Airtime,
+			Traffic:     rn.Traffic,
+			Wireless:    rn.Wireless,
+		}
+		if len(n.Airtime) == 0 && rn.Wireless != nil {
+			n.Airtime = rn.Wireless.airtime()
 		}
+		n.AirtimeUtil = round3(maxChanUtil(n.Airtime))
 
-		if dn, ok := s.Cfg.DomainNames[rn.Domain]; ok {
+		if dn, ok := domainNames[rn.Domain]; ok {
 			n.DomainName = dn
 		}
 
@@ -385,26 +947,43 @@ func (s *Store) ProcessData(raw *MeshviewerData) *Snapshot {
 			math.Abs(rn.Location.Latitude) < 90 &&
 			math.Abs(rn.Location.Longitude) < 180 &&
 			(rn.Location.Latitude != 0 || rn.Location.Longitude != 0) {
-			lat := rn.Location.Latitude
-			lng := rn.Location.Longitude
+			lat, lng := snapToGrid(rn.Location.Latitude, rn.Location.Longitude, s.locationGrid(rn.Domain))
 			n.Lat = &lat
 			n.Lng = &lng
+			if rn.Location.Altitude != nil {
+				alt := float64(*rn.Location.Altitude)
+				n.Alt = &alt
+			}
 		}
 
 		nodes[rn.NodeID] = n
 		nodeList = append(nodeList, n)
 
 		stats.TotalNodes++
-		if bool(rn.IsOnline) {
+		if online {
 			stats.OnlineNodes++
 			stats.TotalClients += int(rn.Clients)
 		}
 		if bool(rn.IsGateway) {
 			stats.Gateways++
 		}
+		if bool(rn.IsUplink) {
+			stats.Uplinks++
+		}
+		if rn.SiteCode != "" {
+			stats.SiteCodes[rn.SiteCode]++
+		}
+		if rn.DomainCode != "" {
+			stats.DomainCodes[rn.DomainCode]++
+		}
+		if rn.Role != "" {
+			stats.Roles[rn.Role]++
+		} else {
+			stats.Roles[DefaultRole]++
+		}
 		if rn.Domain != "" {
 			dn := rn.Domain
-			if name, ok := s.Cfg.DomainNames[dn]; ok {
+			if name, ok := domainNames[dn]; ok {
 				dn = name
 			}
 			stats.Domains[dn]++
@@ -421,32 +1000,76 @@ func (s *Store) ProcessData(raw *MeshviewerData) *Snapshot {
 	}
 
 	// Process links & build neighbour lists
+	var orphans DiagnosticCount
 	links := make([]Link, 0, len(raw.Links))
 	for _, rl := range raw.Links {
+		if hiddenIDs[rl.Source] || hiddenIDs[rl.Target] {
+			continue // dropped, not orphaned, so nothing points at the node
+		}
 		l := Link{
 			Source:   rl.Source,
 			Target:   rl.Target,
 			SourceTQ: rl.SourceTQ,
 			TargetTQ: rl.TargetTQ,
-			Type:     rl.Type,
+			Type:     ClassifyLinkType(rl.Type),
+		}
+		if l.Type != rl.Type {
+			l.RawType = in.get(rl.Type)
 		}
 
 		sn, sok := nodes[rl.Source]
 		tn, tok := nodes[rl.Target]
+		if !sok || !tok {
+			orphans.add(rl.Source + " -> " + rl.Target)
+			switch s.Cfg().OrphanLinks {
+			case "drop":
+				continue
+			case "placeholder":
+				if !sok {
+					sn = placeholderNode(rl.Source)
+					nodes[rl.Source] = sn
+					nodeList = append(nodeList, sn)
+					sok = true
+				}
+				if !tok {
+					tn = placeholderNode(rl.Target)
+					nodes[rl.Target] = tn
+					nodeList = append(nodeList, tn)
+					tok = true
+				}
+			default:
+				l.Orphan = true
+			}
+		}
+		// Share the node's ID string instead of keeping the decoded copy.
+		if sok {
+			l.Source = sn.NodeID
+		}
+		if tok {
+			l.Target = tn.NodeID
+		}
 		if sok && tok && sn.Lat != nil && tn.Lat != nil {
 			l.Distance = Haversine(*sn.Lat, *sn.Lng, *tn.Lat, *tn.Lng)
 		}
 
 		if sok {
-			sn.Neighbours = AppendUnique(sn.Neighbours, rl.Target)
+			sn.Neighbours = AppendUnique(sn.Neighbours, l.Target)
 		}
 		if tok {
-			tn.Neighbours = AppendUnique(tn.Neighbours, rl.Source)
+			tn.Neighbours = AppendUnique(tn.Neighbours, l.Source)
 		}
 
 		links = append(links, l)
 	}
 
+	if hiddenIDs != nil {
+		for _, n := range nodeList {
+			if hiddenIDs[n.Gateway] {
+				n.Gateway = ""
+			}
+		}
+	}
+
 	sort.Slice(nodeList, func(i, j int) bool {
 		if nodeList[i].IsOnline != nodeList[j].IsOnline {
 			return nodeList[i].IsOnline
@@ -454,15 +1077,62 @@ func (s *Store) ProcessData(raw *MeshviewerData) *Snapshot {
 		return strings.ToLower(nodeList[i].Hostname) < strings.ToLower(nodeList[j].Hostname)
 	})
 
-	ts, _ := time.Parse(time.RFC3339, raw.Timestamp)
+	ts, _ := ParseTimestamp(raw.Timestamp)
 
-	return &Snapshot{
+	snap := &Snapshot{
 		Nodes:     nodes,
 		NodeList:  nodeList,
 		Links:     links,
 		Stats:     stats,
 		Timestamp: ts,
+		Orphans:   orphans,
+		Hidden:    len(hiddenIDs),
+	}
+	snap.Stats.DataAgeSeconds, snap.Stats.Stale = s.dataAge(snap, now, now)
+	markDuplicateHostnames(snap)
+	snap.MeshHealth = ComputeMeshHealth(snap)
+	return snap
+}
+
+// SetHideList sets the hide list managed through the admin API. Its nodes
+// are left out of every snapshot, like those of the hiddenNodes setting.
+func (s *Store) SetHideList(l *hidden.List) {
+	s.mu.Lock()
+	s.hide = l
+	s.mu.Unlock()
+}
+
+// hiddenSet returns the normalized IDs and MACs of all hidden nodes.
+func (s *Store) hiddenSet() map[string]bool {
+	s.mu.RLock()
+	l := s.hide
+	s.mu.RUnlock()
+	return l.Set(s.Cfg().HiddenNodes)
+}
+
+// IsHidden reports whether id, a node ID or MAC, is on the hide list or in
+// the hiddenNodes setting. Handlers that look up data by node ID outside
+// the snapshot check it, so a hide takes effect before the next refresh.
+func (s *Store) IsHidden(id string) bool {
+	return s.hiddenSet()[hidden.Normalize(id)]
+}
+
+// isOnline returns the node's online state. With offlineAfter configured,
+// a parseable lastseen overrides the source's is_online flag, since some
+// sources keep reporting dead nodes as online and others omit the flag.
+func (s *Store) isOnline(rn *RawNode, now time.Time) bool {
+	if s.Cfg().OfflineAfterDuration > 0 {
+		if last, ok := ParseTimestamp(string(rn.Lastseen)); ok {
+			return now.Sub(last) <= s.Cfg().OfflineAfterDuration
+		}
 	}
+	return bool(rn.IsOnline)
+}
+
+// placeholderNode stands in for a link endpoint missing from the node data,
+// so every link can be drawn and looked up. It is not counted in stats.
+func placeholderNode(id string) *Node {
+	return &Node{NodeID: id, Hostname: id, Placeholder: true}
 }
 
 // ComputeDiff computes an SSE update between two snapshots.
@@ -0,0 +1,177 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// NodeDecoder converts one element of a "nodes" collection into a RawNode.
+// It returns false for elements that should be skipped.
+type NodeDecoder func(raw json.RawMessage) (RawNode, bool)
+
+// DecodeRawNode is the NodeDecoder for meshviewer.json node entries.
+func DecodeRawNode(raw json.RawMessage) (RawNode, bool) {
+	var rn RawNode
+	if err := json.Unmarshal(raw, &rn); err != nil {
+		return rn, false
+	}
+	return rn, true
+}
+
+// DecodeMeshviewerStream reads a meshviewer-style document token by token,
+// decoding one node or link at a time instead of buffering the whole body.
+// "nodes" may be an array or an object keyed by node ID (nodes.json v1);
+// "timestamp", "updated_at" or "meta.timestamp" (ffmap-d3) set the
+// timestamp; other keys are skipped. Entries the decoder rejects are
+// counted in Skipped. Links referring to nodes by array position, as in
+// ffmap-d3, are resolved once the whole document is read; those pointing
+// at rejected entries are dropped.
+func DecodeMeshviewerStream(r io.Reader, decodeNode NodeDecoder) (*MeshviewerData, error) {
+	if decodeNode == nil {
+		decodeNode = DecodeRawNode
+	}
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	mv := &MeshviewerData{}
+	var ids []string // node ID per array position, "" where rejected
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		switch key {
+		case "timestamp", "updated_at":
+			var ts interface{}
+			if err := dec.Decode(&ts); err != nil {
+				return nil, err
+			}
+			if s, ok := ts.(string); ok && mv.Timestamp == "" {
+				mv.Timestamp = s
+			}
+		case "meta":
+			var meta struct {
+				Timestamp string `json:"timestamp"`
+			}
+			if err := dec.Decode(&meta); err != nil {
+				return nil, err
+			}
+			if mv.Timestamp == "" {
+				mv.Timestamp = meta.Timestamp
+			}
+		case "nodes":
+			if ids, err = decodeNodes(dec, mv, decodeNode); err != nil {
+				return nil, fmt.Errorf("nodes: %w", err)
+			}
+		case "links":
+			if err := decodeLinks(dec, mv); err != nil {
+				return nil, fmt.Errorf("links: %w", err)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	resolveIndexLinks(mv, ids)
+	return mv, nil
+}
+
+// resolveIndexLinks replaces array positions in link endpoints by node IDs.
+func resolveIndexLinks(mv *MeshviewerData, ids []string) {
+	resolve := func(id *string, idx int) bool {
+		if idx == 0 {
+			return true
+		}
+		if idx > len(ids) || ids[idx-1] == "" {
+			return false
+		}
+		*id = ids[idx-1]
+		return true
+	}
+	links := mv.Links[:0]
+	for _, l := range mv.Links {
+		ok := resolve(&l.Source, l.sourceIdx) && resolve(&l.Target, l.targetIdx)
+		l.sourceIdx, l.targetIdx = 0, 0
+		if ok {
+			links = append(links, l)
+		}
+	}
+	mv.Links = links
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("expected %q, got %v", want, tok)
+	}
+	return nil
+}
+
+// decodeNodes appends the decoded nodes to mv and returns the node ID of
+// every array position.
+func decodeNodes(dec *json.Decoder, mv *MeshviewerData, decodeNode NodeDecoder) ([]string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	d, ok := tok.(json.Delim)
+	if !ok {
+		return nil, nil // null
+	}
+	keyed := d == '{'
+	var ids []string
+	for dec.More() {
+		if keyed {
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		id := ""
+		if rn, ok := decodeNode(raw); ok {
+			mv.Nodes = append(mv.Nodes, rn)
+			id = rn.NodeID
+		} else {
+			mv.Skipped++
+		}
+		if !keyed {
+			ids = append(ids, id)
+		}
+	}
+	_, err = dec.Token()
+	return ids, err
+}
+
+func decodeLinks(dec *json.Decoder, mv *MeshviewerData) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if _, ok := tok.(json.Delim); !ok {
+		return nil // null
+	}
+	for dec.More() {
+		var l RawLink
+		if err := dec.Decode(&l); err != nil {
+			return err
+		}
+		mv.Links = append(mv.Links, l)
+	}
+	_, err = dec.Token()
+	return err
+}
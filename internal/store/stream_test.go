@@ -0,0 +1,39 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeMeshviewerStreamTimestamps(t *testing.T) {
+	const doc = `{"timestamp":"2024-05-01T12:00:00+0000","nodes":[
+		{"node_id":"a","firstseen":"2024-01-01T00:00:00Z","lastseen":"2024-05-01T11:59:00Z","uptime":"2024-04-30T12:00:00Z"},
+		{"node_id":"b","firstseen":1704067200,"lastseen":1714564740000,"uptime":86400},
+		{"node_id":"c","firstseen":null}
+	]}`
+	mv, err := DecodeMeshviewerStream(strings.NewReader(doc), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mv.Skipped != 0 || len(mv.Nodes) != 3 {
+		t.Fatalf("got %d nodes, %d skipped; want 3, 0", len(mv.Nodes), mv.Skipped)
+	}
+
+	want := []struct{ firstseen, lastseen string }{
+		{"2024-01-01T00:00:00Z", "2024-05-01T11:59:00Z"},
+		{"2024-01-01T00:00:00Z", "2024-05-01T11:59:00Z"},
+		{"", ""},
+	}
+	for i, w := range want {
+		n := mv.Nodes[i]
+		if got := NormalizeTimestamp(string(n.Firstseen)); got != w.firstseen {
+			t.Errorf("%s: firstseen = %q, want %q", n.NodeID, got, w.firstseen)
+		}
+		if got := NormalizeTimestamp(string(n.Lastseen)); got != w.lastseen {
+			t.Errorf("%s: lastseen = %q, want %q", n.NodeID, got, w.lastseen)
+		}
+	}
+	if mv.Nodes[1].Uptime != "86400" {
+		t.Errorf("numeric uptime = %q, want %q", mv.Nodes[1].Uptime, "86400")
+	}
+}
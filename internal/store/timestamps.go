@@ -0,0 +1,79 @@
+package store
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestampLayouts are the textual formats seen across community sources.
+// Fractional seconds are accepted by all of them. Layouts without a zone
+// are read as UTC, which is what ffmap-backend and most Python generators
+// emit.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05 MST",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// minEpoch rejects small numbers that are durations or counters rather
+// than unix timestamps (2001-09-09).
+const minEpoch = 1e9
+
+// ParseTimestamp reads RFC 3339 and its common variants, zone-less local
+// formats and unix epochs in seconds or milliseconds. Zero times such as
+// "0001-01-01T00:00:00+0000" are treated as missing.
+func ParseTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, false
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return parseEpoch(f)
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			if t.Year() <= 1 {
+				return time.Time{}, false
+			}
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseEpoch(f float64) (time.Time, bool) {
+	if f >= minEpoch*1000 {
+		return time.UnixMilli(int64(f)).UTC(), true
+	}
+	if f >= minEpoch {
+		sec := int64(f)
+		return time.Unix(sec, int64((f-float64(sec))*1e9)).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// NormalizeTimestamp rewrites a timestamp as UTC RFC 3339. Unparseable
+// values are dropped so sorting and age calculations never see them.
+func NormalizeTimestamp(s string) string {
+	t, ok := ParseTimestamp(s)
+	if !ok {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// normalizeUptime returns the boot time as UTC RFC 3339. Besides
+// timestamps, some sources report uptime as seconds since boot.
+func normalizeUptime(s string, now time.Time) string {
+	if secs, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil && secs > 0 && secs < minEpoch {
+		return now.Add(-time.Duration(secs * float64(time.Second))).UTC().Format(time.RFC3339)
+	}
+	return NormalizeTimestamp(s)
+}
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/respondd"
+)
+
+const (
+	// yanicInfluxWindow is how far back nodes are read from InfluxDB unless
+	// the URL sets window; it matches how long respondd sources keep nodes.
+	yanicInfluxWindow = "7d"
+	// yanicInfluxOnline is the age of the last point of an online node.
+	// yanic writes every collect interval, a minute by default.
+	yanicInfluxOnline = 10 * time.Minute
+)
+
+// fetchYanicState reads the state file yanic keeps of every node it has
+// heard, given as yanic:///path/to/state.json. It holds the raw respondd
+// answers, so it is converted like a respondd:// source. yanic does not
+// persist neighbours, so the nodes usually come without links. The
+// timestamp is that of the file, which marks the data stale once yanic
+// stops saving.
+func (s *Store) fetchYanicState(rawURL string) (*MeshviewerData, error) {
+	path := strings.TrimPrefix(rawURL, "yanic://")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading yanic state: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("reading yanic state: %w", err)
+	}
+
+	var state struct {
+		Nodes map[string]struct {
+			Firstseen  string               `json:"firstseen"`
+			Lastseen   string               `json:"lastseen"`
+			Online     bool                 `json:"online"`
+			Nodeinfo   *respondd.NodeInfo   `json:"nodeinfo"`
+			Statistics *respondd.Statistics `json:"statistics"`
+			Neighbours *respondd.Neighbours `json:"neighbours"`
+		} `json:"nodes"`
+	}
+	if err := json.NewDecoder(fetch.LimitReader(f, s.Cfg().MaxDataBytes)).Decode(&state); err != nil {
+		return nil, fmt.Errorf("parsing yanic state: %w", err)
+	}
+
+	nodes := make([]*respondd.Node, 0, len(state.Nodes))
+	for _, sn := range state.Nodes {
+		n := &respondd.Node{
+			NodeInfo:   sn.Nodeinfo,
+			Statistics: sn.Statistics,
+			Neighbours: sn.Neighbours,
+			Online:     sn.Online,
+		}
+		if !n.Online {
+			// Statistics of offline nodes are their last answer.
+			n.Statistics, n.Neighbours = nil, nil
+		}
+		n.Firstseen, _ = ParseTimestamp(sn.Firstseen)
+		n.Lastseen, _ = ParseTimestamp(sn.Lastseen)
+		nodes = append(nodes, n)
+	}
+	return ResponddToMeshviewer(nodes, fi.ModTime()), nil
+}
+
+// fetchYanicInflux reads the newest point of every node and the current
+// links from the InfluxDB 1.x database yanic writes to, given as
+// yanic+http(s)://[user:password@]host:8086/database. The window query
+// parameter limits how far back nodes are looked up.
+func (s *Store) fetchYanicInflux(rawURL string) (*MeshviewerData, error) {
+	u, err := url.Parse(strings.TrimPrefix(rawURL, "yanic+"))
+	if err != nil {
+		return nil, fmt.Errorf("yanic InfluxDB URL: %w", err)
+	}
+	db := strings.Trim(u.Path, "/")
+	if db == "" {
+		db = "yanic"
+	}
+	window := u.Query().Get("window")
+	if window == "" {
+		window = yanicInfluxWindow
+	}
+	if !validInfluxDuration(window) {
+		return nil, fmt.Errorf("yanic InfluxDB URL: invalid window %q", window)
+	}
+	u.Path, u.RawQuery = "/query", ""
+
+	now := time.Now()
+	nodeRows, err := s.influxRows(u, db, `SELECT * FROM "node" WHERE time > now() - `+window+` GROUP BY "nodeid" ORDER BY time DESC LIMIT 1`)
+	if err != nil {
+		return nil, err
+	}
+	linkRows, err := s.influxRows(u, db, `SELECT last("tq") AS "tq" FROM "link" WHERE time > now() - 10m GROUP BY "source.id", "target.id"`)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &MeshviewerData{Timestamp: now.UTC().Format(time.RFC3339)}
+	var newest time.Time
+	for _, row := range nodeRows {
+		rn, ok := influxNode(row, now)
+		if !ok {
+			continue
+		}
+		if ts, _ := ParseTimestamp(string(rn.Lastseen)); ts.After(newest) {
+			newest = ts
+		}
+		data.Nodes = append(data.Nodes, rn)
+	}
+	if !newest.IsZero() {
+		data.Timestamp = newest.Format(time.RFC3339)
+	}
+	data.Links = influxLinks(linkRows)
+	return data, nil
+}
+
+// validInfluxDuration accepts InfluxQL duration literals such as 7d or 12h.
+func validInfluxDuration(s string) bool {
+	n := strings.TrimRight(s, "smhdw")
+	if n == "" || len(s)-len(n) != 1 {
+		return false
+	}
+	for _, c := range n {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// influxRow is one row of a query result, with the group tags merged in.
+type influxRow map[string]interface{}
+
+func (r influxRow) str(k string) string {
+	v, _ := r[k].(string)
+	return v
+}
+
+func (r influxRow) num(k string) float64 {
+	v, _ := r[k].(float64)
+	return v
+}
+
+// influxRows runs stmt with epoch=s timestamps and flattens all series.
+func (s *Store) influxRows(u *url.URL, db, stmt string) ([]influxRow, error) {
+	q := *u
+	q.RawQuery = url.Values{"db": {db}, "q": {stmt}, "epoch": {"s"}}.Encode()
+	resp, err := s.client.Get(q.String())
+	if err != nil {
+		return nil, fmt.Errorf("querying InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status %d from InfluxDB", resp.StatusCode)
+	}
+	body, err := io.ReadAll(fetch.LimitReader(resp.Body, s.Cfg().MaxDataBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading InfluxDB response: %w", err)
+	}
+	var result struct {
+		Results []struct {
+			Series []struct {
+				Tags    map[string]string `json:"tags"`
+				Columns []string          `json:"columns"`
+				Values  [][]interface{}   `json:"values"`
+			} `json:"series"`
+			Error string `json:"error"`
+		} `json:"results"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("parsing InfluxDB response: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("influxdb: %s", result.Error)
+	}
+	var rows []influxRow
+	for _, res := range result.Results {
+		if res.Error != "" {
+			return nil, fmt.Errorf("influxdb: %s", res.Error)
+		}
+		for _, series := range res.Series {
+			for _, vals := range series.Values {
+				row := make(influxRow, len(vals)+len(series.Tags))
+				for k, v := range series.Tags {
+					row[k] = v
+				}
+				for i, v := range vals {
+					if i < len(series.Columns) && v != nil {
+						row[series.Columns[i]] = v
+					}
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// influxNode builds a node from the newest point of yanic's node
+// measurement. Airtime and traffic come as yanic computed them.
+func influxNode(row influxRow, now time.Time) (RawNode, bool) {
+	id := row.str("nodeid")
+	if id == "" {
+		return RawNode{}, false
+	}
+	seen := time.Unix(int64(row.num("time")), 0).UTC()
+	rn := RawNode{
+		NodeID:      id,
+		Hostname:    row.str("hostname"),
+		Owner:       row.str("owner"),
+		Model:       row.str("model"),
+		Domain:      row.str("domain"),
+		Lastseen:    FlexTime(seen.Format(time.RFC3339)),
+		IsOnline:    FlexBool(now.Sub(seen) <= yanicInfluxOnline),
+		Clients:     FlexInt(row.num("clients.total")),
+		ClientsW24:  FlexInt(row.num("clients.wifi24")),
+		ClientsW5:   FlexInt(row.num("clients.wifi5")),
+		ClientsOth:  FlexInt(row.num("clients.total") - row.num("clients.wifi")),
+		LoadAvg:     FlexFloat64(row.num("load")),
+		RootfsUsage: FlexFloat64(row.num("rootfs_usage")),
+		Gateway:     row.str("gateway"),
+		GwNexthop:   row.str("gateway_nexthop"),
+		Firmware: RawFirmware{
+			Base:    row.str("firmware_base"),
+			Release: row.str("firmware_release"),
+		},
+	}
+	// The autoupdater tag is the branch, or "disabled".
+	if br := row.str("autoupdater"); br != "" && br != "disabled" {
+		rn.Autoupdater = RawAutoUpd{Enabled: true, Branch: br}
+	}
+	if up := row.num("time.up"); up > 0 {
+		rn.Uptime = FlexTime(seen.Add(-time.Duration(up * float64(time.Second))).Format(time.RFC3339))
+	}
+	if total := row.num("memory.total"); total > 0 {
+		if avail := row.num("memory.available"); avail > 0 {
+			rn.MemoryUsage = FlexFloat64(1 - avail/total)
+		} else {
+			rn.MemoryUsage = FlexFloat64(1 - (row.num("memory.free")+row.num("memory.buffers")+row.num("memory.cached"))/total)
+		}
+	}
+	if lat, lng := row.num("location.latitude"), row.num("location.longitude"); lat != 0 || lng != 0 {
+		rn.Location = &RawLocation{Latitude: lat, Longitude: lng}
+	}
+	for _, band := range []struct {
+		prefix, freqTag string
+	}{{"airtime24", "frequency11g"}, {"airtime5", "frequency11a"}} {
+		if _, ok := row[band.prefix+".chan_util"]; !ok {
+			continue
+		}
+		var freq int
+		fmt.Sscanf(row.str(band.freqTag), "%d", &freq)
+		rn.Airtime = append(rn.Airtime, Airtime{
+			Frequency: freq,
+			ChanUtil:  row.num(band.prefix + ".chan_util"),
+			RxUtil:    row.num(band.prefix + ".rx_util"),
+			TxUtil:    row.num(band.prefix + ".tx_util"),
+		})
+	}
+	for _, kind := range []string{"rx", "tx", "forward", "mgmt_rx", "mgmt_tx"} {
+		b, okB := row["traffic."+kind+".bytes"]
+		p, okP := row["traffic."+kind+".packets"]
+		if !okB && !okP {
+			continue
+		}
+		if rn.Traffic == nil {
+			rn.Traffic = make(map[string]TrafficCounter)
+		}
+		bf, _ := b.(float64)
+		pf, _ := p.(float64)
+		rn.Traffic[kind] = TrafficCounter{Bytes: int64(bf), Packets: int64(pf)}
+	}
+	return rn, true
+}
+
+// influxLinks joins the per-direction TQ of yanic's link measurement into
+// one link per node pair. Depending on the yanic version TQ is stored as
+// a share or a percentage.
+func influxLinks(rows []influxRow) []RawLink {
+	links := make(map[string]*RawLink)
+	for _, row := range rows {
+		src, dst := row.str("source.id"), row.str("target.id")
+		if src == "" || dst == "" || src == dst {
+			continue
+		}
+		tq := row.num("tq")
+		if tq > 1 {
+			tq /= 100
+		}
+		reverse := src > dst
+		if reverse {
+			src, dst = dst, src
+		}
+		key := src + ">" + dst
+		l := links[key]
+		if l == nil {
+			l = &RawLink{Source: src, Target: dst, Type: LinkTypeOther}
+			links[key] = l
+		}
+		if reverse {
+			l.TargetTQ = max(l.TargetTQ, tq)
+		} else {
+			l.SourceTQ = max(l.SourceTQ, tq)
+		}
+	}
+	keys := make([]string, 0, len(links))
+	for k := range links {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]RawLink, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *links[k])
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package timeseries
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// fluxMetrics are the fields and the post-processing matching the
+// InfluxQL queries: rounded means for clients, bit rates for traffic.
+var fluxMetrics = map[string]struct {
+	field, after string
+	empty        bool // keep empty windows, like fill(null)
+}{
+	"clients":         {"clients.total", fluxRound, true},
+	"traffic_forward": {"traffic.forward.bytes", fluxBitRate, false},
+	"traffic_rx":      {"traffic.rx.bytes", fluxBitRate, false},
+	"traffic_tx":      {"traffic.tx.bytes", fluxBitRate, false},
+	"load":            {"load", "", true},
+	"memory":          {"memory.usage", "", true},
+	"uptime":          {"uptime", "", true},
+	"rootfs":          {"rootfs_usage", "", true},
+	"clients_wifi24":  {"clients.wifi24", fluxRound, true},
+	"clients_wifi5":   {"clients.wifi5", fluxRound, true},
+	"airtime24":       {"airtime11g.chan_util", "", true},
+	"airtime5":        {"airtime11a.chan_util", "", true},
+}
+
+// fluxGlobal are the fields of yanic's "global" measurement by metric.
+var fluxGlobal = map[string]string{
+	"clients": "clients.total",
+	"nodes":   "nodes",
+}
+
+const fluxRound = `|> map(fn: (r) => ({r with _value: if exists r._value then math.round(x: r._value) else r._value}))`
+
+const fluxBitRate = `|> derivative(unit: 1s, nonNegative: true) |> map(fn: (r) => ({r with _value: r._value * 8.0}))`
+
+// Flux queries the InfluxDB 2.x /api/v2/query API with token auth.
+type Flux struct {
+	URL    string // base URL, e.g. http://influx:8086
+	Org    string
+	Bucket string
+	Token  string
+	Client *http.Client
+}
+
+// Query runs the Flux query for q.
+func (b *Flux) Query(ctx context.Context, q Query) (Series, error) {
+	measurement, nodes := "node", fmt.Sprintf("r.nodeid == %q", q.NodeID)
+	m, ok := fluxMetrics[q.Metric]
+	if q.Global {
+		measurement, nodes = "global", "true"
+		m.field, m.after, m.empty = fluxGlobal[q.Metric], "", true
+		ok = m.field != ""
+	}
+	if !ok {
+		return Series{}, fmt.Errorf("unknown metric %q", q.Metric)
+	}
+	sum := ""
+	if len(q.NodeIDs) > 0 {
+		set, _ := json.Marshal(q.NodeIDs)
+		nodes = fmt.Sprintf("contains(value: r.nodeid, set: %s)", set)
+		sum = fmt.Sprintf("\n  |> group()\n  |> aggregateWindow(every: %s, fn: sum, createEmpty: false)", q.Interval)
+	}
+	flux := fmt.Sprintf(`import "math"
+from(bucket: %q)
+  |> range(start: -%s)
+  |> filter(fn: (r) => r._measurement == %q and r._field == %q and %s)
+  |> aggregateWindow(every: %s, fn: mean, createEmpty: %t)
+  %s%s`, b.Bucket, q.Duration, measurement, m.field, nodes, q.Interval, m.empty, m.after, sum)
+	body, _ := json.Marshal(map[string]any{
+		"query":   flux,
+		"type":    "flux",
+		"dialect": map[string]any{"header": true, "annotations": []string{}},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		b.URL+"/api/v2/query?org="+url.QueryEscape(b.Org), bytes.NewReader(body))
+	if err != nil {
+		return Series{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/csv")
+	if b.Token != "" {
+		req.Header.Set("Authorization", "Token "+b.Token)
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return Series{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Series{}, fmt.Errorf("%s returned %d: %s", req.URL.Host, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return parseFluxCSV(q.Metric, io.LimitReader(resp.Body, maxResponseBytes))
+}
+
+// parseFluxCSV reads _time and _value from a CSV response without
+// annotations. Each table starts with its own header row.
+func parseFluxCSV(name string, r io.Reader) (Series, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	s := Series{Name: name}
+	timeCol, valueCol := -1, -1
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return Series{}, err
+		}
+		if header := indexOf(rec, "_time"); header >= 0 {
+			timeCol, valueCol = header, indexOf(rec, "_value")
+			continue
+		}
+		if timeCol < 0 || valueCol < 0 || timeCol >= len(rec) || valueCol >= len(rec) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, rec[timeCol])
+		if err != nil {
+			continue
+		}
+		v, _ := strconv.ParseFloat(rec[valueCol], 64)
+		s.Times = append(s.Times, t.Unix())
+		s.Values = append(s.Values, v)
+	}
+}
+
+func indexOf(rec []string, col string) int {
+	for i, c := range rec {
+		if c == col {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,117 @@
+package timeseries
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// GrafanaDSQuery sends InfluxQL through Grafana's /api/ds/query API,
+// which Grafana 9 and later offer in place of the datasource proxy. The
+// datasource is addressed by UID.
+type GrafanaDSQuery struct {
+	BaseURL string
+	UID     string
+	Client  *http.Client
+}
+
+// Query runs the InfluxQL query for q as a raw query of the datasource.
+func (b *GrafanaDSQuery) Query(ctx context.Context, q Query) (Series, error) {
+	stmt, err := influxQLFor(q)
+	if err != nil {
+		return Series{}, err
+	}
+	body, _ := json.Marshal(map[string]any{
+		"from": "now-" + q.Duration,
+		"to":   "now",
+		"queries": []map[string]any{{
+			"refId":        "A",
+			"datasource":   map[string]string{"uid": b.UID, "type": "influxdb"},
+			"rawQuery":     true,
+			"query":        stmt,
+			"resultFormat": "time_series",
+		}},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.BaseURL+"/api/ds/query", bytes.NewReader(body))
+	if err != nil {
+		return Series{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return Series{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return Series{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Series{}, fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return parseDataFrames(q.Metric, data)
+}
+
+// parseDataFrames reads the first frame of result A: a time field in
+// milliseconds followed by a number field.
+func parseDataFrames(name string, body []byte) (Series, error) {
+	var resp struct {
+		Results map[string]struct {
+			Error  string `json:"error"`
+			Frames []struct {
+				Data struct {
+					Values [][]*float64 `json:"values"`
+				} `json:"data"`
+			} `json:"frames"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Series{}, err
+	}
+	res := resp.Results["A"]
+	if res.Error != "" {
+		return Series{}, fmt.Errorf("grafana: %s", res.Error)
+	}
+	s := Series{Name: name}
+	if len(res.Frames) == 0 {
+		return s, nil
+	}
+	cols := res.Frames[0].Data.Values
+	if len(cols) < 2 {
+		return s, nil
+	}
+	for i, ts := range cols[0] {
+		if ts == nil || i >= len(cols[1]) {
+			continue
+		}
+		var v float64
+		if cols[1][i] != nil {
+			v = *cols[1][i]
+		}
+		s.Times = append(s.Times, int64(*ts/1000))
+		s.Values = append(s.Values, v)
+	}
+	return s, nil
+}
+
+// FirstOf tries its backends in order and returns the first answer.
+type FirstOf []Backend
+
+// Query returns the result of the first backend that succeeds, or the
+// errors of all.
+func (f FirstOf) Query(ctx context.Context, q Query) (Series, error) {
+	var errs []error
+	for _, b := range f {
+		s, err := b.Query(ctx, q)
+		if err == nil {
+			return s, nil
+		}
+		errs = append(errs, err)
+	}
+	return Series{}, errors.Join(errs...)
+}
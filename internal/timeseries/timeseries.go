@@ -0,0 +1,179 @@
+// Package timeseries queries the yanic node statistics behind the charts
+// in the node detail view, from InfluxDB directly or through Grafana.
+package timeseries
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxResponseBytes bounds a query response.
+const maxResponseBytes = 5 << 20
+
+// Series is one metric of one node, as served by /api/metrics/.
+type Series struct {
+	Name   string    `json:"name"`
+	Times  []int64   `json:"times"` // Unix seconds
+	Values []float64 `json:"values"`
+}
+
+// Query selects a metric of a node over the last Duration, averaged per
+// Interval. Both are InfluxQL duration literals like "24h" and "5m".
+// With NodeIDs set, the series is the sum over those nodes instead; with
+// Global, the total of the whole network as yanic records it.
+type Query struct {
+	NodeID   string // hex digits, colons and dashes only
+	NodeIDs  []string
+	Global   bool
+	Metric   string
+	Duration string
+	Interval string
+}
+
+// Backend answers queries for node metrics.
+type Backend interface {
+	Query(ctx context.Context, q Query) (Series, error)
+}
+
+// influxQL holds the selected expression and fill mode by metric name.
+var influxQL = map[string]struct{ expr, fill string }{
+	"clients":         {`round(mean("clients.total"))`, "null"},
+	"traffic_forward": {`non_negative_derivative(mean("traffic.forward.bytes"), 1s) * 8`, "none"},
+	"traffic_rx":      {`non_negative_derivative(mean("traffic.rx.bytes"), 1s) * 8`, "none"},
+	"traffic_tx":      {`non_negative_derivative(mean("traffic.tx.bytes"), 1s) * 8`, "none"},
+	"load":            {`mean("load")`, "null"},
+	"memory":          {`mean("memory.usage")`, "null"},
+	"uptime":          {`max("uptime")`, "null"},
+	"rootfs":          {`mean("rootfs_usage")`, "null"},
+	"clients_wifi24":  {`round(mean("clients.wifi24"))`, "null"},
+	"clients_wifi5":   {`round(mean("clients.wifi5"))`, "null"},
+	"airtime24":       {`mean("airtime11g.chan_util")`, "null"},
+	"airtime5":        {`mean("airtime11a.chan_util")`, "null"},
+}
+
+// globalInfluxQL selects network totals from yanic's "global"
+// measurement, by metric name.
+var globalInfluxQL = map[string]string{
+	"clients": `round(mean("clients.total"))`,
+	"nodes":   `round(mean("nodes"))`,
+}
+
+// Known reports whether metric has a built-in query.
+func Known(metric string) bool {
+	_, ok := influxQL[metric]
+	return ok
+}
+
+// KnownGlobal reports whether metric has a built-in network-wide query.
+func KnownGlobal(metric string) bool {
+	_, ok := globalInfluxQL[metric]
+	return ok
+}
+
+// influxQLFor builds the InfluxQL statement for q. Sums are taken over the
+// per-node series, so a node's counters never mix with another's.
+func influxQLFor(q Query) (string, error) {
+	if q.Global {
+		expr, ok := globalInfluxQL[q.Metric]
+		if !ok {
+			return "", fmt.Errorf("unknown metric %q", q.Metric)
+		}
+		return fmt.Sprintf(`SELECT %s FROM "global" WHERE time >= now() - %s GROUP BY time(%s) fill(null)`,
+			expr, q.Duration, q.Interval), nil
+	}
+	m, ok := influxQL[q.Metric]
+	if !ok {
+		return "", fmt.Errorf("unknown metric %q", q.Metric)
+	}
+	if len(q.NodeIDs) == 0 {
+		return fmt.Sprintf(`SELECT %s FROM "node" WHERE ("nodeid" =~ /^%s$/) AND time >= now() - %s GROUP BY time(%s) fill(%s)`,
+			m.expr, q.NodeID, q.Duration, q.Interval, m.fill), nil
+	}
+	return fmt.Sprintf(`SELECT sum("value") FROM (SELECT %s AS "value" FROM "node" WHERE ("nodeid" =~ /^(%s)$/) AND time >= now() - %s GROUP BY time(%s), "nodeid" fill(none)) WHERE time >= now() - %s GROUP BY time(%s) fill(none)`,
+		m.expr, strings.Join(q.NodeIDs, "|"), q.Duration, q.Interval, q.Duration, q.Interval), nil
+}
+
+// InfluxQL sends InfluxQL to an endpoint speaking the InfluxDB 1.x query
+// API: InfluxDB itself, or Grafana's datasource proxy in front of it.
+type InfluxQL struct {
+	Endpoint string // query URL without parameters, e.g. http://influx:8086/query
+	Database string
+	Username string // basic auth, for InfluxDB with authentication enabled
+	Password string
+	Client   *http.Client
+}
+
+// Query runs the InfluxQL query for q.
+func (b *InfluxQL) Query(ctx context.Context, q Query) (Series, error) {
+	stmt, err := influxQLFor(q)
+	if err != nil {
+		return Series{}, err
+	}
+	params := url.Values{
+		"db":    {b.Database},
+		"q":     {stmt},
+		"epoch": {"s"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.Endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return Series{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if b.Username != "" {
+		req.SetBasicAuth(b.Username, b.Password)
+	}
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return Series{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return Series{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Series{}, fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	return parseInfluxQL(q.Metric, body)
+}
+
+// parseInfluxQL reads the first series of a /query response with
+// epoch=s timestamps. Missing values become 0.
+func parseInfluxQL(name string, body []byte) (Series, error) {
+	var resp struct {
+		Results []struct {
+			Series []struct {
+				Values [][]interface{} `json:"values"`
+			} `json:"series"`
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Series{}, err
+	}
+	s := Series{Name: name}
+	if len(resp.Results) == 0 {
+		return s, nil
+	}
+	if e := resp.Results[0].Error; e != "" {
+		return Series{}, fmt.Errorf("influxdb: %s", e)
+	}
+	if len(resp.Results[0].Series) == 0 {
+		return s, nil
+	}
+	for _, row := range resp.Results[0].Series[0].Values {
+		if len(row) < 2 {
+			continue
+		}
+		ts, _ := row[0].(float64)
+		val, _ := row[1].(float64)
+		s.Times = append(s.Times, int64(ts))
+		s.Values = append(s.Values, val)
+	}
+	return s, nil
+}
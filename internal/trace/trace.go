@@ -0,0 +1,315 @@
+// Package trace records spans for upstream fetches, refresh stages and
+// HTTP requests and exports them to an OpenTelemetry collector over
+// OTLP/HTTP with JSON encoding. Without otlpEndpoint, Start returns nil
+// spans and costs next to nothing; all Span methods accept nil.
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+const (
+	batchSize   = 512
+	flushEvery  = 5 * time.Second
+	queueLength = 4096
+)
+
+// Span kinds as defined by OTLP.
+const (
+	kindInternal = 1
+	kindServer   = 2
+	kindClient   = 3
+)
+
+// Span is one timed operation. It is not safe for concurrent use; start
+// a child span per goroutine instead.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []string // alternating keys and values
+	errMsg   string
+	ended    bool
+}
+
+type spanKey struct{}
+
+type exporter struct {
+	url     string
+	headers map[string]string
+	service string
+	ratio   float64
+	client  *http.Client
+	queue   chan *Span
+	dropped atomic.Int64
+}
+
+var active atomic.Pointer[exporter]
+
+// Init enables tracing when cfg sets otlpEndpoint. Spans are sent by Run.
+func Init(cfg *config.Config) {
+	if cfg.OTLPEndpoint == "" {
+		return
+	}
+	active.Store(&exporter{
+		url:     strings.TrimSuffix(cfg.OTLPEndpoint, "/") + "/v1/traces",
+		headers: cfg.OTLPHeaders,
+		service: cfg.OTELServiceName,
+		ratio:   cfg.TraceSampleRatio,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan *Span, queueLength),
+	})
+}
+
+// Run sends finished spans in batches until ctx is done, then flushes
+// what is queued.
+func Run(ctx context.Context) {
+	e := active.Load()
+	if e == nil {
+		return
+	}
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			e.send(batch)
+			return
+		}
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// Start begins a span as a child of the span in ctx, or as the root of a
+// new trace. attrs alternate between keys and values.
+func Start(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	return start(ctx, name, kindInternal, attrs)
+}
+
+// StartClient begins a span for an outgoing request.
+func StartClient(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	return start(ctx, name, kindClient, attrs)
+}
+
+// StartServer begins the span of an incoming request, continuing the
+// trace from a W3C traceparent header if the caller sent one.
+func StartServer(ctx context.Context, name, traceparent string, attrs ...string) (context.Context, *Span) {
+	if traceID, parentID, sampled, ok := parseTraceparent(traceparent); ok {
+		if !sampled || active.Load() == nil {
+			return ctx, nil
+		}
+		s := newSpan(name, kindServer, attrs)
+		s.traceID, s.parentID = traceID, parentID
+		return context.WithValue(ctx, spanKey{}, s), s
+	}
+	return start(ctx, name, kindServer, attrs)
+}
+
+func start(ctx context.Context, name string, kind int, attrs []string) (context.Context, *Span) {
+	e := active.Load()
+	if e == nil {
+		return ctx, nil
+	}
+	parent, _ := ctx.Value(spanKey{}).(*Span)
+	if parent == nil && e.ratio < 1 && mrand.Float64() >= e.ratio {
+		return ctx, nil
+	}
+	s := newSpan(name, kind, attrs)
+	if parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func newSpan(name string, kind int, attrs []string) *Span {
+	s := &Span{name: name, kind: kind, start: time.Now(), attrs: attrs}
+	rand.Read(s.spanID[:])
+	return s
+}
+
+// SetAttr adds an attribute.
+func (s *Span) SetAttr(key, value string) {
+	if s != nil {
+		s.attrs = append(s.attrs, key, value)
+	}
+}
+
+// SetError marks the span as failed if err is not nil.
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.errMsg = err.Error()
+	}
+}
+
+// End finishes the span and queues it for export; spans are dropped if
+// the collector cannot keep up.
+func (s *Span) End() {
+	if s == nil || s.ended {
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	e := active.Load()
+	if e == nil {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// parseTraceparent reads a version 00 W3C traceparent header.
+func parseTraceparent(h string) (traceID [16]byte, spanID [8]byte, sampled, ok bool) {
+	parts := strings.Split(h, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, spanID, false, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || traceID == [16]byte{} || spanID == [8]byte{} {
+		return traceID, spanID, false, false
+	}
+	return traceID, spanID, flags&1 == 1, true
+}
+
+// OTLP/JSON encoding: IDs are hex, times are decimal strings.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 = error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr  `json:"attributes,omitempty"`
+	Status       *otlpStatus `json:"status,omitempty"`
+}
+
+func (e *exporter) send(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		out := otlpSpan{
+			TraceID:    hex.EncodeToString(s.traceID[:]),
+			SpanID:     hex.EncodeToString(s.spanID[:]),
+			Name:       s.name,
+			Kind:       s.kind,
+			Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes: attributes(s.attrs),
+		}
+		if s.parentID != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			out.Status = &otlpStatus{Code: 2, Message: s.errMsg}
+		}
+		spans[i] = out
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": attributes([]string{"service.name", e.service})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "freifunk-map"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		log.Printf("Tracing: %v", err)
+		return
+	}
+	if err := e.post(body); err != nil {
+		log.Printf("Tracing: dropping %d spans: %v", len(batch), err)
+	}
+	if n := e.dropped.Swap(0); n > 0 {
+		log.Printf("Tracing: queue full, dropped %d spans", n)
+	}
+}
+
+func (e *exporter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func attributes(kv []string) []otlpAttr {
+	if len(kv) < 2 {
+		return nil
+	}
+	out := make([]otlpAttr, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		out = append(out, otlpAttr{Key: kv[i], Value: otlpValue{StringValue: kv[i+1]}})
+	}
+	return out
+}
@@ -1,11 +1,16 @@
 package urlcheck
 
 import (
+	"fmt"
 	"net"
 	"net/url"
 	"strings"
+	"syscall"
 )
 
+// blockedHosts are cloud metadata endpoints outside the private ranges.
+var blockedHosts = []string{"169.254.169.254", "metadata.google.internal", "100.100.100.200"}
+
 // IsSafeURL checks that a URL is safe to fetch (blocks private IPs, metadata endpoints).
 func IsSafeURL(rawURL string) bool {
 	u, err := url.Parse(rawURL)
@@ -19,8 +24,7 @@ func IsSafeURL(rawURL string) bool {
 	if host == "" {
 		return false
 	}
-	blocked := []string{"169.254.169.254", "metadata.google.internal", "100.100.100.200"}
-	for _, b := range blocked {
+	for _, b := range blockedHosts {
 		if host == b {
 			return false
 		}
@@ -45,6 +49,27 @@ func isPrivateIP(ip net.IP) bool {
 	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
 }
 
+// DialControl is a net.Dialer Control hook that refuses connections to the
+// addresses IsSafeURL rejects. It sees the address actually dialed, so a
+// host name that resolves elsewhere by the time of the request (DNS
+// rebinding) is caught as well.
+func DialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivateIP(ip) {
+		return fmt.Errorf("connection to %s not allowed", host)
+	}
+	for _, b := range blockedHosts {
+		if host == b {
+			return fmt.Errorf("connection to %s not allowed", host)
+		}
+	}
+	return nil
+}
+
 // IsHTTPS returns true if the URL uses HTTPS.
 func IsHTTPS(rawURL string) bool {
 	return strings.HasPrefix(rawURL, "https://")
@@ -0,0 +1,322 @@
+// Package watch lets users subscribe to a geographic area and be told when
+// nodes in it go offline or new nodes appear, through a token-protected
+// event feed and optionally a webhook.
+package watch
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
+)
+
+// maxEvents is how many recent events each subscription's feed keeps.
+const maxEvents = 100
+
+// Circle is an area given by center and radius in meters.
+type Circle struct {
+	Lat    float64 `json:"lat"`
+	Lng    float64 `json:"lng"`
+	Radius float64 `json:"radius"`
+}
+
+// Event is a change to a node inside a watched area.
+type Event struct {
+	Type     string    `json:"type"` // "offline" or "new"
+	NodeID   string    `json:"node_id"`
+	Hostname string    `json:"hostname"`
+	Time     time.Time `json:"time"`
+}
+
+// Subscription is a watched area. Token authorizes reading the feed and
+// deleting the subscription; it is only returned on creation.
+type Subscription struct {
+	ID        string       `json:"id"`
+	Token     string       `json:"token,omitempty"`
+	Name      string       `json:"name,omitempty"`
+	Circle    *Circle      `json:"circle,omitempty"`
+	Polygon   [][2]float64 `json:"polygon,omitempty"` // [lat, lng] vertices
+	Webhook   string       `json:"webhook,omitempty"`
+	Client    string       `json:"client,omitempty"` // creator's address, for maxWatchesPerClient
+	CreatedAt time.Time    `json:"created_at"`
+	Events    []Event      `json:"events"`
+}
+
+// Validate checks that exactly one area is set and the webhook is safe.
+func (sub *Subscription) Validate() error {
+	if (sub.Circle == nil) == (len(sub.Polygon) == 0) {
+		return fmt.Errorf("exactly one of circle or polygon is required")
+	}
+	if sub.Circle != nil && (sub.Circle.Radius <= 0 || sub.Circle.Radius > 50000) {
+		return fmt.Errorf("circle radius must be between 0 and 50000 meters")
+	}
+	if len(sub.Polygon) > 0 && (len(sub.Polygon) < 3 || len(sub.Polygon) > 100) {
+		return fmt.Errorf("polygon needs 3 to 100 vertices")
+	}
+	if sub.Webhook != "" && !urlcheck.IsSafeURL(sub.Webhook) {
+		return fmt.Errorf("webhook URL not allowed")
+	}
+	return nil
+}
+
+// bounds returns south, west, north, east of the area.
+func (sub *Subscription) bounds() (float64, float64, float64, float64) {
+	if c := sub.Circle; c != nil {
+		dLat := c.Radius / 111320
+		dLng := dLat / math.Max(math.Cos(c.Lat*math.Pi/180), 0.01)
+		return c.Lat - dLat, c.Lng - dLng, c.Lat + dLat, c.Lng + dLng
+	}
+	s, w, n, e := 90.0, 180.0, -90.0, -180.0
+	for _, p := range sub.Polygon {
+		s, n = math.Min(s, p[0]), math.Max(n, p[0])
+		w, e = math.Min(w, p[1]), math.Max(e, p[1])
+	}
+	return s, w, n, e
+}
+
+func (sub *Subscription) contains(lat, lng float64) bool {
+	if c := sub.Circle; c != nil {
+		return store.Haversine(c.Lat, c.Lng, lat, lng) <= c.Radius
+	}
+	// Ray casting; fine for district-sized polygons away from the poles.
+	in := false
+	p := sub.Polygon
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		if (p[i][0] > lat) != (p[j][0] > lat) &&
+			lng < (p[j][1]-p[i][1])*(lat-p[i][0])/(p[j][0]-p[i][0])+p[i][1] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Watcher holds subscriptions and evaluates them against each snapshot.
+type Watcher struct {
+	path      string
+	max       int
+	perClient int
+	client    *http.Client
+
+	mu   sync.Mutex
+	subs map[string]*Subscription
+	prev *store.Snapshot
+}
+
+// Open loads subscriptions from path; max caps how many may exist, and
+// perClient how many one client address may create.
+func Open(path string, max, perClient int) *Watcher {
+	w := &Watcher{
+		path:      path,
+		max:       max,
+		perClient: perClient,
+		client:    webhookClient(),
+		subs:      make(map[string]*Subscription),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Watch: read error: %v", err)
+		}
+		return w
+	}
+	var list []*Subscription
+	if err := json.Unmarshal(data, &list); err != nil {
+		log.Printf("Watch: corrupt %s, ignoring (%v)", path, err)
+		return w
+	}
+	for _, sub := range list {
+		w.subs[sub.ID] = sub
+	}
+	return w
+}
+
+// webhookClient posts webhooks to public addresses only. The URL is
+// checked when a subscription is created, but its host may resolve
+// elsewhere later, so every connection is checked again, and redirects,
+// which could point anywhere, are not followed. No proxy is used: the
+// check would only see the proxy's address.
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: urlcheck.DialControl}
+	return &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return errors.New("webhook redirects are not followed")
+		},
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func tokenMatches(want, got string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(want), []byte(got)) == 1
+}
+
+// ErrLimit is returned by Add when no more subscriptions may be created.
+var ErrLimit = errors.New("subscription limit reached")
+
+// Add validates and stores a new subscription for the client at address
+// client, assigning ID and token.
+func (w *Watcher) Add(sub Subscription, client string) (Subscription, error) {
+	if err := sub.Validate(); err != nil {
+		return sub, err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.subs) >= w.max {
+		return sub, ErrLimit
+	}
+	n := 0
+	for _, s := range w.subs {
+		if s.Client == client {
+			n++
+		}
+	}
+	if n >= w.perClient {
+		return sub, ErrLimit
+	}
+	sub.Client = client
+	sub.ID = randomHex(8)
+	sub.Token = randomHex(16)
+	sub.CreatedAt = time.Now().UTC()
+	sub.Events = []Event{}
+	w.subs[sub.ID] = &sub
+	w.saveLocked()
+	return sub, nil
+}
+
+// Get returns a subscription if the token matches.
+func (w *Watcher) Get(id, token string) (Subscription, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sub, ok := w.subs[id]
+	if !ok || !tokenMatches(sub.Token, token) {
+		return Subscription{}, false
+	}
+	out := *sub
+	out.Events = append([]Event(nil), sub.Events...)
+	return out, true
+}
+
+// Delete removes a subscription if the token matches.
+func (w *Watcher) Delete(id, token string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sub, ok := w.subs[id]
+	if !ok || !tokenMatches(sub.Token, token) {
+		return false
+	}
+	delete(w.subs, id)
+	w.saveLocked()
+	return true
+}
+
+// Observe compares a new snapshot with the previous one and records events
+// for nodes inside watched areas. It is meant to be a store snapshot listener.
+func (w *Watcher) Observe(snap *store.Snapshot) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prev := w.prev
+	w.prev = snap
+	if prev == nil || len(prev.Nodes) == 0 || len(w.subs) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	cur := store.NewSpatialIndex(snap.NodeList)
+	old := store.NewSpatialIndex(prev.NodeList)
+	changed := false
+	for _, sub := range w.subs {
+		s, we, n, e := sub.bounds()
+		var events []Event
+		for _, nd := range cur.Within(s, we, n, e) {
+			if !sub.contains(*nd.Lat, *nd.Lng) {
+				continue
+			}
+			before, existed := prev.Nodes[nd.NodeID]
+			switch {
+			case !existed:
+				events = append(events, Event{Type: "new", NodeID: nd.NodeID, Hostname: nd.Hostname, Time: now})
+			case before.IsOnline && !nd.IsOnline:
+				events = append(events, Event{Type: "offline", NodeID: nd.NodeID, Hostname: nd.Hostname, Time: now})
+			}
+		}
+		// Nodes that vanished entirely while online count as offline too.
+		for _, nd := range old.Within(s, we, n, e) {
+			if _, still := snap.Nodes[nd.NodeID]; !still && nd.IsOnline && sub.contains(*nd.Lat, *nd.Lng) {
+				events = append(events, Event{Type: "offline", NodeID: nd.NodeID, Hostname: nd.Hostname, Time: now})
+			}
+		}
+		if len(events) == 0 {
+			continue
+		}
+		changed = true
+		sub.Events = append(sub.Events, events...)
+		if len(sub.Events) > maxEvents {
+			sub.Events = sub.Events[len(sub.Events)-maxEvents:]
+		}
+		if sub.Webhook != "" {
+			go w.notify(sub.ID, sub.Name, sub.Webhook, events)
+		}
+	}
+	if changed {
+		w.saveLocked()
+	}
+}
+
+func (w *Watcher) notify(id, name, url string, events []Event) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"subscription": id,
+		"name":         name,
+		"events":       events,
+	})
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Watch: webhook for %s failed: %v", id, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("Watch: webhook for %s returned HTTP %d", id, resp.StatusCode)
+	}
+}
+
+func (w *Watcher) saveLocked() {
+	list := make([]*Subscription, 0, len(w.subs))
+	for _, sub := range w.subs {
+		list = append(list, sub)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		log.Printf("Watch: save error: %v", err)
+		return
+	}
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("Watch: write error: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		log.Printf("Watch: rename error: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+// Package wireguard polls per-peer statistics from wireguard gateways or a
+// wgkex broker and attaches them to nodes as VPN health information.
+package wireguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/fetch"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/urlcheck"
+)
+
+// healthyHandshake is the maximum handshake age of an active peer. Wireguard
+// renews the session every two minutes while traffic flows.
+const healthyHandshake = 3 * time.Minute
+
+// peer is one entry of a stats endpoint. Both the flat snake_case format
+// emitted by gateway exporters and the camelCase wg-json format are read.
+type peer struct {
+	PublicKey       string          `json:"public_key"`
+	NodeID          string          `json:"node_id"`
+	MAC             string          `json:"mac"`
+	Hostname        string          `json:"hostname"`
+	Endpoint        string          `json:"endpoint"`
+	AllowedIPs      []string        `json:"allowed_ips"`
+	LatestHandshake json.RawMessage `json:"latest_handshake"`
+	TransferRx      int64           `json:"transfer_rx"`
+	TransferTx      int64           `json:"transfer_tx"`
+
+	AllowedIPsCamel      []string        `json:"allowedIps"`
+	LatestHandshakeCamel json.RawMessage `json:"latestHandshake"`
+	TransferRxCamel      int64           `json:"transferRx"`
+	TransferTxCamel      int64           `json:"transferTx"`
+
+	gateway string
+}
+
+// wgJSONInterface is one interface of wg-json output, peers keyed by public key.
+type wgJSONInterface struct {
+	Peers map[string]peer `json:"peers"`
+}
+
+// Poller periodically fetches peer statistics and publishes them to the store.
+type Poller struct {
+	cfg    *config.Config
+	s      *store.Store
+	client *http.Client
+}
+
+func NewPoller(cfg *config.Config, s *store.Store) *Poller {
+	return &Poller{
+		cfg:    cfg,
+		s:      s,
+		client: fetch.HTTPClient(cfg, 15*time.Second),
+	}
+}
+
+// Run polls all configured endpoints once per refresh interval until ctx ends.
+func (p *Poller) Run(ctx context.Context) {
+	p.poll()
+	ticker := time.NewTicker(p.cfg.RefreshDuration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.poll()
+		}
+	}
+}
+
+func (p *Poller) poll() {
+	var peers []peer
+	for _, u := range p.cfg.WireguardStatsURLs {
+		got, err := p.fetch(u)
+		if err != nil {
+			log.Printf("Wireguard stats: %s: %v", u, err)
+			continue
+		}
+		for i := range got {
+			got[i].gateway = u
+		}
+		peers = append(peers, got...)
+	}
+
+	snap := p.s.GetSnapshot()
+	now := time.Now()
+	result := make(map[string]store.VPNPeer)
+	idx := newNodeIndex(snap)
+	for _, pr := range peers {
+		nodeID := idx.match(&pr)
+		if nodeID == "" {
+			continue
+		}
+		vp := store.VPNPeer{
+			PublicKey: pr.PublicKey,
+			Gateway:   pr.gateway,
+			RxBytes:   pr.TransferRx,
+			TxBytes:   pr.TransferTx,
+		}
+		if hs, ok := parseHandshake(pr.LatestHandshake); ok {
+			vp.LatestHandshake = hs
+			vp.HandshakeAge = int64(now.Sub(hs).Seconds())
+			vp.Healthy = now.Sub(hs) <= healthyHandshake
+		}
+		// A node may hold sessions to several gateways; keep the freshest.
+		if old, ok := result[nodeID]; ok && old.LatestHandshake.After(vp.LatestHandshake) {
+			continue
+		}
+		result[nodeID] = vp
+	}
+	p.s.SetVPNPeers(result)
+	log.Printf("Wireguard stats: %d peers, %d matched to nodes", len(peers), len(result))
+}
+
+func (p *Poller) fetch(u string) ([]peer, error) {
+	if !urlcheck.IsSafeURL(u) {
+		return nil, fmt.Errorf("blocked unsafe URL")
+	}
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		return nil, err
+	}
+	return parsePeers(body)
+}
+
+// parsePeers accepts a plain array, {"peers": [...]}, or wg-json output.
+func parsePeers(body []byte) ([]peer, error) {
+	var list []peer
+	if err := json.Unmarshal(body, &list); err == nil {
+		return normalize(list), nil
+	}
+	var wrapped struct {
+		Peers []peer `json:"peers"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err == nil && wrapped.Peers != nil {
+		return normalize(wrapped.Peers), nil
+	}
+	var ifaces map[string]wgJSONInterface
+	if err := json.Unmarshal(body, &ifaces); err != nil {
+		return nil, fmt.Errorf("unrecognized peer stats format: %w", err)
+	}
+	for _, iface := range ifaces {
+		for key, pr := range iface.Peers {
+			pr.PublicKey = key
+			list = append(list, pr)
+		}
+	}
+	return normalize(list), nil
+}
+
+func normalize(list []peer) []peer {
+	for i := range list {
+		pr := &list[i]
+		if len(pr.AllowedIPs) == 0 {
+			pr.AllowedIPs = pr.AllowedIPsCamel
+		}
+		if len(pr.LatestHandshake) == 0 {
+			pr.LatestHandshake = pr.LatestHandshakeCamel
+		}
+		if pr.TransferRx == 0 {
+			pr.TransferRx = pr.TransferRxCamel
+		}
+		if pr.TransferTx == 0 {
+			pr.TransferTx = pr.TransferTxCamel
+		}
+	}
+	return list
+}
+
+// parseHandshake reads a unix timestamp or an RFC 3339 string. Zero means
+// the peer never completed a handshake.
+func parseHandshake(raw json.RawMessage) (time.Time, bool) {
+	if len(raw) == 0 {
+		return time.Time{}, false
+	}
+	var unix float64
+	if err := json.Unmarshal(raw, &unix); err == nil {
+		if unix <= 0 {
+			return time.Time{}, false
+		}
+		return time.Unix(int64(unix), 0), true
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		if t, err := time.Parse(time.RFC3339, s); err == nil && !t.IsZero() {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nodeIndex resolves peers to node IDs by node_id, MAC, hostname or one of
+// the peer's allowed IPs appearing among a node's addresses.
+type nodeIndex struct {
+	snap   *store.Snapshot
+	byMAC  map[string]string
+	byName map[string]string
+	byAddr map[string]string
+}
+
+func newNodeIndex(snap *store.Snapshot) *nodeIndex {
+	idx := &nodeIndex{
+		snap:   snap,
+		byMAC:  make(map[string]string),
+		byName: make(map[string]string),
+		byAddr: make(map[string]string),
+	}
+	for _, n := range snap.NodeList {
+		if n.MAC != "" {
+			idx.byMAC[strings.ToLower(n.MAC)] = n.NodeID
+		}
+		if n.Hostname != "" {
+			idx.byName[strings.ToLower(n.Hostname)] = n.NodeID
+		}
+		for _, a := range n.Addresses {
+			idx.byAddr[strings.ToLower(a)] = n.NodeID
+		}
+	}
+	return idx
+}
+
+func (idx *nodeIndex) match(pr *peer) string {
+	if _, ok := idx.snap.Nodes[pr.NodeID]; ok && pr.NodeID != "" {
+		return pr.NodeID
+	}
+	if id, ok := idx.byMAC[strings.ToLower(pr.MAC)]; ok && pr.MAC != "" {
+		return id
+	}
+	if id, ok := idx.byName[strings.ToLower(pr.Hostname)]; ok && pr.Hostname != "" {
+		return id
+	}
+	for _, ip := range pr.AllowedIPs {
+		addr, _, _ := strings.Cut(ip, "/")
+		if id, ok := idx.byAddr[strings.ToLower(addr)]; ok {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
+)
+
+// redirectFDName is the FileDescriptorName= of an activated socket meant
+// for the plain HTTP redirect listener.
+const redirectFDName = "redirect"
+
+// activated returns the sockets passed by systemd socket activation by
+// name; unnamed sockets are called "unknown", as systemd does.
+var activated = sync.OnceValues(func() (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	out := make(map[string]net.Listener, n)
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		// Activated sockets start at fd 3. FileListener dups the fd with
+		// close-on-exec set, so the original is closed.
+		f := os.NewFile(uintptr(3+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("activated socket %d (%s): %w", 3+i, name, err)
+		}
+		if _, dup := out[name]; dup {
+			ln.Close()
+			return nil, fmt.Errorf("more than one activated socket named %q; set FileDescriptorName=", name)
+		}
+		out[name] = ln
+	}
+	return out, nil
+})
+
+// listen opens the main listener. An activated socket not named
+// "redirect" takes precedence; otherwise listen is a TCP address or a Unix
+// domain socket for "unix:/path". A stale socket left by an unclean exit
+// is replaced; any other file at the path is an error.
+func listen(cfg *config.Config) (net.Listener, error) {
+	sockets, err := activated()
+	if err != nil {
+		return nil, err
+	}
+	var primary []net.Listener
+	for name, ln := range sockets {
+		if name != redirectFDName {
+			primary = append(primary, ln)
+		}
+	}
+	switch {
+	case len(primary) > 1:
+		return nil, fmt.Errorf("%d activated sockets; only one besides %q is supported", len(primary), redirectFDName)
+	case len(primary) == 1:
+		return primary[0], nil
+	}
+	path, ok := strings.CutPrefix(cfg.Listen, "unix:")
+	if !ok {
+		return net.Listen("tcp", cfg.Listen)
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, cfg.SocketFileMode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("setting socket mode: %w", err)
+	}
+	return ln, nil
+}
+
+// listenRedirect opens the redirect listener: the activated socket named
+// "redirect", else httpRedirectListen. It returns nil if neither is set.
+func listenRedirect(cfg *config.Config) (net.Listener, error) {
+	sockets, err := activated()
+	if err != nil {
+		return nil, err
+	}
+	if ln, ok := sockets[redirectFDName]; ok {
+		return ln, nil
+	}
+	if cfg.HTTPRedirectListen == "" {
+		return nil, nil
+	}
+	return net.Listen("tcp", cfg.HTTPRedirectListen)
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"embed"
-	"io/fs"
 	"log"
 	"net/http"
 	"os"
@@ -11,106 +11,131 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/freifunkMUC/freifunk-map-modern/internal/acme"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/api"
 	"github.com/freifunkMUC/freifunk-map-modern/internal/config"
-	"github.com/freifunkMUC/freifunk-map-modern/internal/federation"
-	"github.com/freifunkMUC/freifunk-map-modern/internal/sse"
-	"github.com/freifunkMUC/freifunk-map-modern/internal/store"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/trace"
+	"github.com/freifunkMUC/freifunk-map-modern/internal/version"
 )
 
 //go:embed web/*
 var webFS embed.FS
 
 func main() {
-	cfgPath := "config.json"
 	if len(os.Args) > 1 {
-		cfgPath = os.Args[1]
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
 	}
+	cfgPath := configArg(os.Args[1:])
 
 	cfg, err := config.Load(cfgPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	hub := sse.NewHub()
-	var s *store.Store
-	var fedStore *federation.Store
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if cfg.Federation {
-		fedStore = federation.NewStore(cfg)
-		s = fedStore.Store
-
-		// Try to restore cached state for instant startup
-		if fedStore.RestoreState() {
-			log.Println("Federation mode: serving cached data, refreshing in background...")
-			go func() {
-				old := fedStore.GetSnapshot()
-				if err := fedStore.DiscoverAndRefresh(); err != nil {
-					log.Printf("Warning: background federation refresh failed: %v", err)
-					return
-				}
-				snap := fedStore.GetSnapshot()
-				log.Printf("Background refresh complete: %d nodes (%d online)",
-					snap.Stats.TotalNodes, snap.Stats.OnlineNodes)
-				diff := store.ComputeDiff(old, snap)
-				if diff != nil {
-					hub.Broadcast(diff)
-				}
-			}()
-		} else {
-			log.Println("Federation mode: no cache, performing initial discovery...")
-			if err := fedStore.DiscoverAndRefresh(); err != nil {
-				log.Printf("Warning: initial federation discovery failed: %v", err)
-			}
-		}
-		go fedStore.RunRefreshLoop(ctx, hub)
-	} else {
-		s = store.New(cfg)
-		if err := s.Refresh(); err != nil {
-			log.Printf("Warning: initial data fetch failed: %v", err)
-		}
-		go s.RunRefreshLoop(ctx, hub)
-	}
-
-	mux := http.NewServeMux()
-	api.RegisterHandlers(mux, cfg, s, hub)
+	trace.Init(cfg)
+	traced := make(chan struct{})
+	go func() {
+		trace.Run(ctx)
+		close(traced)
+	}()
+	instances, handler := startInstances(ctx, cfgPath, cfg)
 
-	if fedStore != nil {
-		api.RegisterFederationHandlers(mux, cfg, fedStore)
-	} else {
-		api.RegisterMetricsHandler(mux, cfg)
+	server := &http.Server{
+		Handler:      api.RealIP(cfg, handler),
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 60 * time.Second,
+		IdleTimeout:  120 * time.Second,
 	}
 
-	webContent, err := fs.Sub(webFS, "web")
+	ln, err := listen(cfg)
 	if err != nil {
-		log.Fatalf("Failed to mount web FS: %v", err)
+		log.Fatalf("Failed to listen on %s: %v", cfg.Listen, err)
 	}
-	mux.Handle("/", http.FileServer(http.FS(webContent)))
 
-	server := &http.Server{
-		Addr:         cfg.Listen,
-		Handler:      api.GzipHandler(mux),
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  120 * time.Second,
+	var certs *certLoader
+	var redirect *http.Server
+	if cfg.TLSCert != "" || len(cfg.ACMEDomains) > 0 {
+		var getCert func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		plain := httpsRedirect(ln.Addr().String())
+		if cfg.TLSCert != "" {
+			certs = &certLoader{certFile: cfg.TLSCert, keyFile: cfg.TLSKey}
+			if err := certs.reload(); err != nil {
+				log.Fatalf("%v", err)
+			}
+			getCert = certs.getCertificate
+		} else {
+			m := acme.New(cfg)
+			getCert = m.GetCertificate
+			plain = m.HTTPHandler(plain)
+		}
+		server.TLSConfig, err = newTLSConfig(cfg, getCert)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		rln, err := listenRedirect(cfg)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", cfg.HTTPRedirectListen, err)
+		}
+		if rln != nil {
+			redirect = &http.Server{
+				Handler:      plain,
+				ReadTimeout:  10 * time.Second,
+				WriteTimeout: 10 * time.Second,
+			}
+			go func() {
+				log.Printf("Redirecting HTTP on %s to HTTPS", rln.Addr())
+				if err := redirect.Serve(rln); err != http.ErrServerClosed {
+					log.Fatalf("Redirect server error: %v", err)
+				}
+			}()
+		}
 	}
 
 	go func() {
-		log.Printf("🗺️  Freifunk Map starting on %s", cfg.Listen)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			log.Printf("🗺️  Freifunk Map %s starting on %s (HTTPS)", version.Get(), ln.Addr())
+			err = server.ServeTLS(ln, "", "")
+		} else {
+			log.Printf("🗺️  Freifunk Map %s starting on %s", version.Get(), ln.Addr())
+			err = server.Serve(ln)
+		}
+		if err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigCh {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		for _, in := range instances {
+			in.reload()
+		}
+		if certs != nil {
+			if err := certs.reload(); err != nil {
+				log.Printf("Keeping current TLS certificate: %v", err)
+			} else {
+				log.Println("TLS certificate reloaded")
+			}
+		}
+	}
 
 	log.Println("Shutting down...")
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
 	_ = server.Shutdown(shutdownCtx)
+	if redirect != nil {
+		_ = redirect.Shutdown(shutdownCtx)
+	}
+	// Stop background jobs and send the remaining spans.
+	cancel()
+	<-traced
 }
@@ -66,14 +66,22 @@
   let graphAnimFrame = null;
 
   // URL filter params
-  let urlFilters = { domain: '', status: '', model: '', community: '' };
+  let urlFilters = { domain: '', status: '', model: '', community: '', filter: '' };
+  let savedFilterIDs = null; // node IDs matched by a saved filter (?filter=)
   let communities = []; // federation mode
   let grafanaCommunities = new Set(); // communities with Grafana stats
 
   // ────────────────────── Init ──────────────────────
   async function init() {
-    config = await fetchJSON('/api/config');
+    config = await fetchJSON('api/config');
     document.getElementById('header-brand').textContent = config.siteName || 'Freifunk';
+    document.title = config.siteName || document.title;
+    applyBranding(config.theme || {});
+    await loadCatalog();
+    updateStaleBanner(config);
+    // The server keeps serving the last good data when upstream fails,
+    // without any SSE update, so poll for staleness.
+    setInterval(() => fetchJSON('api/stats').then(updateStaleBanner).catch(() => {}), 60000);
 
     // Header links
     const linksEl = document.getElementById('header-links');
@@ -99,11 +107,12 @@
     }
 
     await loadData();
+    await loadSavedFilter();
     populateDomainFilter();
     applyURLFilters();
 
     if (config.federation) {
-      fetchJSON('/api/communities').then(c => {
+      fetchJSON('api/communities').then(c => {
         communities = c || [];
         grafanaCommunities = new Set(
           communities.filter(c => c.grafana_url || c.dashboard_url).map(c => c.key)
@@ -126,6 +135,19 @@
     urlFilters.status = params.get('status') || '';
     urlFilters.model = params.get('model') || '';
     urlFilters.community = params.get('community') || '';
+    urlFilters.filter = params.get('filter') || '';
+  }
+
+  // Saved filters are evaluated server-side; the result restricts the list.
+  async function loadSavedFilter() {
+    savedFilterIDs = null;
+    if (!urlFilters.filter) return;
+    try {
+      const matched = await fetchJSON('api/filters/' + encodeURIComponent(urlFilters.filter) + '/nodes');
+      savedFilterIDs = new Set((matched || []).map(n => n.node_id));
+    } catch (e) {
+      console.warn('Saved filter not found:', urlFilters.filter);
+    }
   }
 
   function applyURLFilters() {
@@ -150,7 +172,7 @@
     renderMarkers();
 
     // If filtering, switch to list tab
-    if (urlFilters.domain || urlFilters.status || urlFilters.model || urlFilters.community) {
+    if (urlFilters.domain || urlFilters.status || urlFilters.model || urlFilters.community || savedFilterIDs) {
       activateTab('list-tab');
     }
   }
@@ -303,6 +325,59 @@
     });
   }
 
+  // ────────────────────── i18n ──────────────────────
+  let catalog = { strings: {}, domainNames: {} };
+
+  function t(key, fallback) {
+    return catalog.strings[key] || fallback;
+  }
+
+  // Translates elements marked with data-i18n (text) or
+  // data-i18n-placeholder, and merges per-language domain names.
+  async function loadCatalog() {
+    const lang = navigator.language || 'en';
+    try {
+      catalog = await fetchJSON('api/i18n/' + encodeURIComponent(lang) + '.json');
+    } catch (e) {
+      return; // keep the built-in English markup
+    }
+    catalog.strings = catalog.strings || {};
+    document.documentElement.lang = catalog.lang || 'en';
+    document.querySelectorAll('[data-i18n]').forEach(el => {
+      el.textContent = t(el.dataset.i18n, el.textContent);
+    });
+    document.querySelectorAll('[data-i18n-placeholder]').forEach(el => {
+      el.placeholder = t(el.dataset.i18nPlaceholder, el.placeholder);
+    });
+    config.domainNames = Object.assign({}, config.domainNames, catalog.domainNames);
+  }
+
+  // Colors, logo, favicon and footer from the server's theme config.
+  function applyBranding(theme) {
+    const root = document.documentElement;
+    Object.entries(theme.colors || {}).forEach(([name, value]) => {
+      root.style.setProperty('--' + name, value);
+    });
+    if (theme.logoURL) {
+      const img = document.createElement('img');
+      img.src = theme.logoURL;
+      img.alt = '';
+      img.className = 'header-logo';
+      document.getElementById('header-brand').prepend(img);
+    }
+    if (theme.faviconURL) {
+      const link = document.createElement('link');
+      link.rel = 'icon';
+      link.href = theme.faviconURL;
+      document.head.appendChild(link);
+    }
+    if (theme.footerText) {
+      const footer = document.getElementById('sidebar-footer');
+      footer.textContent = theme.footerText;
+      footer.classList.remove('hidden');
+    }
+  }
+
   // ────────────────────── Leaflet Map ──────────────────────
   function initLeafletMap() {
     leafletMap = L.map('map', {
@@ -313,17 +388,37 @@
     }).setView(config.mapCenter || [48.135, 11.582], config.mapZoom || 10);
 
     const layers = {};
-    (config.tileLayers || []).forEach((tl, i) => {
+    const tileLayers = config.tileLayers || [];
+    const defaultLayer = (config.theme && config.theme.defaultLayer) ||
+      (tileLayers[0] && tileLayers[0].name);
+    tileLayers.forEach(tl => {
       const layer = L.tileLayer(tl.url, {
         attribution: tl.attribution,
         maxZoom: tl.maxZoom || 19,
       });
       layers[tl.name] = layer;
-      if (i === 0) layer.addTo(leafletMap);
+      if (tl.name === defaultLayer) layer.addTo(leafletMap);
     });
 
-    if (Object.keys(layers).length > 1) {
-      L.control.layers(layers).addTo(leafletMap);
+    const overlays = {};
+    (config.overlays || []).forEach(ov => {
+      // The canvas renderer cannot resolve CSS variables.
+      const color = ov.color ||
+        getComputedStyle(document.documentElement).getPropertyValue('--accent').trim() || '#1566A9';
+      const layer = L.geoJSON(null, {
+        style: { color, weight: 2, fillOpacity: 0.1 },
+        pointToLayer: (f, latlng) => L.circleMarker(latlng, { radius: 5, color }),
+        onEachFeature: (f, l) => {
+          const name = f.properties && (f.properties.name || f.properties.title);
+          if (name) l.bindTooltip(String(name));
+        },
+      }).addTo(leafletMap);
+      fetchJSON(ov.url).then(data => layer.addData(data)).catch(() => {});
+      overlays[ov.name] = layer;
+    });
+
+    if (Object.keys(layers).length > 1 || Object.keys(overlays).length > 0) {
+      L.control.layers(layers, overlays).addTo(leafletMap);
     }
 
     // Dark tiles follow theme — set tilePane ref and apply
@@ -347,8 +442,8 @@
   // ────────────────────── Data ──────────────────────
   async function loadData() {
     const [nodeData, linkData] = await Promise.all([
-      fetchJSON('/api/nodes'),
-      fetchJSON('/api/links'),
+      fetchJSON('api/nodes'),
+      fetchJSON('api/links'),
     ]);
     nodes = nodeData;
     allLinks = linkData;
@@ -383,7 +478,7 @@
       const hasPos = n.lat != null && n.lng != null;
       const age = Math.floor((now - new Date(n.firstseen).getTime()) / 86400000);
       const ageStr = age === 0 ? 'today' : age + 'd ago';
-      html += `<div class="node-list-item" onclick="window.FFMap.selectNode('${escAttr(n.node_id)}')" style="font-size:12px">
+      html += `<div class="node-list-item" data-select-node="${escAttr(n.node_id)}" style="font-size:12px">
         <span class="node-status online" style="width:8px;height:8px;flex-shrink:0"></span>
         ${hasPos ? '<span style="font-size:10px;flex-shrink:0">🌍</span>' : '<span style="width:14px;flex-shrink:0"></span>'}
         <span class="hostname">${esc(n.hostname)}</span>
@@ -400,6 +495,7 @@
     const search = (document.getElementById('list-search')?.value || '').toLowerCase();
 
     return nodes.filter(n => {
+      if (savedFilterIDs && !savedFilterIDs.has(n.node_id)) return false;
       // Community filter
       if (communityVal) {
         if (n.community !== communityVal && !(n.communities || []).includes(communityVal)) return false;
@@ -413,6 +509,7 @@
         case 'gateway': return n.is_gateway;
         case 'haspos': return n.lat != null && n.lng != null;
         case 'nopos': return n.lat == null || n.lng == null;
+        case 'busyradio': return n.is_online && n.airtime_util >= BUSY_AIRTIME;
         case 'hasstats': {
           return (n.communities || [n.community]).some(c => grafanaCommunities.has(c));
         }
@@ -421,9 +518,13 @@
     });
   }
 
+  // Channel utilisation above which a node's busiest radio counts as overloaded.
+  const BUSY_AIRTIME = 0.6;
+
   const MARKER_COLORS = {
     online:          { fill: '#1566A9', stroke: '#1566A9' },
     'online-uplink': { fill: '#1566A9', stroke: '#0D4F8B' },
+    'busy-radio':    { fill: '#1566A9', stroke: '#FF8C00' },
     'new-node':      { fill: '#93E929', stroke: '#1566A9' },
     gateway:         { fill: '#FFD600', stroke: '#1566A9' },
     offline:         { fill: '#D43E2A', stroke: '#D43E2A' },
@@ -456,7 +557,8 @@
       marker.bindTooltip(
         `<strong>${esc(n.hostname)}</strong><br>` +
         `${n.is_online ? '🟢 Online' : '🔴 Offline'}` +
-        (n.clients ? ` · ${n.clients} clients` : ''),
+        (n.clients ? ` · ${n.clients} clients` : '') +
+        (n.is_online && n.airtime_util ? ` · ${(n.airtime_util * 100).toFixed(0)}% airtime` : ''),
         { direction: 'top', offset: [0, -8] }
       );
       marker.on('click', () => selectNode(n.node_id));
@@ -535,6 +637,7 @@
   function getMarkerClass(n) {
     if (!n.is_online) return 'offline';
     if (n.is_gateway) return 'gateway';
+    if (n.airtime_util >= BUSY_AIRTIME) return 'busy-radio';
     if (Date.now() - new Date(n.firstseen).getTime() < 7 * 86400000) return 'new-node';
     if (n.neighbours && n.neighbours.length > 0) return 'online-uplink';
     return 'online';
@@ -566,7 +669,7 @@
     }
 
     renderNodeLinks(nodeId);
-    const detail = await fetchJSON('/api/nodes/' + nodeId);
+    const detail = await fetchJSON('api/nodes/' + nodeId);
     renderNodeDetail(detail);
 
     // Track which tab we came from so X can return there
@@ -605,7 +708,7 @@
     </div>`;
 
     if (devicePicUrl) {
-      html += `<img class="device-image" src="${devicePicUrl}" onerror="this.style.display='none'" alt="${esc(node.model || '')}">`;
+      html += `<img class="device-image" src="${devicePicUrl}" alt="${esc(node.model || '')}">`;
     }
 
     // Device deprecation/EOL warnings
@@ -616,17 +719,41 @@
       }
     }
 
+    if (node.duplicate_hostname) {
+      html += `<div class="device-warning deprecated">⚠️ Another node in this domain uses the same hostname.</div>`;
+    }
+
+    const ann = node.annotation || {};
+    if (ann.maintenance) {
+      html += `<div class="device-warning deprecated">🔧 This node is marked for maintenance.</div>`;
+    }
+
     html += `<dl class="detail-grid">`;
     html += detailRow('Status', node.is_online ? '🟢 Online' : '🔴 Offline');
     if (node.model) html += detailRow('Model', node.model);
     if (node.firmware) html += detailRow('Firmware', `${node.fw_base || ''} ${node.firmware}`);
-    if (node.domain_name || node.domain) html += detailRow('Domain', node.domain_name || node.domain);
+    if (node.domain_name || node.domain) {
+      html += detailRow(t('detail.domain', 'Domain'), (config.domainNames || {})[node.domain] || node.domain_name || node.domain);
+    }
+    if (node.site_code && node.site_code !== node.domain) html += detailRow('Site', node.site_code);
+    if (node.is_uplink) html += detailRow('Uplink', '✓ VPN');
+    if (node.role && node.role !== 'node') html += detailRow('Role', node.role);
     if (node.owner) html += detailRow('Owner', node.owner);
+    if (ann.tags && ann.tags.length) html += detailRow('Tags', ann.tags.join(', '));
+    if (ann.note) html += detailRow('Note', ann.note);
+    if (node.alt != null) html += detailRow('Altitude', `${Math.round(node.alt)} m`);
     html += detailRow('MAC', node.mac);
+    if (node.aliases && node.aliases.length) html += detailRow('Replaces', node.aliases.join(', '));
     if (node.uptime && node.uptime !== '0001-01-01T00:00:00+0000') html += detailRow('Uptime', formatUptime(node.uptime));
     html += detailRow('First seen', formatDate(node.firstseen));
     html += detailRow('Last seen', formatDate(node.lastseen));
     if (node.nproc) html += detailRow('CPUs', node.nproc);
+    if (node.wireless) {
+      const radio = (ch, tx) => ch ? `${ch}${tx ? ` (${tx} dBm)` : ''}` : '';
+      const w24 = radio(node.wireless.channel24, node.wireless.txpower24), w5 = radio(node.wireless.channel5, node.wireless.txpower5);
+      if (w24) html += detailRow('Channel 2.4 GHz', w24);
+      if (w5) html += detailRow('Channel 5 GHz', w5);
+    }
     html += detailRow('Autoupdater', node.autoupdater ? `✓ ${node.branch}` : '✗ off');
     if (node.addresses && node.addresses.length > 0) {
       html += detailRowHTML('Addresses', `<span style="font-size:11px;word-break:break-all">${node.addresses.map(a => esc(a)).join('<br>')}</span>`);
@@ -638,6 +765,14 @@
         <div><small style="color:var(--fg-muted)">Load (${node.load_avg.toFixed(2)})</small>${progressBar(Math.min(node.load_avg / Math.max(node.nproc, 1), 1))}</div>
         <div><small style="color:var(--fg-muted)">Memory (${(node.mem_usage * 100).toFixed(0)}%)</small>${progressBar(node.mem_usage)}</div>
         <div><small style="color:var(--fg-muted)">Storage (${(node.rootfs_usage * 100).toFixed(0)}%)</small>${progressBar(node.rootfs_usage)}</div>`;
+      for (const a of node.airtime || []) {
+        const band = a.frequency ? (a.frequency < 3000 ? '2.4 GHz' : '5 GHz') : '';
+        const split = a.rx_util || a.tx_util ? ` · RX ${(a.rx_util * 100).toFixed(0)}% · TX ${(a.tx_util * 100).toFixed(0)}%` : '';
+        html += `<div><small style="color:var(--fg-muted)">Airtime ${band} (${(a.chan_util * 100).toFixed(0)}%${split})</small>${progressBar(a.chan_util)}</div>`;
+      }
+      if (node.traffic && (node.traffic.rx || node.traffic.tx)) {
+        html += `<dl class="detail-grid">${detailRow('Traffic', `↓ ${formatBytes((node.traffic.rx || {}).bytes || 0)} ↑ ${formatBytes((node.traffic.tx || {}).bytes || 0)}`)}</dl>`;
+      }
     }
 
     if (node.clients > 0) {
@@ -660,17 +795,19 @@
       node.neighbour_details.forEach(nb => {
         const dist = nb.distance > 0 ? ` · ${formatDistance(nb.distance)}` : '';
         const tq = nb.tq > 0 ? ` · TQ ${(nb.tq * 100).toFixed(0)}%` : '';
-        html += `<li class="neighbour-item" onclick="window.FFMap.selectNode('${escAttr(nb.node_id)}')">
+        const alt = nb.alt_diff != null ? ` · ↕ ${nb.alt_diff > 0 ? '+' : ''}${Math.round(nb.alt_diff)} m` : '';
+        html += `<li class="neighbour-item" data-select-node="${escAttr(nb.node_id)}">
           <span class="node-status ${nb.is_online ? 'online' : 'offline'}" style="width:8px;height:8px"></span>
           <span>${esc(nb.hostname || nb.node_id)}</span>
-          <span style="color:var(--fg-muted);font-size:12px;margin-left:auto">${nb.link_type || ''}${tq}${dist}</span>
+          <span style="color:var(--fg-muted);font-size:12px;margin-left:auto">${nb.link_type || ''}${tq}${dist}${alt}</span>
         </li>`;
       });
       html += `</ul>`;
     }
 
-    // Grafana charts via our proxy (works in both single and federation mode)
-    const grafanaLink = getGrafanaDashboardLink(node.node_id);
+    // Grafana charts via our proxy; the server fills in the dashboard link
+    // for both single and federation mode
+    const grafanaLink = node.grafana_dashboard_url || null;
 
     // Charts placeholder — loadGrafanaCharts will fill or hide
     if (node.is_online) {
@@ -684,13 +821,13 @@
       </div>`;
     }
     if (grafanaLink) {
-      html += `<a href="${grafanaLink}" target="_blank" class="grafana-link" id="grafana-link">📊 View in Grafana</a>`;
+      html += `<a href="${escAttr(grafanaLink)}" target="_blank" rel="noopener" class="grafana-link" id="grafana-link">📊 View in Grafana</a>`;
     }
 
     el.innerHTML = html;
 
     if (node.is_online) {
-      loadGrafanaCharts(node.node_id, grafanaLink, '24h');
+      loadGrafanaCharts(node.node_id, node.grafana_panels, '24h');
       // Wire up duration tabs
       el.querySelectorAll('.chart-tab').forEach(btn => {
         btn.addEventListener('click', () => {
@@ -702,43 +839,12 @@
           btn.classList.add('active');
           btn.style.background = 'var(--accent)';
           btn.style.color = '#fff';
-          loadGrafanaCharts(node.node_id, grafanaLink, btn.dataset.duration);
+          loadGrafanaCharts(node.node_id, node.grafana_panels, btn.dataset.duration);
         });
       });
     }
   }
 
-  // Get Grafana dashboard link for a node using community dashboard templates
-  function getGrafanaDashboardLink(nodeId) {
-    if (!config.federation || !communities.length) return null;
-    const node = nodeMap[nodeId];
-    if (!node) return null;
-    // Try each community the node belongs to
-    const comms = node.communities || (node.community ? [node.community] : []);
-    // For gateway nodes, strip the _community suffix to get the original MAC for Grafana
-    let grafanaNodeId = nodeId;
-    if (node.is_gateway) {
-      for (const ck of comms) {
-        const suffix = '_' + ck;
-        if (nodeId.endsWith(suffix)) {
-          grafanaNodeId = nodeId.slice(0, -suffix.length);
-          break;
-        }
-      }
-    }
-    for (const ck of comms) {
-      const community = communities.find(c => c.key === ck);
-      if (!community) continue;
-      if (community.dashboard_url) {
-        return community.dashboard_url.replace(/\{NODE_ID\}/g, grafanaNodeId);
-      }
-      if (community.grafana_url) {
-        return community.grafana_url;
-      }
-    }
-    return null;
-  }
-
   // ────────────────────── Device Pictures ──────────────────────
   // ────────────────────── Device Deprecation Warnings ──────────────────────
   // EOL devices no longer receive firmware/security updates
@@ -812,7 +918,7 @@
   }
 
   // ────────────────────── Grafana Charts via raw data + uPlot ──────────────────────
-  async function loadGrafanaCharts(nodeId, grafanaLink, duration) {
+  async function loadGrafanaCharts(nodeId, panels, duration) {
     if (typeof uPlot === 'undefined') return;
     duration = duration || '24h';
     const chartsContainer = document.getElementById('charts-container');
@@ -835,7 +941,7 @@
       container.innerHTML = `<h4 style="margin:0 0 4px;font-size:13px;color:var(--fg-muted)">${chart.title}</h4><div style="color:var(--fg-muted);font-size:12px">Loading...</div>`;
 
       try {
-        const data = await fetchJSON(`/api/metrics/${nodeId}?metric=${chart.metric}&duration=${duration}`);
+        const data = await fetchJSON(`api/metrics/${nodeId}?metric=${chart.metric}&duration=${duration}`);
         if (!data || !data.length || !data[0].times || !data[0].times.length) {
           container.innerHTML = `<h4 style="margin:0 0 4px;font-size:13px;color:var(--fg-muted)">${chart.title}</h4><span style="color:var(--fg-muted);font-size:12px">No data</span>`;
           continue;
@@ -887,10 +993,15 @@
       }
     }
 
-    // If we got any chart data, show the container; otherwise hide it
+    // If we got any chart data, show the container; otherwise fall back to
+    // the panels Grafana renders for the community's meshviewer, or hide it
     if (chartsContainer) {
       if (anyData) {
         chartsContainer.classList.remove('hidden');
+      } else if (panels && panels.length) {
+        chartsContainer.innerHTML = panels.map((name, i) =>
+          `<img class="grafana-render" src="api/grafana-render/${encodeURIComponent(nodeId)}/${i}" alt="${escAttr(name)}" loading="lazy" style="width:100%;margin-bottom:8px">`).join('');
+        chartsContainer.classList.remove('hidden');
       } else {
         chartsContainer.remove();
       }
@@ -1207,7 +1318,7 @@
   // ────────────────────── SSE ──────────────────────
   function connectSSE() {
     const dot = document.querySelector('.sse-dot');
-    sseSource = new EventSource('/api/events');
+    sseSource = new EventSource('api/events');
 
     sseSource.onopen = () => { dot.className = 'sse-dot connected'; };
     sseSource.onerror = () => { dot.className = 'sse-dot error'; };
@@ -1224,6 +1335,7 @@
     if (update.stats) {
       renderStatsFromData(update.stats);
       updateHeaderStats(update.stats);
+      updateStaleBanner(update.stats);
     }
     if (update.type === 'full') { loadData(); return; }
 
@@ -1264,6 +1376,15 @@
     });
   }
 
+  function updateStaleBanner(s) {
+    const el = document.getElementById('stale-banner');
+    if (!s || !s.stale) { el.classList.add('hidden'); return; }
+    const mins = Math.round((s.data_age_seconds || 0) / 60);
+    el.textContent = `⚠ Data may be outdated (${mins < 120 ? mins + ' min' : Math.round(mins / 60) + ' h'} old)`;
+    el.title = 'The data source has not delivered new data recently';
+    el.classList.remove('hidden');
+  }
+
   function updateHeaderStats(s) {
     document.getElementById('header-stats').textContent =
       `${s.online_nodes}/${s.total_nodes} nodes · ${s.total_clients} clients · ${s.gateways} gw`;
@@ -1374,6 +1495,9 @@
       html += `</div>`;
     }
     sections.push(['Domains', stats.domains, 20]);
+    // Raw codes only add information when nodes report several sites.
+    if (Object.keys(stats.site_codes || {}).length > 1) sections.push(['Site Codes', stats.site_codes, 20]);
+    if (Object.keys(stats.roles || {}).length > 1) sections.push(['Roles', stats.roles, 10]);
     sections.push(['Gluon Version', stats.gluon_versions, 15]);
     sections.push(['Firmware', stats.firmwares, 15]);
     sections.push(['Models', stats.models, 15]);
@@ -1417,6 +1541,9 @@
       case 'firstseen':
         filtered.sort((a, b) => new Date(b.firstseen) - new Date(a.firstseen));
         break;
+      case 'airtime':
+        filtered.sort((a, b) => (b.is_online ? b.airtime_util || 0 : 0) - (a.is_online ? a.airtime_util || 0 : 0));
+        break;
       default: // name: online first, then alpha
         filtered.sort((a, b) => {
           if (a.is_online !== b.is_online) return a.is_online ? -1 : 1;
@@ -1433,7 +1560,7 @@
       const linkCount = n.neighbours?.length || 0;
       const uptimeStr = (n.is_online && n.uptime && n.uptime !== '0001-01-01T00:00:00+0000') ? formatUptime(n.uptime) : '';
       const hasPos = (n.lat != null && n.lng != null);
-      html += `<div class="node-list-item" onclick="window.FFMap.selectNode('${escAttr(n.node_id)}')">
+      html += `<div class="node-list-item" data-select-node="${escAttr(n.node_id)}">
         <span class="node-status ${n.is_online ? 'online' : 'offline'}" style="width:8px;height:8px;flex-shrink:0"></span>
         ${hasPos ? '<span title="Has location" style="font-size:10px;flex-shrink:0">🌍</span>' : '<span style="width:14px;flex-shrink:0"></span>'}
         <span class="hostname">${esc(n.hostname)}</span>
@@ -1455,7 +1582,7 @@
       ).slice(0, 10);
       if (!matches.length) { results.classList.add('hidden'); return; }
       results.innerHTML = matches.map(n =>
-        `<div class="search-item" onclick="window.FFMap.selectNode('${escAttr(n.node_id)}');document.getElementById('search-results').classList.add('hidden')">
+        `<div class="search-item" data-select-node="${escAttr(n.node_id)}">
           <span class="node-status ${n.is_online ? 'online' : 'offline'}" style="width:8px;height:8px"></span>
           ${esc(n.hostname)}
         </div>`
@@ -1519,7 +1646,7 @@
       if (currentView === 'graph') hideGraph();
     }
     if (tabId === 'stats-tab') {
-      fetchJSON('/api/stats').then(renderStatsFromData);
+      fetchJSON('api/stats').then(renderStatsFromData);
     }
   }
 
@@ -1530,6 +1657,12 @@
     if (hash && hash.startsWith('#!')) {
       const nodeId = hash.slice(2);
       if (nodeId && nodeMap[nodeId]) selectNode(nodeId);
+      else if (nodeId) {
+        // Permalink of replaced hardware: the server resolves node aliases.
+        fetchJSON('api/nodes/' + encodeURIComponent(nodeId))
+          .then(d => { if (d.node_id !== nodeId && nodeMap[d.node_id]) selectNode(d.node_id); })
+          .catch(() => {});
+      }
     }
   }
 
@@ -1547,8 +1680,22 @@
     try { const d = Date.now() - new Date(s).getTime(); if (d < 0) return '?'; const dd = Math.floor(d/86400000), hh = Math.floor((d%86400000)/3600000); return dd > 0 ? `${dd}d ${hh}h` : `${hh}h ${Math.floor((d%3600000)/60000)}m`; } catch { return s; }
   }
   function formatDate(s) { if (!s) return '-'; try { const d = new Date(s); return isNaN(d) ? '-' : d.toLocaleString(); } catch { return '-'; } }
+  function formatBytes(b) { const u = ['B', 'KB', 'MB', 'GB', 'TB']; let i = 0; while (b >= 1024 && i < u.length - 1) { b /= 1024; i++; } return `${b.toFixed(i ? 1 : 0)} ${u[i]}`; }
   function formatDistance(m) { return m < 1000 ? Math.round(m) + ' m' : (m / 1000).toFixed(1) + ' km'; }
 
+  // Delegated handlers instead of inline ones, which the
+  // Content-Security-Policy does not allow.
+  document.addEventListener('click', e => {
+    const el = e.target.closest('[data-select-node]');
+    if (!el) return;
+    if (el.classList.contains('search-item')) document.getElementById('search-results').classList.add('hidden');
+    selectNode(el.dataset.selectNode);
+  });
+  document.addEventListener('error', e => {
+    const cl = e.target.classList;
+    if (cl && (cl.contains('device-image') || cl.contains('grafana-render'))) e.target.style.display = 'none';
+  }, true);
+
   // ────────────────────── Public API ──────────────────────
   window.FFMap = { selectNode };
   document.addEventListener('DOMContentLoaded', init);
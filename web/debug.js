@@ -0,0 +1,83 @@
+let data = [];
+let sortCol = 'served_nodes';
+let sortDir = 'desc';
+
+async function loadData() {
+  const resp = await fetch('api/debug/communities');
+  data = await resp.json();
+  render();
+}
+
+function render() {
+  const q = document.getElementById('search').value.toLowerCase();
+  const sf = document.getElementById('status-filter').value;
+  
+  let filtered = data.filter(c => {
+    if (sf && c.status !== sf) return false;
+    if (q) {
+      const text = (c.key + ' ' + c.name + ' ' + (c.all_keys || []).join(' ')).toLowerCase();
+      return text.includes(q);
+    }
+    return true;
+  });
+
+  filtered.sort((a, b) => {
+    let va = a[sortCol], vb = b[sortCol];
+    if (typeof va === 'string') va = va.toLowerCase();
+    if (typeof vb === 'string') vb = vb.toLowerCase();
+    if (typeof va === 'number' && typeof vb === 'number') {
+      return sortDir === 'asc' ? va - vb : vb - va;
+    }
+    return sortDir === 'asc' ? String(va).localeCompare(String(vb)) : String(vb).localeCompare(String(va));
+  });
+
+  const totalServed = data.reduce((s, c) => s + (c.served_nodes || 0), 0);
+  const activeCount = data.filter(c => c.status === 'active').length;
+  document.getElementById('stats').textContent = 
+    `${data.length} communities | ${activeCount} active | ${totalServed.toLocaleString()} nodes served | showing ${filtered.length}`;
+
+  document.querySelectorAll('th').forEach(th => {
+    th.classList.remove('sorted-asc', 'sorted-desc');
+    if (th.dataset.sort === sortCol) th.classList.add(sortDir === 'asc' ? 'sorted-asc' : 'sorted-desc');
+  });
+
+  const tbody = document.getElementById('tbody');
+  tbody.innerHTML = filtered.map(c => {
+    const urls = [];
+    (c.meshviewer_urls || []).forEach(u => urls.push(`<a href="${esc(u)}" target="_blank" title="meshviewer">${esc(shorten(u))}</a>`));
+    (c.nodelist_urls || []).forEach(u => urls.push(`<a href="${esc(u)}" target="_blank" title="nodelist">${esc(shorten(u))}</a>`));
+    
+    const sources = (c.active_sources || []).map(s => 
+      `<span class="source ${esc(s.data_type)}"><a href="${esc(s.data_url)}" target="_blank">${esc(s.data_type)}: ${esc(shorten(s.data_url))}</a></span>`
+    ).join('<br>');
+
+    const keys = (c.all_keys && c.all_keys.length > 1) ? `<div class="keys">Also: ${esc(c.all_keys.filter(k => k !== c.key).join(', '))}</div>` : '';
+
+    return `<tr>
+      <td>${esc(c.key)}${keys}</td>
+      <td>${esc(c.name)}${c.metacommunity ? `<br><span class="keys">${esc(c.metacommunity)}</span>` : ''}</td>
+      <td><span class="badge badge-${esc(c.status)}">${esc(c.status)}</span></td>
+      <td>${c.api_nodes || 0}</td>
+      <td><strong>${c.served_nodes || 0}</strong></td>
+      <td class="url-list">${urls.join('<br>') || '<em>none</em>'}</td>
+      <td>${sources || '<em>none</em>'}</td>
+    </tr>`;
+  }).join('');
+}
+
+function esc(s) { const d = document.createElement('div'); d.textContent = s || ''; return d.innerHTML; }
+function shorten(u) { try { const p = new URL(u); return p.hostname + p.pathname; } catch { return u; } }
+
+document.querySelectorAll('th[data-sort]').forEach(th => {
+  th.addEventListener('click', () => {
+    const col = th.dataset.sort;
+    if (sortCol === col) sortDir = sortDir === 'asc' ? 'desc' : 'asc';
+    else { sortCol = col; sortDir = col === 'key' || col === 'name' ? 'asc' : 'desc'; }
+    render();
+  });
+});
+
+document.getElementById('search').addEventListener('input', render);
+document.getElementById('status-filter').addEventListener('change', render);
+
+loadData();